@@ -0,0 +1,88 @@
+package randr
+
+import "context"
+
+// State is a snapshot of the outputs, as returned by Detect.
+type State struct {
+	// Outputs lists the connected outputs.
+	Outputs []Output
+
+	outputs  []output
+	screen   screen
+	settings *settings
+}
+
+// Detect queries the current outputs once.
+func Detect(ctx context.Context, opts ...Option) (State, error) {
+	o := newOptions(opts)
+	st := o.over(defaultSettings())
+	ctx = withSettings(ctx, st)
+	outputs, scr, err := queryOutputs(ctx, o.backend)
+	if err != nil {
+		return State{}, err
+	}
+	s := State{outputs: outputs, screen: scr, settings: st}
+	for _, o := range connectedOutputs(outputs) {
+		s.Outputs = append(s.Outputs, newOutput(o))
+	}
+	return s, nil
+}
+
+// context returns a context carrying the settings of the Detect call.
+func (s State) context() context.Context {
+	if s.settings == nil {
+		return withSettings(context.Background(), defaultSettings())
+	}
+	return withSettings(context.Background(), s.settings)
+}
+
+// Plan is a layout decision for a State, ready to be applied.
+type Plan struct {
+	p        *plan
+	screen   screen
+	settings *settings
+}
+
+// PlanMirror plans mirroring every connected output at the best resolution
+// they have in common, scaling those that lack it.
+func PlanMirror(s State) *Plan {
+	ctx := s.context()
+	return planLayout(ctx, s, "mirror", mirrorLayout(ctx, s.outputs))
+}
+
+// PlanExtend plans placing the connected outputs left to right at their
+// preferred modes, the first one being primary.
+func PlanExtend(s State) *Plan {
+	return planLayout(s.context(), s, "extend", extend(connectedOutputs(s.outputs)))
+}
+
+func planLayout(ctx context.Context, s State, reason string, l layout) *Plan {
+	return &Plan{p: newPlan(ctx, reason, l, s.outputs), screen: s.screen, settings: settingsFrom(ctx)}
+}
+
+// Changed reports whether applying the plan would change anything.
+func (p *Plan) Changed() bool {
+	return len(p.p.delta()) > 0
+}
+
+// String describes the plan as a diff of current against desired state, as
+// `randr plan` prints it.
+func (p *Plan) String() string {
+	return p.p.String()
+}
+
+// Apply applies the plan through b, or the xrandr binary if b is nil, and
+// verifies the result. If the layout does not take effect the internal panel
+// is lit again, so the screens are never left dark.
+func (p *Plan) Apply(ctx context.Context, b Backend) error {
+	if b == nil {
+		b = xrandrBackend{}
+	}
+	if p.settings != nil {
+		ctx = withSettings(ctx, p.settings)
+	}
+	if err := p.p.validate(p.screen); err != nil {
+		return err
+	}
+	return applyVerified(ctx, newExecutor(b, settingsFrom(ctx)), b, p.p, &daemonState{})
+}
@@ -0,0 +1,126 @@
+package randr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// askTimeout is how long a notification asking for a layout waits for an
+// answer.
+const askTimeout = 2 * time.Minute
+
+// notifyCommand shows desktop notifications.
+var notifyCommand = "notify-send"
+
+// choiceIgnore answers a notification by keeping the usual layout.
+const choiceIgnore = "ignore"
+
+// choices are the answers a notification offers, as notify-send actions.
+var choices = []struct{ name, label string }{
+	{layoutMirror, "Mirror"},
+	{layoutExtend, "Extend"},
+	{layoutExternalOnly, "External only"},
+	{choiceIgnore, "Ignore"},
+}
+
+// loadChoices reads the remembered answers, keyed by monitor fingerprint. A
+// missing file yields none.
+func loadChoices(path string) (map[string]string, error) {
+	choices := make(map[string]string)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return choices, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &choices); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return choices, nil
+}
+
+func saveChoices(path string, choices map[string]string) error {
+	data, err := json.MarshalIndent(choices, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// rememberedChoice returns the answer remembered for the first of the
+// monitors that has one. Monitors without a usable EDID have no
+// fingerprint to remember them by.
+func rememberedChoice(ctx context.Context, path string, monitors []output) (string, bool) {
+	remembered, err := loadChoices(path)
+	if err != nil {
+		logf(ctx, "ask: %v", err)
+		return "", false
+	}
+	for _, o := range monitors {
+		if c, ok := remembered[o.id()]; ok && !o.Unidentified {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// rememberChoice saves the answer for the monitors.
+func rememberChoice(ctx context.Context, path string, monitors []output, choice string) {
+	remembered, err := loadChoices(path)
+	if err != nil {
+		logf(ctx, "ask: %v", err)
+		return
+	}
+	for _, o := range monitors {
+		if !o.Unidentified {
+			remembered[o.id()] = choice
+		}
+	}
+	if err := saveChoices(path, remembered); err != nil {
+		logf(ctx, "ask: %v", err)
+	}
+}
+
+// askLayout asks with a notification how to use the monitors that were
+// connected, and returns the answer, or "" if the notification was
+// dismissed or timed out. It takes notify-send 0.7.10 or later, for the
+// actions.
+func askLayout(ctx context.Context, monitors []output) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, askTimeout)
+	defer cancel()
+	var names []string
+	for _, o := range monitors {
+		if o.Monitor.Name != "" {
+			names = append(names, fmt.Sprintf("%s (%s)", o.Monitor.Name, o.Name))
+		} else {
+			names = append(names, o.Name)
+		}
+	}
+	args := []string{"--app-name=randr", "--wait", "--expire-time=" + fmt.Sprint(askTimeout.Milliseconds())}
+	for _, c := range choices {
+		args = append(args, "--action="+c.name+"="+c.label)
+	}
+	args = append(args, "Monitor connected", "How should "+strings.Join(names, ", ")+" be used?")
+	out, err := exec.CommandContext(ctx, notifyCommand, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", notifyCommand, err)
+	}
+	answer := strings.TrimSpace(string(out))
+	for _, c := range choices {
+		if c.name == answer {
+			return answer, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,19 @@
+package randr
+
+import "slices"
+
+// audioOff are the values of the driver's "audio" output property, on
+// Intel and AMD, that keep sound off the link.
+var audioOff = []string{"off", "force-dvi"}
+
+// audioSink reports whether the output carries sound to a monitor that
+// plays it: the monitor's EDID announces audio, the connector is not the
+// internal panel's, and the driver's "audio" property, if it has one, does
+// not turn sound off.
+func (o output) audioSink() bool {
+	if !o.Connected || o.internal() || !o.CEA.Audio {
+		return false
+	}
+	v, ok := o.Props["audio"]
+	return !ok || !slices.Contains(audioOff, v)
+}
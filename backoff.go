@@ -0,0 +1,41 @@
+package randr
+
+import "time"
+
+const (
+	// maxBackoff caps the poll delay while xrandr keeps failing.
+	maxBackoff = time.Minute
+	// defaultMaxFailures is how many consecutive failures are tolerated
+	// before the daemon reports itself degraded.
+	defaultMaxFailures = 10
+)
+
+// backoff tracks consecutive xrandr query failures and stretches the poll
+// interval exponentially while they last.
+type backoff struct {
+	// base is the delay without failures.
+	base     time.Duration
+	failures int
+}
+
+// delay returns how long to wait before the next poll.
+func (b *backoff) delay() time.Duration {
+	d := b.base
+	for i := 0; i < b.failures && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// fail records a failure and returns the number of consecutive failures.
+func (b *backoff) fail() int {
+	b.failures++
+	return b.failures
+}
+
+// reset records a success and returns how many failures preceded it.
+func (b *backoff) reset() int {
+	n := b.failures
+	b.failures = 0
+	return n
+}
@@ -0,0 +1,56 @@
+package randr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	bo := backoff{base: 2 * time.Second}
+	if d := bo.delay(); d != 2*time.Second {
+		t.Errorf("delay without failures = %s, want the poll interval", d)
+	}
+	for i, want := range []time.Duration{4, 8, 16, 32, 60, 60} {
+		if n := bo.fail(); n != i+1 {
+			t.Errorf("fail %d counted %d failures", i+1, n)
+		}
+		if d := bo.delay(); d != want*time.Second {
+			t.Errorf("delay after %d failures = %s, want %ds", i+1, d, want)
+		}
+	}
+	if n := bo.reset(); n != 6 {
+		t.Errorf("reset after %d failures, want 6", n)
+	}
+	if d := bo.delay(); d != 2*time.Second {
+		t.Errorf("delay after recovering = %s, want the poll interval", d)
+	}
+}
+
+func TestPollDegraded(t *testing.T) {
+	gone := errors.New("X is gone")
+	for _, exit := range []bool{false, true} {
+		cfg := &config{MaxFailures: 3, ExitOnDegraded: exit}
+		ctx := withSettings(context.Background(), cfg.settings())
+		l := &loop{
+			d:     &Daemon{options: newOptions([]Option{WithBackend(fileBackend{err: gone})})},
+			cfg:   cfg,
+			timer: time.NewTimer(time.Hour),
+			bo:    backoff{base: time.Second},
+		}
+		for i := 1; i <= 4; i++ {
+			err := l.poll(ctx)
+			if degraded := i == 3 && exit; (err != nil) != degraded {
+				t.Fatalf("exit_on_degraded=%t: poll %d returned %v", exit, i, err)
+			}
+			if err != nil && !errors.Is(err, gone) {
+				t.Errorf("poll %d returned %v, want the query error", i, err)
+			}
+		}
+		if l.bo.failures != 4 || l.stats.Failures != 4 {
+			t.Errorf("exit_on_degraded=%t: %d failures counted, %d in stats, want 4", exit, l.bo.failures, l.stats.Failures)
+		}
+		l.timer.Stop()
+	}
+}
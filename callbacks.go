@@ -0,0 +1,119 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+// defaultCallbackLimit is how many callbacks run at once unless the config
+// says otherwise; the rest wait for a slot.
+const defaultCallbackLimit = 4
+
+// callbackConfig are commands run when an output matching its key, by
+// connector name, EDID fingerprint or pattern, connects, disconnects or
+// changes.
+type callbackConfig struct {
+	OnConnect    string `json:"on_connect,omitempty"`
+	OnDisconnect string `json:"on_disconnect,omitempty"`
+	OnChange     string `json:"on_change,omitempty"`
+	// Timeout bounds each command, command_timeout by default.
+	Timeout duration `json:"timeout,omitempty"`
+}
+
+// callbackEvents are the events callbacks run on, by their config name.
+var callbackEvents = map[string]string{
+	"on_connect":    eventConnected,
+	"on_disconnect": eventDisconnected,
+	"on_change":     eventChanged,
+}
+
+// command returns the command for the event, or "".
+func (c callbackConfig) command(event string) string {
+	switch event {
+	case eventConnected:
+		return c.OnConnect
+	case eventDisconnected:
+		return c.OnDisconnect
+	case eventChanged:
+		return c.OnChange
+	}
+	return ""
+}
+
+// callbacks runs the callbacks of the config it was made for, no more than
+// its limit at once.
+type callbacks struct {
+	cfg   map[string]callbackConfig
+	slots chan struct{}
+}
+
+func newCallbacks(cfg *config) *callbacks {
+	limit := defaultCallbackLimit
+	if cfg.CallbackLimit > 0 {
+		limit = cfg.CallbackLimit
+	}
+	return &callbacks{cfg: cfg.Callbacks, slots: make(chan struct{}, limit)}
+}
+
+// run runs, in the background, the callbacks for the event of every output
+// their key matches, in key order. A callback that outlives its timeout is
+// killed.
+func (cb *callbacks) run(ctx context.Context, event string, o output) {
+	for _, key := range slices.Sorted(maps.Keys(cb.cfg)) {
+		c := cb.cfg[key]
+		command := c.command(event)
+		if command == "" || !matchesOutput(key, o) {
+			continue
+		}
+		timeout := settingsFrom(ctx).commandTimeout
+		if c.Timeout > 0 {
+			timeout = time.Duration(c.Timeout)
+		}
+		debugf(ctx, "%s %s callback: %s", o.Name, event, command)
+		go func() {
+			select {
+			case cb.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-cb.slots }()
+			_, err := runCommandTimeout(ctx, timeout, false, "sh", []string{"-c", command}, func(cmd *exec.Cmd) {
+				cmd.Env = append(os.Environ(),
+					"RANDR_CALLBACK="+event,
+					"RANDR_OUTPUT="+o.Name,
+					"RANDR_MONITOR="+o.Monitor.String(),
+				)
+				cmd.Stdout = os.Stderr
+				cmd.Stderr = os.Stderr
+			})
+			if err != nil {
+				logf(ctx, "%s %s callback %q: %v", o.Name, event, command, err)
+			}
+		}()
+	}
+}
+
+// checkCallbacks reports callbacks with a bad key or timeout.
+func (c *config) checkCallbacks() []*configError {
+	var errs []*configError
+	top := func(path, format string, args ...any) {
+		errs = append(errs, &configError{File: c.file, Path: path, Msg: fmt.Sprintf(format, args...)})
+	}
+	if c.CallbackLimit < 0 {
+		top("callback_limit", "callback_limit must not be negative, not %d", c.CallbackLimit)
+	}
+	for _, key := range slices.Sorted(maps.Keys(c.Callbacks)) {
+		if err := checkPattern(key); err != nil {
+			top("callbacks."+key, "callback %q: %v", key, err)
+		}
+		if t := c.Callbacks[key].Timeout; t < 0 {
+			top("callbacks."+key+".timeout", "callback %q: timeout must not be negative, not %s", key, time.Duration(t))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,82 @@
+package randr
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitFile waits up to d for the file to exist and returns its contents.
+func waitFile(t *testing.T, path string, d time.Duration) string {
+	t.Helper()
+	for deadline := time.Now().Add(d); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if data, err := os.ReadFile(path); err == nil {
+			return string(data)
+		}
+	}
+	t.Fatalf("%s not written within %s", filepath.Base(path), d)
+	return ""
+}
+
+func TestCallbacks(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{Callbacks: map[string]callbackConfig{
+		"DEL-A0B8-718NY83": {OnConnect: `echo "$RANDR_CALLBACK $RANDR_OUTPUT $RANDR_MONITOR" > ` + dir + "/dell"},
+		"HDMI-?":           {OnDisconnect: "touch " + dir + "/hdmi"},
+		"DP-1":             {OnConnect: "touch " + dir + "/dp"},
+	}}
+	ctx, cancel := context.WithCancel(withSettings(context.Background(), cfg.settings()))
+	defer cancel()
+	cb := newCallbacks(cfg)
+	dell := output{Name: "HDMI-1", Connected: true, Monitor: monitorID{Vendor: "DEL", Product: 0xa0b8, Serial: "718NY83"}}
+	cb.run(ctx, eventConnected, dell)
+	if got, want := waitFile(t, filepath.Join(dir, "dell"), 5*time.Second), "connected HDMI-1 DEL-A0B8-718NY83\n"; got != want {
+		t.Errorf("callback saw %q, want %q", got, want)
+	}
+	cb.run(ctx, eventDisconnected, dell)
+	waitFile(t, filepath.Join(dir, "hdmi"), 5*time.Second)
+	if _, err := os.Stat(filepath.Join(dir, "dp")); err == nil {
+		t.Error("DP-1's callback ran for HDMI-1")
+	}
+}
+
+// A callback that hangs holds its slot only until its timeout.
+func TestCallbackLimitAndTimeout(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{CallbackLimit: 1, Callbacks: map[string]callbackConfig{
+		"HDMI-1": {OnChange: "touch " + dir + "/started; exec sleep 10", Timeout: duration(100 * time.Millisecond)},
+		"DP-1":   {OnConnect: "touch " + dir + "/dp"},
+	}}
+	s := cfg.settings()
+	s.logger = log.New(io.Discard, "", 0)
+	ctx, cancel := context.WithCancel(withSettings(context.Background(), s))
+	defer cancel()
+	cb := newCallbacks(cfg)
+	start := time.Now()
+	cb.run(ctx, eventChanged, output{Name: "HDMI-1"})
+	waitFile(t, filepath.Join(dir, "started"), 5*time.Second)
+	cb.run(ctx, eventConnected, output{Name: "DP-1"})
+	waitFile(t, filepath.Join(dir, "dp"), 5*time.Second)
+	if d := time.Since(start); d < 100*time.Millisecond || d > 5*time.Second {
+		t.Errorf("second callback ran after %s, want after the first timed out", d)
+	}
+}
+
+func TestCheckCallbacks(t *testing.T) {
+	cfg := &config{CallbackLimit: -1, Callbacks: map[string]callbackConfig{
+		"DP-[1": {OnConnect: "true"},
+		"DP-1":  {OnConnect: "true", Timeout: duration(-time.Second)},
+	}}
+	var msgs []string
+	for _, err := range cfg.checkCallbacks() {
+		msgs = append(msgs, err.Path)
+	}
+	if got, want := strings.Join(msgs, " "), "callback_limit callbacks.DP-1.timeout callbacks.DP-[1"; got != want {
+		t.Errorf("errors at %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package randr
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"slices"
+)
+
+// What happens when a connected output changes, from output_changes.
+const (
+	// outputChangesReplan plans the layout again, as for a hotplug.
+	outputChangesReplan = "replan"
+	// outputChangesIgnore only reports the change.
+	outputChangesIgnore = "ignore"
+)
+
+// signature hashes what tells the output's monitor and link apart without
+// a hotplug: the monitor's identity and capabilities, the resolutions it
+// offers, and the link status. Properties randr sets itself, such as
+// Broadcast RGB, are left out so applying them is not seen as a change.
+func (o output) signature() uint64 {
+	res := slices.Clone(o.Resolutions)
+	// Forced modes are listed once added to the server, maybe elsewhere.
+	slices.SortFunc(res, func(a, b resolution) int { return cmp.Or(cmp.Compare(a.W, b.W), cmp.Compare(a.H, b.H)) })
+	res = slices.Compact(res)
+	h := fnv.New64a()
+	fmt.Fprint(h, o.Monitor, o.EDIDError, o.Physical, o.CEA, res, o.Props["link-status"])
+	return h.Sum64()
+}
+
+// changedOutputs returns the outputs connected both before and now whose
+// signature differs, such as a monitor swapped behind a KVM switch or a
+// link that went bad.
+func changedOutputs(prev, cur []output) []string {
+	var names []string
+	for _, o := range cur {
+		if !o.Connected {
+			continue
+		}
+		if p, ok := findOutput(prev, o.Name); ok && p.Connected && p.signature() != o.signature() {
+			names = append(names, o.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,342 @@
+package randr
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const commandHelp = `commands:
+  (none)                    run the daemon
+  status                    show the outputs, the matching profile and the daemon's state
+  plan [profile]            show what would be applied, without applying it
+  list [output]             list outputs with their monitors and modes
+  cycle-resolution [output] switch the output, primary by default, to its next resolution
+  cycle-rate [output]       switch the output, primary by default, to its next refresh rate
+  apply profile [--for d]   have the daemon apply the profile, undoing it again after d
+  cycle                     have the daemon apply the next profile for the connected monitors
+  undo                      have the daemon put back the layout before its last change
+  redo                      have the daemon put back the layout it last undid
+  revert                    same as undo
+  module [--format f]       print the daemon's status for a status bar: i3blocks, polybar, waybar
+  stats                     show the daemon's uptime, hotplugs, layouts applied and failures
+  history [--since t]       show the recorded monitor events and applied layouts
+  config validate           check the config and profiles for problems
+  self-update [--check]     replace the binary with a newer release (checksummed, not signed)
+  version                   print the release the binary was built from
+  completion bash|zsh|fish  print a shell completion script`
+
+// Main runs the randr command line: the daemon, or one of the commands in
+// commandHelp. It exits the process on failure.
+func Main() {
+	dirs := defaultPaths()
+	dirs.registerFlags(flag.CommandLine)
+	registerLogFlags(flag.CommandLine)
+	registerEventFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: randr [flags] [command]\n\n%s\n\nflags:\n", commandHelp)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+		d := NewDaemon()
+		d.dirs = dirs
+		err = d.Run(ctx)
+	case "status":
+		err = runStatus(ctx, dirs)
+	case "plan":
+		err = runPlan(ctx, dirs, flag.Arg(1))
+	case "list":
+		err = runList(ctx, dirs, flag.Arg(1))
+	case "cycle-resolution":
+		err = runCycleResolution(ctx, dirs, flag.Arg(1))
+	case "cycle-rate":
+		err = runCycleRate(ctx, dirs, flag.Arg(1))
+	case "apply":
+		err = runApply(ctx, dirs, flag.Args()[1:])
+	case "cycle":
+		err = runCycle(ctx, dirs)
+	case "undo", "redo":
+		err = runStep(ctx, dirs, cmd)
+	case "revert":
+		err = runStep(ctx, dirs, "undo")
+	case "module":
+		err = runModule(ctx, dirs, flag.Args()[1:])
+	case "stats":
+		err = runStats(ctx, dirs)
+	case "history":
+		err = runHistory(dirs, flag.Args()[1:])
+	case "self-update":
+		err = runSelfUpdate(ctx, flag.Args()[1:])
+	case "version":
+		fmt.Println(version)
+	case "completion":
+		err = runCompletion(flag.Arg(1))
+	case "__complete":
+		runComplete(ctx, dirs, flag.Args()[1:])
+	case "config":
+		if flag.Arg(1) != "validate" {
+			flag.Usage()
+			err = fmt.Errorf("unknown config command %q", flag.Arg(1))
+			break
+		}
+		err = runConfigValidate(ctx, dirs)
+	default:
+		flag.Usage()
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "randr: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runPlan prints what the daemon would do for the currently connected
+// outputs, or what applying the named profile would do, without changing
+// anything.
+func runPlan(ctx context.Context, dirs paths, name string) error {
+	cfg, err := loadConfig(dirs)
+	if err != nil {
+		return err
+	}
+	ctx = withSettings(ctx, cfg.settings())
+	outputs, scr, err := parseXrandr(ctx)
+	if err != nil {
+		return err
+	}
+	pl := &planner{cfg: cfg}
+	p := pl.connected(ctx, outputs)
+	if name != "" {
+		prof := cfg.profile(name)
+		if prof == nil {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+		p = pl.profile(ctx, prof, outputs)
+	}
+	fmt.Print(p)
+	if prof := cfg.lookup(p.Profile); prof != nil {
+		for _, args := range monitorArgs(ctx, prof.monitors(outputs), p.layout(), outputs) {
+			fmt.Printf("  monitor %s %s %s\n", args[1], args[2], args[3])
+		}
+	}
+	return p.validate(scr)
+}
+
+// runStatus prints each output's state, the profile matching the connected
+// monitors, and the daemon's state. A running daemon is asked over the
+// control socket, so the output reflects what it actually thinks; otherwise
+// xrandr and the state file are read directly.
+func runStatus(ctx context.Context, dirs paths) error {
+	ds, err := queryDaemon(ctx, dirs.socket())
+	running := err == nil
+	if err != nil && !errors.Is(err, errNotRunning) {
+		return err
+	}
+	if !running {
+		if ds, err = offlineStatus(ctx, dirs); err != nil {
+			return err
+		}
+	}
+
+	for _, o := range ds.Outputs {
+		if !o.Connected && !o.CRTC {
+			continue
+		}
+		var desc []string
+		switch {
+		case !o.Connected:
+			desc = append(desc, "disconnected")
+		case o.active():
+			desc = append(desc, fmt.Sprintf("%s+%d+%d", o.Resolutions[o.Current], o.X, o.Y))
+		default:
+			desc = append(desc, "off")
+		}
+		if o.Rotation != "" {
+			desc = append(desc, "rotated "+o.Rotation)
+		}
+		if o.Primary {
+			desc = append(desc, "primary")
+		}
+		if o.Unidentified {
+			desc = append(desc, "unidentified")
+		}
+		if o.tv() {
+			desc = append(desc, "tv")
+		} else if o.projector() {
+			desc = append(desc, "projector")
+		}
+		if o.Monitor.Name != "" {
+			desc = append(desc, strconv.Quote(o.Monitor.Name))
+		}
+		fmt.Printf("%-10s %s\n", o.Name, strings.Join(desc, " "))
+	}
+
+	// Monitors set up with --setmonitor are what window managers see in
+	// place of the outputs they cover; the daemon doesn't track them.
+	if running {
+		if cfg, err := loadConfig(dirs); err == nil {
+			ctx = withSettings(ctx, cfg.settings())
+		}
+	}
+	mons, err := queryMonitors(ctx, xrandrBackend{})
+	if err != nil {
+		return err
+	}
+	for _, m := range mons {
+		if m.Automatic {
+			continue
+		}
+		on := strings.Join(m.Outputs, ", ")
+		if on == "" {
+			on = "no output"
+		}
+		fmt.Printf("monitor:   %s %s on %s\n", m.Name, mmRe.ReplaceAllString(m.Geometry, ""), on)
+	}
+
+	if ds.Dock != "" {
+		fmt.Printf("dock:      %s\n", ds.Dock)
+	}
+	if ds.Default {
+		fmt.Printf("profile:   %s (no profile matches)\n", ds.Matched)
+	} else {
+		fmt.Printf("profile:   %s\n", ds.Matched)
+	}
+	var daemon string
+	switch {
+	case !running:
+		daemon = "not running"
+	case ds.Paused:
+		daemon = "paused, the layout was changed by hand"
+	default:
+		daemon = "watching"
+	}
+	if ds.Pending {
+		daemon += ", manual change pending"
+	}
+	if !ds.Until.IsZero() {
+		daemon += ", temporary layout until " + ds.Until.Local().Format("15:04")
+	}
+	if ds.Failures > 0 {
+		daemon += fmt.Sprintf(", %d failed queries", ds.Failures)
+	}
+	fmt.Printf("daemon:    %s\n", daemon)
+	if ds.LastAction != "" {
+		fmt.Printf("last:      %s at %s\n", ds.LastAction, ds.LastActionTime.Format(time.DateTime))
+	}
+	if ds.LastError != "" {
+		fmt.Printf("error:     %s at %s\n", ds.LastError, ds.LastErrorTime.Format(time.DateTime))
+	}
+	return nil
+}
+
+// offlineStatus builds the status without a daemon, from xrandr, the config
+// and the state file.
+func offlineStatus(ctx context.Context, dirs paths) (*daemonStatus, error) {
+	cfg, err := loadConfig(dirs)
+	if err != nil {
+		return nil, err
+	}
+	ctx = withSettings(ctx, cfg.settings())
+	outputs, _, err := parseXrandr(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st, err := loadState(dirs.state())
+	if err != nil {
+		return nil, err
+	}
+	ds := &daemonStatus{
+		Outputs:        outputs,
+		Profile:        st.Profile,
+		LastAction:     st.LastAction,
+		LastActionTime: st.LastActionTime,
+	}
+	ds.Matched, ds.Default = matchedProfile(ctx, cfg, outputs, newChecks(ctx))
+	ds.Dock = cfg.currentDock(connectedOutputs(outputs))
+	return ds, nil
+}
+
+// runList prints every output, or just the named one, with its monitor
+// fingerprint and modes. The preferred mode is marked "+", the current "*".
+func runList(ctx context.Context, dirs paths, name string) error {
+	outputs, err := listOutputs(ctx, dirs)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, o := range outputs {
+		if name != "" && o.Name != name {
+			continue
+		}
+		found = true
+		status := "disconnected"
+		if o.NonDesktop && !o.Connected {
+			status = "non-desktop"
+		}
+		if o.Connected {
+			status = "connected"
+			if o.Primary {
+				status += " primary"
+			}
+		}
+		if o.UnknownConnection {
+			status += " (unknown connection)"
+		}
+		fmt.Printf("%s %s", o.Name, status)
+		if o.Connected {
+			fmt.Printf(" %s", o.id())
+			if o.Monitor.Name != "" {
+				fmt.Printf(" %q", o.Monitor.Name)
+			}
+			if o.audioSink() {
+				fmt.Print(" audio")
+			}
+		}
+		fmt.Println()
+		for i, r := range o.Resolutions {
+			var mark strings.Builder
+			if i == o.Current {
+				mark.WriteString("*")
+			}
+			if i == o.Preferred {
+				mark.WriteString("+")
+			}
+			fmt.Printf("  %-12s%s\n", r, mark.String())
+		}
+	}
+	if name != "" && !found {
+		return fmt.Errorf("unknown output %q", name)
+	}
+	return nil
+}
+
+// listOutputs returns the outputs as the running daemon last saw them, or
+// queries them, with the config's settings, when no daemon is running.
+func listOutputs(ctx context.Context, dirs paths) ([]output, error) {
+	ds, err := queryDaemon(ctx, dirs.socket())
+	switch {
+	case err == nil:
+		return ds.Outputs, nil
+	case errors.Is(err, errNotRunning):
+		if cfg, err := loadConfig(dirs); err == nil {
+			ctx = withSettings(ctx, cfg.settings())
+		}
+		outputs, _, err := parseXrandr(ctx)
+		return outputs, err
+	default:
+		return nil, err
+	}
+}
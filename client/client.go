@@ -0,0 +1,179 @@
+// Package client talks to a randr daemon's HTTP API, for tooling that
+// controls and observes many daemons, e.g. across a signage deployment.
+// The event stream is newline-delimited JSON. For the gRPC service, use the
+// generated client in randr/randrpb.
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"randr"
+)
+
+// Client is a connection to one daemon.
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// New returns a client for the daemon whose "http" setting is addr, e.g.
+// "localhost:7600" or "http://signage-3:7600".
+func New(addr string) *Client {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{base: strings.TrimSuffix(addr, "/"), http: http.DefaultClient}
+}
+
+// Profile is a configured profile as the daemon reports it.
+type Profile struct {
+	Name    string   `json:"name"`
+	Layout  string   `json:"layout,omitempty"`
+	Outputs []string `json:"outputs,omitempty"`
+	// Matches is set when the profile applies to the connected outputs,
+	// Active when it is the one last applied.
+	Matches bool `json:"matches"`
+	Active  bool `json:"active"`
+}
+
+// Event is an event from the daemon: "connected" or "disconnected" when a
+// monitor is plugged in or out, "changed" when a connected output changes,
+// e.g. a KVM switch swapping its monitor, "layout-applied" when the daemon
+// changed the layout, or "error".
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// Output, Monitor and Name identify the output of connected,
+	// disconnected and changed events: the connector, its EDID fingerprint
+	// and the monitor's name.
+	Output  string `json:"output,omitempty"`
+	Monitor string `json:"monitor,omitempty"`
+	Name    string `json:"name,omitempty"`
+	// Unidentified is set for an output without a usable EDID, and
+	// EDIDError says what was wrong with it.
+	Unidentified bool   `json:"unidentified,omitempty"`
+	EDIDError    string `json:"edid_error,omitempty"`
+	// Audio is set when the output carries sound to its monitor.
+	Audio bool `json:"audio,omitempty"`
+	// Profile, Reason and Layout describe an applied layout.
+	Profile string         `json:"profile,omitempty"`
+	Reason  string         `json:"reason,omitempty"`
+	Layout  []LayoutOutput `json:"layout,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// LayoutOutput is one output of an applied layout.
+type LayoutOutput struct {
+	Name      string `json:"name"`
+	Off       bool   `json:"off,omitempty"`
+	Mode      string `json:"mode"`
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Primary   bool   `json:"primary,omitempty"`
+	ScaleFrom string `json:"scale_from,omitempty"`
+	// Rotation is "normal", "left", "right" or "inverted", or empty when
+	// the layout left the rotation as it was; Rate is the refresh rate, 0
+	// when left to xrandr.
+	Rotation string  `json:"rotation,omitempty"`
+	Rate     float64 `json:"rate,omitempty"`
+}
+
+// Outputs returns the connected outputs.
+func (c *Client) Outputs(ctx context.Context) ([]randr.Output, error) {
+	var outputs []randr.Output
+	return outputs, c.do(ctx, http.MethodGet, "/outputs", &outputs)
+}
+
+// Profiles returns the configured profiles.
+func (c *Client) Profiles(ctx context.Context) ([]Profile, error) {
+	var profiles []Profile
+	return profiles, c.do(ctx, http.MethodGet, "/profiles", &profiles)
+}
+
+// Apply applies the named profile, or the built-in "mirror" or "extend"
+// layout.
+func (c *Client) Apply(ctx context.Context, profile string) error {
+	return c.do(ctx, http.MethodPost, "/apply/"+url.PathEscape(profile), nil)
+}
+
+// Cycle applies the next profile matching the connected monitors and
+// returns its name.
+func (c *Client) Cycle(ctx context.Context) (string, error) {
+	var resp struct {
+		Profile string `json:"profile"`
+	}
+	err := c.do(ctx, http.MethodPost, "/cycle", &resp)
+	return resp.Profile, err
+}
+
+// Events streams the daemon's events until ctx is cancelled or the
+// connection drops; the channel is closed then.
+func (c *Client) Events(ctx context.Context) (<-chan Event, error) {
+	resp, err := c.request(ctx, http.MethodGet, "/events")
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan Event)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var e Event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				continue
+			}
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, v any) error {
+	resp, err := c.request(ctx, method, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// request sends a request and turns error responses into errors.
+func (c *Client) request(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var e struct {
+			Error string `json:"error"`
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(body, &e) != nil || e.Error == "" {
+			return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return nil, errors.New(e.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// daemonEvents are events as the daemon streams them.
+const daemonEvents = `{"time":"2026-10-14T14:32:07Z","type":"connected","output":"HDMI-1","monitor":"DEL-A0B8-718NY83","name":"DELL U2720Q","audio":true}
+{"time":"2026-10-14T14:32:07Z","type":"changed","output":"DP-1","unidentified":true,"edid_error":"bad checksum"}
+{"time":"2026-10-14T14:32:08Z","type":"layout-applied","profile":"desk","reason":"profile \"desk\"","layout":[{"name":"eDP-1","off":true,"mode":"0x0","x":0,"y":0},{"name":"HDMI-1","mode":"2560x1440","x":0,"y":0,"primary":true,"rotation":"left","rate":59.95}]}
+not json
+{"time":"2026-10-14T14:32:09Z","type":"error","error":"xrandr: exit status 1"}
+`
+
+func TestEvents(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/events" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, daemonEvents)
+	}))
+	defer srv.Close()
+
+	events, err := New(srv.URL).Events(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Event
+	for e := range events {
+		got = append(got, e)
+	}
+	if len(got) != 4 {
+		t.Fatalf("got %d events, want 4: %+v", len(got), got)
+	}
+	if e := got[0]; e.Type != "connected" || e.Output != "HDMI-1" || e.Monitor != "DEL-A0B8-718NY83" ||
+		e.Name != "DELL U2720Q" || !e.Audio {
+		t.Errorf("connected event %+v", e)
+	}
+	if e := got[1]; e.Type != "changed" || !e.Unidentified || e.EDIDError != "bad checksum" {
+		t.Errorf("changed event %+v", e)
+	}
+	e := got[2]
+	if e.Type != "layout-applied" || e.Profile != "desk" || e.Reason != `profile "desk"` || len(e.Layout) != 2 {
+		t.Fatalf("layout event %+v", e)
+	}
+	want := LayoutOutput{Name: "HDMI-1", Mode: "2560x1440", Primary: true, Rotation: "left", Rate: 59.95}
+	if e.Layout[1] != want {
+		t.Errorf("layout output %+v, want %+v", e.Layout[1], want)
+	}
+	if e := got[3]; e.Type != "error" || e.Error != "xrandr: exit status 1" {
+		t.Errorf("error event %+v", e)
+	}
+}
@@ -0,0 +1,8 @@
+// Command randr mirrors or arranges displays as monitors come and go.
+package main
+
+import "randr"
+
+func main() {
+	randr.Main()
+}
@@ -0,0 +1,52 @@
+package randr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"time"
+)
+
+const defaultCommandTimeout = 10 * time.Second
+
+// runXrandr runs xrandr with the configured binary and global arguments.
+func runXrandr(ctx context.Context, capture bool, args []string, setup func(*exec.Cmd)) ([]byte, error) {
+	s := settingsFrom(ctx)
+	return runCommand(ctx, capture, s.xrandrPath, append(slices.Clone(s.xrandrArgs), args...), setup)
+}
+
+// runCommand runs the named command, killing it if it outlives
+// the command timeout or ctx is cancelled. setup, if given, can adjust the
+// command before it starts. The command's stdout is returned when capture
+// is set.
+func runCommand(ctx context.Context, capture bool, name string, args []string, setup func(*exec.Cmd)) ([]byte, error) {
+	return runCommandTimeout(ctx, settingsFrom(ctx).commandTimeout, capture, name, args, setup)
+}
+
+// runCommandTimeout is runCommand with its own timeout.
+func runCommandTimeout(ctx context.Context, timeout time.Duration, capture bool, name string, args []string, setup func(*exec.Cmd)) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Don't wait forever on pipes held open by stray children.
+	cmd.WaitDelay = time.Second
+	if setup != nil {
+		setup(cmd)
+	}
+
+	var out []byte
+	var err error
+	if capture {
+		out, err = cmd.Output()
+	} else {
+		err = cmd.Run()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		logf(ctx, "%s: killed after %s", name, timeout)
+		return out, fmt.Errorf("%s: timed out after %s", name, timeout)
+	}
+	return out, err
+}
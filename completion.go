@@ -0,0 +1,112 @@
+package randr
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// The completion scripts defer to `randr __complete <words>`, which prints
+// the candidates for the next word, so completion logic lives in one place
+// and profile and output names are always current.
+const (
+	bashCompletion = `_randr() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    local IFS=$'\n'
+    COMPREPLY=($(compgen -W "$(randr __complete "${COMP_WORDS[@]:1:COMP_CWORD-1}" 2>/dev/null)" -- "$cur"))
+}
+complete -F _randr randr
+`
+	zshCompletion = `#compdef randr
+_randr() {
+    local -a candidates
+    candidates=(${(f)"$(randr __complete ${words[2,CURRENT-1]} 2>/dev/null)"})
+    compadd -a candidates
+}
+compdef _randr randr
+`
+	fishCompletion = `complete -c randr -f -a '(randr __complete (commandline -opc)[2..-1] 2>/dev/null)'
+`
+)
+
+func runCompletion(shell string) error {
+	switch shell {
+	case "bash":
+		fmt.Print(bashCompletion)
+	case "zsh":
+		fmt.Print(zshCompletion)
+	case "fish":
+		fmt.Print(fishCompletion)
+	default:
+		return fmt.Errorf("unsupported shell %q (bash, zsh or fish)", shell)
+	}
+	return nil
+}
+
+// runComplete prints the completion candidates for the word following args,
+// one per line. Errors are swallowed; a failed lookup just completes nothing.
+func runComplete(ctx context.Context, dirs paths, args []string) {
+	// Drop flags and their values; only the command words matter.
+	var words []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if strings.HasPrefix(a, "-") {
+			name := strings.TrimLeft(a, "-")
+			if f := flag.Lookup(name); f != nil && !isBoolFlag(f) {
+				i++
+			}
+			continue
+		}
+		words = append(words, a)
+	}
+
+	var candidates []string
+	switch {
+	case len(words) == 0:
+		candidates = []string{"status", "plan", "list", "cycle-resolution", "cycle-rate", "apply", "cycle", "undo", "redo", "revert", "module", "history", "stats", "config", "self-update", "version", "completion"}
+	case len(words) == 1:
+		switch words[0] {
+		case "plan", "apply":
+			candidates = completeProfiles(dirs)
+		case "list", "cycle-resolution", "cycle-rate":
+			candidates = completeOutputs(ctx, dirs)
+		case "config":
+			candidates = []string{"validate"}
+		case "completion":
+			candidates = []string{"bash", "zsh", "fish"}
+		}
+	}
+	for _, c := range candidates {
+		fmt.Println(c)
+	}
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+func completeProfiles(dirs paths) []string {
+	cfg, err := loadConfig(dirs)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, p := range cfg.Profiles {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func completeOutputs(ctx context.Context, dirs paths) []string {
+	outputs, err := listOutputs(ctx, dirs)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, o := range connectedOutputs(outputs) {
+		names = append(names, o.Name)
+	}
+	return names
+}
@@ -0,0 +1,121 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// compositorResets are the compositors that reinitialize on SIGUSR1, which
+// is gentler than a restart.
+var compositorResets = []string{"picom", "compton"}
+
+// compositorConfig has the compositor refresh after layout changes, as mode
+// changes often leave it drawing garbage or the old screen size.
+type compositorConfig struct {
+	// Name is the compositor's process name, e.g. "picom"; empty leaves
+	// the compositor alone.
+	Name string `json:"name,omitempty"`
+	// Restart restarts the compositor with its command line and
+	// environment instead of signalling it; compositors other than picom
+	// and compton are always restarted.
+	Restart bool `json:"restart,omitempty"`
+	// Delay waits before refreshing, for the screen to settle.
+	Delay duration `json:"delay,omitempty"`
+}
+
+// refresh has the compositor pick up the new layout, after Delay.
+func (c *compositorConfig) refresh(ctx context.Context) {
+	if c.Name == "" {
+		return
+	}
+	select {
+	case <-time.After(time.Duration(c.Delay)):
+	case <-ctx.Done():
+		return
+	}
+	pids := findProcesses(c.Name)
+	if len(pids) == 0 {
+		debugf(ctx, "compositor: %s is not running", c.Name)
+		return
+	}
+	for _, pid := range pids {
+		if !c.Restart && slices.Contains(compositorResets, c.Name) {
+			infof(ctx, "compositor: resetting %s (%d)", c.Name, pid)
+			if err := syscall.Kill(pid, syscall.SIGUSR1); err != nil {
+				logf(ctx, "compositor: %v", err)
+			}
+			continue
+		}
+		if err := restartProcess(ctx, pid); err != nil {
+			logf(ctx, "compositor: restarting %s: %v", c.Name, err)
+		}
+	}
+}
+
+// findProcesses returns the IDs of the user's processes with the given
+// name.
+func findProcesses(name string) []int {
+	var pids []int
+	comms, _ := filepath.Glob(filepath.Join(procDir, "[0-9]*", "comm"))
+	for _, f := range comms {
+		data, err := os.ReadFile(f)
+		if err != nil || strings.TrimSpace(string(data)) != name {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != os.Getuid() {
+			continue
+		}
+		if pid, err := strconv.Atoi(filepath.Base(filepath.Dir(f))); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// restartProcess stops the process and starts it again, detached, with the
+// command line and environment it had.
+func restartProcess(ctx context.Context, pid int) error {
+	dir := filepath.Join(procDir, strconv.Itoa(pid))
+	cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return err
+	}
+	environ, err := os.ReadFile(filepath.Join(dir, "environ"))
+	if err != nil {
+		return err
+	}
+	args := strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	infof(ctx, "compositor: restarting %s", strings.Join(args, " "))
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return err
+	}
+	// Give it a moment to let go of the screen before the new one starts.
+	for range 20 {
+		if _, err := os.Stat(dir); err != nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	for _, kv := range bytes.Split(bytes.TrimRight(environ, "\x00"), []byte{0}) {
+		cmd.Env = append(cmd.Env, string(kv))
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
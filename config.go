@@ -0,0 +1,596 @@
+package randr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// config is the on-disk configuration, read from
+// $XDG_CONFIG_HOME/randr/config.json. A missing file is not an error; the
+// daemon then behaves as if no profiles were defined.
+type config struct {
+	Profiles []profile `json:"profiles"`
+	// Default names the profile applied when no other profile matches.
+	Default string `json:"default,omitempty"`
+	// Learn saves manual layout changes as profiles for the monitor set.
+	// It takes precedence over RespectManual: a layout randr put back
+	// could not be learned, so manual changes are always respected.
+	Learn bool `json:"learn,omitempty"`
+	// RespectManual leaves layouts changed outside randr alone until the
+	// connected set changes. It defaults to true; when false randr
+	// re-applies its own layout, unless Learn is set.
+	RespectManual *bool `json:"respect_manual,omitempty"`
+	// MaxFailures is the number of consecutive xrandr query failures after
+	// which the daemon is considered degraded; ExitOnDegraded makes it exit
+	// then, leaving the restart to systemd.
+	MaxFailures    int  `json:"max_failures,omitempty"`
+	ExitOnDegraded bool `json:"exit_on_degraded,omitempty"`
+	// CommandTimeout bounds each xrandr invocation, e.g. "10s".
+	CommandTimeout duration `json:"command_timeout,omitempty"`
+	// XrandrPath and XrandrArgs override the xrandr binary and add global
+	// arguments to every call. The RANDR_XRANDR and RANDR_XRANDR_ARGS
+	// environment variables take precedence.
+	XrandrPath string   `json:"xrandr_path,omitempty"`
+	XrandrArgs []string `json:"xrandr_args,omitempty"`
+	// Apply is how randr talks to the X server: "xrandr" (the default)
+	// runs xrandr, "protocol" speaks the RandR protocol itself, to query
+	// the outputs as well as to apply layouts, so xrandr is not needed.
+	Apply string `json:"apply,omitempty"`
+	// PollInterval is how often xrandr is queried, e.g. "2s".
+	PollInterval duration `json:"poll_interval,omitempty"`
+	// LowPower skips the query of a poll while the kernel's DRM
+	// connectors look unchanged, for single board computers.
+	LowPower bool `json:"low_power,omitempty"`
+	// Mode is the layout of the built-in default profile, used when no
+	// profile matches and Default is unset.
+	Mode string `json:"mode,omitempty"`
+	// LogLevel is one of "error", "info", "debug" or "trace".
+	LogLevel string `json:"log_level,omitempty"`
+	// HTTP is the address the HTTP API listens on, e.g. "localhost:7600".
+	// It is off when empty.
+	HTTP string `json:"http,omitempty"`
+	// GRPC is the address the gRPC service listens on, e.g.
+	// "localhost:7601". It is off when empty.
+	GRPC string `json:"grpc,omitempty"`
+	// MQTT publishes events to a broker and takes commands from it.
+	MQTT *mqttConfig `json:"mqtt,omitempty"`
+	// Hooks run around every layout change, around the applied profile's
+	// own hooks.
+	Hooks hooks `json:"hooks,omitzero"`
+	// Script is a Starlark script (.star) or an executable that decides
+	// the layout in place of the profiles; a relative path is relative to
+	// the config file.
+	Script string `json:"script,omitempty"`
+	// Rules pick a profile or layout by condition, ahead of the profiles.
+	Rules []rule `json:"rules,omitempty"`
+	// Docks identify docking stations by name, for profiles and rules.
+	Docks map[string]dockSpec `json:"docks,omitempty"`
+	// Providers is "auto" (the default) to have the main GPU feed output
+	// to USB display adapters and secondary GPUs, the name or ID of the
+	// provider to feed them from instead, or "off".
+	Providers string `json:"providers,omitempty"`
+	// ReversePrime is "auto" (the default) to sequence layout changes
+	// when an NVIDIA GPU sinks output from another, "on" to always do so,
+	// or "off".
+	ReversePrime string `json:"reverse_prime,omitempty"`
+	// TV tunes the defaults televisions get.
+	TV tvConfig `json:"tv,omitzero"`
+	// Projector tunes how projectors are set up when no profile matches.
+	Projector projectorConfig `json:"projector,omitzero"`
+	// Quirks work around monitor models that misreport themselves, keyed
+	// by "VENDOR-PRODUCT" or a pattern, on top of the built-in ones.
+	Quirks map[string]quirk `json:"quirks,omitempty"`
+	// ForcedModes are the modes to run outputs without a usable EDID at,
+	// by connector name, e.g. {"HDMI-1": "1920x1080"}.
+	ForcedModes map[string]string `json:"forced_modes,omitempty"`
+	// DPMS handles monitor power around layout changes.
+	DPMS dpmsConfig `json:"dpms,omitzero"`
+	// Locker coordinates layout changes with the screen locker.
+	Locker lockerConfig `json:"locker,omitzero"`
+	// Rotation rotates the internal panel as the device is turned.
+	Rotation rotationConfig `json:"rotation,omitzero"`
+	// Power sets refresh rate and resolution policies by power source.
+	Power powerConfig `json:"power,omitzero"`
+	// Disconnect is what happens when monitors are disconnected:
+	// "restore" (the default) returns the primary output to its preferred
+	// mode, "replan" plans the layout for the remaining outputs as if they
+	// had just been connected, "none" only switches the disconnected ones
+	// off, and anything else names the profile to apply.
+	Disconnect string `json:"disconnect,omitempty"`
+	// OutputChanges is what happens when a connected output changes
+	// without a hotplug, as when a KVM switch swaps the monitor or a link
+	// goes bad: "replan" (the default) plans the layout again, "ignore"
+	// only reports it.
+	OutputChanges string `json:"output_changes,omitempty"`
+	// UnknownConnection decides whether outputs xrandr reports in
+	// "unknown connection" state count as connected: "probe" (the
+	// default) when they have an EDID or modes, "connected" or
+	// "disconnected" always.
+	UnknownConnection string `json:"unknown_connection,omitempty"`
+	// UseNonDesktop lays out outputs marked non-desktop, such as VR
+	// headsets, like monitors instead of ignoring them.
+	UseNonDesktop bool `json:"use_non_desktop,omitempty"`
+	// RateTolerance is how many hertz apart refresh rates can be and still
+	// count as the same, 0.5 by default.
+	RateTolerance float64 `json:"rate_tolerance,omitempty"`
+	// RestoreOnExit puts the layout from when the daemon started back when
+	// it is stopped.
+	RestoreOnExit bool `json:"restore_on_exit,omitempty"`
+	// DockedInternalOff keeps the internal panel off as long as any
+	// external output is lit, whatever the layout says.
+	DockedInternalOff bool `json:"docked_internal_off,omitempty"`
+	// Compositor has the compositor refresh after layout changes.
+	Compositor compositorConfig `json:"compositor,omitzero"`
+	// Feedback are commands giving feedback on monitor changes and layout
+	// changes.
+	Feedback feedbackConfig `json:"feedback,omitzero"`
+	// Callbacks are commands run when particular outputs connect,
+	// disconnect or change, keyed by connector name, EDID fingerprint or
+	// pattern; CallbackLimit is how many run at once, 4 by default.
+	Callbacks     map[string]callbackConfig `json:"callbacks,omitempty"`
+	CallbackLimit int                       `json:"callback_limit,omitempty"`
+	// Ask asks with a notification how to use monitors that no profile
+	// covers when they are connected, and remembers the answer.
+	Ask bool `json:"ask,omitempty"`
+	// Windows looks after application windows around layout changes.
+	Windows windowsConfig `json:"windows,omitzero"`
+	// Cycle tunes the cycle commands.
+	Cycle cycleConfig `json:"cycle,omitzero"`
+	// Primary are the outputs, by connector name, EDID fingerprint or
+	// pattern, to make primary whenever one of them is lit, the first
+	// lit one winning over whatever the layout picks.
+	Primary []string `json:"primary,omitempty"`
+	// PromoteExternal makes an external output primary whenever one is
+	// lit, and the internal panel otherwise, after the Primary outputs.
+	PromoteExternal bool `json:"promote_external,omitempty"`
+
+	// file is where the config was read from.
+	file string
+}
+
+// tolerance returns the refresh rate tolerance the config asks for.
+func (c *config) tolerance() tolerance {
+	if c.RateTolerance > 0 {
+		return tolerance(c.RateTolerance)
+	}
+	return defaultRateTolerance
+}
+
+// duration is a time.Duration written as a string like "1m30s" in the
+// config.
+type duration time.Duration
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (c *config) maxFailures() int {
+	if c.MaxFailures > 0 {
+		return c.MaxFailures
+	}
+	return defaultMaxFailures
+}
+
+// respectManual reports whether manual layout changes are left alone: as
+// RespectManual says, and always when learning them.
+func (c *config) respectManual() bool {
+	return c.RespectManual == nil || *c.RespectManual || c.Learn
+}
+
+func loadConfig(p paths) (*config, error) {
+	cfg, err := readConfig(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	// Learned profiles rank after the user's own.
+	learned, err := loadLearned(p.learned())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range learned {
+		if cfg.profile(p.Name) == nil {
+			cfg.Profiles = append(cfg.Profiles, p)
+		}
+	}
+	return cfg, nil
+}
+
+// readConfig reads the config file and profile directory and applies
+// environment overrides, without validating the result.
+func readConfig(p paths) (*config, error) {
+	cfg := &config{}
+	path := p.Config
+	cfg.file = path
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, jsonError(path, data, err)
+		}
+	}
+	for i := range cfg.Profiles {
+		cfg.Profiles[i].file = path
+		cfg.Profiles[i].path = fmt.Sprintf("profiles[%d]", i)
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	files, err := loadProfileDir(p.Profiles)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Profiles = append(cfg.Profiles, files...)
+	for i := range cfg.Profiles {
+		if d, ok := cfg.Docks[cfg.Profiles[i].Dock]; ok {
+			cfg.Profiles[i].dock = &d
+		}
+	}
+	return cfg, nil
+}
+
+// configError is a problem in the config, located by the file and the JSON
+// path of the offending value, e.g. "profiles[2].layout".
+type configError struct {
+	File string
+	Path string
+	Msg  string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("%s: %s", e.File, e.Msg)
+}
+
+func (c *config) validate() error {
+	if errs := c.check(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// check returns every problem found in the config.
+func (c *config) check() []*configError {
+	var errs []*configError
+	top := func(path, format string, args ...any) {
+		errs = append(errs, &configError{File: c.file, Path: path, Msg: fmt.Sprintf(format, args...)})
+	}
+	switch c.Mode {
+	case "", layoutMirror, layoutExtend:
+	default:
+		top("mode", "unknown mode %q", c.Mode)
+	}
+	switch c.ReversePrime {
+	case "", "auto", "on", "off":
+	default:
+		top("reverse_prime", "reverse_prime must be \"auto\", \"on\" or \"off\", not %q", c.ReversePrime)
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		top("log_level", "%v", err)
+	}
+	if c.TV.Underscan < 0 {
+		top("tv.underscan", "negative tv.underscan")
+	}
+	if c.Projector.Mode != "" {
+		if _, err := parseResolution(c.Projector.Mode); err != nil {
+			top("projector.mode", "%v", err)
+		}
+	}
+	if c.Power.Battery.MaxRate < 0 {
+		top("power.battery.max_rate", "negative power.battery.max_rate")
+	}
+	if c.Power.Battery.MaxMode != "" {
+		if _, err := parseResolution(c.Power.Battery.MaxMode); err != nil {
+			top("power.battery.max_mode", "%v", err)
+		}
+	}
+	for i, s := range c.Cycle.Resolutions {
+		if _, err := parseResolution(s); err != nil {
+			top(fmt.Sprintf("cycle.resolutions[%d]", i), "%v", err)
+		}
+	}
+	for i, want := range c.Primary {
+		if err := checkPattern(want); err != nil {
+			top(fmt.Sprintf("primary[%d]", i), "%v", err)
+		}
+	}
+	if c.Compositor.Delay < 0 {
+		top("compositor.delay", "negative compositor.delay")
+	}
+	if c.RateTolerance < 0 {
+		top("rate_tolerance", "negative rate_tolerance")
+	}
+	if c.PollInterval < 0 {
+		top("poll_interval", "negative poll_interval")
+	}
+	if c.HTTP != "" {
+		if _, _, err := net.SplitHostPort(c.HTTP); err != nil {
+			top("http", "bad http address: %v", err)
+		}
+	}
+	if c.GRPC != "" {
+		if _, _, err := net.SplitHostPort(c.GRPC); err != nil {
+			top("grpc", "bad grpc address: %v", err)
+		}
+	}
+	if c.MQTT != nil {
+		if c.MQTT.Broker == "" {
+			top("mqtt.broker", "mqtt without a broker")
+		}
+		if c.MQTT.Topic == "" {
+			top("mqtt.topic", "mqtt without a topic")
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range c.Profiles {
+		bad := func(field, format string, args ...any) {
+			errs = append(errs, &configError{File: p.file, Path: joinPath(p.path, field),
+				Msg: fmt.Sprintf("profile %q: ", p.Name) + fmt.Sprintf(format, args...)})
+		}
+		if p.Name == "" {
+			bad("", "profile without a name")
+		}
+		if seen[p.Name] {
+			bad("name", "duplicate profile")
+		}
+		seen[p.Name] = true
+		switch p.Layout {
+		case "", layoutMirror, layoutExtend, layoutExternalOnly, layoutInternalOnly, layoutMixed:
+		case layoutFixed:
+			if len(p.Arrangement) == 0 {
+				bad("layout", "fixed layout without arrangement")
+			}
+		default:
+			bad("layout", "unknown layout %q", p.Layout)
+		}
+		if _, ok := c.Docks[p.Dock]; p.Dock != "" && !ok {
+			bad("dock", "unknown dock %q", p.Dock)
+		}
+		if p.Time != "" {
+			if _, err := parseTimeWindow(p.Time); err != nil {
+				bad("time", "%v", err)
+			}
+		}
+		if err := checkDays(p.Days); err != nil {
+			bad("days", "%v", err)
+		}
+		switch p.Ultrawide {
+		case "", ultrawideWhole, ultrawideSplit:
+		default:
+			bad("ultrawide", "ultrawide must be %q or %q, not %q", ultrawideWhole, ultrawideSplit, p.Ultrawide)
+		}
+		monitors := make(map[string]bool)
+		for i, m := range p.Monitors {
+			if err := m.validate(); err != nil {
+				bad(fmt.Sprintf("monitors[%d]", i), "%v", err)
+			}
+			if monitors[m.Name] {
+				bad(fmt.Sprintf("monitors[%d]", i), "duplicate monitor %q", m.Name)
+			}
+			monitors[m.Name] = true
+		}
+		for i, want := range p.Outputs {
+			if err := checkPattern(want); err != nil {
+				bad(fmt.Sprintf("outputs[%d]", i), "%v", err)
+			}
+		}
+		if len(p.Mirror) > 0 && p.Layout != "" && p.Layout != layoutMirror && p.Layout != layoutMixed {
+			bad("mirror", "mirror needs the mirror or mixed layout, not %q", p.Layout)
+		}
+		if len(p.MirrorGroups) > 0 && p.Layout != "" && p.Layout != layoutMirror {
+			bad("mirror_groups", "mirror_groups needs the mirror layout, not %q", p.Layout)
+		}
+		for i, want := range p.Mirror {
+			if err := checkPattern(want); err != nil {
+				bad(fmt.Sprintf("mirror[%d]", i), "%v", err)
+			}
+		}
+		for i, g := range p.MirrorGroups {
+			if len(g) < 2 {
+				bad(fmt.Sprintf("mirror_groups[%d]", i), "a mirror group needs at least two outputs")
+			}
+			for j, want := range g {
+				if err := checkPattern(want); err != nil {
+					bad(fmt.Sprintf("mirror_groups[%d][%d]", i, j), "%v", err)
+				}
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(p.Arrangement)) {
+			if err := checkPattern(name); err != nil {
+				bad("arrangement."+name, "%v", err)
+			}
+			if err := p.Arrangement[name].validate(); err != nil {
+				bad("arrangement."+name, "%s: %v", name, err)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(p.Rotate)) {
+			if err := checkPattern(name); err != nil {
+				bad("rotate."+name, "%v", err)
+			}
+			if r := p.Rotate[name]; !slices.Contains(rotations, r) {
+				bad("rotate."+name, "%s: rotation must be one of %s, not %q", name, strings.Join(rotations, ", "), r)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(p.Scale)) {
+			if err := checkPattern(name); err != nil {
+				bad("scale."+name, "%v", err)
+			}
+			if f := p.Scale[name]; f < minScale || f > maxScale {
+				bad("scale."+name, "%s: scale must be between %g and %g, not %g", name, minScale, maxScale, f)
+			}
+		}
+	}
+	for _, p := range c.Profiles {
+		if p.Fallback != "" && !seen[p.Fallback] {
+			errs = append(errs, &configError{File: p.file, Path: joinPath(p.path, "fallback"),
+				Msg: fmt.Sprintf("profile %q: unknown fallback %q", p.Name, p.Fallback)})
+		}
+	}
+	switch c.Disconnect {
+	case "", disconnectRestore, disconnectReplan, disconnectNone:
+	default:
+		if c.lookup(c.Disconnect) == nil {
+			top("disconnect", "disconnect must be %q, %q, %q or a profile, not %q", disconnectRestore, disconnectReplan, disconnectNone, c.Disconnect)
+		}
+	}
+	switch c.UnknownConnection {
+	case "", unknownProbe, unknownConnected, unknownDisconnected:
+	default:
+		top("unknown_connection", "unknown_connection must be %q, %q or %q, not %q", unknownProbe, unknownConnected, unknownDisconnected, c.UnknownConnection)
+	}
+	switch c.Apply {
+	case "", applyXrandr, applyProtocol:
+	default:
+		top("apply", "apply must be %q or %q, not %q", applyXrandr, applyProtocol, c.Apply)
+	}
+	switch c.OutputChanges {
+	case "", outputChangesReplan, outputChangesIgnore:
+	default:
+		top("output_changes", "output_changes must be %q or %q, not %q", outputChangesReplan, outputChangesIgnore, c.OutputChanges)
+	}
+	if c.Default != "" && !seen[c.Default] {
+		top("default", "unknown default profile %q", c.Default)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Docks)) {
+		d := c.Docks[name]
+		if d.USB == "" && d.Branch == "" {
+			top("docks."+name, "dock %q: neither usb nor branch", name)
+		}
+		if n := strings.Count(d.USB, ":"); d.USB != "" && n != 1 && n != 2 {
+			top("docks."+name+".usb", "dock %q: usb must be vendor:product[:serial]", name)
+		}
+		if err := checkPattern(d.Branch); err != nil {
+			top("docks."+name+".branch", "dock %q: %v", name, err)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(c.Quirks)) {
+		if err := checkPattern(key); err != nil {
+			top("quirks."+key, "quirk %q: %v", key, err)
+		}
+		if err := c.Quirks[key].validate(); err != nil {
+			top("quirks."+key, "quirk %q: %v", key, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.ForcedModes)) {
+		if _, err := parseResolution(c.ForcedModes[name]); err != nil {
+			top("forced_modes."+name, "forced mode for %s: %v", name, err)
+		}
+	}
+	errs = append(errs, c.checkRules()...)
+	errs = append(errs, c.checkCallbacks()...)
+	return errs
+}
+
+// builtinDefault is used as the catch-all when the config names no default.
+var builtinDefault = profile{Name: "default", Layout: layoutMirror}
+
+// defaultProfile returns the profile applied when nothing else matches.
+func (c *config) defaultProfile() *profile {
+	if p := c.profile(c.Default); p != nil {
+		return p
+	}
+	if c.Mode != "" {
+		return &profile{Name: builtinDefault.Name, Layout: c.Mode}
+	}
+	return &builtinDefault
+}
+
+// lookup returns the named profile, or the built-in layout of that name,
+// "mirror", "extend", "external-only" or "internal-only", when no profile
+// takes it.
+func (c *config) lookup(name string) *profile {
+	if p := c.profile(name); p != nil {
+		return p
+	}
+	switch name {
+	case layoutMirror, layoutExtend, layoutExternalOnly, layoutInternalOnly:
+		return &profile{Name: name, Layout: name}
+	}
+	return nil
+}
+
+func (c *config) profile(name string) *profile {
+	for i := range c.Profiles {
+		if c.Profiles[i].Name == name {
+			return &c.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// loadProfileDir reads one profile per *.json file in dir, in name order. A
+// profile's name defaults to its file name without the extension.
+func loadProfileDir(dir string) ([]profile, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var profiles []profile
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		p := profile{file: f}
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, jsonError(f, data, err)
+		}
+		if p.Name == "" {
+			p.Name = strings.TrimSuffix(filepath.Base(f), ".json")
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// jsonError adds the line and column to JSON decoding errors that carry an
+// offset into the file.
+func jsonError(file string, data []byte, err error) error {
+	var off int64
+	var syn *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syn):
+		off = syn.Offset
+	case errors.As(err, &typ):
+		off = typ.Offset
+	default:
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	line, col := lineCol(data, int(off))
+	return fmt.Errorf("%s:%d:%d: %w", file, line, col, err)
+}
+
+// lineCol converts a byte offset in data to a 1-based line and column.
+func lineCol(data []byte, off int) (int, int) {
+	off = min(off, len(data))
+	before := data[:off]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := off - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
@@ -0,0 +1,37 @@
+package randr
+
+import "context"
+
+// Policies for outputs xrandr reports in "unknown connection" state, from
+// unknown_connection. Some drivers report it for connectors they cannot
+// detect a monitor on, such as VGA behind some docks or virtual outputs.
+const (
+	// unknownProbe counts the output as connected when it shows signs of a
+	// monitor: an EDID or modes.
+	unknownProbe = "probe"
+	// unknownConnected and unknownDisconnected take the output as one or
+	// the other.
+	unknownConnected    = "connected"
+	unknownDisconnected = "disconnected"
+)
+
+// resolveUnknown decides whether the outputs in unknown connection state
+// count as connected.
+func resolveUnknown(ctx context.Context, outputs []output) {
+	policy := settingsFrom(ctx).unknownConnection
+	for i := range outputs {
+		o := &outputs[i]
+		if !o.UnknownConnection {
+			continue
+		}
+		switch policy {
+		case unknownConnected:
+			o.Connected = true
+		case unknownDisconnected:
+			o.Connected = false
+		default:
+			o.Connected = o.Monitor.Vendor != "" || len(o.Resolutions) > 0
+		}
+		tracef(ctx, "%s: unknown connection, taken as connected=%t", o.Name, o.Connected)
+	}
+}
@@ -0,0 +1,363 @@
+package randr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// controlTimeout bounds a single exchange on the control socket.
+const controlTimeout = 2 * time.Second
+
+// arrangeTimeout bounds an exchange that applies a layout, which waits for
+// the layout to be verified.
+const arrangeTimeout = 30 * time.Second
+
+// daemonStatus is the daemon's in-memory view, served on the control socket.
+type daemonStatus struct {
+	// Outputs are the outputs as of the last successful query.
+	Outputs []output `json:"outputs"`
+	// Profile is the profile of the last applied layout; Matched is the
+	// profile for the connected monitors now, which is the default profile
+	// when Default is set.
+	Profile string `json:"profile,omitempty"`
+	Matched string `json:"matched"`
+	Default bool   `json:"default,omitempty"`
+	// Dock is the configured docking station that is attached, if any.
+	Dock   string `json:"dock,omitempty"`
+	Paused bool   `json:"paused,omitempty"`
+	// Pending is set while a manual layout change is waiting out
+	// learnDelay before it is learned.
+	Pending bool `json:"pending,omitempty"`
+	// Until is when the temporary layout in place is undone.
+	Until time.Time `json:"until,omitzero"`
+	// Failures counts consecutive failed xrandr queries.
+	Failures       int       `json:"failures,omitempty"`
+	LastAction     string    `json:"last_action,omitempty"`
+	LastActionTime time.Time `json:"last_action_time,omitzero"`
+	LastError      string    `json:"last_error,omitempty"`
+	LastErrorTime  time.Time `json:"last_error_time,omitzero"`
+
+	// profiles are the configured profiles, for the HTTP API, and checks
+	// the outcome of their check commands when last planned.
+	profiles []profile
+	checks   *checks
+	// stats are answered to the "stats" command only.
+	stats daemonStats
+}
+
+// statusBoard holds the latest daemonStatus for the control server.
+type statusBoard struct {
+	mu sync.Mutex
+	s  daemonStatus
+	// changed is closed by the next set, for watchers.
+	changed chan struct{}
+}
+
+func (b *statusBoard) set(s daemonStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.s = s
+	if b.changed != nil {
+		close(b.changed)
+		b.changed = nil
+	}
+}
+
+// watch returns the status and a channel closed when it is next set.
+func (b *statusBoard) watch() (daemonStatus, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.changed == nil {
+		b.changed = make(chan struct{})
+	}
+	return b.s, b.changed
+}
+
+func (b *statusBoard) get() daemonStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.s
+}
+
+// controlRequest and controlResponse are exchanged as one JSON line each.
+// The "status" command asks for the daemon's status, "arrange" has it apply
+// Layout as a manual change, "apply" has it apply Profile, and "cycle" the
+// next applicable profile. "undo", or "revert", has it put back the layout
+// before its last change, and "redo" the one it last undid. "watch" keeps
+// the connection open and answers with the status again whenever it
+// changes, and "stats" asks for the daemon's statistics.
+type controlRequest struct {
+	Command string `json:"command"`
+	Layout  layout `json:"layout,omitempty"`
+	// Profile is the profile "apply" applies, undone again after For if
+	// that is set.
+	Profile string   `json:"profile,omitempty"`
+	For     duration `json:"for,omitempty"`
+}
+
+type controlResponse struct {
+	Status *daemonStatus `json:"status,omitempty"`
+	Stats  *daemonStats  `json:"stats,omitempty"`
+	// Profile is the profile "apply", "cycle", "undo" or "redo" applied.
+	Profile string `json:"profile,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// listenControl opens the control socket at path and serves it until ctx is
+// cancelled. A socket left behind by a dead daemon is replaced; one a live
+// daemon answers on is an error, so two daemons never fight over the
+// displays. Layouts sent to it are handed to the daemon loop with send.
+func listenControl(ctx context.Context, path string, board *statusBoard,
+	send func(context.Context, command) (string, error)) error {
+	if conn, err := net.DialTimeout("unix", path, controlTimeout); err == nil {
+		conn.Close()
+		return fmt.Errorf("already running (%s)", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	os.Remove(path)
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveControl(ctx, conn, board, send)
+		}
+	}()
+	debugf(ctx, "control socket: %s", path)
+	return nil
+}
+
+func serveControl(ctx context.Context, conn net.Conn, board *statusBoard,
+	send func(context.Context, command) (string, error)) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req controlRequest
+	var resp controlResponse
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	switch {
+	case err != nil:
+		return
+	case json.Unmarshal(line, &req) != nil:
+		resp.Error = "malformed request"
+	case req.Command == "status":
+		s := board.get()
+		resp.Status = &s
+	case req.Command == "stats":
+		s := board.get().stats
+		resp.Stats = &s
+	case req.Command == "arrange" && len(req.Layout) > 0:
+		conn.SetDeadline(time.Now().Add(arrangeTimeout))
+		if _, err := send(ctx, command{layout: req.Layout}); err != nil {
+			resp.Error = err.Error()
+		}
+	case req.Command == "apply" && req.Profile != "":
+		conn.SetDeadline(time.Now().Add(arrangeTimeout))
+		profile, err := send(ctx, command{profile: req.Profile, ttl: time.Duration(req.For)})
+		resp.Profile = profile
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	case req.Command == "cycle":
+		conn.SetDeadline(time.Now().Add(arrangeTimeout))
+		profile, err := send(ctx, command{cycle: true})
+		resp.Profile = profile
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	case req.Command == "undo" || req.Command == "redo" || req.Command == "revert":
+		conn.SetDeadline(time.Now().Add(arrangeTimeout))
+		step := req.Command
+		if step == "revert" {
+			step = "undo"
+		}
+		profile, err := send(ctx, command{step: step})
+		resp.Profile = profile
+		if err != nil {
+			resp.Error = err.Error()
+		}
+	case req.Command == "watch":
+		conn.SetDeadline(time.Time{})
+		watchStatus(ctx, conn, board)
+		return
+	default:
+		resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		debugf(ctx, "control: %v", err)
+	}
+}
+
+// watchStatus writes the status to conn, and again whenever it changes,
+// until the watcher goes away or ctx is cancelled.
+func watchStatus(ctx context.Context, conn net.Conn, board *statusBoard) {
+	// The watcher sends nothing more; reading notices it going away.
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	// The daemon publishes after every poll; only changes are sent.
+	var last []byte
+	enc := json.NewEncoder(conn)
+	for {
+		s, changed := board.watch()
+		if data, _ := json.Marshal(s); !bytes.Equal(data, last) {
+			if err := enc.Encode(controlResponse{Status: &s}); err != nil {
+				return
+			}
+			last = data
+		}
+		select {
+		case <-changed:
+		case <-gone:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// errNotRunning is returned by queryDaemon when no daemon is listening.
+var errNotRunning = errors.New("randr daemon is not running")
+
+// queryDaemon asks the daemon listening on path for its status.
+func queryDaemon(ctx context.Context, path string) (*daemonStatus, error) {
+	resp, err := callDaemon(ctx, path, controlRequest{Command: "status"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status == nil {
+		return nil, errors.New("control: empty response")
+	}
+	return resp.Status, nil
+}
+
+// arrangeDaemon has the daemon listening on path apply the layout.
+func arrangeDaemon(ctx context.Context, path string, l layout) error {
+	_, err := callDaemon(ctx, path, controlRequest{Command: "arrange", Layout: l})
+	return err
+}
+
+// applyDaemon has the daemon listening on path apply the named profile, and
+// undo it again after ttl unless that is zero.
+func applyDaemon(ctx context.Context, path, name string, ttl time.Duration) (string, error) {
+	resp, err := callDaemon(ctx, path, controlRequest{Command: "apply", Profile: name, For: duration(ttl)})
+	if err != nil {
+		return "", err
+	}
+	return resp.Profile, nil
+}
+
+// cycleDaemon has the daemon listening on path apply the next applicable
+// profile, and returns its name.
+func cycleDaemon(ctx context.Context, path string) (string, error) {
+	resp, err := callDaemon(ctx, path, controlRequest{Command: "cycle"})
+	if err != nil {
+		return "", err
+	}
+	return resp.Profile, nil
+}
+
+// stepDaemon has the daemon listening on path undo or redo a layout
+// change, as step says, and returns the profile the layout it put back came
+// from, if any.
+func stepDaemon(ctx context.Context, path, step string) (string, error) {
+	resp, err := callDaemon(ctx, path, controlRequest{Command: step})
+	if err != nil {
+		return "", err
+	}
+	return resp.Profile, nil
+}
+
+// watchDaemon streams the status of the daemon listening on path, sending
+// it whenever it changes. The channel is closed when the daemon goes away
+// or ctx is cancelled.
+func watchDaemon(ctx context.Context, path string) (<-chan daemonStatus, error) {
+	d := net.Dialer{Timeout: controlTimeout}
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, errNotRunning
+	}
+	if err := json.NewEncoder(conn).Encode(controlRequest{Command: "watch"}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	ch := make(chan daemonStatus)
+	go func() {
+		defer close(ch)
+		defer close(done)
+		defer conn.Close()
+		dec := json.NewDecoder(conn)
+		for {
+			var resp controlResponse
+			if err := dec.Decode(&resp); err != nil || resp.Status == nil {
+				return
+			}
+			select {
+			case ch <- *resp.Status:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// callDaemon sends one request to the daemon listening on path and reads
+// its response.
+func callDaemon(ctx context.Context, path string, req controlRequest) (*controlResponse, error) {
+	d := net.Dialer{Timeout: controlTimeout}
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, errNotRunning
+	}
+	defer conn.Close()
+	timeout := controlTimeout
+	if req.Command == "arrange" || req.Command == "apply" || req.Command == "cycle" ||
+		req.Command == "undo" || req.Command == "redo" || req.Command == "revert" {
+		timeout = arrangeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var resp controlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("control: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("control: %s", resp.Error)
+	}
+	return &resp, nil
+}
@@ -0,0 +1,178 @@
+package randr
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// cycleConfig tunes the cycle commands.
+type cycleConfig struct {
+	// Resolutions are the resolutions cycle-resolution steps through, in
+	// order, instead of all the output's modes; ones the output lacks are
+	// skipped.
+	Resolutions []string `json:"resolutions,omitempty"`
+}
+
+// activeOutput returns the named output, or the primary one if name is
+// empty, which must be lit.
+func activeOutput(outputs []output, name string) (output, error) {
+	for _, o := range connectedOutputs(outputs) {
+		if name != "" && o.Name != name || name == "" && !o.Primary {
+			continue
+		}
+		if !o.active() {
+			return o, fmt.Errorf("%s is off", o.Name)
+		}
+		return o, nil
+	}
+	if name == "" {
+		return output{}, errors.New("no primary output; name one")
+	}
+	return output{}, fmt.Errorf("unknown output %q", name)
+}
+
+// nextResolution returns the resolution after the output's current one,
+// among the shortlist if there is one, wrapping around.
+func nextResolution(o output, shortlist []resolution) (resolution, bool) {
+	modes := o.Resolutions
+	if len(shortlist) > 0 {
+		modes = slices.DeleteFunc(slices.Clone(shortlist), func(r resolution) bool {
+			return !slices.Contains(o.Resolutions, r)
+		})
+	}
+	if len(modes) == 0 {
+		return resolution{}, false
+	}
+	i := slices.Index(modes, o.Resolutions[o.Current])
+	return modes[(i+1)%len(modes)], true
+}
+
+// nextRate returns the refresh rate below the output's current one for its
+// current resolution, wrapping around to the highest.
+func nextRate(ctx context.Context, o output) (float64, bool) {
+	t := settingsFrom(ctx).rates
+	if o.Current >= len(o.Rates) {
+		return 0, false
+	}
+	rates := slices.Clone(o.Rates[o.Current])
+	slices.SortFunc(rates, func(a, b float64) int { return cmp.Compare(b, a) })
+	// Rates within the tolerance, such as 60.00 and 59.94, count as one, as
+	// they do when checking a layout took effect.
+	rates = slices.CompactFunc(rates, t.sameRate)
+	if len(rates) < 2 {
+		return 0, false
+	}
+	for _, r := range rates {
+		if r < o.Rate && !t.sameRate(r, o.Rate) {
+			return r, true
+		}
+	}
+	return rates[0], true
+}
+
+// reshape returns the layout giving the active output a new state. The
+// outputs beyond its right or bottom edge move along by the change in its
+// size, so extended screens stay side by side.
+func reshape(outputs []output, name string, st outputState) layout {
+	cur := currentLayout(outputs)
+	from := cur[name]
+	dw, dh := st.size().W-from.size().W, st.size().H-from.size().H
+	l := layout{{Name: name, outputState: st}}
+	for _, o := range connectedOutputs(outputs) {
+		other := cur[o.Name]
+		if o.Name == name || other.Off {
+			continue
+		}
+		moved := other
+		if other.X >= from.X+from.size().W {
+			moved.X += dw
+		}
+		if other.Y >= from.Y+from.size().H {
+			moved.Y += dh
+		}
+		if moved != other {
+			l = append(l, outputConfig{Name: o.Name, outputState: moved})
+		}
+	}
+	return l
+}
+
+// runCycleResolution switches the named output, or the primary one, to its
+// next resolution: through the running daemon, which treats it as a manual
+// change, or with xrandr directly.
+func runCycleResolution(ctx context.Context, dirs paths, name string) error {
+	cfg, err := loadConfig(dirs)
+	if err != nil {
+		return err
+	}
+	ctx = withSettings(ctx, cfg.settings())
+	outputs, err := listOutputs(ctx, dirs)
+	if err != nil {
+		return err
+	}
+	o, err := activeOutput(outputs, name)
+	if err != nil {
+		return err
+	}
+	var shortlist []resolution
+	for _, s := range cfg.Cycle.Resolutions {
+		res, _ := parseResolution(s)
+		shortlist = append(shortlist, res)
+	}
+	res, ok := nextResolution(o, shortlist)
+	if !ok {
+		return fmt.Errorf("%s has none of the cycle resolutions", o.Name)
+	}
+	st := currentLayout(outputs)[o.Name]
+	st.Mode, st.Rate, st.ScaleFrom = res, 0, resolution{}
+	fmt.Printf("%s: %s\n", o.Name, res)
+	return applyCycle(ctx, dirs, reshape(outputs, o.Name, st))
+}
+
+// runCycleRate switches the named output, or the primary one, to the next
+// lower refresh rate of its current resolution, from the lowest back to the
+// highest, like runCycleResolution.
+func runCycleRate(ctx context.Context, dirs paths, name string) error {
+	cfg, err := loadConfig(dirs)
+	if err != nil {
+		return err
+	}
+	ctx = withSettings(ctx, cfg.settings())
+	outputs, err := listOutputs(ctx, dirs)
+	if err != nil {
+		return err
+	}
+	o, err := activeOutput(outputs, name)
+	if err != nil {
+		return err
+	}
+	rate, ok := nextRate(ctx, o)
+	if !ok {
+		return fmt.Errorf("%s has only one refresh rate at %s", o.Name, o.Resolutions[o.Current])
+	}
+	st := currentLayout(outputs)[o.Name]
+	st.Rate = rate
+	fmt.Printf("%s: %s at %.2fHz\n", o.Name, st.Mode, rate)
+	return applyCycle(ctx, dirs, layout{{Name: o.Name, outputState: st}})
+}
+
+// applyCycle applies a layout made by a cycle command.
+func applyCycle(ctx context.Context, dirs paths, l layout) error {
+	err := arrangeDaemon(ctx, dirs.socket(), l)
+	if !errors.Is(err, errNotRunning) {
+		return err
+	}
+	outputs, scr, err := parseXrandr(ctx)
+	if err != nil {
+		return err
+	}
+	p := newPlan(ctx, "cycle", l, outputs)
+	if err := p.validate(scr); err != nil {
+		return err
+	}
+	b := xrandrBackend{}
+	return applyVerified(ctx, newExecutor(b, settingsFrom(ctx)), b, p, &daemonState{})
+}
@@ -0,0 +1,713 @@
+package randr
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Backend is how randr talks to the display server. The default runs the
+// xrandr binary; tests and embedders can substitute their own.
+type Backend interface {
+	// Query returns the output of `xrandr --query --prop`.
+	Query(ctx context.Context) ([]byte, error)
+	// Configure changes the layout; args are xrandr arguments.
+	Configure(ctx context.Context, args []string) error
+}
+
+// xrandrBackend runs the xrandr binary selected by the config, or with
+// "apply": "protocol" has protocolBackend stand in for it.
+type xrandrBackend struct{}
+
+func (xrandrBackend) Query(ctx context.Context) ([]byte, error) {
+	if useProtocol(ctx) {
+		return protocolBackend{}.Query(ctx)
+	}
+	return runXrandr(ctx, true, []string{"--query", "--prop"}, nil)
+}
+
+func (xrandrBackend) Configure(ctx context.Context, args []string) error {
+	if useProtocol(ctx) {
+		return protocolBackend{}.Configure(ctx, args)
+	}
+	return xrandr(ctx, args...)
+}
+
+// coalesceWindow is how long a trigger, such as the accelerometer turning,
+// waits for others before the outputs are queried, so a burst of them is
+// handled in a single poll.
+const coalesceWindow = 250 * time.Millisecond
+
+// Policy is the layout used for monitor sets no profile matches.
+type Policy string
+
+const (
+	Mirror Policy = layoutMirror
+	Extend Policy = layoutExtend
+)
+
+// options are the settings shared by Daemon and Watcher.
+type options struct {
+	backend  Backend
+	interval time.Duration
+	policy   Policy
+	logger   *log.Logger
+}
+
+// Option configures a Daemon or Watcher.
+type Option func(*options)
+
+// WithBackend replaces the xrandr binary as the way to query and configure
+// the outputs.
+func WithBackend(b Backend) Option {
+	return func(o *options) {
+		o.backend = b
+	}
+}
+
+// WithPollInterval sets how often the outputs are queried, overriding the
+// config's poll_interval. The default is two seconds.
+func WithPollInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithPolicy sets the layout for monitor sets no profile matches,
+// overriding the config's mode. It has no effect on a Watcher.
+func WithPolicy(p Policy) Option {
+	return func(o *options) {
+		o.policy = p
+	}
+}
+
+// WithLogger sends the logging of the Daemon or Watcher to l instead of the
+// standard logger.
+func WithLogger(l *log.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{backend: xrandrBackend{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Daemon arranges the displays according to the profiles in its config
+// files as monitors come and go.
+type Daemon struct {
+	options
+	dirs     paths
+	status   statusBoard
+	commands chan command
+}
+
+// NewDaemon returns a daemon reading its config, profiles and state from the
+// XDG base directories, as the randr command does by default.
+func NewDaemon(opts ...Option) *Daemon {
+	return &Daemon{options: newOptions(opts), dirs: defaultPaths(), commands: make(chan command)}
+}
+
+// command asks the running daemon to apply a profile: the named one, or for
+// cycle the next one applicable to the connected monitors; to apply a
+// layout directly; or to step through the layouts it applied, as step
+// "undo" or "redo" says. A profile applied for a ttl is undone after it.
+// The outcome is sent on reply.
+type command struct {
+	cycle   bool
+	step    string
+	profile string
+	ttl     time.Duration
+	layout  layout
+	reply   chan commandResult
+}
+
+type commandResult struct {
+	profile string
+	err     error
+}
+
+// send hands a command to the daemon loop and waits for the outcome.
+func (d *Daemon) send(ctx context.Context, c command) (string, error) {
+	c.reply = make(chan commandResult, 1)
+	select {
+	case d.commands <- c:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	select {
+	case r := <-c.reply:
+		return r.profile, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// ask asks how to use newly connected monitors, remembers the answer for
+// them and applies the layout picked.
+func (d *Daemon) ask(ctx context.Context, dirs paths, monitors []output) {
+	answer, err := askLayout(ctx, monitors)
+	if err != nil {
+		logf(ctx, "ask: %v", err)
+		return
+	}
+	if answer == "" {
+		return
+	}
+	infof(ctx, "ask: %s picked for %s", answer, monitors[0].id())
+	rememberChoice(ctx, dirs.choices(), monitors, answer)
+	if answer == choiceIgnore {
+		return
+	}
+	if _, err := d.send(ctx, command{profile: answer}); err != nil {
+		logf(ctx, "ask: %v", err)
+	}
+}
+
+// setup applies the config, then the daemon's own options over it, to the
+// settings ctx carries, keeping the state they hold.
+func (d *Daemon) setup(ctx context.Context, cfg *config) {
+	setSettings(ctx, settingsFrom(ctx).carry(d.over(cfg.settings())))
+	if d.policy != "" {
+		cfg.Mode = string(d.policy)
+	}
+}
+
+// Run applies layouts as monitors come and go until ctx is cancelled.
+func (d *Daemon) Run(ctx context.Context) error {
+	ctx = withSettings(ctx, d.over(defaultSettings()))
+	dirs := d.dirs
+
+	cfg, err := loadConfig(dirs)
+	if err != nil {
+		return err
+	}
+	d.setup(ctx, cfg)
+	setupTracing(ctx)
+	if err := listenControl(ctx, dirs.socket(), &d.status, d.send); err != nil {
+		return err
+	}
+	if cfg.HTTP != "" {
+		if err := d.serveHTTP(ctx, cfg.HTTP); err != nil {
+			return err
+		}
+	}
+	if cfg.GRPC != "" {
+		if err := d.serveGRPC(ctx, cfg.GRPC); err != nil {
+			return err
+		}
+	}
+	if cfg.MQTT != nil {
+		go d.runMQTT(ctx, *cfg.MQTT)
+	}
+	recordHistory(ctx, dirs.history())
+	l := &loop{d: d, dirs: dirs, cfg: cfg, cb: newCallbacks(cfg), modeless: make(map[string]int)}
+	infof(ctx, "randr: watching for monitor changes...")
+	debugf(ctx, "paths: %+v", dirs)
+
+	l.cards = drmCards()
+	l.relink(ctx)
+
+	prev, scr, err := queryOutputs(ctx, d.backend)
+	if err != nil {
+		return err
+	}
+	// Outputs unplugged without being switched off, as happens around
+	// unclean shutdowns, keep the screen larger than what is visible.
+	if z := zombieOutputs(prev); len(z) > 0 {
+		infof(ctx, "startup: switching off %s, disconnected but still driving a CRTC", strings.Join(z, ", "))
+		if err := l.ex.apply(ctx, (&plan{Reason: "switch off disconnected outputs"}).off(prev, z)); err != nil {
+			logf(ctx, "startup: %v", err)
+		} else if prev, scr, err = queryOutputs(ctx, d.backend); err != nil {
+			return err
+		}
+	}
+	l.prev, l.prevSet, l.scr = prev, connectedSet(prev), scr
+	// The layout before randr changes anything, put back on exit if so
+	// configured.
+	startup, startupFingerprint := snapshotLayout(prev), fingerprint(connectedOutputs(prev))
+
+	l.st, err = loadState(dirs.state())
+	if err != nil {
+		logf(ctx, "state: %v", err)
+		l.st = &daemonState{}
+	}
+	st := l.st
+	l.stats = daemonStats{Started: time.Now()}
+
+	// Starting with only the internal panel lit, its configuration is the
+	// baseline to go back to; otherwise the saved one stays.
+	if b := baseline(prev); len(b) > 0 {
+		st.Baseline = b
+	}
+	l.pl = &planner{cfg: cfg, baseline: st.Baseline}
+
+	// Reconcile the displays with the configuration at startup, so booting
+	// already docked gets the right layout, unless this is a restart and
+	// randr's or the user's layout is still in place.
+	sameSet := st.Fingerprint == fingerprint(connectedOutputs(prev))
+	switch {
+	case sameSet && st.Paused:
+		infof(ctx, "layout was changed by hand before restart, leaving it alone")
+		l.manual = true
+	case sameSet && len(st.Layout) > 0 && len(newPlan(ctx, "", st.Layout, prev).delta()) == 0:
+		infof(ctx, "last applied layout (profile %q) still active", st.Profile)
+	default:
+		infof(ctx, "startup: %d monitor(s) connected, reconciling layout", len(l.prevSet))
+		sctx, sp := startSpan(ctx, "startup")
+		sp.finish(l.apply(sctx, l.pl.connected(sctx, prev), hookEnv{Event: "startup"}))
+	}
+	st.Fingerprint = fingerprint(connectedOutputs(prev))
+	st.Paused = l.manual
+	l.saveState(ctx)
+
+	l.timer = time.NewTimer(settingsFrom(ctx).pollInterval)
+	defer l.timer.Stop()
+	l.bo = backoff{base: settingsFrom(ctx).pollInterval}
+
+	// The accelerometer's orientation decides the internal panel's
+	// rotation; changes go through the same planning as hotplugs.
+	var orientation <-chan string
+	if cfg.Rotation.Sensor {
+		orientation = watchOrientation(ctx)
+	}
+	l.tablet, _ = tabletMode(ctx)
+	l.onAC, _ = acState()
+	l.switchAt = cfg.nextSwitch(time.Now())
+
+	// Power supply events can change what docks offer, even when the
+	// power source stays the same, so they re-plan too.
+	supply, err := watchPowerSupply(ctx)
+	if err != nil {
+		debugf(ctx, "power supply changes will only be noticed by polling: %v", err)
+	}
+
+	changes, err := watchDirs(ctx, filepath.Dir(dirs.Config), dirs.Profiles, dirs.Data)
+	if err != nil {
+		logf(ctx, "config changes will not be picked up: %v", err)
+	}
+
+	for {
+		l.publish(ctx)
+		select {
+		case <-ctx.Done():
+			infof(ctx, "randr: shutting down")
+			if l.cfg.RestoreOnExit {
+				d.restoreStartup(ctx, l.ex, startup, startupFingerprint)
+			}
+			return nil
+		case <-changes:
+			// Let the editor finish writing before reading.
+			select {
+			case <-time.After(reloadDelay):
+			case <-ctx.Done():
+				continue
+			}
+			l.reload(ctx)
+		case r, ok := <-orientation:
+			if !ok {
+				orientation = nil
+				continue
+			}
+			infof(ctx, "orientation: %s", r)
+			l.turned, l.reoriented = r, true
+			l.rotatePanel(ctx)
+			l.wake(coalesceWindow)
+		case <-supply:
+			debugf(ctx, "power supply changed, querying in %s", powerSupplyDelay)
+			l.supplied = true
+			l.wake(powerSupplyDelay)
+		case c := <-d.commands:
+			l.command(ctx, c)
+		case <-l.timer.C:
+			if err := l.poll(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// loop is the state of a running daemon, owned by the goroutine in Run.
+type loop struct {
+	d    *Daemon
+	dirs paths
+	cfg  *config
+	cb   *callbacks
+	pl   *planner
+	ex   executor
+	st   *daemonState
+
+	stats       daemonStats
+	lastErr     error
+	lastErrTime time.Time
+
+	// prev are the outputs as of the last poll, prevSet the connected
+	// ones, and scr the screen.
+	prev    []output
+	prevSet map[string]bool
+	scr     screen
+	// cards is the number of DRM devices; USB display adapters and
+	// secondary GPUs only show their outputs once the main GPU feeds
+	// them, so they are linked again whenever a device comes or goes.
+	cards int
+
+	// chosen is the profile explicitly requested over the control
+	// interfaces; it replaces the matching profile until the connected
+	// set changes.
+	chosen string
+	// manual is set once the layout was changed outside randr; it
+	// suppresses automatic re-application until the connected set
+	// changes.
+	manual bool
+	// blankingOff is set while a presentation has screen blanking off.
+	blankingOff bool
+	// deferred is the event of a layout change put off until the screen
+	// is unlocked.
+	deferred *hookEnv
+
+	timer *time.Timer
+	bo    backoff
+	// wakeAt is when the timer fires early for the triggers since the
+	// last poll; later triggers ride along rather than put it off, and the
+	// first one sooner than it brings it forward.
+	wakeAt time.Time
+	// pre lets polls skip the query while the connectors are unchanged;
+	// any layout change makes the next poll query again.
+	pre precheck
+	// lastSum is the checksum of the query output the last poll compared.
+	lastSum  uint64
+	learn    learner
+	modeless map[string]int
+
+	// turned is the sensor's last orientation; with tablet_only it only
+	// rotates the panel in tablet mode. reoriented is set when it turned
+	// since the last poll, and supplied when a power supply changed.
+	turned     string
+	reoriented bool
+	supplied   bool
+	tablet     bool
+	onAC       bool
+	// switchAt is when the next time window of a profile or rule opens or
+	// closes, or the day changes for ones limited to some days. Polls
+	// compare it to the wall clock, which unlike a timer keeps counting
+	// while the machine is suspended.
+	switchAt time.Time
+}
+
+func (l *loop) saveState(ctx context.Context) {
+	if err := l.st.save(l.dirs.state()); err != nil {
+		logf(ctx, "state: %v", err)
+	}
+}
+
+func (l *loop) fail(ctx context.Context, err error) {
+	l.lastErr, l.lastErrTime = err, time.Now()
+	l.stats.Failures++
+	emit(ctx, errorEvent(err))
+}
+
+// relink feeds the outputs of USB display adapters and secondary GPUs from
+// the main GPU, and picks the executor that suits the providers. It runs
+// whenever a DRM device comes or goes, and before every layout change, as a
+// suspend can lose the links without a device coming or going.
+func (l *loop) relink(ctx context.Context) {
+	var sinks []provider
+	if l.cfg.Providers != "off" {
+		var err error
+		if sinks, err = linkProviders(ctx, l.d.backend, l.cfg.Providers); err != nil {
+			logf(ctx, "providers: %v", err)
+		}
+	}
+	s := *settingsFrom(ctx)
+	s.settleDelay = verifyDelay
+	if len(sinks) > 0 {
+		s.settleDelay = providerSettleDelay
+	}
+	setSettings(ctx, &s)
+	l.ex = newExecutor(l.d.backend, &s)
+	if l.cfg.reversePrime(sinks) {
+		l.ex = sequencedExecutor{l.d.backend}
+	}
+}
+
+// apply applies the plan and everything that goes with a layout change:
+// hooks, windows, touch input, virtual monitors, feedback and events. The
+// change is put off while the screen is locked, if the locker needs that.
+func (l *loop) apply(ctx context.Context, p *plan, ev hookEnv) (err error) {
+	cfg, st := l.cfg, l.st
+	ctx, sp := startSpan(ctx, "apply")
+	defer func() { sp.finish(err) }()
+	l.pre.reset()
+	sp.keep()
+	sp.set("reason", p.Reason)
+	sp.set("profile", p.Profile)
+	locked := cfg.Locker.locked(ctx)
+	if locked && cfg.Locker.Notify == "" {
+		infof(ctx, "screen is locked, applying %s once it is unlocked", p.Reason)
+		l.deferred = &ev
+		return nil
+	}
+	l.deferred = nil
+	infof(ctx, "applying %s", p.Reason)
+	if err := p.validate(l.scr); err != nil {
+		logf(ctx, "refusing layout: %v", err)
+		l.fail(ctx, err)
+		cfg.Feedback.run(ctx, "failed", "RANDR_ERROR="+err.Error())
+		st.action("refused %s: %v", p.Reason, err)
+		l.saveState(ctx)
+		return err
+	}
+	// Arrangements only name the outputs they move.
+	if cfg.DPMS.OffUnused && ev.Event != "arrange" {
+		p = p.offUnused(ctx)
+	}
+	pre, post := cfg.hooks(p.Profile)
+	changed := len(p.delta()) > 0
+	if !changed {
+		pre, post = nil, nil
+	} else {
+		l.relink(ctx)
+	}
+	runHooks(ctx, "pre", pre, ev.vars("pre", p))
+	// Windows are remembered for the profile being left and put back
+	// for the one coming back.
+	switched := changed && cfg.Windows.Remember && p.Profile != st.Profile
+	if switched && st.Profile != "" {
+		rememberWindows(ctx, l.dirs.windows(), st.Profile)
+	}
+	started := time.Now()
+	if err := applyVerified(ctx, l.ex, l.d.backend, p, st); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		logf(ctx, "apply failed: %v", err)
+		l.fail(ctx, err)
+		cfg.Feedback.run(ctx, "failed", "RANDR_ERROR="+err.Error())
+		st.action("failed to apply %s: %v", p.Reason, err)
+		l.saveState(ctx)
+		return err
+	}
+	if changed {
+		l.stats.Applied++
+		l.stats.ApplyTime += time.Since(started)
+	}
+	if changed && locked {
+		cfg.Locker.notify(ctx)
+	}
+	if changed && len(cfg.Rotation.Touch) > 0 {
+		if i := slices.IndexFunc(p.layout(), func(c outputConfig) bool {
+			return !c.Off && (output{Name: c.Name}).internal()
+		}); i >= 0 {
+			mapTouch(ctx, cfg.Rotation.Touch, p.layout()[i].Name)
+		}
+	}
+	if switched && p.Profile != "" {
+		restoreWindows(ctx, l.dirs.windows(), p.Profile)
+	}
+	if changed && cfg.Windows.Rescue {
+		if outputs, _, err := queryOutputs(ctx, l.d.backend); err == nil {
+			rescueWindows(ctx, outputs)
+		}
+	}
+	if changed && cfg.Compositor.Name != "" {
+		go cfg.Compositor.refresh(ctx)
+	}
+	if changed && cfg.DPMS.Wake {
+		if err := wakeMonitors(ctx); err != nil {
+			logf(ctx, "dpms: %v", err)
+		}
+	}
+	if off := p.presentation && !cfg.Projector.KeepBlanking; off != l.blankingOff {
+		if err := setBlanking(ctx, !off); err != nil {
+			logf(ctx, "blanking: %v", err)
+		}
+		l.blankingOff = off
+	}
+	var mons []virtualMonitor
+	if prof := cfg.lookup(p.Profile); prof != nil {
+		mons = prof.monitors(p.outputs)
+	}
+	if len(mons) > 0 || len(st.Monitors) > 0 {
+		if outputs, _, err := queryOutputs(ctx, l.d.backend); err == nil {
+			st.Monitors = setMonitors(ctx, l.d.backend, st.Monitors, mons, nil, outputs)
+		}
+	}
+	if changed {
+		cfg.Feedback.run(ctx, "applied")
+	}
+	// Undoing and redoing move along the stacks instead.
+	if changed && ev.Event != "undo" && ev.Event != "redo" {
+		st.record(snapshot{Layout: p.before, Profile: st.Profile, Fingerprint: p.monitors})
+	}
+	st.Profile, st.Layout = p.Profile, p.layout()
+	st.action("applied %s", p.Reason)
+	l.saveState(ctx)
+	emit(ctx, event{Type: eventLayoutApplied, Profile: p.Profile, Reason: p.Reason, Layout: p.layout()})
+	runHooks(ctx, "post", post, ev.vars("post", p))
+	return nil
+}
+
+// target plans the layout for the outputs: the profile chosen over the
+// control interfaces, if any, else the matching one.
+func (l *loop) target(ctx context.Context, outputs []output) *plan {
+	if p := l.cfg.lookup(l.chosen); p != nil {
+		return l.pl.profile(ctx, p, outputs)
+	}
+	return l.pl.connected(ctx, outputs)
+}
+
+// publish hands the current view to the control socket.
+func (l *loop) publish(ctx context.Context) {
+	st := l.st
+	s := daemonStatus{
+		Outputs:        l.prev,
+		Profile:        st.Profile,
+		Paused:         l.manual,
+		Pending:        l.learn.pending != nil,
+		Until:          st.Until,
+		Failures:       l.bo.failures,
+		LastAction:     st.LastAction,
+		LastActionTime: st.LastActionTime,
+		profiles:       slices.Clone(l.cfg.Profiles),
+		checks:         l.pl.checks.cached(),
+		stats:          l.stats,
+	}
+	if l.bo.failures > 0 {
+		s.stats.Backoff, s.stats.Delay = l.bo.failures, l.bo.delay()
+	}
+	s.Matched, s.Default = matchedProfile(ctx, l.cfg, l.prev, s.checks)
+	s.Dock = l.cfg.currentDock(connectedOutputs(l.prev))
+	if l.lastErr != nil {
+		s.LastError, s.LastErrorTime = l.lastErr.Error(), l.lastErrTime
+	}
+	l.d.status.set(s)
+}
+
+// rotatePanel plans the internal panel with the sensor's rotation, or
+// upright out of tablet mode with tablet_only.
+func (l *loop) rotatePanel(ctx context.Context) {
+	p := settingsFrom(ctx).panel
+	if l.turned != "" && l.cfg.Rotation.TabletOnly && !l.tablet {
+		p.set("normal")
+	} else {
+		p.set(l.turned)
+	}
+}
+
+// wake has the next poll come no later than after from now.
+func (l *loop) wake(after time.Duration) {
+	at := time.Now().Add(after)
+	if l.wakeAt.IsZero() || at.Before(l.wakeAt) {
+		l.wakeAt = at
+		l.timer.Reset(after)
+	}
+}
+
+// reload reads the config again and, if it changed, plans the layout with
+// it unless the layout was arranged by hand.
+func (l *loop) reload(ctx context.Context) {
+	cfg, ok := reloadConfig(ctx, l.dirs, l.cfg)
+	if !ok {
+		return
+	}
+	l.cfg = cfg
+	l.d.setup(ctx, cfg)
+	l.pl.cfg = cfg
+	l.cb = newCallbacks(cfg)
+	l.bo.base = settingsFrom(ctx).pollInterval
+	l.switchAt = cfg.nextSwitch(time.Now())
+	if !l.manual {
+		rctx, sp := startSpan(ctx, "reload")
+		sp.finish(l.apply(rctx, l.target(rctx, l.prev), hookEnv{Event: "reload"}))
+		l.learn.reset()
+	}
+}
+
+// command carries out a command from the control interfaces and replies
+// with the outcome.
+func (l *loop) command(ctx context.Context, c command) {
+	st, prev := l.st, l.prev
+	if c.layout != nil {
+		// A hand-made arrangement is treated like a manual change: it
+		// stays until the connected set changes.
+		cctx, sp := startSpan(ctx, "arrange")
+		err := l.apply(cctx, newPlan(ctx, "custom arrangement", c.layout, prev), hookEnv{Event: "arrange"})
+		sp.finish(err)
+		l.learn.reset()
+		l.chosen, l.manual, st.Paused = "", true, true
+		st.Until, st.RevertTo = time.Time{}, nil
+		l.saveState(ctx)
+		c.reply <- commandResult{err: err}
+		return
+	}
+	if c.step != "" {
+		// Like an arrangement, the layout put back stays until the
+		// connected set changes.
+		from, to := st.stacks(c.step)
+		if len(*from) == 0 {
+			c.reply <- commandResult{err: fmt.Errorf("nothing to %s", c.step)}
+			return
+		}
+		s := (*from)[len(*from)-1]
+		if s.Fingerprint != fingerprint(connectedOutputs(prev)) {
+			c.reply <- commandResult{err: fmt.Errorf("the layout to %s is for other monitors", c.step)}
+			return
+		}
+		reason := "the layout before the last change"
+		if c.step == "redo" {
+			reason = "the layout last undone"
+		}
+		p := newPlan(ctx, reason, s.Layout, prev)
+		p.Profile, p.resize = s.Profile, true
+		left := snapshot{Layout: p.before, Profile: st.Profile, Fingerprint: p.monitors}
+		cctx, sp := startSpan(ctx, c.step)
+		err := l.apply(cctx, p, hookEnv{Event: c.step})
+		sp.finish(err)
+		if err == nil {
+			*from, *to = (*from)[:len(*from)-1], append(*to, left)
+			st.Until, st.RevertTo = time.Time{}, nil
+		}
+		l.learn.reset()
+		l.chosen, l.manual, st.Paused = "", true, true
+		l.saveState(ctx)
+		c.reply <- commandResult{profile: p.Profile, err: err}
+		return
+	}
+	p := l.cfg.lookup(c.profile)
+	if c.cycle {
+		var err error
+		if p, err = nextProfile(ctx, l.cfg, prev, cmp.Or(l.chosen, st.Profile), l.pl.begin(ctx)); err != nil {
+			c.reply <- commandResult{err: err}
+			return
+		}
+	}
+	if p == nil {
+		c.reply <- commandResult{err: fmt.Errorf("unknown profile %q", c.profile)}
+		return
+	}
+	l.chosen = p.Name
+	cctx, sp := startSpan(ctx, "command")
+	sp.set("profile", p.Name)
+	pp := l.pl.profile(ctx, p, prev)
+	left := snapshot{Layout: pp.before, Profile: st.Profile, Fingerprint: pp.monitors}
+	err := l.apply(cctx, pp, hookEnv{Event: "command"})
+	sp.finish(err)
+	l.learn.reset()
+	l.manual, st.Paused = false, false
+	st.Until, st.RevertTo = time.Time{}, nil
+	if err == nil && c.ttl > 0 {
+		st.Until, st.RevertTo = time.Now().Add(c.ttl), &left
+		infof(ctx, "%s is temporary, undoing it at %s", p.Name, st.Until.Format("15:04"))
+	}
+	l.saveState(ctx)
+	c.reply <- commandResult{profile: p.Name, err: err}
+}
@@ -0,0 +1,135 @@
+package randr
+
+import (
+	"context"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// usbDevicesDir is where USB devices are listed in sysfs.
+var usbDevicesDir = "/sys/bus/usb/devices"
+
+// dockSpec identifies a docking station, so profiles can tell docks with
+// identical monitors apart. Every field that is set must match.
+type dockSpec struct {
+	// USB is the "vendor:product" ID of a USB device built into the dock,
+	// such as its hub or network adapter, as lsusb shows it, optionally
+	// followed by ":serial".
+	USB string `json:"usb,omitempty"`
+	// Branch is the DisplayPort MST branch the dock's outputs hang off,
+	// e.g. "DP-1" for DP-1-1 and DP-1-2; patterns are allowed.
+	Branch string `json:"branch,omitempty"`
+}
+
+// present reports whether the dock is attached.
+func (d *dockSpec) present(connected []output) bool {
+	if d.Branch != "" && !hasBranch(connected, d.Branch) {
+		return false
+	}
+	if d.USB != "" && !hasUSBDevice(d.USB) {
+		return false
+	}
+	return true
+}
+
+// mstBranch returns the branch an MST output hangs off, "DP-1" for
+// "DP-1-2", or "" for outputs that are not behind a branch.
+func mstBranch(name string) string {
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 || strings.Count(name, "-") < 2 {
+		return ""
+	}
+	return name[:i]
+}
+
+func hasBranch(connected []output, want string) bool {
+	for _, o := range connected {
+		if b := mstBranch(o.Name); b != "" && matchesOutput(want, output{Name: b}) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUSBDevice reports whether a USB device with the given
+// "vendor:product[:serial]" ID is attached.
+func hasUSBDevice(id string) bool {
+	want := strings.Split(strings.ToLower(id), ":")
+	dirs, _ := filepath.Glob(filepath.Join(usbDevicesDir, "*"))
+	for _, d := range dirs {
+		attr := func(name string) string {
+			data, _ := os.ReadFile(filepath.Join(d, name))
+			return strings.ToLower(strings.TrimSpace(string(data)))
+		}
+		if len(want) < 2 || attr("idVendor") != want[0] || attr("idProduct") != want[1] {
+			continue
+		}
+		if len(want) > 2 && attr("serial") != want[2] {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// currentDock returns the name of the first configured dock that is
+// attached, in name order, or "".
+func (c *config) currentDock(connected []output) string {
+	for _, name := range slices.Sorted(maps.Keys(c.Docks)) {
+		if d := c.Docks[name]; d.present(connected) {
+			return name
+		}
+	}
+	return ""
+}
+
+// keepInternalOff returns the layout with the internal panels switched off
+// if docked_internal_off is set and an external output is to be lit. Outputs
+// the layout leaves alone are added as they are, so they move along: those
+// beyond the right or bottom edge of a panel that goes off move in to close
+// the gap, and the layout is shifted back to 0x0. The panel's primary role
+// passes to the first output still lit.
+func keepInternalOff(ctx context.Context, l layout, outputs []output) layout {
+	if !settingsFrom(ctx).dockedInternalOff {
+		return l
+	}
+	docked := slices.Clone(l)
+	cur := currentLayout(outputs)
+	for _, o := range connectedOutputs(outputs) {
+		if st := cur[o.Name]; !st.Off && !slices.ContainsFunc(l, func(c outputConfig) bool { return c.Name == o.Name }) {
+			docked = append(docked, outputConfig{Name: o.Name, outputState: st})
+		}
+	}
+	internal := func(c outputConfig) bool { return output{Name: c.Name}.internal() }
+	if !slices.ContainsFunc(docked, func(c outputConfig) bool { return !c.Off && !internal(c) }) {
+		return l
+	}
+	primary := false
+	for i, panel := range docked {
+		if panel.Off || !internal(panel) {
+			continue
+		}
+		primary = primary || panel.Primary
+		docked[i] = outputConfig{Name: panel.Name, outputState: outputState{Off: true}}
+		sz := panel.size()
+		for j, c := range docked {
+			if c.Off {
+				continue
+			}
+			if c.X >= panel.X+sz.W {
+				docked[j].X -= sz.W
+			}
+			if c.Y >= panel.Y+sz.H {
+				docked[j].Y -= sz.H
+			}
+		}
+	}
+	if primary && !slices.ContainsFunc(docked, func(c outputConfig) bool { return c.Primary && !c.Off }) {
+		docked[slices.IndexFunc(docked, func(c outputConfig) bool { return !c.Off })].Primary = true
+	}
+	docked.toOrigin()
+	return docked
+}
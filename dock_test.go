@@ -0,0 +1,89 @@
+package randr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMSTBranch(t *testing.T) {
+	for name, want := range map[string]string{
+		"DP-1-2": "DP-1", "DP-3-1-1": "DP-3-1", "DP-1": "", "eDP-1": "",
+	} {
+		if got := mstBranch(name); got != want {
+			t.Errorf("mstBranch(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// fakeUSB lists the given devices as sysfs would, each a vendor, product
+// and serial.
+func fakeUSB(t *testing.T, devices ...[3]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for i, d := range devices {
+		dev := filepath.Join(dir, "1-"+string(rune('1'+i)))
+		if err := os.Mkdir(dev, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for j, attr := range []string{"idVendor", "idProduct", "serial"} {
+			if err := os.WriteFile(filepath.Join(dev, attr), []byte(d[j]+"\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	old := usbDevicesDir
+	usbDevicesDir = dir
+	t.Cleanup(func() { usbDevicesDir = old })
+}
+
+func TestCurrentDock(t *testing.T) {
+	fakeUSB(t, [3]string{"17ef", "30b4", "1S40AY"}, [3]string{"0bda", "8153", "000001"})
+	desk := []output{{Name: "eDP-1"}, {Name: "DP-2-1"}, {Name: "DP-2-2"}}
+	for _, tc := range []struct {
+		name      string
+		dock      dockSpec
+		connected []output
+		want      bool
+	}{
+		{"usb", dockSpec{USB: "17EF:30B4"}, nil, true},
+		{"usb serial", dockSpec{USB: "17ef:30b4:1s40ay"}, nil, true},
+		{"other serial", dockSpec{USB: "17ef:30b4:1S40AZ"}, nil, false},
+		{"usb absent", dockSpec{USB: "17ef:a3c1"}, nil, false},
+		{"branch", dockSpec{Branch: "DP-2"}, desk, true},
+		{"branch pattern", dockSpec{Branch: "DP-?"}, desk, true},
+		{"branch absent", dockSpec{Branch: "DP-3"}, desk, false},
+		{"no branch", dockSpec{Branch: "DP-*"}, desk[:1], false},
+		{"both", dockSpec{USB: "0bda:8153", Branch: "DP-2"}, desk, true},
+		{"both, branch absent", dockSpec{USB: "0bda:8153", Branch: "DP-2"}, desk[:1], false},
+	} {
+		if got := tc.dock.present(tc.connected); got != tc.want {
+			t.Errorf("%s: present = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+
+	// Docks with identical monitors are told apart by their USB devices.
+	cfg := &config{Docks: map[string]dockSpec{
+		"office": {USB: "17ef:30b4", Branch: "DP-2"},
+		"home":   {USB: "17ef:a3c1", Branch: "DP-2"},
+	}}
+	if got := cfg.currentDock(desk); got != "office" {
+		t.Errorf("current dock = %q, want office", got)
+	}
+	if got := cfg.currentDock(desk[:1]); got != "" {
+		t.Errorf("current dock undocked = %q, want none", got)
+	}
+	cfg.Docks["any"] = dockSpec{Branch: "DP-*"}
+	if got := cfg.currentDock(desk); got != "any" {
+		t.Errorf("current dock = %q, want the first by name", got)
+	}
+
+	ctx := withSettings(context.Background(), defaultSettings())
+	outputs := []string{"eDP-1", "DP-*-1", "DP-*-2"}
+	home := profile{Name: "home", Outputs: outputs, Dock: "home", dock: &dockSpec{USB: "17ef:a3c1"}}
+	office := profile{Name: "office", Outputs: outputs, Dock: "office", dock: &dockSpec{USB: "17ef:30b4"}}
+	if ck := newChecks(ctx); home.matches(ctx, desk, ck) || !office.matches(ctx, desk, ck) {
+		t.Error("profiles are not told apart by their dock")
+	}
+}
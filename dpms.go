@@ -0,0 +1,35 @@
+package randr
+
+import (
+	"context"
+	"slices"
+)
+
+// dpmsConfig sets up DPMS handling around layout changes.
+type dpmsConfig struct {
+	// Wake forces the monitors on after every layout change, as some stay
+	// in standby after a mode set.
+	Wake bool `json:"wake,omitempty"`
+	// OffUnused switches off connected outputs that are lit but not part
+	// of the layout being applied, so they go to standby.
+	OffUnused bool `json:"off_unused,omitempty"`
+}
+
+// wakeMonitors forces the monitors out of DPMS standby.
+func wakeMonitors(ctx context.Context) error {
+	infof(ctx, "xset dpms force on")
+	_, err := runCommand(ctx, false, "xset", []string{"dpms", "force", "on"}, nil)
+	return err
+}
+
+// offUnused extends the plan to switch off the lit outputs its layout does
+// not name.
+func (p *plan) offUnused(ctx context.Context) *plan {
+	for _, c := range p.unused {
+		if !slices.ContainsFunc(p.Changes, func(ch change) bool { return ch.To.Name == c.To.Name }) {
+			infof(ctx, "%s is not part of the layout, switching it off", c.To.Name)
+			p.Changes = append(p.Changes, c)
+		}
+	}
+	return p
+}
@@ -0,0 +1,118 @@
+package randr
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// monitorID identifies a physical monitor by the vendor, product and serial
+// fields of its EDID, independent of the connector it is plugged into.
+type monitorID struct {
+	Vendor  string
+	Product uint16
+	Serial  string
+	Name    string
+}
+
+// String returns the fingerprint used to match monitors in profiles, e.g.
+// "DEL-41A2-7JN5C3".
+func (m monitorID) String() string {
+	if m.Vendor == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s-%04X-%s", m.Vendor, m.Product, m.Serial)
+}
+
+// parseEDID decodes the identity fields of a hex-encoded EDID block as
+// printed by `xrandr --prop`.
+func parseEDID(s string) (monitorID, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return monitorID{}, fmt.Errorf("edid: %w", err)
+	}
+	if len(b) < 128 {
+		return monitorID{}, fmt.Errorf("edid: short block (%d bytes)", len(b))
+	}
+	if string(b[:8]) != "\x00\xff\xff\xff\xff\xff\xff\x00" {
+		return monitorID{}, fmt.Errorf("edid: bad header")
+	}
+
+	// Manufacturer ID is three 5-bit letters packed big-endian.
+	v := binary.BigEndian.Uint16(b[8:10])
+	for _, l := range []uint16{v >> 10 & 0x1f, v >> 5 & 0x1f, v & 0x1f} {
+		if l < 1 || l > 26 {
+			return monitorID{}, fmt.Errorf("edid: bad manufacturer ID %04x", v)
+		}
+	}
+	id := monitorID{
+		Vendor: string([]byte{
+			byte('A' - 1 + ((v >> 10) & 0x1f)),
+			byte('A' - 1 + ((v >> 5) & 0x1f)),
+			byte('A' - 1 + (v & 0x1f)),
+		}),
+		Product: binary.LittleEndian.Uint16(b[10:12]),
+		Serial:  fmt.Sprintf("%08X", binary.LittleEndian.Uint32(b[12:16])),
+	}
+
+	// Display descriptors may carry a readable name (0xFC) and a serial
+	// string (0xFF), which is more stable than the numeric serial.
+	for off := 54; off+18 <= 126; off += 18 {
+		d := b[off : off+18]
+		if d[0] != 0 || d[1] != 0 {
+			continue
+		}
+		text := strings.TrimSpace(strings.SplitN(string(d[5:]), "\n", 2)[0])
+		switch d[3] {
+		case 0xfc:
+			id.Name = text
+		case 0xff:
+			if text != "" {
+				id.Serial = text
+			}
+		}
+	}
+	return id, nil
+}
+
+// ceaInfo holds the capabilities a monitor announces in the CEA-861
+// extension of its EDID, which televisions and most HDMI monitors carry.
+type ceaInfo struct {
+	// HDMI is set when the monitor has the HDMI vendor-specific block.
+	HDMI bool
+	// Audio is set when the monitor plays audio.
+	Audio bool
+	// Underscan is set when the monitor underscans by default.
+	Underscan bool
+}
+
+// parseCEA decodes the CEA-861 extension blocks of a hex-encoded EDID. It
+// returns the zero ceaInfo for EDIDs without one.
+func parseCEA(s string) ceaInfo {
+	var info ceaInfo
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return info
+	}
+	for ext := 128; ext+128 <= len(b); ext += 128 {
+		blk := b[ext : ext+128]
+		if blk[0] != 0x02 || blk[1] < 2 {
+			continue
+		}
+		info.Underscan = info.Underscan || blk[3]&0x80 != 0
+		info.Audio = info.Audio || blk[3]&0x40 != 0
+		// Data blocks run from byte 4 up to the offset in byte 2, each
+		// headed by a tag and a length.
+		end := min(int(blk[2]), 127)
+		for off := 4; off < end; {
+			tag, n := blk[off]>>5, int(blk[off]&0x1f)
+			if tag == 3 && n >= 3 && off+3 < len(blk) &&
+				blk[off+1] == 0x03 && blk[off+2] == 0x0c && blk[off+3] == 0x00 {
+				info.HDMI = true
+			}
+			off += 1 + n
+		}
+	}
+	return info
+}
@@ -0,0 +1,90 @@
+package randr
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// dellEDID is the base block of a Dell U2720Q, with its serial number
+// string.
+const dellEDID = "00ffffffffffff0010acb8a04c383530151e0103803c2278ee4455a9554d9d26" +
+	"0f5054a54b00714f8180a9c0d1c00101010101010101565e00a0a0a029503020" +
+	"350055502100001a000000ff003731384e5938330a2020202020000000fc0044" +
+	"454c4c205532373230510a20000000fd00314c1e5a19000a2020202020200124"
+
+func TestParseEDID(t *testing.T) {
+	for _, tc := range []struct {
+		name, edid string
+		want       monitorID
+		err        string
+	}{
+		{"descriptors", dellEDID, monitorID{Vendor: "DEL", Product: 0xa0b8, Serial: "718NY83", Name: "DELL U2720Q"}, ""},
+		{"numeric serial", dellEDID[:72*2] + "0000001000" + strings.Repeat("00", 13) + dellEDID[90*2:],
+			monitorID{Vendor: "DEL", Product: 0xa0b8, Serial: "3035384C", Name: "DELL U2720Q"}, ""},
+		{"extension ignored", dellEDID + strings.Repeat("00", 128), monitorID{Vendor: "DEL", Product: 0xa0b8, Serial: "718NY83", Name: "DELL U2720Q"}, ""},
+		{"not hex", "00ffffffffffff0g", monitorID{}, "invalid byte"},
+		{"short", dellEDID[:254], monitorID{}, "short block (127 bytes)"},
+		{"bad header", "01" + dellEDID[2:], monitorID{}, "bad header"},
+		{"bad manufacturer", dellEDID[:16] + "0000" + dellEDID[20:], monitorID{}, "bad manufacturer ID 0000"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseEDID(tc.edid)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMonitorIDString(t *testing.T) {
+	if got := (monitorID{Vendor: "DEL", Product: 0xa0b8, Serial: "718NY83"}).String(); got != "DEL-A0B8-718NY83" {
+		t.Errorf("got %q", got)
+	}
+	if got := (monitorID{}).String(); got != "" {
+		t.Errorf("unknown monitor: got %q, want none", got)
+	}
+}
+
+// ceaBlock returns a CEA-861 extension block with the flags byte and data
+// blocks given in hex.
+func ceaBlock(flags byte, blocks string) string {
+	b := make([]byte, 128)
+	data, _ := hex.DecodeString(blocks)
+	b[0], b[1], b[2], b[3] = 0x02, 0x03, byte(4+len(data)), flags
+	copy(b[4:], data)
+	return hex.EncodeToString(b)
+}
+
+func TestParseCEA(t *testing.T) {
+	// An audio block, then the HDMI vendor-specific block with its IEEE
+	// OUI 00-0C-03 and source address 1.0.0.0.
+	const audio, hdmi = "2309070783010000", "65030c001000"
+	for _, tc := range []struct {
+		name, edid string
+		want       ceaInfo
+	}{
+		{"no extension", dellEDID, ceaInfo{}},
+		{"not hex", "zz", ceaInfo{}},
+		{"television", dellEDID + ceaBlock(0xc0, audio+hdmi), ceaInfo{HDMI: true, Audio: true, Underscan: true}},
+		{"DVI monitor", dellEDID + ceaBlock(0x00, audio), ceaInfo{}},
+		{"other vendor block", dellEDID + ceaBlock(0x40, "6500d0460000"), ceaInfo{Audio: true}},
+		{"not CEA", dellEDID + strings.Repeat("f0", 128), ceaInfo{}},
+		{"truncated extension", dellEDID + ceaBlock(0xc0, hdmi)[:200], ceaInfo{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseCEA(tc.edid); got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
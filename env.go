@@ -0,0 +1,38 @@
+package randr
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// applyEnv overrides config values from RANDR_* environment variables, so
+// systemd drop-ins and kiosk containers can tune the daemon without editing
+// files.
+func (c *config) applyEnv() error {
+	if v := os.Getenv("RANDR_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("RANDR_POLL_INTERVAL: %w", err)
+		}
+		c.PollInterval = duration(d)
+	}
+	if v := os.Getenv("RANDR_MODE"); v != "" {
+		c.Mode = v
+	}
+	if v := os.Getenv("RANDR_LOG_LEVEL"); v != "" {
+		c.LogLevel = v
+	}
+	if v := os.Getenv("RANDR_LEARN"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("RANDR_LEARN: %w", err)
+		}
+		c.Learn = b
+	}
+	if v := os.Getenv("RANDR_DEFAULT"); v != "" {
+		c.Default = v
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package randr
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	eventConnected     = "connected"
+	eventDisconnected  = "disconnected"
+	eventChanged       = "changed"
+	eventLayoutApplied = "layout-applied"
+	eventError         = "error"
+)
+
+// event is something the daemon noticed or did. With --emit-events every
+// event is written to stdout as one JSON object per line, so other programs
+// can follow monitor changes without polling xrandr themselves.
+type event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+	// Output, Monitor and Name identify the output of connect, disconnect
+	// and change events: the connector, its EDID fingerprint and the
+	// monitor's name.
+	Output  string `json:"output,omitempty"`
+	Monitor string `json:"monitor,omitempty"`
+	Name    string `json:"name,omitempty"`
+	// Unidentified is set for a connected output without a usable EDID,
+	// and EDIDError says what was wrong with it.
+	Unidentified bool   `json:"unidentified,omitempty"`
+	EDIDError    string `json:"edid_error,omitempty"`
+	// Audio is set when the connected output carries sound to its monitor.
+	Audio bool `json:"audio,omitempty"`
+	// Profile, Reason and Layout describe an applied layout.
+	Profile string `json:"profile,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Layout  layout `json:"layout,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+var (
+	emitEvents bool
+	emitMu     sync.Mutex
+	// subscribers receive every event, for the HTTP event stream.
+	subscribers = make(map[chan event]bool)
+)
+
+// registerEventFlags adds the event stream flag to fs.
+func registerEventFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&emitEvents, "emit-events", false, "print monitor and layout events to stdout as JSON lines")
+}
+
+// emit publishes an event, stamping it with the current time.
+func emit(ctx context.Context, e event) {
+	e.Time = time.Now()
+	emitMu.Lock()
+	defer emitMu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+			debugf(ctx, "events: dropped %s event for a slow subscriber", e.Type)
+		}
+	}
+	if !emitEvents {
+		return
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(e); err != nil {
+		logf(ctx, "events: %v", err)
+	}
+}
+
+// subscribe returns a channel receiving all events from now on, and a
+// function that stops the subscription.
+func subscribe() (<-chan event, func()) {
+	ch := make(chan event, 16)
+	emitMu.Lock()
+	subscribers[ch] = true
+	emitMu.Unlock()
+	return ch, func() {
+		emitMu.Lock()
+		delete(subscribers, ch)
+		emitMu.Unlock()
+	}
+}
+
+// outputEvent builds a connect, disconnect or change event for o.
+func outputEvent(typ string, o output) event {
+	return event{Type: typ, Output: o.Name, Monitor: o.Monitor.String(), Name: o.Monitor.Name,
+		Unidentified: o.Unidentified, EDIDError: o.EDIDError, Audio: o.audioSink()}
+}
+
+// errorEvent builds an error event.
+func errorEvent(err error) event {
+	return event{Type: eventError, Error: err.Error()}
+}
@@ -0,0 +1,86 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// forceModes has unidentified outputs with a forced mode prefer it. Without
+// an EDID the server only offers a few fallback modes, so a forced mode it
+// does not list is added to the output when it is first used.
+func forceModes(ctx context.Context, outputs []output) {
+	forced := settingsFrom(ctx).forcedModes
+	for i := range outputs {
+		o := &outputs[i]
+		res, ok := forced[o.Name]
+		if !o.Unidentified || !ok {
+			continue
+		}
+		j := slices.Index(o.Resolutions, res)
+		if j < 0 {
+			j = len(o.Resolutions)
+			o.Resolutions = append(o.Resolutions, res)
+			o.Rates = append(o.Rates, []float64{60})
+			o.added = append(o.added, res)
+		}
+		debugf(ctx, "%s: unidentified, forcing %s", o.Name, res)
+		o.Preferred = j
+	}
+}
+
+// addModes adds the modes the plan uses but the outputs do not have yet, as
+// CVT reduced blanking modes at 60Hz. A mode of that name that already
+// exists in the server is reused.
+func (p *plan) addModes(ctx context.Context, b Backend) error {
+	for _, c := range p.delta() {
+		if !c.addMode {
+			continue
+		}
+		name := c.Mode.String()
+		if err := b.Configure(ctx, append([]string{"--newmode", name}, cvtModeline(c.Mode, 60)...)); err != nil {
+			debugf(ctx, "mode %s: %v", name, err)
+		}
+		if err := b.Configure(ctx, []string{"--addmode", c.Name, name}); err != nil {
+			return fmt.Errorf("adding mode %s to %s: %w", name, c.Name, err)
+		}
+	}
+	return nil
+}
+
+// cvtModeline returns the xrandr modeline of a VESA CVT reduced blanking
+// mode, as `cvt -r` prints it.
+func cvtModeline(res resolution, refresh float64) []string {
+	const (
+		minVBlank = 460.0 // µs
+		hBlank    = 160
+		hSync     = 32
+		hFront    = 48
+		vFront    = 3
+		minVBack  = 6
+	)
+	w := res.W / 8 * 8
+	vSync := 10
+	switch {
+	case res.H*4 == w*3:
+		vSync = 4
+	case res.H*16 == w*9:
+		vSync = 5
+	case res.H*16 == w*10:
+		vSync = 6
+	case res.H*5 == w*4, res.H*15 == w*9:
+		vSync = 7
+	}
+	hPeriod := (1e6/refresh - minVBlank) / float64(res.H)
+	vbi := max(int(minVBlank/hPeriod)+1, vFront+vSync+minVBack)
+	vTotal := res.H + vbi
+	hTotal := w + hBlank
+	clock := 0.25 * math.Floor(refresh*float64(vTotal*hTotal)/1e6/0.25)
+	return []string{
+		fmt.Sprintf("%.2f", clock),
+		fmt.Sprint(w), fmt.Sprint(w + hFront), fmt.Sprint(w + hFront + hSync), fmt.Sprint(hTotal),
+		fmt.Sprint(res.H), fmt.Sprint(res.H + vFront), fmt.Sprint(res.H + vFront + vSync), fmt.Sprint(vTotal),
+		"+hsync", "-vsync",
+	}
+}
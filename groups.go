@@ -0,0 +1,90 @@
+package randr
+
+import "slices"
+
+// mirrorGroups lays out groups of outputs left to right. The outputs of a
+// group show the same picture, the first one's, at the best resolution
+// they share; one that lacks it scales to it. A group of one output
+// extends at its preferred resolution. The first output of the first group
+// is primary.
+func mirrorGroups(groups [][]output) layout {
+	var l layout
+	x := 0
+	for _, g := range groups {
+		first := len(l) == 0
+		if len(g) == 1 {
+			res, ok := g[0].preferred()
+			if !ok {
+				continue
+			}
+			st := g[0].state(res)
+			st.X, st.Primary = x, first
+			l = append(l, outputConfig{Name: g[0].Name, outputState: st})
+			x += st.size().W
+			continue
+		}
+		res := bestCommonResolution(g[0], g)
+		for _, c := range mirror(g[0], g[1:], res) {
+			c.X, c.Primary = x, c.Primary && first
+			l = append(l, c)
+		}
+		x += res.W
+	}
+	return l
+}
+
+// groups splits the connected outputs, in the profile's order, into its
+// mirror groups. With Mirror there is one, of the primary output (or the
+// first one) and the outputs Mirror names; with MirrorGroups there is one
+// for each group that has outputs connected, in its order. The outputs in
+// no group follow, one to a group, so they extend. The mixed layout
+// mirrors the projector without Mirror, or else the last external.
+func (p *profile) groups(connected []output) [][]output {
+	ordered := p.ordered(connected)
+	mirrored := p.Mirror
+	if p.Layout == layoutMixed && len(mirrored) == 0 {
+		name := ""
+		for _, o := range ordered {
+			if !o.internal() && !o.Primary {
+				name = o.Name
+			}
+		}
+		if i := slices.IndexFunc(ordered, func(o output) bool { return o.projector() && !o.Primary }); i >= 0 {
+			name = ordered[i].Name
+		}
+		if name != "" {
+			mirrored = []string{name}
+		}
+	}
+	used := make(map[string]bool)
+	take := func(wants []string) []output {
+		var g []output
+		for _, want := range wants {
+			for _, o := range ordered {
+				if !used[o.Name] && matchesOutput(want, o) {
+					g = append(g, o)
+					used[o.Name] = true
+				}
+			}
+		}
+		return g
+	}
+
+	var groups [][]output
+	if len(mirrored) > 0 && len(ordered) > 0 {
+		primary := ordered[max(slices.IndexFunc(ordered, func(o output) bool { return o.Primary }), 0)]
+		used[primary.Name] = true
+		groups = append(groups, append([]output{primary}, take(mirrored)...))
+	}
+	for _, wants := range p.MirrorGroups {
+		if g := take(wants); len(g) > 0 {
+			groups = append(groups, g)
+		}
+	}
+	for _, o := range ordered {
+		if !used[o.Name] {
+			groups = append(groups, []output{o})
+		}
+	}
+	return groups
+}
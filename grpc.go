@@ -0,0 +1,172 @@
+package randr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"randr/randrpb"
+)
+
+// serveGRPC starts the gRPC service of randrpb on addr, until ctx is
+// cancelled. It takes the same calls as the HTTP API, and streams events
+// the same way.
+//
+// It speaks HTTP/2 without TLS only, so browsers, which reach plain HTTP
+// addresses over HTTP/1, cannot call it. Like the HTTP API it has no
+// authentication.
+func (d *Daemon) serveGRPC(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if host, _, _ := net.SplitHostPort(ln.Addr().String()); !net.ParseIP(host).IsLoopback() {
+		logf(ctx, "warning: gRPC service on %s is reachable from other hosts", ln.Addr())
+	}
+	srv := &http.Server{Handler: randrpb.NewRandrHandler(grpcServer{d}), ReadHeaderTimeout: 5 * time.Second,
+		BaseContext: func(net.Listener) context.Context { return ctx }, Protocols: new(http.Protocols)}
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logf(ctx, "grpc: %v", err)
+		}
+	}()
+	infof(ctx, "gRPC service listening on %s", ln.Addr())
+	return nil
+}
+
+// grpcServer serves the daemon's gRPC calls.
+type grpcServer struct {
+	d *Daemon
+}
+
+func (s grpcServer) ListOutputs(ctx context.Context, _ *randrpb.ListOutputsRequest) (*randrpb.ListOutputsResponse, error) {
+	resp := &randrpb.ListOutputsResponse{}
+	for _, o := range s.d.connected() {
+		resp.Outputs = append(resp.Outputs, &randrpb.Output{
+			Name:         o.Name,
+			Monitor:      o.Monitor,
+			MonitorName:  o.MonitorName,
+			Unidentified: o.Unidentified,
+			EdidError:    o.EDIDError,
+			Primary:      o.Primary,
+			Audio:        o.Audio,
+			Active:       o.Active,
+			Width:        int32(o.Width),
+			Height:       int32(o.Height),
+			X:            int32(o.X),
+			Y:            int32(o.Y),
+			Rotation:     o.Rotation,
+		})
+	}
+	return resp, nil
+}
+
+func (s grpcServer) ListProfiles(ctx context.Context, _ *randrpb.ListProfilesRequest) (*randrpb.ListProfilesResponse, error) {
+	resp := &randrpb.ListProfilesResponse{}
+	for _, p := range s.d.profiles(ctx) {
+		resp.Profiles = append(resp.Profiles, &randrpb.Profile{
+			Name:    p.Name,
+			Layout:  p.Layout,
+			Outputs: p.Outputs,
+			Matches: p.Matches,
+			Active:  p.Active,
+		})
+	}
+	return resp, nil
+}
+
+func (s grpcServer) Apply(ctx context.Context, req *randrpb.ApplyRequest) (*randrpb.ApplyResponse, error) {
+	if !s.d.knows(req.Profile) {
+		return nil, randrpb.Errorf(randrpb.NotFound, "unknown profile %s", req.Profile)
+	}
+	name, err := s.d.send(ctx, command{profile: req.Profile})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &randrpb.ApplyResponse{Profile: name}, nil
+}
+
+func (s grpcServer) Cycle(ctx context.Context, _ *randrpb.CycleRequest) (*randrpb.CycleResponse, error) {
+	name, err := s.d.send(ctx, command{cycle: true})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &randrpb.CycleResponse{Profile: name}, nil
+}
+
+func (s grpcServer) Arrange(ctx context.Context, req *randrpb.ArrangeRequest) (*randrpb.ArrangeResponse, error) {
+	var moves []position
+	for _, p := range req.Outputs {
+		moves = append(moves, position{Name: p.Name, X: int(p.X), Y: int(p.Y)})
+	}
+	l, err := s.d.arrangement(moves)
+	if err != nil {
+		return nil, randrpb.Errorf(randrpb.InvalidArgument, "%v", err)
+	}
+	if _, err := s.d.send(ctx, command{layout: l}); err != nil {
+		return nil, grpcError(err)
+	}
+	return &randrpb.ArrangeResponse{}, nil
+}
+
+func (s grpcServer) Events(_ *randrpb.EventsRequest, stream *randrpb.RandrEventsServer) error {
+	events, stop := subscribe()
+	defer stop()
+	for {
+		select {
+		case e := <-events:
+			if err := stream.Send(grpcEvent(e)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// grpcEvent converts an event for the gRPC event stream.
+func grpcEvent(e event) *randrpb.Event {
+	pe := &randrpb.Event{
+		TimeUnixNano: e.Time.UnixNano(),
+		Type:         e.Type,
+		Output:       e.Output,
+		Monitor:      e.Monitor,
+		Name:         e.Name,
+		Unidentified: e.Unidentified,
+		EdidError:    e.EDIDError,
+		Audio:        e.Audio,
+		Profile:      e.Profile,
+		Reason:       e.Reason,
+		Error:        e.Error,
+	}
+	for _, c := range e.Layout {
+		lo := &randrpb.LayoutOutput{Name: c.Name, Off: c.Off}
+		if !c.Off {
+			lo.Mode, lo.X, lo.Y, lo.Primary = c.Mode.String(), int32(c.X), int32(c.Y), c.Primary
+			lo.Rotation, lo.Rate = c.Rotation, c.Rate
+			if c.ScaleFrom != (resolution{}) {
+				lo.ScaleFrom = c.ScaleFrom.String()
+			}
+		}
+		pe.Layout = append(pe.Layout, lo)
+	}
+	return pe
+}
+
+// grpcError gives a failed command the status code it calls for.
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, errNoCycle):
+		return randrpb.Errorf(randrpb.FailedPrecondition, "%v", err)
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	}
+	return randrpb.Errorf(randrpb.Internal, "%v", err)
+}
@@ -0,0 +1,143 @@
+package randr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"randr/randrpb"
+)
+
+// grpcDaemon serves a daemon whose loop is played by handle over gRPC, and
+// returns a client for it.
+func grpcDaemon(t *testing.T, handle func(command) commandResult) *randrpb.RandrClient {
+	t.Helper()
+	outputs, _ := readQuery(t, "dock.txt")
+	d := &Daemon{commands: make(chan command)}
+	d.status.set(daemonStatus{Outputs: outputs, Profile: "laptop", profiles: []profile{
+		{Name: "laptop", Outputs: []string{"eDP-1"}},
+		{Name: "desk", Outputs: []string{"eDP-1", "DEL-*"}},
+	}})
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case c := <-d.commands:
+				c.reply <- handle(c)
+			case <-done:
+				return
+			}
+		}
+	}()
+	srv := httptest.NewUnstartedServer(randrpb.NewRandrHandler(grpcServer{d}))
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+	cc := randrpb.Dial(strings.TrimPrefix(srv.URL, "http://"))
+	t.Cleanup(func() {
+		cc.Close()
+		srv.Close()
+		close(done)
+	})
+	return randrpb.NewRandrClient(cc)
+}
+
+func TestGRPCControl(t *testing.T) {
+	var sent []command
+	c := grpcDaemon(t, func(cmd command) commandResult {
+		sent = append(sent, cmd)
+		if cmd.cycle {
+			return commandResult{err: errNoCycle}
+		}
+		return commandResult{profile: cmd.profile}
+	})
+	ctx := context.Background()
+
+	outputs, err := c.ListOutputs(ctx, &randrpb.ListOutputsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, o := range outputs.Outputs {
+		names = append(names, o.Name)
+	}
+	if got := strings.Join(names, " "); got != "eDP-1 HDMI-1" {
+		t.Errorf("outputs %s, want eDP-1 HDMI-1", got)
+	}
+	if o := outputs.Outputs[1]; o.Monitor != "DEL-A0B8-718NY83" || o.Active {
+		t.Errorf("HDMI-1 is %+v", o)
+	}
+
+	profiles, err := c.ListProfiles(ctx, &randrpb.ListProfilesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles.Profiles) != 2 || !profiles.Profiles[0].Active || !profiles.Profiles[1].Matches {
+		t.Errorf("profiles %+v", profiles.Profiles)
+	}
+
+	resp, err := c.Apply(ctx, &randrpb.ApplyRequest{Profile: "desk"})
+	if err != nil || resp.Profile != "desk" {
+		t.Errorf("apply desk: %+v, %v", resp, err)
+	}
+	if _, err := c.Apply(ctx, &randrpb.ApplyRequest{Profile: "couch"}); randrpb.CodeOf(err) != randrpb.NotFound {
+		t.Errorf("apply couch: %v, want NotFound", err)
+	}
+	if _, err := c.Cycle(ctx, &randrpb.CycleRequest{}); randrpb.CodeOf(err) != randrpb.FailedPrecondition {
+		t.Errorf("cycle: %v, want FailedPrecondition", err)
+	}
+
+	_, err = c.Arrange(ctx, &randrpb.ArrangeRequest{Outputs: []*randrpb.Position{{Name: "HDMI-1", X: 1920}}})
+	if randrpb.CodeOf(err) != randrpb.InvalidArgument || !strings.Contains(err.Error(), "HDMI-1 is not active") {
+		t.Errorf("arranging a dark output: %v, want InvalidArgument", err)
+	}
+	if _, err := c.Arrange(ctx, &randrpb.ArrangeRequest{Outputs: []*randrpb.Position{{Name: "eDP-1", X: 100, Y: 50}}}); err != nil {
+		t.Errorf("arrange: %v", err)
+	}
+	if last := sent[len(sent)-1]; len(last.layout) != 1 || last.layout[0].X != 0 || last.layout[0].Y != 0 {
+		t.Errorf("arranged %v, want eDP-1 moved back to 0x0", last.layout)
+	}
+}
+
+func TestGRPCEvents(t *testing.T) {
+	c := grpcDaemon(t, func(command) commandResult { return commandResult{} })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := c.Events(ctx, &randrpb.EventsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	// The server subscribes once the call arrives, so keep emitting until
+	// an event gets through.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		applied := event{Type: eventLayoutApplied, Profile: "desk", Layout: layout{
+			{Name: "eDP-1", outputState: outputState{Off: true}},
+			{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, Primary: true, Rate: 59.95}},
+		}}
+		for {
+			emit(ctx, applied)
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	e, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != eventLayoutApplied || e.Profile != "desk" || e.TimeUnixNano == 0 || len(e.Layout) != 2 {
+		t.Fatalf("event %+v", e)
+	}
+	if l := e.Layout[1]; l.Name != "HDMI-1" || l.Mode != "2560x1440" || !l.Primary || l.Rate != 59.95 {
+		t.Errorf("layout output %+v", l)
+	}
+}
@@ -0,0 +1,178 @@
+package randr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// historyLimit is how many events the history keeps; older ones are
+// dropped.
+const historyLimit = 1000
+
+// recordHistory appends every event the daemon emits to the history file
+// at path, until ctx is cancelled.
+func recordHistory(ctx context.Context, path string) {
+	// Subscribe before returning so the startup events are not missed.
+	events, stop := subscribe()
+	go func() {
+		defer stop()
+		for {
+			select {
+			case e := <-events:
+				if err := appendHistory(path, e); err != nil {
+					logf(ctx, "history: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// appendHistory adds the event to the history file, one JSON object a
+// line, dropping the oldest beyond historyLimit. The file is replaced
+// atomically, like the state file.
+func appendHistory(path string, e event) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	lines := append(bytes.SplitAfter(data, []byte("\n")), append(line, '\n'))
+	lines = slices.DeleteFunc(lines, func(l []byte) bool { return len(l) == 0 })
+	if len(lines) > historyLimit {
+		lines = lines[len(lines)-historyLimit:]
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, bytes.Join(lines, nil), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadHistory reads the history file, oldest event first. A missing file
+// is an empty history; a line that does not parse is skipped.
+func loadHistory(path string) ([]event, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var events []event
+	sc := bufio.NewScanner(f)
+	// A layout event of many outputs makes a long line.
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		var e event
+		if json.Unmarshal(sc.Bytes(), &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, sc.Err()
+}
+
+// parseSince parses the start of a history query, relative to now: a
+// duration back, as "2h"; "today" or "yesterday", optionally followed by a
+// time; a time today, as "14:30"; a date, as "2026-10-14"; or a date and
+// time, as "2026-10-14 14:30" or in RFC 3339.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	word, clock, _ := strings.Cut(s, " ")
+	switch word {
+	case "yesterday":
+		day = day.AddDate(0, 0, -1)
+		fallthrough
+	case "today":
+		if clock == "" {
+			return day, nil
+		}
+	default:
+		clock = s
+	}
+	if t, err := time.ParseInLocation("15:04", clock, now.Location()); err == nil {
+		return day.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q, want a duration such as 2h, today, yesterday 14:30, 14:30 or 2026-10-14 14:30", s)
+}
+
+// describe summarizes the event in one line for the history command.
+func (e event) describe() string {
+	switch e.Type {
+	case eventConnected, eventDisconnected:
+		s := e.Output
+		if e.Name != "" {
+			s += fmt.Sprintf(" %q", e.Name)
+		}
+		if e.Monitor != "" {
+			s += " " + e.Monitor
+		}
+		return s
+	case eventLayoutApplied:
+		var outputs []string
+		for _, c := range e.Layout {
+			outputs = append(outputs, c.Name+" "+c.outputState.String())
+		}
+		s := strings.Join(outputs, ", ")
+		if e.Reason != "" {
+			s = e.Reason + ": " + s
+		}
+		return s
+	}
+	return e.Error
+}
+
+// runHistory prints the recorded monitor events and applied layouts, since
+// the given time if --since is set.
+func runHistory(dirs paths, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	sinceFlag := fs.String("since", "", "only events since then: 2h, today, yesterday 14:30, 2026-10-14 14:30, ...")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = parseSince(*sinceFlag, time.Now()); err != nil {
+			return err
+		}
+	}
+	events, err := loadHistory(dirs.history())
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if e.Time.Before(since) {
+			continue
+		}
+		fmt.Printf("%s  %-14s %s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Type, e.describe())
+	}
+	return nil
+}
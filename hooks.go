@@ -0,0 +1,235 @@
+package randr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// hooks are shell commands run around a layout change: Pre before xrandr is
+// called, Post once the new layout is verified. Each runs with sh -c and is
+// bounded by command_timeout; long-running programs should be started in the
+// background with "&".
+type hooks struct {
+	Pre  []string `json:"pre,omitempty"`
+	Post []string `json:"post,omitempty"`
+}
+
+// hooks returns the commands to run around applying the named profile: the
+// global pre hooks come before the profile's own, its post hooks before the
+// global ones. They are copies, so callers cannot change the config's.
+func (c *config) hooks(profile string) (pre, post []string) {
+	pre = slices.Clone(c.Hooks.Pre)
+	post = slices.Clone(c.Hooks.Post)
+	if p := c.profile(profile); p != nil {
+		pre = append(pre, p.Hooks.Pre...)
+		post = append(slices.Clone(p.Hooks.Post), post...)
+	}
+	return pre, post
+}
+
+// hookEnv describes a layout change to the hooks through environment
+// variables, so scripts don't have to query and parse xrandr themselves.
+type hookEnv struct {
+	// Event is what caused the change: "startup", "connected",
+	// "disconnected", "changed", "reload", "command", "arrange", "undo",
+	// "redo", "expire", "rotate", "tablet-mode", "power", "schedule" or
+	// "manual". It is RANDR_EVENT to the hooks.
+	Event string
+	// Changed are the outputs that were connected, disconnected or
+	// changed.
+	Changed []string
+}
+
+// vars returns the variables for the hooks of stage around applying p.
+// RANDR_OUTPUTS is the layout in the format of the layout-applied event.
+func (e hookEnv) vars(stage string, p *plan) []string {
+	l := p.layout()
+	outputs, _ := json.Marshal(l)
+	var primary string
+	var audio []string
+	for _, c := range p.Changes {
+		if c.audio && !c.To.Off {
+			audio = append(audio, c.To.Name)
+		}
+		switch {
+		case c.To.Off:
+		case c.To.Primary:
+			primary = c.To.Name
+		case c.From.Primary && primary == "":
+			primary = c.To.Name
+		}
+	}
+	return []string{
+		"RANDR_HOOK=" + stage,
+		"RANDR_EVENT=" + e.Event,
+		"RANDR_PROFILE=" + p.Profile,
+		"RANDR_OUTPUTS=" + string(outputs),
+		"RANDR_PRIMARY=" + primary,
+		"RANDR_CHANGED_OUTPUTS=" + strings.Join(e.Changed, " "),
+		"RANDR_AUDIO_OUTPUTS=" + strings.Join(audio, " "),
+	}
+}
+
+// runHooks runs the commands in order with env added to the environment. A
+// failing hook is logged but stops neither the remaining hooks nor the
+// layout change.
+func runHooks(ctx context.Context, stage string, cmds, env []string) {
+	if len(cmds) == 0 {
+		return
+	}
+	ctx, sp := startSpan(ctx, stage+" hooks")
+	defer sp.finish(nil)
+	for _, c := range cmds {
+		infof(ctx, "%s hook: %s", stage, c)
+		_, err := runCommand(ctx, false, "sh", []string{"-c", c}, func(cmd *exec.Cmd) {
+			cmd.Env = append(os.Environ(), env...)
+			cmd.Stdout = os.Stderr
+			cmd.Stderr = os.Stderr
+		})
+		if err != nil {
+			logf(ctx, "%s hook %q: %v", stage, c, err)
+		}
+	}
+}
+
+// feedbackConfig are shell commands giving feedback on what the daemon does,
+// such as playing a sound or flashing a LED on kiosks where nobody reads
+// the log. They run in the background, with RANDR_FEEDBACK set to the
+// event and RANDR_CHANGED_OUTPUTS or RANDR_ERROR where it applies.
+type feedbackConfig struct {
+	// Connected and Disconnected run when monitors are connected or
+	// disconnected, Changed when a connected output changes.
+	Connected    string `json:"connected,omitempty"`
+	Disconnected string `json:"disconnected,omitempty"`
+	Changed      string `json:"changed,omitempty"`
+	// Applied runs when a layout change took effect, Failed when one
+	// was refused or failed.
+	Applied string `json:"applied,omitempty"`
+	Failed  string `json:"failed,omitempty"`
+}
+
+// feedbackEvents are the events feedback can be given on.
+var feedbackEvents = []string{"connected", "disconnected", "changed", "applied", "failed"}
+
+// command returns the command for the event, or "".
+func (f *feedbackConfig) command(event string) string {
+	switch event {
+	case "connected":
+		return f.Connected
+	case "disconnected":
+		return f.Disconnected
+	case "changed":
+		return f.Changed
+	case "applied":
+		return f.Applied
+	case "failed":
+		return f.Failed
+	}
+	return ""
+}
+
+// run runs the command for the event in the background, if there is one.
+func (f *feedbackConfig) run(ctx context.Context, event string, env ...string) {
+	c := f.command(event)
+	if c == "" {
+		return
+	}
+	debugf(ctx, "%s feedback: %s", event, c)
+	go func() {
+		_, err := runCommand(ctx, false, "sh", []string{"-c", c}, func(cmd *exec.Cmd) {
+			cmd.Env = append(append(os.Environ(), "RANDR_FEEDBACK="+event), env...)
+			cmd.Stdout = os.Stderr
+			cmd.Stderr = os.Stderr
+		})
+		if err != nil {
+			logf(ctx, "%s feedback %q: %v", event, c, err)
+		}
+	}()
+}
+
+// checkHooks reports hooks whose command is not found in $PATH.
+func checkHooks(cfg *config) []*configError {
+	var errs []*configError
+	check := func(file, path, prefix, stage string, cmds []string) {
+		for i, c := range cmds {
+			name := hookCommand(c)
+			if name == "" {
+				continue
+			}
+			if err := checkCommand(name); err != nil {
+				errs = append(errs, &configError{File: file, Path: joinPath(path, fmt.Sprintf("hooks.%s[%d]", stage, i)),
+					Msg: prefix + fmt.Sprintf("%s hook: %v", stage, err)})
+			}
+		}
+	}
+	check(cfg.file, "", "", "pre", cfg.Hooks.Pre)
+	check(cfg.file, "", "", "post", cfg.Hooks.Post)
+	for _, event := range feedbackEvents {
+		name := hookCommand(cfg.Feedback.command(event))
+		if name == "" {
+			continue
+		}
+		if err := checkCommand(name); err != nil {
+			errs = append(errs, &configError{File: cfg.file, Path: "feedback." + event,
+				Msg: fmt.Sprintf("%s feedback: %v", event, err)})
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(cfg.Callbacks)) {
+		for _, field := range slices.Sorted(maps.Keys(callbackEvents)) {
+			name := hookCommand(cfg.Callbacks[key].command(callbackEvents[field]))
+			if name == "" {
+				continue
+			}
+			if err := checkCommand(name); err != nil {
+				errs = append(errs, &configError{File: cfg.file, Path: "callbacks." + key + "." + field,
+					Msg: fmt.Sprintf("callback %q: %s: %v", key, field, err)})
+			}
+		}
+	}
+	for _, p := range cfg.Profiles {
+		prefix := fmt.Sprintf("profile %q: ", p.Name)
+		check(p.file, p.path, prefix, "pre", p.Hooks.Pre)
+		check(p.file, p.path, prefix, "post", p.Hooks.Post)
+	}
+	return errs
+}
+
+// hookCommand returns the program a hook runs, or "" when that can't be
+// told without a shell, e.g. for leading variable assignments, or it is
+// the shell's own.
+func hookCommand(c string) string {
+	f := strings.Fields(c)
+	if len(f) == 0 || strings.ContainsAny(f[0], "=$`'\"(){};|&<>") || slices.Contains(shellWords, f[0]) {
+		return ""
+	}
+	return f[0]
+}
+
+// shellWords are the builtins and keywords a hook can start with that are
+// not programs.
+var shellWords = []string{
+	"!", ".", ":", "[", "case", "cd", "command", "eval", "exec", "exit", "export",
+	"for", "if", "read", "set", "trap", "unset", "until", "wait", "while",
+}
+
+// checkCommand reports a program that is not on the PATH or, given as a
+// path, does not exist or is not executable.
+func checkCommand(name string) error {
+	_, err := exec.LookPath(name)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("%s is not executable", name)
+	default:
+		return fmt.Errorf("%s not found", name)
+	}
+}
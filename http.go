@@ -0,0 +1,276 @@
+package randr
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// webUI shows the arrangement and lets it be dragged around and profiles be
+// applied from another device, on top of the API below.
+//
+//go:embed web/index.html
+var webUI []byte
+
+// serveHTTP starts the HTTP API on addr, until ctx is cancelled:
+//
+//	GET  /                  web UI
+//	GET  /outputs           connected outputs
+//	GET  /profiles          profiles, and which apply to the connected outputs
+//	POST /apply/{profile}   apply a profile
+//	POST /cycle             apply the next applicable profile
+//	POST /arrange           move the active outputs to new positions
+//	GET  /events            stream of events, one JSON object per line
+//
+// There is no authentication; anything that can reach addr can change the
+// layout, so it should normally be a loopback address. Web pages the user
+// visits can reach it too, through their browser, which guard turns away.
+func (d *Daemon) serveHTTP(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if host, _, _ := net.SplitHostPort(ln.Addr().String()); !net.ParseIP(host).IsLoopback() {
+		logf(ctx, "warning: HTTP API on %s is reachable from other hosts", ln.Addr())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webUI)
+	})
+	mux.HandleFunc("GET /outputs", d.handleOutputs)
+	mux.HandleFunc("GET /profiles", d.handleProfiles)
+	mux.HandleFunc("POST /apply/{profile}", d.handleApply)
+	mux.HandleFunc("POST /cycle", d.handleApply)
+	mux.HandleFunc("POST /arrange", d.handleArrange)
+	mux.HandleFunc("GET /events", handleEvents)
+	srv := &http.Server{Handler: guard(allowedHosts(addr), mux), ReadHeaderTimeout: 5 * time.Second,
+		BaseContext: func(net.Listener) context.Context { return ctx }}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logf(ctx, "http: %v", err)
+		}
+	}()
+	infof(ctx, "HTTP API listening on %s", ln.Addr())
+	return nil
+}
+
+// allowedHosts are the names the API answers to besides IP addresses: the
+// host it listens on, localhost and the machine's host name.
+func allowedHosts(addr string) map[string]bool {
+	hosts := map[string]bool{"localhost": true}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		hosts[strings.ToLower(host)] = true
+	}
+	if name, err := os.Hostname(); err == nil {
+		name = strings.ToLower(name)
+		short, _, _ := strings.Cut(name, ".")
+		hosts[name], hosts[short], hosts[short+".local"] = true, true, true
+	}
+	return hosts
+}
+
+// guard rejects what a web page on another site can have the browser send:
+// requests for a name other than those of hosts, as after the page's own
+// name was rebound to the API's address, and changes from another origin,
+// which browsers mark with their Origin header. Programs such as curl send
+// neither.
+func guard(hosts map[string]bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if net.ParseIP(host) == nil && !hosts[strings.ToLower(host)] {
+			writeJSON(w, r, http.StatusForbidden, map[string]string{"error": "unknown host " + r.Host})
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if origin := r.Header.Get("Origin"); origin != "" {
+				if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+					writeJSON(w, r, http.StatusForbidden, map[string]string{"error": "cross-origin request from " + origin})
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Daemon) handleOutputs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, d.connected())
+}
+
+// connected returns the connected outputs, as the APIs list them.
+func (d *Daemon) connected() []Output {
+	outputs := []Output{}
+	for _, o := range connectedOutputs(d.status.get().Outputs) {
+		outputs = append(outputs, newOutput(o))
+	}
+	return outputs
+}
+
+// apiProfile is a profile as listed by GET /profiles.
+type apiProfile struct {
+	Name    string   `json:"name"`
+	Layout  string   `json:"layout,omitempty"`
+	Outputs []string `json:"outputs,omitempty"`
+	// Matches is set when the profile applies to the connected outputs,
+	// Active when it is the one last applied.
+	Matches bool `json:"matches"`
+	Active  bool `json:"active"`
+}
+
+func (d *Daemon) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, d.profiles(r.Context()))
+}
+
+// profiles returns the configured profiles, as the APIs list them.
+func (d *Daemon) profiles(ctx context.Context) []apiProfile {
+	s := d.status.get()
+	connected := connectedOutputs(s.Outputs)
+	profiles := []apiProfile{}
+	for _, p := range s.profiles {
+		profiles = append(profiles, apiProfile{
+			Name:    p.Name,
+			Layout:  p.Layout,
+			Outputs: p.Outputs,
+			Matches: p.matches(ctx, connected, s.checks),
+			Active:  p.Name == s.Profile,
+		})
+	}
+	return profiles
+}
+
+// knows reports whether the named profile is configured.
+func (d *Daemon) knows(profile string) bool {
+	cfg := config{Profiles: d.status.get().profiles}
+	return cfg.lookup(profile) != nil
+}
+
+// handleApply serves both POST /apply/{profile} and POST /cycle.
+func (d *Daemon) handleApply(w http.ResponseWriter, r *http.Request) {
+	c := command{profile: r.PathValue("profile"), cycle: r.PathValue("profile") == ""}
+	if !c.cycle && !d.knows(c.profile) {
+		writeJSON(w, r, http.StatusNotFound, map[string]string{"error": "unknown profile " + c.profile})
+		return
+	}
+	name, err := d.send(r.Context(), c)
+	if errors.Is(err, errNoCycle) {
+		writeJSON(w, r, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, r, http.StatusInternalServerError, map[string]string{"profile": name, "error": err.Error()})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]string{"profile": name})
+}
+
+// handleArrange applies new positions for the active outputs, keeping their
+// modes, as sent by the web UI: [{"name": "HDMI-1", "x": 1920, "y": 0}].
+func (d *Daemon) handleArrange(w http.ResponseWriter, r *http.Request) {
+	// Forms can't post JSON, so no page can post this without the browser
+	// asking first.
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		writeJSON(w, r, http.StatusUnsupportedMediaType, map[string]string{"error": "want Content-Type: application/json"})
+		return
+	}
+	var req []position
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, r, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	l, err := d.arrangement(req)
+	if err != nil {
+		writeJSON(w, r, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if _, err := d.send(r.Context(), command{layout: l}); err != nil {
+		writeJSON(w, r, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, r, http.StatusOK, map[string]string{})
+}
+
+// position places an active output, as asked to over the APIs.
+type position struct {
+	Name string `json:"name"`
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+}
+
+// arrangement returns the layout moving the active outputs to the new
+// positions, keeping their modes.
+func (d *Daemon) arrangement(moves []position) (layout, error) {
+	cur := currentLayout(d.status.get().Outputs)
+	var l layout
+	for _, o := range moves {
+		st, ok := cur[o.Name]
+		if !ok || st.Off {
+			return nil, fmt.Errorf("%s is not active", o.Name)
+		}
+		st.X, st.Y = o.X, o.Y
+		l = append(l, outputConfig{Name: o.Name, outputState: st})
+	}
+	if len(l) == 0 {
+		return nil, errors.New("no outputs")
+	}
+
+	// Dragging can leave the layout anywhere; xrandr wants it at 0x0.
+	minX, minY := l[0].X, l[0].Y
+	for _, c := range l {
+		minX, minY = min(minX, c.X), min(minY, c.Y)
+	}
+	for i := range l {
+		l[i].X -= minX
+		l[i].Y -= minY
+	}
+	return l, nil
+}
+
+// handleEvents streams events as they happen, in the --emit-events format,
+// until the client goes away.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	events, stop := subscribe()
+	defer stop()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	for {
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case e := <-events:
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		debugf(r.Context(), "http: %v", err)
+	}
+}
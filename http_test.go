@@ -0,0 +1,68 @@
+package randr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	hosts := map[string]bool{"localhost": true, "signage-3": true}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tc := range []struct {
+		name, method, host, origin string
+		want                       int
+	}{
+		{"loopback", "GET", "127.0.0.1:7600", "", http.StatusOK},
+		{"ipv6", "GET", "[::1]:7600", "", http.StatusOK},
+		{"localhost", "POST", "localhost:7600", "", http.StatusOK},
+		{"host name", "POST", "Signage-3:7600", "", http.StatusOK},
+		{"rebound name", "GET", "evil.example:7600", "", http.StatusForbidden},
+		{"same origin", "POST", "localhost:7600", "http://localhost:7600", http.StatusOK},
+		{"other origin", "POST", "localhost:7600", "https://evil.example", http.StatusForbidden},
+		{"null origin", "POST", "127.0.0.1:7600", "null", http.StatusForbidden},
+		{"other origin reading", "GET", "localhost:7600", "https://evil.example", http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, "/cycle", nil)
+			r.Host = tc.host
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			w := httptest.NewRecorder()
+			guard(hosts, ok).ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestArrangeWantsJSON(t *testing.T) {
+	d := &Daemon{}
+	for _, ct := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		r := httptest.NewRequest("POST", "/arrange", strings.NewReader(`[{"name":"HDMI-1","x":0,"y":0}]`))
+		if ct != "" {
+			r.Header.Set("Content-Type", ct)
+		}
+		w := httptest.NewRecorder()
+		d.handleArrange(w, r)
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Content-Type %q: status %d, want %d", ct, w.Code, http.StatusUnsupportedMediaType)
+		}
+	}
+}
+
+func TestCycleNothingToCycleTo(t *testing.T) {
+	d := &Daemon{commands: make(chan command)}
+	go func() {
+		c := <-d.commands
+		c.reply <- commandResult{err: errNoCycle}
+	}()
+	w := httptest.NewRecorder()
+	d.handleApply(w, httptest.NewRequest("POST", "/cycle", nil))
+	if w.Code != http.StatusConflict {
+		t.Errorf("status %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+}
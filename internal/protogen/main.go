@@ -0,0 +1,575 @@
+// Protogen generates the Go code of randrpb from randr.proto: a struct with
+// Marshal and Unmarshal methods per message, and a client, server interface
+// and HTTP handler per service, on the runtime in randrpb.
+//
+// It reads the subset of proto3 that randr.proto is written in: messages of
+// scalar, string and message fields, repeated or not, and services of unary
+// and server-streaming methods. Anything else is an error.
+//
+// Usage:
+//
+//	protogen -o randr.pb.go randr.proto
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// token is a word, number, string or symbol of the proto file, with the
+// comment lines right before it.
+type token struct {
+	text    string
+	line    int
+	comment []string
+}
+
+// lex splits a proto file into tokens. A comment block is attached to the
+// token that follows it without a blank line in between.
+func lex(src string) ([]token, error) {
+	var toks []token
+	var comment []string
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			if i > 0 && src[i-1] == '\n' {
+				comment = nil
+			}
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			end := strings.IndexByte(src[i:], '\n')
+			if end < 0 {
+				end = len(src) - i
+			}
+			text := strings.TrimPrefix(src[i+2:i+end], " ")
+			comment = append(comment, text)
+			i += end
+		case c == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			toks = append(toks, token{text: src[i : i+end+2], line: line, comment: comment})
+			comment = nil
+			i += end + 2
+		case isWord(rune(c)):
+			j := i
+			for j < len(src) && (isWord(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{text: src[i:j], line: line, comment: comment})
+			comment = nil
+			i = j
+		case strings.ContainsRune("{}()=;", rune(c)):
+			toks = append(toks, token{text: string(c), line: line, comment: comment})
+			comment = nil
+			i++
+		default:
+			return nil, fmt.Errorf("line %d: unexpected %q", line, c)
+		}
+	}
+	return toks, nil
+}
+
+func isWord(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+type file struct {
+	pkg      string
+	messages []*message
+	services []*service
+}
+
+type message struct {
+	name    string
+	comment []string
+	fields  []*field
+}
+
+type field struct {
+	name     string
+	typ      string
+	num      int
+	repeated bool
+	comment  []string
+}
+
+type service struct {
+	name    string
+	comment []string
+	methods []*rpc
+}
+
+type rpc struct {
+	name, in, out string
+	stream        bool
+	comment       []string
+}
+
+// parser reads a file from its tokens.
+type parser struct {
+	toks []token
+	pos  int
+	err  error
+}
+
+func (p *parser) peek() token {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return token{text: "", line: -1}
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) fail(t token, format string, args ...any) {
+	if p.err == nil {
+		p.err = fmt.Errorf("line %d: %s", t.line, fmt.Sprintf(format, args...))
+	}
+}
+
+// expect consumes the token if it is text, and fails otherwise.
+func (p *parser) expect(text string) token {
+	t := p.next()
+	if t.text != text {
+		p.fail(t, "want %q, got %q", text, t.text)
+	}
+	return t
+}
+
+func (p *parser) ident() string {
+	t := p.next()
+	if t.text == "" || !isWord(rune(t.text[0])) {
+		p.fail(t, "want a name, got %q", t.text)
+	}
+	return t.text
+}
+
+func parse(toks []token) (*file, error) {
+	p := &parser{toks: toks}
+	f := &file{}
+	for p.err == nil && p.pos < len(p.toks) {
+		t := p.next()
+		switch t.text {
+		case "syntax":
+			p.expect("=")
+			if s := p.next(); s.text != `"proto3"` {
+				p.fail(s, "only proto3 is supported")
+			}
+			p.expect(";")
+		case "package":
+			f.pkg = p.ident()
+			p.expect(";")
+		case "option":
+			p.ident()
+			p.expect("=")
+			p.next()
+			p.expect(";")
+		case "message":
+			f.messages = append(f.messages, p.message(t))
+		case "service":
+			f.services = append(f.services, p.service(t))
+		default:
+			p.fail(t, "unexpected %q", t.text)
+		}
+	}
+	return f, p.err
+}
+
+func (p *parser) message(start token) *message {
+	m := &message{name: p.ident(), comment: start.comment}
+	p.expect("{")
+	for p.err == nil && p.peek().text != "}" {
+		first := p.peek()
+		f := &field{comment: first.comment}
+		if first.text == "repeated" {
+			f.repeated = true
+			p.next()
+		}
+		f.typ = p.ident()
+		f.name = p.ident()
+		p.expect("=")
+		n := p.next()
+		num, err := strconv.Atoi(n.text)
+		if err != nil || num <= 0 {
+			p.fail(n, "bad field number %q", n.text)
+		}
+		f.num = num
+		p.expect(";")
+		m.fields = append(m.fields, f)
+	}
+	p.expect("}")
+	return m
+}
+
+func (p *parser) service(start token) *service {
+	s := &service{name: p.ident(), comment: start.comment}
+	p.expect("{")
+	for p.err == nil && p.peek().text != "}" {
+		r := &rpc{comment: p.expect("rpc").comment}
+		r.name = p.ident()
+		p.expect("(")
+		r.in = p.ident()
+		p.expect(")")
+		p.expect("returns")
+		p.expect("(")
+		if p.peek().text == "stream" {
+			r.stream = true
+			p.next()
+		}
+		r.out = p.ident()
+		p.expect(")")
+		p.expect(";")
+		s.methods = append(s.methods, r)
+	}
+	p.expect("}")
+	return s
+}
+
+// scalars maps the scalar types supported to their Go type and the name
+// of their append function and field method in randrpb's runtime.
+var scalars = map[string]struct{ goType, fn string }{
+	"string": {"string", "String"},
+	"bool":   {"bool", "Bool"},
+	"int32":  {"int32", "Int32"},
+	"int64":  {"int64", "Int64"},
+	"double": {"float64", "Double"},
+}
+
+// camel turns a snake_case proto name into a Go one.
+func camel(s string) string {
+	var b strings.Builder
+	for part := range strings.SplitSeq(s, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
+
+// generator writes the Go code of a file.
+type generator struct {
+	bytes.Buffer
+	f      *file
+	source string
+}
+
+func (g *generator) p(format string, args ...any) {
+	fmt.Fprintf(g, format+"\n", args...)
+}
+
+func (g *generator) comment(lines []string, indent string) {
+	for _, l := range lines {
+		g.p("%s// %s", indent, l)
+	}
+}
+
+func (g *generator) isMessage(name string) bool {
+	for _, m := range g.f.messages {
+		if m.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *generator) generate() error {
+	g.p("// Code generated by protogen from %s. DO NOT EDIT.", g.source)
+	g.p("")
+	g.p("package randrpb")
+	g.p("")
+	if len(g.f.services) > 0 {
+		g.p(`import (
+	"context"
+	"net/http"
+)`)
+	}
+	for _, m := range g.f.messages {
+		if err := g.message(m); err != nil {
+			return err
+		}
+	}
+	for _, s := range g.f.services {
+		if err := g.service(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *generator) message(m *message) error {
+	g.p("")
+	g.comment(m.comment, "")
+	g.p("type %s struct {", m.name)
+	for _, f := range m.fields {
+		typ := ""
+		switch s, ok := scalars[f.typ]; {
+		case ok && f.repeated && f.typ != "string":
+			return fmt.Errorf("%s.%s: repeated %s is not supported", m.name, f.name, f.typ)
+		case ok:
+			typ = s.goType
+		case g.isMessage(f.typ):
+			typ = "*" + f.typ
+		default:
+			return fmt.Errorf("%s.%s: unknown type %s", m.name, f.name, f.typ)
+		}
+		if f.repeated {
+			typ = "[]" + typ
+		}
+		g.comment(f.comment, "\t")
+		g.p("\t%s %s", camel(f.name), typ)
+	}
+	g.p("}")
+
+	g.p("")
+	g.p("// Marshal encodes the message in the protocol buffer wire format.")
+	g.p("func (m *%s) Marshal() []byte {", m.name)
+	if len(m.fields) == 0 {
+		g.p("\treturn nil")
+		g.p("}")
+	} else {
+		g.p("\tif m == nil {")
+		g.p("\t\treturn nil")
+		g.p("\t}")
+		g.p("\tvar b []byte")
+		for _, f := range m.fields {
+			name := camel(f.name)
+			s, scalar := scalars[f.typ]
+			switch {
+			case f.repeated && scalar:
+				g.p("\tfor _, v := range m.%s {", name)
+				g.p("\t\tb = appendBytes(b, %d, []byte(v))", f.num)
+				g.p("\t}")
+			case f.repeated:
+				g.p("\tfor _, v := range m.%s {", name)
+				g.p("\t\tb = appendMessage(b, %d, v)", f.num)
+				g.p("\t}")
+			case scalar:
+				g.p("\tb = append%s(b, %d, m.%s)", s.fn, f.num, name)
+			default:
+				g.p("\tif m.%s != nil {", name)
+				g.p("\t\tb = appendMessage(b, %d, m.%s)", f.num, name)
+				g.p("\t}")
+			}
+		}
+		g.p("\treturn b")
+		g.p("}")
+	}
+
+	g.p("")
+	g.p("// Unmarshal decodes the message from the protocol buffer wire format.")
+	g.p("// Unknown fields are skipped.")
+	g.p("func (m *%s) Unmarshal(b []byte) error {", m.name)
+	g.p("\t*m = %s{}", m.name)
+	if len(m.fields) == 0 {
+		g.p("\treturn decode(b, func(field) error { return nil })")
+		g.p("}")
+		return nil
+	}
+	g.p("\treturn decode(b, func(f field) (err error) {")
+	g.p("\t\tswitch f.num {")
+	for _, f := range m.fields {
+		name := camel(f.name)
+		s, scalar := scalars[f.typ]
+		g.p("\t\tcase %d:", f.num)
+		switch {
+		case f.repeated && scalar:
+			g.p("\t\t\tvar v %s", s.goType)
+			g.p("\t\t\tv, err = f.%s()", strings.ToLower(s.fn[:1])+s.fn[1:])
+			g.p("\t\t\tm.%s = append(m.%s, v)", name, name)
+		case f.repeated:
+			g.p("\t\t\tv := new(%s)", f.typ)
+			g.p("\t\t\terr = f.message(v)")
+			g.p("\t\t\tm.%s = append(m.%s, v)", name, name)
+		case scalar:
+			g.p("\t\t\tm.%s, err = f.%s()", name, strings.ToLower(s.fn[:1])+s.fn[1:])
+		default:
+			g.p("\t\t\tm.%s = new(%s)", name, f.typ)
+			g.p("\t\t\terr = f.message(m.%s)", name)
+		}
+	}
+	g.p("\t\t}")
+	g.p("\t\treturn err")
+	g.p("\t})")
+	g.p("}")
+	return nil
+}
+
+func (g *generator) service(s *service) error {
+	for _, r := range s.methods {
+		if !g.isMessage(r.in) || !g.isMessage(r.out) {
+			return fmt.Errorf("%s.%s: unknown message", s.name, r.name)
+		}
+	}
+	path := func(r *rpc) string { return "/" + g.f.pkg + "." + s.name + "/" + r.name }
+
+	g.p("")
+	g.p("// %sClient is a client of the %s service.", s.name, s.name)
+	if len(s.comment) > 0 {
+		g.p("//")
+		g.comment(s.comment, "")
+	}
+	g.p("type %sClient struct {", s.name)
+	g.p("\tcc *ClientConn")
+	g.p("}")
+	g.p("")
+	g.p("// New%sClient returns a client calling the %s service over cc.", s.name, s.name)
+	g.p("func New%sClient(cc *ClientConn) *%sClient {", s.name, s.name)
+	g.p("\treturn &%sClient{cc}", s.name)
+	g.p("}")
+	for _, r := range s.methods {
+		g.p("")
+		g.comment(r.comment, "")
+		if !r.stream {
+			g.p("func (c *%sClient) %s(ctx context.Context, in *%s) (*%s, error) {", s.name, r.name, r.in, r.out)
+			g.p("\tout := new(%s)", r.out)
+			g.p("\tif err := c.cc.Invoke(ctx, %q, in, out); err != nil {", path(r))
+			g.p("\t\treturn nil, err")
+			g.p("\t}")
+			g.p("\treturn out, nil")
+			g.p("}")
+			continue
+		}
+		stream := s.name + r.name + "Client"
+		g.p("func (c *%sClient) %s(ctx context.Context, in *%s) (*%s, error) {", s.name, r.name, r.in, stream)
+		g.p("\tst, err := c.cc.NewStream(ctx, %q, in)", path(r))
+		g.p("\tif err != nil {")
+		g.p("\t\treturn nil, err")
+		g.p("\t}")
+		g.p("\treturn &%s{st}, nil", stream)
+		g.p("}")
+		g.p("")
+		g.p("// %s receives the responses of %s.%s.", stream, s.name, r.name)
+		g.p("type %s struct {", stream)
+		g.p("\tstream *ClientStream")
+		g.p("}")
+		g.p("")
+		g.p("// Recv returns the next response, or io.EOF once the call ended.")
+		g.p("func (x *%s) Recv() (*%s, error) {", stream, r.out)
+		g.p("\tm := new(%s)", r.out)
+		g.p("\tif err := x.stream.RecvMsg(m); err != nil {")
+		g.p("\t\treturn nil, err")
+		g.p("\t}")
+		g.p("\treturn m, nil")
+		g.p("}")
+		g.p("")
+		g.p("// Close ends the call.")
+		g.p("func (x *%s) Close() error {", stream)
+		g.p("\treturn x.stream.Close()")
+		g.p("}")
+	}
+
+	g.p("")
+	g.p("// %sServer implements the %s service.", s.name, s.name)
+	g.p("type %sServer interface {", s.name)
+	for _, r := range s.methods {
+		g.comment(r.comment, "\t")
+		if r.stream {
+			g.p("\t%s(*%s, *%s%sServer) error", r.name, r.in, s.name, r.name)
+		} else {
+			g.p("\t%s(context.Context, *%s) (*%s, error)", r.name, r.in, r.out)
+		}
+	}
+	g.p("}")
+	for _, r := range s.methods {
+		if !r.stream {
+			continue
+		}
+		stream := s.name + r.name + "Server"
+		g.p("")
+		g.p("// %s sends the responses of %s.%s.", stream, s.name, r.name)
+		g.p("type %s struct {", stream)
+		g.p("\tstream *ServerStream")
+		g.p("}")
+		g.p("")
+		g.p("// Send sends a response.")
+		g.p("func (x *%s) Send(m *%s) error {", stream, r.out)
+		g.p("\treturn x.stream.SendMsg(m)")
+		g.p("}")
+		g.p("")
+		g.p("// Context returns the call's context, cancelled when the client goes away.")
+		g.p("func (x *%s) Context() context.Context {", stream)
+		g.p("\treturn x.stream.Context()")
+		g.p("}")
+	}
+	g.p("")
+	g.p("// New%sHandler returns a handler serving srv to gRPC clients. It must be", s.name)
+	g.p("// served over HTTP/2.")
+	g.p("func New%sHandler(srv %sServer) http.Handler {", s.name, s.name)
+	g.p("\treturn service{")
+	for _, r := range s.methods {
+		if r.stream {
+			g.p("\t\t%q: streamMethod(func(in *%s, st *ServerStream) error { return srv.%s(in, &%s%sServer{st}) }),",
+				path(r), r.in, r.name, s.name, r.name)
+		} else {
+			g.p("\t\t%q: unaryMethod(srv.%s),", path(r), r.name)
+		}
+	}
+	g.p("\t}")
+	g.p("}")
+	return nil
+}
+
+// generate returns the Go code for the proto file at path.
+func generate(path string) ([]byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	toks, err := lex(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	f, err := parse(toks)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	g := &generator{f: f, source: filepath.Base(path)}
+	if err := g.generate(); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	code, err := format.Source(g.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting the generated code: %v", err)
+	}
+	return code, nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("protogen: ")
+	out := flag.String("o", "", "write the Go code to `file` instead of stdout")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		log.Fatal("usage: protogen [-o file] file.proto")
+	}
+	code, err := generate(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err := os.WriteFile(*out, code, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestGeneratedCodeIsCurrent(t *testing.T) {
+	code, err := generate("../../randrpb/randr.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := os.ReadFile("../../randrpb/randr.pb.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(code, have) {
+		t.Error("randrpb/randr.pb.go is out of date; run go generate ./randrpb")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		`syntax = "proto2";`,
+		`message M { string name = 0; }`,
+		`message M { repeated int32 ids = 1; }`,
+		`message M { Other o = 1; }`,
+		`service S { rpc Call(In) returns (Out); }`,
+		`enum E { A = 0; }`,
+	} {
+		toks, err := lex(src)
+		if err == nil {
+			var f *file
+			if f, err = parse(toks); err == nil {
+				g := &generator{f: f, source: "test.proto"}
+				err = g.generate()
+			}
+		}
+		if err == nil {
+			t.Errorf("%s: no error", src)
+		}
+	}
+}
@@ -0,0 +1,1038 @@
+package starlark
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type builtinFunc = func(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error)
+
+// universe holds the functions every program can call, and methods the
+// methods of strings, lists and dicts. They are filled in by init, as
+// they call back into the interpreter.
+var (
+	universe map[string]Value
+	methods  map[string]map[string]builtinFunc
+)
+
+func init() {
+	universe = map[string]Value{}
+	for name, fn := range map[string]builtinFunc{
+		"abs": builtinAbs, "all": builtinAll, "any": builtinAny, "bool": builtinBool,
+		"dict": builtinDict, "enumerate": builtinEnumerate, "fail": builtinFail,
+		"float": builtinFloat, "getattr": builtinGetattr, "hasattr": builtinHasattr,
+		"int": builtinInt, "len": builtinLen, "list": builtinList, "max": builtinMinMax,
+		"min": builtinMinMax, "print": builtinPrint, "range": builtinRange, "repr": builtinRepr,
+		"reversed": builtinReversed, "sorted": builtinSorted, "str": builtinStr,
+		"tuple": builtinTuple, "type": builtinType, "zip": builtinZip,
+	} {
+		universe[name] = &Builtin{name: name, fn: fn}
+	}
+	methods = map[string]map[string]builtinFunc{
+		"string": {
+			"count": stringCount, "endswith": stringAffix, "find": stringFind, "format": stringFormat,
+			"isdigit": stringIsdigit, "join": stringJoin, "lower": stringCase, "lstrip": stringStrip,
+			"replace": stringReplace, "rstrip": stringStrip, "split": stringSplit,
+			"startswith": stringAffix, "strip": stringStrip, "upper": stringCase,
+		},
+		"list": {
+			"append": listAppend, "clear": listClear, "extend": listExtend, "index": listIndex,
+			"insert": listInsert, "pop": listPop, "remove": listRemove,
+		},
+		"dict": {
+			"clear": dictClear, "get": dictGet, "items": dictItems, "keys": dictKeys,
+			"pop": dictPop, "setdefault": dictSetdefault, "update": dictUpdate, "values": dictValues,
+		},
+	}
+}
+
+// attr returns the method of a value.
+func attr(v Value, name string) (Value, error) {
+	if fn, ok := methods[Type(v)][name]; ok {
+		return &Builtin{name: name, recv: v, fn: fn}, nil
+	}
+	return nil, fmt.Errorf("%s has no .%s field or method", Type(v), name)
+}
+
+// unpack binds the arguments of a builtin to its parameters, the first
+// required of them required. It reports which parameters were given.
+func unpack(b *Builtin, args Tuple, kwargs []kwarg, required int, params ...string) ([]Value, []bool, error) {
+	if len(args) > len(params) {
+		return nil, nil, fmt.Errorf("%s: got %d arguments, want at most %d", b.name, len(args), len(params))
+	}
+	vals := make([]Value, len(params))
+	set := make([]bool, len(params))
+	for i, a := range args {
+		vals[i], set[i] = a, true
+	}
+	for _, kw := range kwargs {
+		i := slices.Index(params, kw.name)
+		if i < 0 {
+			return nil, nil, fmt.Errorf("%s: unexpected keyword argument %s", b.name, kw.name)
+		}
+		if set[i] {
+			return nil, nil, fmt.Errorf("%s: got multiple values for parameter %s", b.name, kw.name)
+		}
+		vals[i], set[i] = kw.val, true
+	}
+	for i := 0; i < required; i++ {
+		if !set[i] {
+			return nil, nil, fmt.Errorf("%s: missing argument for %s", b.name, params[i])
+		}
+	}
+	return vals, set, nil
+}
+
+func noKwargs(b *Builtin, kwargs []kwarg) error {
+	if len(kwargs) > 0 {
+		return fmt.Errorf("%s: unexpected keyword argument %s", b.name, kwargs[0].name)
+	}
+	return nil
+}
+
+func builtinAbs(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	switch x := v[0].(type) {
+	case int64:
+		if x == math.MinInt64 {
+			return nil, errOverflow
+		}
+		if x < 0 {
+			return -x, nil
+		}
+		return x, nil
+	case float64:
+		return math.Abs(x), nil
+	}
+	return nil, fmt.Errorf("abs: got %s, want int or float", Type(v[0]))
+}
+
+func builtinAll(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	return truthOf(b, args, kwargs, false)
+}
+
+func builtinAny(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	return truthOf(b, args, kwargs, true)
+}
+
+// truthOf implements all and any: it returns found as soon as an element's
+// truth is found.
+func truthOf(b *Builtin, args Tuple, kwargs []kwarg, found bool) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	elems, err := elements(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.name, err)
+	}
+	for _, e := range elems {
+		if Truth(e) == found {
+			return found, nil
+		}
+	}
+	return !found, nil
+}
+
+func builtinBool(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 0, "x")
+	if err != nil {
+		return nil, err
+	}
+	return Truth(v[0]), nil
+}
+
+func builtinDict(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("dict: got %d arguments, want at most 1", len(args))
+	}
+	d := NewDict()
+	if len(args) == 1 {
+		if err := update(d, args[0]); err != nil {
+			return nil, fmt.Errorf("dict: %v", err)
+		}
+	}
+	for _, kw := range kwargs {
+		if err := d.Set(kw.name, kw.val); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// update sets the entries of a dict, or of an iterable of pairs, in d.
+func update(d *Dict, from Value) error {
+	if src, ok := from.(*Dict); ok {
+		for i, k := range src.Keys() {
+			if err := d.Set(k, src.vals[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	elems, err := elements(from)
+	if err != nil {
+		return err
+	}
+	for i, e := range elems {
+		pair, err := elements(e)
+		if err != nil || len(pair) != 2 {
+			return fmt.Errorf("element %d is not a pair", i)
+		}
+		if err := d.Set(pair[0], pair[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func builtinEnumerate(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x", "start")
+	if err != nil {
+		return nil, err
+	}
+	start := 0
+	if v[1] != nil {
+		if start, err = toInt(v[1]); err != nil {
+			return nil, fmt.Errorf("enumerate: %v", err)
+		}
+	}
+	elems, err := elements(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("enumerate: %v", err)
+	}
+	pairs := make([]Value, len(elems))
+	for i, e := range elems {
+		pairs[i] = Tuple{int64(start + i), e}
+	}
+	return NewList(pairs), nil
+}
+
+func builtinFail(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if err := noKwargs(b, kwargs); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("fail: " + join(args, " "))
+}
+
+func join(args Tuple, sep string) string {
+	strs := make([]string, len(args))
+	for i, a := range args {
+		strs[i] = Str(a)
+	}
+	return strings.Join(strs, sep)
+}
+
+func builtinFloat(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 0, "x")
+	if err != nil {
+		return nil, err
+	}
+	switch x := v[0].(type) {
+	case nil:
+		return 0.0, nil
+	case bool:
+		if x {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	case int64:
+		return float64(x), nil
+	case float64:
+		return x, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return nil, fmt.Errorf("float: invalid literal %s", Repr(x))
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("float: got %s, want number or string", Type(v[0]))
+}
+
+func builtinGetattr(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, set, err := unpack(b, args, kwargs, 2, "x", "name", "default")
+	if err != nil {
+		return nil, err
+	}
+	name, ok := v[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("getattr: got %s for name, want string", Type(v[1]))
+	}
+	m, err := attr(v[0], name)
+	if err != nil && set[2] {
+		return v[2], nil
+	}
+	return m, err
+}
+
+func builtinHasattr(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 2, "x", "name")
+	if err != nil {
+		return nil, err
+	}
+	name, ok := v[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("hasattr: got %s for name, want string", Type(v[1]))
+	}
+	_, err = attr(v[0], name)
+	return err == nil, nil
+}
+
+func builtinInt(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, set, err := unpack(b, args, kwargs, 0, "x", "base")
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := v[0].(string); ok {
+		base := 10
+		if set[1] {
+			if base, err = toInt(v[1]); err != nil {
+				return nil, fmt.Errorf("int: base: %v", err)
+			}
+		}
+		i, err := strconv.ParseInt(strings.TrimSpace(s), base, 64)
+		if err != nil {
+			return nil, fmt.Errorf("int: invalid literal %s", Repr(s))
+		}
+		return i, nil
+	}
+	if set[1] {
+		return nil, errors.New("int: can't convert non-string with explicit base")
+	}
+	switch x := v[0].(type) {
+	case nil:
+		return int64(0), nil
+	case bool:
+		if x {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case int64:
+		return x, nil
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) || math.Abs(x) >= 1<<63 {
+			return nil, fmt.Errorf("int: cannot convert %s to int", formatFloat(x))
+		}
+		return int64(x), nil
+	}
+	return nil, fmt.Errorf("int: got %s, want number or string", Type(v[0]))
+}
+
+func builtinLen(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	switch x := v[0].(type) {
+	case string:
+		return int64(len(x)), nil
+	case *List:
+		return int64(len(x.elems)), nil
+	case Tuple:
+		return int64(len(x)), nil
+	case *Dict:
+		return int64(len(x.keys)), nil
+	}
+	return nil, fmt.Errorf("len: value of type %s has no len", Type(v[0]))
+}
+
+func builtinList(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 0, "x")
+	if err != nil {
+		return nil, err
+	}
+	if v[0] == nil {
+		return NewList(nil), nil
+	}
+	elems, err := elements(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("list: %v", err)
+	}
+	return NewList(elems), nil
+}
+
+func builtinTuple(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 0, "x")
+	if err != nil {
+		return nil, err
+	}
+	if v[0] == nil {
+		return Tuple{}, nil
+	}
+	elems, err := elements(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("tuple: %v", err)
+	}
+	return Tuple(elems), nil
+}
+
+// builtinMinMax implements min and max, of an iterable or of the
+// arguments, compared by key when one is given.
+func builtinMinMax(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	var key Value
+	for _, kw := range kwargs {
+		if kw.name != "key" {
+			return nil, fmt.Errorf("%s: unexpected keyword argument %s", b.name, kw.name)
+		}
+		key = kw.val
+	}
+	elems := []Value(args)
+	switch len(args) {
+	case 0:
+		return nil, fmt.Errorf("%s: got 0 arguments, want at least 1", b.name)
+	case 1:
+		var err error
+		if elems, err = elements(args[0]); err != nil {
+			return nil, fmt.Errorf("%s: %v", b.name, err)
+		}
+	}
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("%s: empty sequence", b.name)
+	}
+	best, bestKey := elems[0], elems[0]
+	for i, e := range elems {
+		k := e
+		if key != nil {
+			var err error
+			if k, err = th.call(key, Tuple{e}, nil); err != nil {
+				return nil, err
+			}
+		}
+		if i == 0 {
+			bestKey = k
+			continue
+		}
+		c, err := Compare(k, bestKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", b.name, err)
+		}
+		if b.name == "min" && c < 0 || b.name == "max" && c > 0 {
+			best, bestKey = e, k
+		}
+	}
+	return best, nil
+}
+
+func builtinPrint(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	sep := " "
+	for _, kw := range kwargs {
+		s, ok := kw.val.(string)
+		if kw.name != "sep" || !ok {
+			return nil, fmt.Errorf("print: unexpected keyword argument %s", kw.name)
+		}
+		sep = s
+	}
+	if th.Print != nil {
+		th.Print(join(args, sep))
+	}
+	return nil, nil
+}
+
+func builtinRange(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if err := noKwargs(b, kwargs); err != nil {
+		return nil, err
+	}
+	if len(args) < 1 || len(args) > 3 {
+		return nil, fmt.Errorf("range: got %d arguments, want 1 to 3", len(args))
+	}
+	bounds := []int{0, 0, 1}
+	for i, a := range args {
+		n, err := toInt(a)
+		if err != nil {
+			return nil, fmt.Errorf("range: %v", err)
+		}
+		bounds[i] = n
+	}
+	start, stop, step := bounds[0], bounds[1], bounds[2]
+	if len(args) == 1 {
+		start, stop = 0, bounds[0]
+	}
+	if step == 0 {
+		return nil, errors.New("range: step argument must not be zero")
+	}
+	var elems []Value
+	for i := start; step > 0 && i < stop || step < 0 && i > stop; i += step {
+		if len(elems) >= maxLen {
+			return nil, errors.New("range: too many elements")
+		}
+		elems = append(elems, int64(i))
+	}
+	return NewList(elems), nil
+}
+
+func builtinRepr(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	return Repr(v[0]), nil
+}
+
+func builtinReversed(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	elems, err := elements(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("reversed: %v", err)
+	}
+	slices.Reverse(elems)
+	return NewList(elems), nil
+}
+
+func builtinSorted(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x", "key", "reverse")
+	if err != nil {
+		return nil, err
+	}
+	elems, err := elements(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("sorted: %v", err)
+	}
+	keys := elems
+	if v[1] != nil {
+		keys = make([]Value, len(elems))
+		for i, e := range elems {
+			if keys[i], err = th.call(v[1], Tuple{e}, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	order := make([]int, len(elems))
+	for i := range order {
+		order[i] = i
+	}
+	reverse := Truth(v[2])
+	var cmpErr error
+	sort.SliceStable(order, func(i, j int) bool {
+		c, err := Compare(keys[order[i]], keys[order[j]])
+		if err != nil && cmpErr == nil {
+			cmpErr = err
+		}
+		if reverse {
+			return c > 0
+		}
+		return c < 0
+	})
+	if cmpErr != nil {
+		return nil, fmt.Errorf("sorted: %v", cmpErr)
+	}
+	sorted := make([]Value, len(elems))
+	for i, j := range order {
+		sorted[i] = elems[j]
+	}
+	return NewList(sorted), nil
+}
+
+func builtinStr(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	return Str(v[0]), nil
+}
+
+func builtinType(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	return Type(v[0]), nil
+}
+
+func builtinZip(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if err := noKwargs(b, kwargs); err != nil {
+		return nil, err
+	}
+	var lists [][]Value
+	for _, a := range args {
+		elems, err := elements(a)
+		if err != nil {
+			return nil, fmt.Errorf("zip: %v", err)
+		}
+		lists = append(lists, elems)
+	}
+	var tuples []Value
+	for i := 0; len(lists) > 0; i++ {
+		t := make(Tuple, len(lists))
+		for j, l := range lists {
+			if i >= len(l) {
+				return NewList(tuples), nil
+			}
+			t[j] = l[i]
+		}
+		tuples = append(tuples, t)
+	}
+	return NewList(tuples), nil
+}
+
+// stringArg returns an argument that must be a string.
+func stringArg(b *Builtin, v Value, name string) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: got %s for %s, want string", b.name, Type(v), name)
+	}
+	return s, nil
+}
+
+func stringCount(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "sub")
+	if err != nil {
+		return nil, err
+	}
+	sub, err := stringArg(b, v[0], "sub")
+	if err != nil {
+		return nil, err
+	}
+	return int64(strings.Count(b.recv.(string), sub)), nil
+}
+
+// stringAffix implements startswith and endswith, of a string or of any of
+// a tuple of them.
+func stringAffix(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	affixes := Tuple{v[0]}
+	if t, ok := v[0].(Tuple); ok {
+		affixes = t
+	}
+	s := b.recv.(string)
+	for _, a := range affixes {
+		x, err := stringArg(b, a, "x")
+		if err != nil {
+			return nil, err
+		}
+		if b.name == "startswith" && strings.HasPrefix(s, x) || b.name == "endswith" && strings.HasSuffix(s, x) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func stringFind(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "sub")
+	if err != nil {
+		return nil, err
+	}
+	sub, err := stringArg(b, v[0], "sub")
+	if err != nil {
+		return nil, err
+	}
+	return int64(strings.Index(b.recv.(string), sub)), nil
+}
+
+// stringFormat replaces the fields of a string: {} with the next argument,
+// {0} with an argument by position, {name} with one by keyword.
+func stringFormat(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	s := b.recv.(string)
+	var out strings.Builder
+	next := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '{' && strings.HasPrefix(s[i:], "{{"), c == '}' && strings.HasPrefix(s[i:], "}}"):
+			out.WriteByte(c)
+			i++
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return nil, errors.New("format: unmatched '{'")
+			}
+			field := s[i+1 : i+end]
+			i += end
+			var v Value
+			if n, err := strconv.Atoi(field); err == nil || field == "" {
+				if field == "" {
+					n = next
+					next++
+				}
+				if n < 0 || n >= len(args) {
+					return nil, fmt.Errorf("format: index %d out of range", n)
+				}
+				v = args[n]
+			} else {
+				found := false
+				for _, kw := range kwargs {
+					if kw.name == field {
+						v, found = kw.val, true
+					}
+				}
+				if !found {
+					return nil, fmt.Errorf("format: keyword %s not found", field)
+				}
+			}
+			out.WriteString(Str(v))
+		case c == '}':
+			return nil, errors.New("format: single '}' in format")
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String(), nil
+}
+
+func stringIsdigit(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if _, _, err := unpack(b, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	s := b.recv.(string)
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) {
+			return false, nil
+		}
+	}
+	return s != "", nil
+}
+
+func stringJoin(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	elems, err := elements(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("join: %v", err)
+	}
+	strs := make([]string, len(elems))
+	for i, e := range elems {
+		if strs[i], err = stringArg(b, e, "element"); err != nil {
+			return nil, err
+		}
+	}
+	return strings.Join(strs, b.recv.(string)), nil
+}
+
+// stringCase implements lower and upper.
+func stringCase(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if _, _, err := unpack(b, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	if b.name == "lower" {
+		return strings.ToLower(b.recv.(string)), nil
+	}
+	return strings.ToUpper(b.recv.(string)), nil
+}
+
+func stringReplace(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, set, err := unpack(b, args, kwargs, 2, "old", "new", "count")
+	if err != nil {
+		return nil, err
+	}
+	old, err := stringArg(b, v[0], "old")
+	if err != nil {
+		return nil, err
+	}
+	repl, err := stringArg(b, v[1], "new")
+	if err != nil {
+		return nil, err
+	}
+	n := -1
+	if set[2] {
+		if n, err = toInt(v[2]); err != nil {
+			return nil, fmt.Errorf("replace: count: %v", err)
+		}
+	}
+	return strings.Replace(b.recv.(string), old, repl, n), nil
+}
+
+func stringSplit(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, set, err := unpack(b, args, kwargs, 0, "sep", "maxsplit")
+	if err != nil {
+		return nil, err
+	}
+	n := -1
+	if set[1] {
+		if n, err = toInt(v[1]); err != nil {
+			return nil, fmt.Errorf("split: maxsplit: %v", err)
+		}
+	}
+	s := b.recv.(string)
+	var parts []string
+	if v[0] == nil {
+		parts = strings.Fields(s)
+		if n >= 0 && len(parts) > n+1 {
+			rest := s
+			parts = parts[:0]
+			for i := 0; i < n; i++ {
+				rest = strings.TrimLeft(rest, " \t\n\r\v\f")
+				j := strings.IndexAny(rest, " \t\n\r\v\f")
+				parts = append(parts, rest[:j])
+				rest = rest[j:]
+			}
+			parts = append(parts, strings.TrimLeft(rest, " \t\n\r\v\f"))
+		}
+	} else {
+		sep, err := stringArg(b, v[0], "sep")
+		if err != nil {
+			return nil, err
+		}
+		if sep == "" {
+			return nil, errors.New("split: empty separator")
+		}
+		if n >= 0 {
+			n++
+		}
+		parts = strings.SplitN(s, sep, n)
+	}
+	elems := make([]Value, len(parts))
+	for i, p := range parts {
+		elems[i] = p
+	}
+	return NewList(elems), nil
+}
+
+// stringStrip implements strip, lstrip and rstrip, of whitespace or of the
+// given characters.
+func stringStrip(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 0, "chars")
+	if err != nil {
+		return nil, err
+	}
+	chars := " \t\n\r\v\f"
+	if v[0] != nil {
+		if chars, err = stringArg(b, v[0], "chars"); err != nil {
+			return nil, err
+		}
+	}
+	s := b.recv.(string)
+	switch b.name {
+	case "lstrip":
+		return strings.TrimLeft(s, chars), nil
+	case "rstrip":
+		return strings.TrimRight(s, chars), nil
+	}
+	return strings.Trim(s, chars), nil
+}
+
+func listAppend(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	l := b.recv.(*List)
+	if err := l.checkMutable(); err != nil {
+		return nil, err
+	}
+	l.elems = append(l.elems, v[0])
+	return nil, nil
+}
+
+func listClear(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if _, _, err := unpack(b, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	l := b.recv.(*List)
+	if err := l.checkMutable(); err != nil {
+		return nil, err
+	}
+	l.elems = nil
+	return nil, nil
+}
+
+func listExtend(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	l := b.recv.(*List)
+	if err := l.checkMutable(); err != nil {
+		return nil, err
+	}
+	elems, err := elements(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("extend: %v", err)
+	}
+	l.elems = append(l.elems, elems...)
+	return nil, nil
+}
+
+func listIndex(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range b.recv.(*List).elems {
+		if eq, err := Equal(e, v[0]); err != nil || eq {
+			return int64(i), err
+		}
+	}
+	return nil, fmt.Errorf("index: value %s not in list", Repr(v[0]))
+}
+
+func listInsert(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 2, "index", "x")
+	if err != nil {
+		return nil, err
+	}
+	l := b.recv.(*List)
+	if err := l.checkMutable(); err != nil {
+		return nil, err
+	}
+	i, err := toInt(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("insert: index: %v", err)
+	}
+	if i < 0 {
+		i += len(l.elems)
+	}
+	i = min(max(i, 0), len(l.elems))
+	l.elems = slices.Insert(l.elems, i, v[1])
+	return nil, nil
+}
+
+func listPop(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, set, err := unpack(b, args, kwargs, 0, "index")
+	if err != nil {
+		return nil, err
+	}
+	l := b.recv.(*List)
+	if err := l.checkMutable(); err != nil {
+		return nil, err
+	}
+	k := Value(int64(-1))
+	if set[0] {
+		k = v[0]
+	}
+	i, err := elementIndex(k, len(l.elems))
+	if err != nil {
+		return nil, fmt.Errorf("pop: %v", err)
+	}
+	e := l.elems[i]
+	l.elems = slices.Delete(l.elems, i, i+1)
+	return e, nil
+}
+
+func listRemove(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "x")
+	if err != nil {
+		return nil, err
+	}
+	l := b.recv.(*List)
+	if err := l.checkMutable(); err != nil {
+		return nil, err
+	}
+	for i, e := range l.elems {
+		if eq, err := Equal(e, v[0]); err != nil {
+			return nil, err
+		} else if eq {
+			l.elems = slices.Delete(l.elems, i, i+1)
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("remove: element %s not found", Repr(v[0]))
+}
+
+func dictClear(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if _, _, err := unpack(b, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	d := b.recv.(*Dict)
+	if d.iterating > 0 {
+		return nil, errors.New("dict changed during iteration")
+	}
+	*d = *NewDict()
+	return nil, nil
+}
+
+func dictGet(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "key", "default")
+	if err != nil {
+		return nil, err
+	}
+	val, ok, err := b.recv.(*Dict).Get(v[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return v[1], nil
+	}
+	return val, nil
+}
+
+func dictItems(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if _, _, err := unpack(b, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	d := b.recv.(*Dict)
+	items := make([]Value, len(d.keys))
+	for i, k := range d.keys {
+		items[i] = Tuple{k, d.vals[i]}
+	}
+	return NewList(items), nil
+}
+
+func dictKeys(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if _, _, err := unpack(b, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return NewList(b.recv.(*Dict).Keys()), nil
+}
+
+func dictPop(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, set, err := unpack(b, args, kwargs, 1, "key", "default")
+	if err != nil {
+		return nil, err
+	}
+	val, ok, err := b.recv.(*Dict).delete(v[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if set[1] {
+			return v[1], nil
+		}
+		return nil, fmt.Errorf("pop: missing key %s", Repr(v[0]))
+	}
+	return val, nil
+}
+
+func dictSetdefault(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	v, _, err := unpack(b, args, kwargs, 1, "key", "default")
+	if err != nil {
+		return nil, err
+	}
+	d := b.recv.(*Dict)
+	val, ok, err := d.Get(v[0])
+	if err != nil || ok {
+		return val, err
+	}
+	return v[1], d.Set(v[0], v[1])
+}
+
+func dictUpdate(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("update: got %d arguments, want at most 1", len(args))
+	}
+	d := b.recv.(*Dict)
+	if len(args) == 1 {
+		if err := update(d, args[0]); err != nil {
+			return nil, fmt.Errorf("update: %v", err)
+		}
+	}
+	for _, kw := range kwargs {
+		if err := d.Set(kw.name, kw.val); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func dictValues(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error) {
+	if _, _, err := unpack(b, args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	d := b.recv.(*Dict)
+	return NewList(append([]Value(nil), d.vals...)), nil
+}
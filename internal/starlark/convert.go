@@ -0,0 +1,104 @@
+package starlark
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// FromGo converts a value as encoding/json decodes it into a Starlark
+// value. Whole numbers become ints, objects dicts with sorted keys.
+func FromGo(v any) (Value, error) {
+	switch v := v.(type) {
+	case nil, bool, string, int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v), nil
+		}
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	case []any:
+		elems := make([]Value, len(v))
+		for i, e := range v {
+			var err error
+			if elems[i], err = FromGo(e); err != nil {
+				return nil, err
+			}
+		}
+		return NewList(elems), nil
+	case map[string]any:
+		d := NewDict()
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			e, err := FromGo(v[k])
+			if err != nil {
+				return nil, err
+			}
+			if err := d.Set(k, e); err != nil {
+				return nil, err
+			}
+		}
+		return d, nil
+	}
+	return nil, fmt.Errorf("cannot convert %T to a Starlark value", v)
+}
+
+// ToGo converts a Starlark value into one encoding/json can encode: lists
+// and tuples become slices, dicts, whose keys must be strings, maps.
+func ToGo(v Value) (any, error) {
+	switch v := v.(type) {
+	case nil, bool, string, int64:
+		return v, nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("cannot convert %s", formatFloat(v))
+		}
+		return v, nil
+	case *List:
+		return toGoSlice(v.elems)
+	case Tuple:
+		return toGoSlice(v)
+	case *Dict:
+		m := make(map[string]any, len(v.keys))
+		for i, k := range v.keys {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("cannot convert dict with %s key", Type(k))
+			}
+			e, err := ToGo(v.vals[i])
+			if err != nil {
+				return nil, err
+			}
+			m[s] = e
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s", Type(v))
+}
+
+func toGoSlice(elems []Value) (any, error) {
+	s := make([]any, len(elems))
+	for i, e := range elems {
+		var err error
+		if s[i], err = ToGo(e); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
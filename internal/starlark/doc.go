@@ -0,0 +1,14 @@
+// Package starlark interprets the subset of Starlark that randr's layout
+// scripts are written in.
+//
+// Programs have the statements def, if, for, return, break, continue,
+// pass and assignments, augmented and unpacking ones included, and the
+// expressions of Starlark but for *args, **kwargs and chained comparisons.
+// Values are None, bools, 64-bit ints, floats, strings, lists, tuples,
+// dicts and functions. The universe holds the usual builtins (len, range,
+// sorted, min, max, print, fail and so on) and strings, lists and dicts
+// have their common methods. load is not supported.
+//
+// As in Starlark, functions may not recurse, so every program ends; a run
+// is also bounded in steps and stopped by its thread's context.
+package starlark
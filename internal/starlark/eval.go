@@ -0,0 +1,646 @@
+package starlark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// maxSteps bounds the statements and calls of a run, so that a program
+// stops even when its context never does.
+const maxSteps = 10_000_000
+
+// Thread is the state of one run of a program. A thread runs one program
+// at a time.
+type Thread struct {
+	// Context stops the run when it is done. A nil context never is.
+	Context context.Context
+	// Print receives what the program prints. Without it, prints are
+	// discarded.
+	Print func(msg string)
+
+	file  string
+	steps int
+	stack []*funcDef
+}
+
+// env holds the variables of a scope: the globals of a program, the locals
+// of a call, or the variables of a comprehension.
+type env struct {
+	vars   map[string]Value
+	parent *env
+	// fn is the function of a call's scope.
+	fn *funcDef
+}
+
+func (e *env) lookup(name string) (Value, error) {
+	for s := e; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, nil
+		}
+		if s.fn != nil && s.fn.locals[name] {
+			return nil, fmt.Errorf("local variable %s referenced before assignment", name)
+		}
+	}
+	if b, ok := universe[name]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("undefined: %s", name)
+}
+
+// ExecFile runs a program and returns its global variables. The filename
+// is used in errors.
+func ExecFile(th *Thread, filename string, src []byte) (map[string]Value, error) {
+	th.file = filename
+	stmts, err := parse(string(src))
+	if err != nil {
+		return nil, th.errorAt(Pos{}, err)
+	}
+	globals := &env{vars: map[string]Value{}}
+	if _, _, err := th.exec(globals, stmts); err != nil {
+		return nil, err
+	}
+	return globals.vars, nil
+}
+
+// Call calls a function with positional arguments.
+func Call(th *Thread, fn Value, args ...Value) (Value, error) {
+	v, err := th.call(fn, args, nil)
+	if err != nil {
+		var e *Error
+		if !errors.As(err, &e) {
+			err = &Error{File: th.file, Msg: err.Error()}
+		}
+	}
+	return v, err
+}
+
+// errorAt places an error at a position, unless it comes from deeper in the
+// program and already has one.
+func (th *Thread) errorAt(p Pos, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		if e.File == "" {
+			e.File = th.file
+		}
+		return e
+	}
+	return &Error{File: th.file, Pos: p, Msg: err.Error()}
+}
+
+func (th *Thread) step(p Pos) error {
+	th.steps++
+	if th.steps > maxSteps {
+		return th.errorAt(p, errors.New("too many steps"))
+	}
+	if th.steps%1024 == 0 && th.Context != nil {
+		if err := th.Context.Err(); err != nil {
+			return th.errorAt(p, err)
+		}
+	}
+	return nil
+}
+
+type flow int
+
+const (
+	flowNext flow = iota
+	flowBreak
+	flowContinue
+	flowReturn
+)
+
+func (th *Thread) exec(e *env, stmts []stmt) (flow, Value, error) {
+	for _, s := range stmts {
+		if err := th.step(s.position()); err != nil {
+			return flowNext, nil, err
+		}
+		switch s := s.(type) {
+		case *exprStmt:
+			if _, err := th.eval(e, s.x); err != nil {
+				return flowNext, nil, err
+			}
+		case *assignStmt:
+			if err := th.execAssign(e, s); err != nil {
+				return flowNext, nil, err
+			}
+		case *ifStmt:
+			cond, err := th.eval(e, s.cond)
+			if err != nil {
+				return flowNext, nil, err
+			}
+			body := s.els
+			if Truth(cond) {
+				body = s.then
+			}
+			if f, v, err := th.exec(e, body); err != nil || f != flowNext {
+				return f, v, err
+			}
+		case *forStmt:
+			if f, v, err := th.execFor(e, s); err != nil || f != flowNext {
+				return f, v, err
+			}
+		case *returnStmt:
+			var v Value
+			if s.x != nil {
+				var err error
+				if v, err = th.eval(e, s.x); err != nil {
+					return flowNext, nil, err
+				}
+			}
+			return flowReturn, v, nil
+		case *branchStmt:
+			switch s.kind {
+			case "break":
+				return flowBreak, nil, nil
+			case "continue":
+				return flowContinue, nil, nil
+			}
+		case *defStmt:
+			fn, err := th.function(e, s.fn)
+			if err != nil {
+				return flowNext, nil, err
+			}
+			e.vars[s.fn.name] = fn
+		}
+	}
+	return flowNext, nil, nil
+}
+
+func (th *Thread) execFor(e *env, s *forStmt) (flow, Value, error) {
+	x, err := th.eval(e, s.x)
+	if err != nil {
+		return flowNext, nil, err
+	}
+	elems, done, err := iterate(x)
+	if err != nil {
+		return flowNext, nil, th.errorAt(s.x.position(), err)
+	}
+	defer done()
+	for _, el := range elems {
+		if err := th.assign(e, s.vars, el); err != nil {
+			return flowNext, nil, err
+		}
+		f, v, err := th.exec(e, s.body)
+		if err != nil {
+			return flowNext, nil, err
+		}
+		switch f {
+		case flowBreak:
+			return flowNext, nil, nil
+		case flowReturn:
+			return f, v, nil
+		}
+	}
+	return flowNext, nil, nil
+}
+
+func (th *Thread) execAssign(e *env, s *assignStmt) error {
+	if s.op == "=" {
+		v, err := th.eval(e, s.rhs)
+		if err != nil {
+			return err
+		}
+		return th.assign(e, s.lhs, v)
+	}
+	op := strings.TrimSuffix(s.op, "=")
+	update := func(old Value) (Value, error) {
+		y, err := th.eval(e, s.rhs)
+		if err != nil {
+			return nil, err
+		}
+		if l, ok := old.(*List); ok && op == "+" {
+			elems, err := elements(y)
+			if err != nil {
+				return nil, th.errorAt(s.pos, err)
+			}
+			if err := l.checkMutable(); err != nil {
+				return nil, th.errorAt(s.pos, err)
+			}
+			l.elems = append(l.elems, elems...)
+			return l, nil
+		}
+		v, err := binaryOp(op, old, y)
+		if err != nil {
+			return nil, th.errorAt(s.pos, err)
+		}
+		return v, nil
+	}
+	switch lhs := s.lhs.(type) {
+	case *identExpr:
+		old, err := e.lookup(lhs.name)
+		if err != nil {
+			return th.errorAt(lhs.pos, err)
+		}
+		v, err := update(old)
+		if err != nil {
+			return err
+		}
+		e.vars[lhs.name] = v
+		return nil
+	case *indexExpr:
+		x, err := th.eval(e, lhs.x)
+		if err != nil {
+			return err
+		}
+		k, err := th.eval(e, lhs.index)
+		if err != nil {
+			return err
+		}
+		old, err := index(x, k)
+		if err != nil {
+			return th.errorAt(lhs.pos, err)
+		}
+		v, err := update(old)
+		if err != nil {
+			return err
+		}
+		if err := setIndex(x, k, v); err != nil {
+			return th.errorAt(lhs.pos, err)
+		}
+		return nil
+	}
+	return th.errorAt(s.pos, errors.New("cannot assign to this expression"))
+}
+
+// assign binds a value to a target: a variable, an element, or a tuple or
+// list of targets the value unpacks into.
+func (th *Thread) assign(e *env, target expr, v Value) error {
+	switch t := target.(type) {
+	case *identExpr:
+		e.vars[t.name] = v
+		return nil
+	case *indexExpr:
+		x, err := th.eval(e, t.x)
+		if err != nil {
+			return err
+		}
+		k, err := th.eval(e, t.index)
+		if err != nil {
+			return err
+		}
+		if err := setIndex(x, k, v); err != nil {
+			return th.errorAt(t.pos, err)
+		}
+		return nil
+	case *tupleExpr:
+		return th.unpack(e, t.pos, t.elems, v)
+	case *listExpr:
+		return th.unpack(e, t.pos, t.elems, v)
+	}
+	return th.errorAt(target.position(), errors.New("cannot assign to this expression"))
+}
+
+func (th *Thread) unpack(e *env, p Pos, targets []expr, v Value) error {
+	elems, err := elements(v)
+	if err != nil {
+		return th.errorAt(p, fmt.Errorf("cannot unpack %s", Type(v)))
+	}
+	if len(elems) != len(targets) {
+		return th.errorAt(p, fmt.Errorf("cannot unpack %d values into %d variables", len(elems), len(targets)))
+	}
+	for i, t := range targets {
+		if err := th.assign(e, t, elems[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// function makes a function of a definition, evaluating its defaults.
+func (th *Thread) function(e *env, def *funcDef) (*Function, error) {
+	fn := &Function{def: def, env: e}
+	for _, p := range def.params {
+		var v Value
+		if p.def != nil {
+			var err error
+			if v, err = th.eval(e, p.def); err != nil {
+				return nil, err
+			}
+		}
+		fn.defaults = append(fn.defaults, v)
+	}
+	return fn, nil
+}
+
+func (th *Thread) eval(e *env, x expr) (Value, error) {
+	switch x := x.(type) {
+	case *identExpr:
+		v, err := e.lookup(x.name)
+		if err != nil {
+			return nil, th.errorAt(x.pos, err)
+		}
+		return v, nil
+	case *literalExpr:
+		return x.val, nil
+	case *listExpr:
+		elems, err := th.evalAll(e, x.elems)
+		if err != nil {
+			return nil, err
+		}
+		return NewList(elems), nil
+	case *tupleExpr:
+		elems, err := th.evalAll(e, x.elems)
+		if err != nil {
+			return nil, err
+		}
+		return Tuple(elems), nil
+	case *dictExpr:
+		d := NewDict()
+		for i := range x.keys {
+			k, err := th.eval(e, x.keys[i])
+			if err != nil {
+				return nil, err
+			}
+			v, err := th.eval(e, x.vals[i])
+			if err != nil {
+				return nil, err
+			}
+			_, dup, err := d.Get(k)
+			if err == nil && dup {
+				err = fmt.Errorf("duplicate key %s", Repr(k))
+			}
+			if err == nil {
+				err = d.Set(k, v)
+			}
+			if err != nil {
+				return nil, th.errorAt(x.keys[i].position(), err)
+			}
+		}
+		return d, nil
+	case *compExpr:
+		return th.comprehension(e, x)
+	case *unaryExpr:
+		v, err := th.eval(e, x.x)
+		if err != nil {
+			return nil, err
+		}
+		if x.op == "not" {
+			return !Truth(v), nil
+		}
+		switch v := v.(type) {
+		case int64:
+			if x.op == "-" {
+				if v == math.MinInt64 {
+					return nil, th.errorAt(x.pos, errors.New("integer overflow"))
+				}
+				return -v, nil
+			}
+			return v, nil
+		case float64:
+			if x.op == "-" {
+				return -v, nil
+			}
+			return v, nil
+		}
+		return nil, th.errorAt(x.pos, fmt.Errorf("unknown unary op: %s%s", x.op, Type(v)))
+	case *binaryExpr:
+		l, err := th.eval(e, x.x)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "and":
+			if !Truth(l) {
+				return l, nil
+			}
+			return th.eval(e, x.y)
+		case "or":
+			if Truth(l) {
+				return l, nil
+			}
+			return th.eval(e, x.y)
+		}
+		r, err := th.eval(e, x.y)
+		if err != nil {
+			return nil, err
+		}
+		v, err := binaryOp(x.op, l, r)
+		if err != nil {
+			return nil, th.errorAt(x.pos, err)
+		}
+		return v, nil
+	case *condExpr:
+		cond, err := th.eval(e, x.cond)
+		if err != nil {
+			return nil, err
+		}
+		if Truth(cond) {
+			return th.eval(e, x.then)
+		}
+		return th.eval(e, x.els)
+	case *indexExpr:
+		v, err := th.eval(e, x.x)
+		if err != nil {
+			return nil, err
+		}
+		k, err := th.eval(e, x.index)
+		if err != nil {
+			return nil, err
+		}
+		r, err := index(v, k)
+		if err != nil {
+			return nil, th.errorAt(x.pos, err)
+		}
+		return r, nil
+	case *sliceExpr:
+		v, err := th.eval(e, x.x)
+		if err != nil {
+			return nil, err
+		}
+		var bounds [3]Value
+		for i, b := range []expr{x.lo, x.hi, x.step} {
+			if b != nil {
+				if bounds[i], err = th.eval(e, b); err != nil {
+					return nil, err
+				}
+			}
+		}
+		r, err := slice(v, bounds[0], bounds[1], bounds[2])
+		if err != nil {
+			return nil, th.errorAt(x.pos, err)
+		}
+		return r, nil
+	case *dotExpr:
+		v, err := th.eval(e, x.x)
+		if err != nil {
+			return nil, err
+		}
+		m, err := attr(v, x.name)
+		if err != nil {
+			return nil, th.errorAt(x.pos, err)
+		}
+		return m, nil
+	case *callExpr:
+		fn, err := th.eval(e, x.fn)
+		if err != nil {
+			return nil, err
+		}
+		var args Tuple
+		var kwargs []kwarg
+		for _, a := range x.args {
+			v, err := th.eval(e, a.x)
+			if err != nil {
+				return nil, err
+			}
+			if a.name == "" {
+				args = append(args, v)
+			} else {
+				kwargs = append(kwargs, kwarg{a.name, v})
+			}
+		}
+		if err := th.step(x.pos); err != nil {
+			return nil, err
+		}
+		v, err := th.call(fn, args, kwargs)
+		if err != nil {
+			return nil, th.errorAt(x.pos, err)
+		}
+		return v, nil
+	case *lambdaExpr:
+		return th.function(e, x.fn)
+	}
+	return nil, th.errorAt(x.position(), fmt.Errorf("unexpected expression %T", x))
+}
+
+func (th *Thread) evalAll(e *env, xs []expr) ([]Value, error) {
+	vals := make([]Value, len(xs))
+	for i, x := range xs {
+		v, err := th.eval(e, x)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+// comprehension evaluates a comprehension in a scope of its own, so that
+// its loop variables do not leak.
+func (th *Thread) comprehension(e *env, x *compExpr) (Value, error) {
+	scope := &env{vars: map[string]Value{}, parent: e}
+	var list []Value
+	dict := NewDict()
+	var loop func(clauses []compClause) error
+	loop = func(clauses []compClause) error {
+		if len(clauses) == 0 {
+			if err := th.step(x.pos); err != nil {
+				return err
+			}
+			v, err := th.eval(scope, x.val)
+			if err != nil {
+				return err
+			}
+			if x.key == nil {
+				list = append(list, v)
+				return nil
+			}
+			k, err := th.eval(scope, x.key)
+			if err != nil {
+				return err
+			}
+			if err := dict.Set(k, v); err != nil {
+				return th.errorAt(x.key.position(), err)
+			}
+			return nil
+		}
+		c := clauses[0]
+		v, err := th.eval(scope, c.x)
+		if err != nil {
+			return err
+		}
+		if c.vars == nil {
+			if Truth(v) {
+				return loop(clauses[1:])
+			}
+			return nil
+		}
+		elems, done, err := iterate(v)
+		if err != nil {
+			return th.errorAt(c.x.position(), err)
+		}
+		defer done()
+		for _, el := range elems {
+			if err := th.assign(scope, c.vars, el); err != nil {
+				return err
+			}
+			if err := loop(clauses[1:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := loop(x.clauses); err != nil {
+		return nil, err
+	}
+	if x.key == nil {
+		return NewList(list), nil
+	}
+	return dict, nil
+}
+
+// call calls a function. Starlark functions may not recurse.
+func (th *Thread) call(fn Value, args Tuple, kwargs []kwarg) (Value, error) {
+	switch f := fn.(type) {
+	case *Builtin:
+		return f.fn(th, f, args, kwargs)
+	case *Function:
+		for _, d := range th.stack {
+			if d == f.def {
+				return nil, fmt.Errorf("function %s called recursively", f.def.name)
+			}
+		}
+		vars, err := bind(f, args, kwargs)
+		if err != nil {
+			return nil, err
+		}
+		th.stack = append(th.stack, f.def)
+		defer func() { th.stack = th.stack[:len(th.stack)-1] }()
+		_, v, err := th.exec(&env{vars: vars, parent: f.env, fn: f.def}, f.def.body)
+		return v, err
+	}
+	return nil, fmt.Errorf("invalid call of non-function (%s)", Type(fn))
+}
+
+// bind binds the arguments of a call to the parameters of the function.
+func bind(f *Function, args Tuple, kwargs []kwarg) (map[string]Value, error) {
+	params := f.def.params
+	if len(args) > len(params) {
+		return nil, fmt.Errorf("function %s accepts at most %d positional arguments (%d given)", f.def.name, len(params), len(args))
+	}
+	vars := make(map[string]Value, len(params))
+	set := make(map[string]bool, len(params))
+	for i, a := range args {
+		vars[params[i].name] = a
+		set[params[i].name] = true
+	}
+	for _, kw := range kwargs {
+		found := false
+		for _, p := range params {
+			if p.name == kw.name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("function %s got an unexpected keyword argument %s", f.def.name, kw.name)
+		}
+		if set[kw.name] {
+			return nil, fmt.Errorf("function %s got multiple values for parameter %s", f.def.name, kw.name)
+		}
+		vars[kw.name] = kw.val
+		set[kw.name] = true
+	}
+	for i, p := range params {
+		if set[p.name] {
+			continue
+		}
+		if p.def == nil {
+			return nil, fmt.Errorf("function %s missing argument for %s", f.def.name, p.name)
+		}
+		vars[p.name] = f.defaults[i]
+	}
+	return vars, nil
+}
@@ -0,0 +1,467 @@
+package starlark
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxLen bounds the lists and strings that repetition and range make.
+const maxLen = 1 << 20
+
+var errOverflow = errors.New("integer overflow")
+
+func binaryOp(op string, x, y Value) (Value, error) {
+	switch op {
+	case "==":
+		return Equal(x, y)
+	case "!=":
+		eq, err := Equal(x, y)
+		return !eq, err
+	case "<", "<=", ">", ">=":
+		c, err := Compare(x, y)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "in", "not in":
+		in, err := contains(y, x)
+		if err != nil {
+			return nil, err
+		}
+		return in == (op == "in"), nil
+	}
+	if xi, ok := x.(int64); ok {
+		if yi, ok := y.(int64); ok {
+			return intOp(op, xi, yi)
+		}
+	}
+	if xf, yf, ok := numbers(x, y); ok {
+		switch op {
+		case "+":
+			return xf + yf, nil
+		case "-":
+			return xf - yf, nil
+		case "*":
+			return xf * yf, nil
+		case "/", "//", "%":
+			if yf == 0 {
+				return nil, errors.New("floating-point division by zero")
+			}
+			switch op {
+			case "/":
+				return xf / yf, nil
+			case "//":
+				return math.Floor(xf / yf), nil
+			}
+			r := math.Mod(xf, yf)
+			if r != 0 && (r < 0) != (yf < 0) {
+				r += yf
+			}
+			return r, nil
+		}
+	}
+	switch x := x.(type) {
+	case string:
+		switch y := y.(type) {
+		case string:
+			if op == "+" {
+				return x + y, nil
+			}
+		case int64:
+			if op == "*" {
+				return repeatString(x, y)
+			}
+		}
+		if op == "%" {
+			return format(x, y)
+		}
+	case int64:
+		if op == "*" {
+			switch y.(type) {
+			case string, *List, Tuple:
+				return binaryOp(op, y, x)
+			}
+		}
+	case *List:
+		switch y := y.(type) {
+		case *List:
+			if op == "+" {
+				return NewList(append(append([]Value(nil), x.elems...), y.elems...)), nil
+			}
+		case int64:
+			if op == "*" {
+				elems, err := repeat(x.elems, y)
+				return NewList(elems), err
+			}
+		}
+	case Tuple:
+		switch y := y.(type) {
+		case Tuple:
+			if op == "+" {
+				return append(append(Tuple(nil), x...), y...), nil
+			}
+		case int64:
+			if op == "*" {
+				elems, err := repeat(x, y)
+				return Tuple(elems), err
+			}
+		}
+	case *Dict:
+		if y, ok := y.(*Dict); ok && op == "|" {
+			d := NewDict()
+			for _, src := range []*Dict{x, y} {
+				for i, k := range src.keys {
+					if err := d.Set(k, src.vals[i]); err != nil {
+						return nil, err
+					}
+				}
+			}
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown binary op: %s %s %s", Type(x), op, Type(y))
+}
+
+func intOp(op string, x, y int64) (Value, error) {
+	switch op {
+	case "+":
+		r := x + y
+		if (r > x) != (y > 0) {
+			return nil, errOverflow
+		}
+		return r, nil
+	case "-":
+		r := x - y
+		if (r < x) != (y > 0) {
+			return nil, errOverflow
+		}
+		return r, nil
+	case "*":
+		if x == 0 || y == 0 {
+			return int64(0), nil
+		}
+		r := x * y
+		if r/y != x || x == -1 && y == math.MinInt64 || y == -1 && x == math.MinInt64 {
+			return nil, errOverflow
+		}
+		return r, nil
+	case "/":
+		if y == 0 {
+			return nil, errors.New("floating-point division by zero")
+		}
+		return float64(x) / float64(y), nil
+	case "//", "%":
+		if y == 0 {
+			return nil, errors.New("integer division by zero")
+		}
+		if x == math.MinInt64 && y == -1 {
+			if op == "%" {
+				return int64(0), nil
+			}
+			return nil, errOverflow
+		}
+		q, r := x/y, x%y
+		if r != 0 && (r < 0) != (y < 0) {
+			q--
+			r += y
+		}
+		if op == "//" {
+			return q, nil
+		}
+		return r, nil
+	case "|":
+		return x | y, nil
+	}
+	return nil, fmt.Errorf("unknown binary op: int %s int", op)
+}
+
+func repeatString(s string, n int64) (Value, error) {
+	if n <= 0 {
+		return "", nil
+	}
+	if int64(len(s))*n > maxLen || n > maxLen {
+		return nil, errors.New("excessive repeat")
+	}
+	return strings.Repeat(s, int(n)), nil
+}
+
+func repeat(elems []Value, n int64) ([]Value, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if int64(len(elems))*n > maxLen || n > maxLen {
+		return nil, errors.New("excessive repeat")
+	}
+	var r []Value
+	for i := int64(0); i < n; i++ {
+		r = append(r, elems...)
+	}
+	return r, nil
+}
+
+// contains reports whether x is in y: an element of a list or tuple, a key
+// of a dict, or a substring of a string.
+func contains(y, x Value) (bool, error) {
+	switch y := y.(type) {
+	case *List:
+		return containsValue(y.elems, x)
+	case Tuple:
+		return containsValue(y, x)
+	case *Dict:
+		_, ok, err := y.Get(x)
+		return ok, err
+	case string:
+		s, ok := x.(string)
+		if !ok {
+			return false, fmt.Errorf("'in <string>' requires string as left operand, not %s", Type(x))
+		}
+		return strings.Contains(y, s), nil
+	}
+	return false, fmt.Errorf("unknown binary op: %s in %s", Type(x), Type(y))
+}
+
+func containsValue(elems []Value, x Value) (bool, error) {
+	for _, e := range elems {
+		if eq, err := Equal(e, x); err != nil || eq {
+			return eq, err
+		}
+	}
+	return false, nil
+}
+
+// toInt returns v as an int, for indices and counts.
+func toInt(v Value) (int, error) {
+	i, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("got %s, want int", Type(v))
+	}
+	if i > math.MaxInt32 || i < math.MinInt32 {
+		return 0, errors.New("int out of range")
+	}
+	return int(i), nil
+}
+
+// elementIndex resolves an index into a sequence of length n, counting
+// from the end when negative.
+func elementIndex(k Value, n int) (int, error) {
+	i, err := toInt(k)
+	if err != nil {
+		return 0, fmt.Errorf("index: %v", err)
+	}
+	if i < 0 {
+		i += n
+	}
+	if i < 0 || i >= n {
+		return 0, fmt.Errorf("index %d out of range [0:%d]", i, n)
+	}
+	return i, nil
+}
+
+func index(x, k Value) (Value, error) {
+	switch x := x.(type) {
+	case *List:
+		i, err := elementIndex(k, len(x.elems))
+		if err != nil {
+			return nil, err
+		}
+		return x.elems[i], nil
+	case Tuple:
+		i, err := elementIndex(k, len(x))
+		if err != nil {
+			return nil, err
+		}
+		return x[i], nil
+	case string:
+		i, err := elementIndex(k, len(x))
+		if err != nil {
+			return nil, err
+		}
+		return x[i : i+1], nil
+	case *Dict:
+		v, ok, err := x.Get(k)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("key %s not in dict", Repr(k))
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unhandled index operation %s[%s]", Type(x), Type(k))
+}
+
+func setIndex(x, k, v Value) error {
+	switch x := x.(type) {
+	case *List:
+		i, err := elementIndex(k, len(x.elems))
+		if err != nil {
+			return err
+		}
+		x.elems[i] = v
+		return nil
+	case *Dict:
+		return x.Set(k, v)
+	}
+	return fmt.Errorf("%s value does not support item assignment", Type(x))
+}
+
+func slice(x, lo, hi, step Value) (Value, error) {
+	n := 0
+	switch x := x.(type) {
+	case *List:
+		n = len(x.elems)
+	case Tuple:
+		n = len(x)
+	case string:
+		n = len(x)
+	default:
+		return nil, fmt.Errorf("invalid slice operand %s", Type(x))
+	}
+	st := 1
+	if step != nil {
+		var err error
+		if st, err = toInt(step); err != nil {
+			return nil, fmt.Errorf("slice step: %v", err)
+		}
+		if st == 0 {
+			return nil, errors.New("zero is not a valid slice step")
+		}
+	}
+	bound := func(v Value, def int) (int, error) {
+		if v == nil {
+			return def, nil
+		}
+		i, err := toInt(v)
+		if err != nil {
+			return 0, fmt.Errorf("slice bound: %v", err)
+		}
+		if i < 0 {
+			i += n
+		}
+		lowest, highest := 0, n
+		if st < 0 {
+			lowest, highest = -1, n-1
+		}
+		return min(max(i, lowest), highest), nil
+	}
+	var start, end int
+	var err error
+	if st > 0 {
+		start, err = bound(lo, 0)
+		if err == nil {
+			end, err = bound(hi, n)
+		}
+	} else {
+		start, err = bound(lo, n-1)
+		if err == nil {
+			end, err = bound(hi, -1)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var idx []int
+	for i := start; st > 0 && i < end || st < 0 && i > end; i += st {
+		idx = append(idx, i)
+	}
+	switch x := x.(type) {
+	case *List:
+		elems := make([]Value, len(idx))
+		for j, i := range idx {
+			elems[j] = x.elems[i]
+		}
+		return NewList(elems), nil
+	case Tuple:
+		elems := make(Tuple, len(idx))
+		for j, i := range idx {
+			elems[j] = x[i]
+		}
+		return elems, nil
+	}
+	s := x.(string)
+	if st == 1 {
+		return s[start:max(start, end)], nil
+	}
+	var b strings.Builder
+	for _, i := range idx {
+		b.WriteByte(s[i])
+	}
+	return b.String(), nil
+}
+
+// format formats a string with the % operator: %s, %r, %d, %i, %x, %o,
+// %f, %g and %%.
+func format(f string, arg Value) (Value, error) {
+	args := Tuple{arg}
+	if t, ok := arg.(Tuple); ok {
+		args = t
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(f); i++ {
+		c := f[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+1 >= len(f) {
+			return nil, errors.New("incomplete format")
+		}
+		i++
+		verb := f[i]
+		if verb == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		if n >= len(args) {
+			return nil, errors.New("not enough arguments for format string")
+		}
+		v := args[n]
+		n++
+		switch verb {
+		case 's':
+			b.WriteString(Str(v))
+		case 'r':
+			b.WriteString(Repr(v))
+		case 'd', 'i', 'x', 'o':
+			var i int64
+			switch v := v.(type) {
+			case int64:
+				i = v
+			case float64:
+				i = int64(v)
+			default:
+				return nil, fmt.Errorf("%%%c format requires integer: %s", verb, Type(v))
+			}
+			base := map[byte]int{'d': 10, 'i': 10, 'x': 16, 'o': 8}[verb]
+			b.WriteString(strconv.FormatInt(i, base))
+		case 'f', 'g':
+			fv, ok := number(v)
+			if !ok {
+				return nil, fmt.Errorf("%%%c format requires float: %s", verb, Type(v))
+			}
+			if verb == 'f' {
+				b.WriteString(strconv.FormatFloat(fv, 'f', 6, 64))
+			} else {
+				b.WriteString(strconv.FormatFloat(fv, 'g', -1, 64))
+			}
+		default:
+			return nil, fmt.Errorf("unknown conversion %%%c", verb)
+		}
+	}
+	if n < len(args) {
+		return nil, errors.New("too many arguments for format string")
+	}
+	return b.String(), nil
+}
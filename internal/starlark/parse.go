@@ -0,0 +1,1016 @@
+package starlark
+
+import "fmt"
+
+type expr interface{ position() Pos }
+
+type (
+	identExpr struct {
+		pos  Pos
+		name string
+	}
+	literalExpr struct {
+		pos Pos
+		val Value
+	}
+	listExpr struct {
+		pos   Pos
+		elems []expr
+	}
+	tupleExpr struct {
+		pos   Pos
+		elems []expr
+	}
+	dictExpr struct {
+		pos        Pos
+		keys, vals []expr
+	}
+	// compExpr is a list comprehension, or a dict one when key is set.
+	compExpr struct {
+		pos     Pos
+		key     expr
+		val     expr
+		clauses []compClause
+	}
+	unaryExpr struct {
+		pos Pos
+		op  string
+		x   expr
+	}
+	binaryExpr struct {
+		pos  Pos
+		op   string
+		x, y expr
+	}
+	condExpr struct {
+		pos             Pos
+		cond, then, els expr
+	}
+	indexExpr struct {
+		pos      Pos
+		x, index expr
+	}
+	sliceExpr struct {
+		pos             Pos
+		x, lo, hi, step expr
+	}
+	dotExpr struct {
+		pos  Pos
+		x    expr
+		name string
+	}
+	callExpr struct {
+		pos  Pos
+		fn   expr
+		args []argument
+	}
+	lambdaExpr struct {
+		pos Pos
+		fn  *funcDef
+	}
+)
+
+// compClause is a for clause of a comprehension, or an if clause when vars
+// is nil.
+type compClause struct {
+	vars expr
+	x    expr
+}
+
+// argument is an argument of a call, named when passed by keyword.
+type argument struct {
+	name string
+	x    expr
+}
+
+func (e *identExpr) position() Pos   { return e.pos }
+func (e *literalExpr) position() Pos { return e.pos }
+func (e *listExpr) position() Pos    { return e.pos }
+func (e *tupleExpr) position() Pos   { return e.pos }
+func (e *dictExpr) position() Pos    { return e.pos }
+func (e *compExpr) position() Pos    { return e.pos }
+func (e *unaryExpr) position() Pos   { return e.pos }
+func (e *binaryExpr) position() Pos  { return e.pos }
+func (e *condExpr) position() Pos    { return e.pos }
+func (e *indexExpr) position() Pos   { return e.pos }
+func (e *sliceExpr) position() Pos   { return e.pos }
+func (e *dotExpr) position() Pos     { return e.pos }
+func (e *callExpr) position() Pos    { return e.pos }
+func (e *lambdaExpr) position() Pos  { return e.pos }
+
+type stmt interface{ position() Pos }
+
+type (
+	exprStmt struct {
+		pos Pos
+		x   expr
+	}
+	// assignStmt is an assignment, augmented when op is not "=".
+	assignStmt struct {
+		pos      Pos
+		op       string
+		lhs, rhs expr
+	}
+	ifStmt struct {
+		pos       Pos
+		cond      expr
+		then, els []stmt
+	}
+	forStmt struct {
+		pos  Pos
+		vars expr
+		x    expr
+		body []stmt
+	}
+	returnStmt struct {
+		pos Pos
+		x   expr
+	}
+	// branchStmt is break, continue or pass.
+	branchStmt struct {
+		pos  Pos
+		kind string
+	}
+	defStmt struct {
+		pos Pos
+		fn  *funcDef
+	}
+)
+
+func (s *exprStmt) position() Pos   { return s.pos }
+func (s *assignStmt) position() Pos { return s.pos }
+func (s *ifStmt) position() Pos     { return s.pos }
+func (s *forStmt) position() Pos    { return s.pos }
+func (s *returnStmt) position() Pos { return s.pos }
+func (s *branchStmt) position() Pos { return s.pos }
+func (s *defStmt) position() Pos    { return s.pos }
+
+// funcDef is the definition of a function by def or lambda. Its locals are
+// the names its parameters and statements bind.
+type funcDef struct {
+	pos    Pos
+	name   string
+	params []param
+	body   []stmt
+	locals map[string]bool
+}
+
+func newFuncDef(pos Pos, name string, params []param, body []stmt) *funcDef {
+	fn := &funcDef{pos: pos, name: name, params: params, body: body, locals: map[string]bool{}}
+	for _, p := range params {
+		fn.locals[p.name] = true
+	}
+	bindings(body, fn.locals)
+	return fn
+}
+
+// bindings adds the names the statements bind to names. Nested functions
+// and comprehensions have scopes of their own.
+func bindings(stmts []stmt, names map[string]bool) {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case *assignStmt:
+			targets(s.lhs, names)
+		case *forStmt:
+			targets(s.vars, names)
+			bindings(s.body, names)
+		case *ifStmt:
+			bindings(s.then, names)
+			bindings(s.els, names)
+		case *defStmt:
+			names[s.fn.name] = true
+		}
+	}
+}
+
+func targets(x expr, names map[string]bool) {
+	switch x := x.(type) {
+	case *identExpr:
+		names[x.name] = true
+	case *tupleExpr:
+		for _, e := range x.elems {
+			targets(e, names)
+		}
+	case *listExpr:
+		for _, e := range x.elems {
+			targets(e, names)
+		}
+	}
+}
+
+// param is a parameter of a function, optional when it has a default.
+type param struct {
+	name string
+	def  expr
+}
+
+type parser struct {
+	toks []token
+	i    int
+	// loops and funcs count the loops and functions being parsed.
+	loops, funcs int
+}
+
+// parse parses a program into its statements.
+func parse(src string) ([]stmt, error) {
+	toks, err := scan(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	var stmts []stmt
+	for p.tok().kind != tokEOF {
+		s, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s...)
+	}
+	return stmts, nil
+}
+
+func (p *parser) tok() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// is reports whether the current token is the operator or keyword s.
+func (p *parser) is(s string) bool {
+	t := p.tok()
+	return (t.kind == tokOp || t.kind == tokName) && t.text == s
+}
+
+func (p *parser) accept(s string) bool {
+	if p.is(s) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.accept(s) {
+		return p.errorf("expected %s, got %s", s, describe(p.tok()))
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{Pos: p.tok().pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokEOF:
+		return "end of file"
+	case tokNewline:
+		return "newline"
+	case tokIndent:
+		return "indent"
+	case tokDedent:
+		return "dedent"
+	case tokInt, tokFloat:
+		return t.text
+	case tokString:
+		return Repr(t.val)
+	}
+	return t.text
+}
+
+func (p *parser) stmt() ([]stmt, error) {
+	t := p.tok()
+	switch {
+	case p.is("def"):
+		p.next()
+		name := p.tok()
+		if name.kind != tokName || keywords[name.text] {
+			return nil, p.errorf("expected function name, got %s", describe(name))
+		}
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		params, err := p.params(")")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		loops := p.loops
+		p.loops = 0
+		p.funcs++
+		body, err := p.suite()
+		p.loops = loops
+		p.funcs--
+		if err != nil {
+			return nil, err
+		}
+		fn := newFuncDef(t.pos, name.text, params, body)
+		return []stmt{&defStmt{pos: t.pos, fn: fn}}, nil
+	case p.is("if"):
+		s, err := p.ifStmt()
+		if err != nil {
+			return nil, err
+		}
+		return []stmt{s}, nil
+	case p.is("for"):
+		p.next()
+		vars, err := p.loopVars()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("in"); err != nil {
+			return nil, err
+		}
+		x, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		p.loops++
+		body, err := p.suite()
+		p.loops--
+		if err != nil {
+			return nil, err
+		}
+		return []stmt{&forStmt{pos: t.pos, vars: vars, x: x, body: body}}, nil
+	}
+	return p.simpleStmts()
+}
+
+func (p *parser) ifStmt() (stmt, error) {
+	t := p.next()
+	cond, err := p.test()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	then, err := p.suite()
+	if err != nil {
+		return nil, err
+	}
+	s := &ifStmt{pos: t.pos, cond: cond, then: then}
+	switch {
+	case p.is("elif"):
+		els, err := p.ifStmt()
+		if err != nil {
+			return nil, err
+		}
+		s.els = []stmt{els}
+	case p.accept("else"):
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if s.els, err = p.suite(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// suite parses the body of a compound statement: simple statements on the
+// same line, or an indented block.
+func (p *parser) suite() ([]stmt, error) {
+	if p.tok().kind != tokNewline {
+		return p.simpleStmts()
+	}
+	p.next()
+	if p.tok().kind != tokIndent {
+		return nil, p.errorf("expected an indented block")
+	}
+	p.next()
+	var stmts []stmt
+	for p.tok().kind != tokDedent && p.tok().kind != tokEOF {
+		s, err := p.stmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s...)
+	}
+	p.next()
+	return stmts, nil
+}
+
+func (p *parser) simpleStmts() ([]stmt, error) {
+	var stmts []stmt
+	for {
+		s, err := p.smallStmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+		if !p.accept(";") || p.tok().kind == tokNewline {
+			break
+		}
+	}
+	if p.tok().kind != tokNewline {
+		return nil, p.errorf("unexpected %s", describe(p.tok()))
+	}
+	p.next()
+	return stmts, nil
+}
+
+func (p *parser) smallStmt() (stmt, error) {
+	t := p.tok()
+	switch {
+	case p.is("return"):
+		if p.funcs == 0 {
+			return nil, p.errorf("return outside function")
+		}
+		p.next()
+		s := &returnStmt{pos: t.pos}
+		if p.tok().kind != tokNewline && !p.is(";") {
+			x, err := p.exprList()
+			if err != nil {
+				return nil, err
+			}
+			s.x = x
+		}
+		return s, nil
+	case p.is("break"), p.is("continue"):
+		if p.loops == 0 {
+			return nil, p.errorf("%s outside loop", t.text)
+		}
+		p.next()
+		return &branchStmt{pos: t.pos, kind: t.text}, nil
+	case p.accept("pass"):
+		return &branchStmt{pos: t.pos, kind: t.text}, nil
+	case p.is("load"):
+		return nil, p.errorf("load is not supported")
+	case t.kind == tokName && keywords[t.text] && t.text != "None" && t.text != "True" &&
+		t.text != "False" && t.text != "not" && t.text != "lambda":
+		return nil, p.errorf("unexpected keyword %s", t.text)
+	}
+	x, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	op := p.tok()
+	switch op.text {
+	case "=", "+=", "-=", "*=", "/=", "//=", "%=", "|=":
+		if op.kind != tokOp {
+			break
+		}
+		p.next()
+		if err := checkTarget(x, op.text == "="); err != nil {
+			return nil, err
+		}
+		rhs, err := p.exprList()
+		if err != nil {
+			return nil, err
+		}
+		return &assignStmt{pos: op.pos, op: op.text, lhs: x, rhs: rhs}, nil
+	}
+	return &exprStmt{pos: t.pos, x: x}, nil
+}
+
+// checkTarget reports whether x can be assigned to. Tuples and lists of
+// targets unpack, but not in augmented assignments.
+func checkTarget(x expr, unpack bool) error {
+	switch x := x.(type) {
+	case *identExpr, *indexExpr, *dotExpr:
+		return nil
+	case *tupleExpr:
+		if unpack {
+			for _, e := range x.elems {
+				if err := checkTarget(e, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case *listExpr:
+		if unpack {
+			for _, e := range x.elems {
+				if err := checkTarget(e, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return &Error{Pos: x.position(), Msg: "cannot assign to this expression"}
+}
+
+// params parses parameters up to the closing token.
+func (p *parser) params(end string) ([]param, error) {
+	var params []param
+	seen := map[string]bool{}
+	for !p.is(end) {
+		t := p.tok()
+		if t.kind != tokName || keywords[t.text] {
+			return nil, p.errorf("expected parameter name, got %s", describe(t))
+		}
+		p.next()
+		if seen[t.text] {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("duplicate parameter %s", t.text)}
+		}
+		seen[t.text] = true
+		prm := param{name: t.text}
+		if p.accept("=") {
+			def, err := p.test()
+			if err != nil {
+				return nil, err
+			}
+			prm.def = def
+		} else if len(params) > 0 && params[len(params)-1].def != nil {
+			return nil, &Error{Pos: t.pos, Msg: "required parameter follows optional"}
+		}
+		params = append(params, prm)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return params, nil
+}
+
+// loopVars parses the variables of a for loop or clause.
+func (p *parser) loopVars() (expr, error) {
+	t := p.tok()
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is(",") {
+		return x, checkTarget(x, true)
+	}
+	elems := []expr{x}
+	for p.accept(",") && !p.is("in") {
+		x, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, x)
+	}
+	tuple := &tupleExpr{pos: t.pos, elems: elems}
+	return tuple, checkTarget(tuple, true)
+}
+
+// exprList parses an expression, or a tuple of them without parentheses.
+func (p *parser) exprList() (expr, error) {
+	t := p.tok()
+	x, err := p.test()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is(",") {
+		return x, nil
+	}
+	elems := []expr{x}
+	for p.accept(",") {
+		if !p.startsExpr() {
+			break
+		}
+		x, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, x)
+	}
+	return &tupleExpr{pos: t.pos, elems: elems}, nil
+}
+
+// startsExpr reports whether the current token can begin an expression.
+func (p *parser) startsExpr() bool {
+	t := p.tok()
+	switch t.kind {
+	case tokInt, tokFloat, tokString:
+		return true
+	case tokName:
+		switch t.text {
+		case "None", "True", "False", "not", "lambda":
+			return true
+		}
+		return !keywords[t.text]
+	case tokOp:
+		switch t.text {
+		case "(", "[", "{", "-", "+":
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) test() (expr, error) {
+	t := p.tok()
+	if p.accept("lambda") {
+		params, err := p.params(":")
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		body, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		fn := newFuncDef(t.pos, "lambda", params, []stmt{&returnStmt{pos: body.position(), x: body}})
+		return &lambdaExpr{pos: t.pos, fn: fn}, nil
+	}
+	x, err := p.orTest()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is("if") {
+		return x, nil
+	}
+	pos := p.next().pos
+	cond, err := p.orTest()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("else"); err != nil {
+		return nil, err
+	}
+	els, err := p.test()
+	if err != nil {
+		return nil, err
+	}
+	return &condExpr{pos: pos, cond: cond, then: x, els: els}, nil
+}
+
+func (p *parser) orTest() (expr, error) {
+	x, err := p.andTest()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("or") {
+		t := p.next()
+		y, err := p.andTest()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{pos: t.pos, op: "or", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) andTest() (expr, error) {
+	x, err := p.notTest()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("and") {
+		t := p.next()
+		y, err := p.notTest()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{pos: t.pos, op: "and", x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) notTest() (expr, error) {
+	if p.is("not") {
+		t := p.next()
+		x, err := p.notTest()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{pos: t.pos, op: "not", x: x}, nil
+	}
+	return p.comparison()
+}
+
+// comparison parses a comparison. Comparisons do not chain.
+func (p *parser) comparison() (expr, error) {
+	x, err := p.bitOr()
+	if err != nil {
+		return nil, err
+	}
+	t := p.tok()
+	op := ""
+	switch {
+	case t.kind == tokOp && (t.text == "==" || t.text == "!=" || t.text == "<" || t.text == "<=" || t.text == ">" || t.text == ">="):
+		op = t.text
+		p.next()
+	case p.is("in"):
+		op = "in"
+		p.next()
+	case p.is("not") && p.toks[p.i+1].kind == tokName && p.toks[p.i+1].text == "in":
+		op = "not in"
+		p.i += 2
+	default:
+		return x, nil
+	}
+	y, err := p.bitOr()
+	if err != nil {
+		return nil, err
+	}
+	return &binaryExpr{pos: t.pos, op: op, x: x, y: y}, nil
+}
+
+func (p *parser) bitOr() (expr, error) {
+	return p.binary(p.arith, "|")
+}
+
+func (p *parser) arith() (expr, error) {
+	return p.binary(p.term, "+", "-")
+}
+
+func (p *parser) term() (expr, error) {
+	return p.binary(p.factor, "*", "/", "//", "%")
+}
+
+// binary parses left-associative operations on operands parsed by operand.
+func (p *parser) binary(operand func() (expr, error), ops ...string) (expr, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.tok()
+		if t.kind != tokOp || !oneOf(ops, t.text) {
+			return x, nil
+		}
+		p.next()
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryExpr{pos: t.pos, op: t.text, x: x, y: y}
+	}
+}
+
+func oneOf(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) factor() (expr, error) {
+	if t := p.tok(); t.kind == tokOp && (t.text == "-" || t.text == "+") {
+		p.next()
+		x, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{pos: t.pos, op: t.text, x: x}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	x, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.tok()
+		switch {
+		case p.accept("."):
+			name := p.tok()
+			if name.kind != tokName {
+				return nil, p.errorf("expected attribute name, got %s", describe(name))
+			}
+			p.next()
+			x = &dotExpr{pos: t.pos, x: x, name: name.text}
+		case p.accept("["):
+			if x, err = p.index(x, t.pos); err != nil {
+				return nil, err
+			}
+		case p.accept("("):
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			x = &callExpr{pos: t.pos, fn: x, args: args}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// index parses an index or slice after its opening bracket.
+func (p *parser) index(x expr, pos Pos) (expr, error) {
+	var parts [3]expr
+	n := 0
+	for {
+		if !p.is(":") && !p.is("]") {
+			e, err := p.test()
+			if err != nil {
+				return nil, err
+			}
+			parts[n] = e
+		}
+		if p.accept("]") {
+			break
+		}
+		if n == 2 {
+			return nil, p.errorf("expected ], got %s", describe(p.tok()))
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		n++
+	}
+	if n == 0 {
+		if parts[0] == nil {
+			return nil, &Error{Pos: pos, Msg: "empty index"}
+		}
+		return &indexExpr{pos: pos, x: x, index: parts[0]}, nil
+	}
+	return &sliceExpr{pos: pos, x: x, lo: parts[0], hi: parts[1], step: parts[2]}, nil
+}
+
+// args parses the arguments of a call after its opening parenthesis.
+func (p *parser) args() ([]argument, error) {
+	var args []argument
+	named := false
+	for !p.accept(")") {
+		var a argument
+		if t := p.tok(); t.kind == tokName && p.toks[p.i+1].kind == tokOp && p.toks[p.i+1].text == "=" {
+			a.name = t.text
+			p.i += 2
+			named = true
+		} else if named {
+			return nil, p.errorf("positional argument follows keyword argument")
+		}
+		x, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		a.x = x
+		args = append(args, a)
+		if !p.accept(",") {
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return args, nil
+}
+
+func (p *parser) operand() (expr, error) {
+	t := p.tok()
+	switch t.kind {
+	case tokInt, tokFloat:
+		p.next()
+		return &literalExpr{pos: t.pos, val: t.val}, nil
+	case tokString:
+		s := ""
+		for p.tok().kind == tokString {
+			s += p.next().val.(string)
+		}
+		return &literalExpr{pos: t.pos, val: s}, nil
+	case tokName:
+		switch t.text {
+		case "None":
+			p.next()
+			return &literalExpr{pos: t.pos, val: nil}, nil
+		case "True", "False":
+			p.next()
+			return &literalExpr{pos: t.pos, val: t.text == "True"}, nil
+		}
+		if keywords[t.text] {
+			return nil, p.errorf("unexpected keyword %s", t.text)
+		}
+		p.next()
+		return &identExpr{pos: t.pos, name: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			p.next()
+			if p.accept(")") {
+				return &tupleExpr{pos: t.pos}, nil
+			}
+			x, err := p.test()
+			if err != nil {
+				return nil, err
+			}
+			if p.accept(")") {
+				return x, nil
+			}
+			elems := []expr{x}
+			for p.accept(",") && !p.is(")") {
+				x, err := p.test()
+				if err != nil {
+					return nil, err
+				}
+				elems = append(elems, x)
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return &tupleExpr{pos: t.pos, elems: elems}, nil
+		case "[":
+			p.next()
+			return p.list(t.pos)
+		case "{":
+			p.next()
+			return p.dict(t.pos)
+		}
+	}
+	return nil, p.errorf("unexpected %s", describe(t))
+}
+
+// list parses a list or list comprehension after its opening bracket.
+func (p *parser) list(pos Pos) (expr, error) {
+	var elems []expr
+	for !p.accept("]") {
+		x, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		if len(elems) == 0 && p.is("for") {
+			clauses, err := p.clauses("]")
+			if err != nil {
+				return nil, err
+			}
+			return &compExpr{pos: pos, val: x, clauses: clauses}, nil
+		}
+		elems = append(elems, x)
+		if !p.accept(",") {
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return &listExpr{pos: pos, elems: elems}, nil
+}
+
+// dict parses a dict or dict comprehension after its opening brace.
+func (p *parser) dict(pos Pos) (expr, error) {
+	d := &dictExpr{pos: pos}
+	for !p.accept("}") {
+		k, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		v, err := p.test()
+		if err != nil {
+			return nil, err
+		}
+		if len(d.keys) == 0 && p.is("for") {
+			clauses, err := p.clauses("}")
+			if err != nil {
+				return nil, err
+			}
+			return &compExpr{pos: pos, key: k, val: v, clauses: clauses}, nil
+		}
+		d.keys = append(d.keys, k)
+		d.vals = append(d.vals, v)
+		if !p.accept(",") {
+			if err := p.expect("}"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return d, nil
+}
+
+// clauses parses the for and if clauses of a comprehension, and its
+// closing bracket.
+func (p *parser) clauses(end string) ([]compClause, error) {
+	var clauses []compClause
+	for !p.accept(end) {
+		switch {
+		case p.accept("for"):
+			vars, err := p.loopVars()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("in"); err != nil {
+				return nil, err
+			}
+			x, err := p.orTest()
+			if err != nil {
+				return nil, err
+			}
+			clauses = append(clauses, compClause{vars: vars, x: x})
+		case p.accept("if"):
+			x, err := p.orTest()
+			if err != nil {
+				return nil, err
+			}
+			clauses = append(clauses, compClause{x: x})
+		default:
+			return nil, p.errorf("expected for, if or %s, got %s", end, describe(p.tok()))
+		}
+	}
+	return clauses, nil
+}
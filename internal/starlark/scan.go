@@ -0,0 +1,353 @@
+package starlark
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pos is a position in a program: its line and column, from 1.
+type Pos struct {
+	Line, Col int
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNewline
+	tokIndent
+	tokDedent
+	tokName
+	tokInt
+	tokFloat
+	tokString
+	tokOp
+)
+
+// token is a token of a program. Names, keywords and operators have their
+// text; literals their value.
+type token struct {
+	kind tokenKind
+	text string
+	val  Value
+	pos  Pos
+}
+
+// keywords are the names the grammar reserves, including those of Python
+// that Starlark keeps out of programs.
+var keywords = map[string]bool{
+	"and": true, "break": true, "continue": true, "def": true, "elif": true, "else": true,
+	"for": true, "if": true, "in": true, "lambda": true, "load": true, "not": true,
+	"or": true, "pass": true, "return": true, "None": true, "True": true, "False": true,
+	"as": true, "assert": true, "class": true, "del": true, "except": true, "finally": true,
+	"from": true, "global": true, "import": true, "is": true, "nonlocal": true, "raise": true,
+	"try": true, "while": true, "with": true, "yield": true,
+}
+
+// operators are the operators and punctuation, longest first.
+var operators = []string{
+	"//=", "**",
+	"==", "!=", "<=", ">=", "//", "+=", "-=", "*=", "/=", "%=", "|=",
+	"+", "-", "*", "/", "%", "<", ">", "=", "(", ")", "[", "]", "{", "}", ",", ":", ".", ";", "|",
+}
+
+// scanner splits a program into tokens. Lines are joined inside brackets;
+// elsewhere a line ends in a newline token, and changes of indentation make
+// indent and dedent tokens.
+type scanner struct {
+	src       string
+	i         int
+	line      int
+	lineStart int
+	depth     int
+	indents   []int
+	toks      []token
+}
+
+func (s *scanner) pos() Pos {
+	return Pos{s.line, s.i - s.lineStart + 1}
+}
+
+func (s *scanner) errorf(p Pos, format string, args ...any) error {
+	return &Error{Pos: p, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (s *scanner) emit(kind tokenKind, text string, val Value, p Pos) {
+	s.toks = append(s.toks, token{kind: kind, text: text, val: val, pos: p})
+}
+
+func (s *scanner) newline() {
+	s.i++
+	s.line++
+	s.lineStart = s.i
+}
+
+func scan(src string) ([]token, error) {
+	s := &scanner{src: src, line: 1, indents: []int{0}}
+	startOfLine := true
+	for {
+		if startOfLine && s.depth == 0 {
+			n := 0
+			for s.i < len(s.src) && (s.src[s.i] == ' ' || s.src[s.i] == '\t') {
+				if s.src[s.i] == '\t' {
+					n += 8 - n%8
+				} else {
+					n++
+				}
+				s.i++
+			}
+			if s.i >= len(s.src) {
+				break
+			}
+			switch s.src[s.i] {
+			case '\n':
+				s.newline()
+				continue
+			case '\r':
+				s.i++
+				continue
+			case '#':
+				s.comment()
+				continue
+			}
+			p := s.pos()
+			if top := s.indents[len(s.indents)-1]; n > top {
+				s.indents = append(s.indents, n)
+				s.emit(tokIndent, "", nil, p)
+			} else {
+				for n < s.indents[len(s.indents)-1] {
+					s.indents = s.indents[:len(s.indents)-1]
+					s.emit(tokDedent, "", nil, p)
+				}
+				if n != s.indents[len(s.indents)-1] {
+					return nil, s.errorf(p, "unindent does not match any outer indentation")
+				}
+			}
+			startOfLine = false
+		}
+		if s.i >= len(s.src) {
+			break
+		}
+		p := s.pos()
+		c := s.src[s.i]
+		switch {
+		case c == '\n':
+			if s.depth == 0 {
+				s.emit(tokNewline, "", nil, p)
+				startOfLine = true
+			}
+			s.newline()
+		case c == ' ' || c == '\t' || c == '\r':
+			s.i++
+		case c == '#':
+			s.comment()
+		case c == '\\' && strings.HasPrefix(s.src[s.i+1:], "\n"):
+			s.i++
+			s.newline()
+		case c == '"' || c == '\'' || (c == 'r' && s.i+1 < len(s.src) && (s.src[s.i+1] == '"' || s.src[s.i+1] == '\'')):
+			v, err := s.string()
+			if err != nil {
+				return nil, err
+			}
+			s.emit(tokString, "", v, p)
+		case isLetter(c):
+			j := s.i
+			for j < len(s.src) && (isLetter(s.src[j]) || isDigit(s.src[j])) {
+				j++
+			}
+			s.emit(tokName, s.src[s.i:j], nil, p)
+			s.i = j
+		case isDigit(c) || c == '.' && s.i+1 < len(s.src) && isDigit(s.src[s.i+1]):
+			if err := s.number(); err != nil {
+				return nil, err
+			}
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(s.src[s.i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				r, _ := utf8.DecodeRuneInString(s.src[s.i:])
+				return nil, s.errorf(p, "unexpected %q", r)
+			}
+			switch op {
+			case "(", "[", "{":
+				s.depth++
+			case ")", "]", "}":
+				if s.depth == 0 {
+					return nil, s.errorf(p, "unexpected %q", op)
+				}
+				s.depth--
+			}
+			s.emit(tokOp, op, nil, p)
+			s.i += len(op)
+		}
+	}
+	p := s.pos()
+	if s.depth > 0 {
+		return nil, s.errorf(p, "unexpected end of file in brackets")
+	}
+	if n := len(s.toks); n > 0 && s.toks[n-1].kind != tokNewline && s.toks[n-1].kind != tokDedent {
+		s.emit(tokNewline, "", nil, p)
+	}
+	for len(s.indents) > 1 {
+		s.indents = s.indents[:len(s.indents)-1]
+		s.emit(tokDedent, "", nil, p)
+	}
+	s.emit(tokEOF, "", nil, p)
+	return s.toks, nil
+}
+
+func (s *scanner) comment() {
+	for s.i < len(s.src) && s.src[s.i] != '\n' {
+		s.i++
+	}
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= utf8.RuneSelf
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+// number scans an int, decimal or 0x, 0o or 0b prefixed, or a float.
+func (s *scanner) number() error {
+	p := s.pos()
+	j := s.i
+	float := false
+	for j < len(s.src) {
+		c := s.src[j]
+		switch {
+		case isDigit(c) || isLetter(c) && !(c == 'e' || c == 'E') || c == '_':
+		case c == '.':
+			float = true
+		case c == 'e' || c == 'E':
+			if strings.HasPrefix(s.src[s.i:], "0x") || strings.HasPrefix(s.src[s.i:], "0X") {
+				break
+			}
+			float = true
+			if j+1 < len(s.src) && (s.src[j+1] == '+' || s.src[j+1] == '-') {
+				j++
+			}
+		default:
+			goto done
+		}
+		j++
+	}
+done:
+	text := s.src[s.i:j]
+	s.i = j
+	if float {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return s.errorf(p, "bad number %s", text)
+		}
+		s.emit(tokFloat, text, f, p)
+		return nil
+	}
+	if len(text) > 1 && text[0] == '0' && isDigit(text[1]) {
+		return s.errorf(p, "bad number %s: use 0o for octal", text)
+	}
+	n, err := strconv.ParseInt(text, 0, 64)
+	if err != nil {
+		return s.errorf(p, "bad number %s", text)
+	}
+	s.emit(tokInt, text, n, p)
+	return nil
+}
+
+// string scans a string literal: single, double or triple quoted, raw when
+// prefixed with r.
+func (s *scanner) string() (string, error) {
+	p := s.pos()
+	raw := s.src[s.i] == 'r'
+	if raw {
+		s.i++
+	}
+	q := s.src[s.i : s.i+1]
+	if strings.HasPrefix(s.src[s.i:], q+q+q) {
+		q = q + q + q
+	}
+	s.i += len(q)
+	var b strings.Builder
+	for {
+		if s.i >= len(s.src) {
+			return "", s.errorf(p, "unterminated string")
+		}
+		if strings.HasPrefix(s.src[s.i:], q) {
+			s.i += len(q)
+			return b.String(), nil
+		}
+		c := s.src[s.i]
+		switch {
+		case c == '\n':
+			if len(q) == 1 {
+				return "", s.errorf(p, "unterminated string")
+			}
+			b.WriteByte(c)
+			s.newline()
+		case c == '\\' && s.i+1 < len(s.src):
+			if raw {
+				b.WriteString(s.src[s.i : s.i+2])
+				if s.src[s.i+1] == '\n' {
+					s.i++
+					s.newline()
+				} else {
+					s.i += 2
+				}
+				continue
+			}
+			if err := s.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			s.i++
+		}
+	}
+}
+
+// escape decodes the escape sequence at the scanner's position.
+func (s *scanner) escape(b *strings.Builder) error {
+	p := s.pos()
+	c := s.src[s.i+1]
+	s.i += 2
+	switch c {
+	case '\n':
+		s.line++
+		s.lineStart = s.i
+	case 'n':
+		b.WriteByte('\n')
+	case 't':
+		b.WriteByte('\t')
+	case 'r':
+		b.WriteByte('\r')
+	case '0':
+		b.WriteByte(0)
+	case '\\', '\'', '"':
+		b.WriteByte(c)
+	case 'x', 'u', 'U':
+		n := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+		if s.i+n > len(s.src) {
+			return s.errorf(p, "bad escape \\%c", c)
+		}
+		v, err := strconv.ParseUint(s.src[s.i:s.i+n], 16, 32)
+		if err != nil || c != 'x' && !utf8.ValidRune(rune(v)) {
+			return s.errorf(p, "bad escape \\%c%s", c, s.src[s.i:s.i+n])
+		}
+		if c == 'x' {
+			b.WriteByte(byte(v))
+		} else {
+			b.WriteRune(rune(v))
+		}
+		s.i += n
+	default:
+		return s.errorf(p, "bad escape \\%c", c)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package starlark
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func run(t *testing.T, src string) (map[string]Value, error) {
+	t.Helper()
+	return ExecFile(&Thread{}, "test.star", []byte(src))
+}
+
+func TestExec(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{`x = 1 + 2 * 3`, `7`},
+		{`x = 7 // 2, -7 // 2, 7 % -3, 7 / 2`, `(3, -4, -2, 3.5)`},
+		{`x = 1.5 + 1`, `2.5`},
+		{`x = "a" + "b" * 3`, `"abbb"`},
+		{`x = [1, 2] + [3]`, `[1, 2, 3]`},
+		{`x = 1 < 2 and "yes" or "no"`, `"yes"`},
+		{`x = not None`, `True`},
+		{`x = 3 if False else 4`, `4`},
+		{`x = "b" in "abc", 2 in [1, 2], "k" in {"k": 1}, 3 not in (1, 2)`, `(True, True, True, True)`},
+		{`x = [i * i for i in range(5) if i % 2 == 0]`, `[0, 4, 16]`},
+		{`x = {k: v for k, v in [("a", 1), ("b", 2)]}`, `{"a": 1, "b": 2}`},
+		{`x = [a + b for a in "xy".split(",") for b in ["1", "2"]]`, `["xy1", "xy2"]`},
+		{`x = [1, 2, 3, 4][1:3], [1, 2, 3][::-1], "hello"[-3:]`, `([2, 3], [3, 2, 1], "llo")`},
+		{`x = "%s is %d" % ("n", 3)`, `"n is 3"`},
+		{`x = "{} {name}".format(1, name="b")`, `"1 b"`},
+		{`x = sorted([3, 1, 2], reverse=True)`, `[3, 2, 1]`},
+		{`x = sorted(["bb", "a", "ccc"], key=len)`, `["a", "bb", "ccc"]`},
+		{`x = max([{"w": 1}, {"w": 3}], key=lambda o: o["w"])`, `{"w": 3}`},
+		{`x = min(4, 2, 8)`, `2`},
+		{`x = list(enumerate(["a", "b"]))`, `[(0, "a"), (1, "b")]`},
+		{`x = zip([1, 2], "ab".split("x"))`, `[(1, "ab")]`},
+		{`x = any([0, 1]), all([1, 0]), len({"a": 1})`, `(True, False, 1)`},
+		{`x = int("42") + int(2.9), float(1), str(1.0), bool([])`, `(44, 1.0, "1.0", False)`},
+		{`x = " a b ".strip().upper().replace(" ", "-")`, `"A-B"`},
+		{`x = ", ".join(["a", "b"])`, `"a, b"`},
+		{`x = "HDMI-1".startswith(("DP", "HDMI")), "eDP-1".endswith("-1")`, `(True, True)`},
+		{`x = {"a": 1} | {"b": 2}`, `{"a": 1, "b": 2}`},
+		{`x = 1 == 1.0, True == 1, (1, 2) < (1, 3)`, `(True, False, True)`},
+		{`
+d = {"a": 1}
+d["b"] = 2
+d.update(c=3)
+x = (d.get("z", 0), d.pop("a"), sorted(d.keys()), d.items())
+`, `(0, 1, ["b", "c"], [("b", 2), ("c", 3)])`},
+		{`
+l = [3]
+l.append(1)
+l.extend([2])
+l.insert(0, 9)
+l.remove(3)
+l += [5]
+x = (l.pop(), l, l.index(2))
+`, `(5, [9, 1, 2], 2)`},
+		{`
+def f(a, b=10):
+    total = 0
+    for i in range(a):
+        if i == 2:
+            continue
+        if i == 4:
+            break
+        total += i
+    return total + b
+
+x = f(10), f(3, b=0)
+`, `(14, 1)`},
+		{`
+def outer(n):
+    add = lambda x: x + n
+    def twice(x):
+        return add(add(x))
+    return twice(1)
+
+x = outer(5)
+`, `11`},
+		{`
+a, (b, c) = 1, [2, 3]
+x = [a, b, c]
+`, `[1, 2, 3]`},
+		{`
+x = 0
+for i in range(3): x += i
+`, `3`},
+		{"x = '''a\nb''' + r'\\n' + \"\\x41\\u00e9\"", `"a\nb\\nAé"`},
+		{`x = 0x10 + 0o10 + 0b10 + 1e2`, `126.0`},
+	} {
+		globals, err := run(t, tt.src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.src, err)
+			continue
+		}
+		if got := Repr(globals["x"]); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want string
+	}{
+		{`x = y`, "test.star:1:5: undefined: y"},
+		{"x = 1\nx = x + \"a\"", "test.star:2:7: unknown binary op: int + string"},
+		{`x = [1][3]`, "index 3 out of range"},
+		{`x = {}["k"]`, `key "k" not in dict`},
+		{`x = 1 // 0`, "integer division by zero"},
+		{`x = 9223372036854775807 + 1`, "integer overflow"},
+		{`fail("bad", 1)`, "test.star:1:5: fail: bad 1"},
+		{"def f():\n    return f()\nf()", "function f called recursively"},
+		{"def f():\n    x += 1\nf()", "local variable x referenced before assignment"},
+		{"l = [1]\nfor x in l:\n    l.append(x)", "list changed during iteration"},
+		{`x = {[]: 1}`, "unhashable type: list"},
+		{`x = {"a": 1, "a": 2}`, `duplicate key "a"`},
+		{`break`, "break outside loop"},
+		{`return 1`, "return outside function"},
+		{"if True:\nx = 1", "expected an indented block"},
+		{"if True:\n    x = 1\n  y = 2", "unindent does not match"},
+		{`while True: pass`, "unexpected keyword while"},
+		{`load("x.star", "y")`, "load is not supported"},
+		{`x = (1, 2`, "unexpected end of file"},
+		{`x = "abc`, "unterminated string"},
+		{`x = 1 < 2 < 3`, "unexpected <"},
+		{`def f(a=1, b): pass`, "required parameter follows optional"},
+		{"def f(a): pass\nf(1, a=2)", "multiple values for parameter a"},
+		{`x = len(1)`, "len: value of type int has no len"},
+		{`x = range(10000000)`, "range: too many elements"},
+		{"x = 0\nfor i in range(1000):\n    for j in range(1000):\n        for k in range(100):\n            x += 1", "too many steps"},
+	} {
+		_, err := run(t, tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	var printed []string
+	th := &Thread{Print: func(msg string) { printed = append(printed, msg) }}
+	globals, err := ExecFile(th, "test.star", []byte(`
+def layout(state):
+    print("outputs:", len(state["outputs"]))
+    return [o["name"] for o in state["outputs"] if o["connected"]]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in any
+	d := json.NewDecoder(strings.NewReader(`{"outputs": [{"name": "eDP-1", "connected": true}, {"name": "DP-1", "connected": false}]}`))
+	d.UseNumber()
+	if err := d.Decode(&in); err != nil {
+		t.Fatal(err)
+	}
+	state, err := FromGo(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := Call(th, globals["layout"], state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ToGo(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{"eDP-1"}; !reflect.DeepEqual(out, want) {
+		t.Errorf("got %v, want %v", out, want)
+	}
+	if want := []string{"outputs: 2"}; !reflect.DeepEqual(printed, want) {
+		t.Errorf("printed %q, want %q", printed, want)
+	}
+}
+
+func TestContextStopsRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	th := &Thread{Context: ctx}
+	_, err := ExecFile(th, "test.star", []byte("x = 0\nfor i in range(10000):\n    x += i"))
+	if err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Errorf("got %v, want context canceled", err)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	v, err := FromGo(map[string]any{"rate": json.Number("59.95"), "x": json.Number("1920"), "f": 2.0, "l": []any{true, nil}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := Repr(v), `{"f": 2, "l": [True, None], "rate": 59.95, "x": 1920}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	globals, err := run(t, `x = {1: "a"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ToGo(globals["x"]); err == nil {
+		t.Error("converted a dict with an int key")
+	}
+}
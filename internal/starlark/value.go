@@ -0,0 +1,497 @@
+package starlark
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Value is a Starlark value: nil for None, a bool, an int64, a float64, a
+// string, a *List, a *Dict, a Tuple, a *Function or a *Builtin.
+type Value any
+
+// List is a mutable sequence.
+type List struct {
+	elems []Value
+	// iterating counts the loops over the list, which may not change it.
+	iterating int
+}
+
+// NewList returns a list of the elements.
+func NewList(elems []Value) *List { return &List{elems: elems} }
+
+// Len returns the number of elements of the list.
+func (l *List) Len() int { return len(l.elems) }
+
+// Index returns the i'th element of the list.
+func (l *List) Index(i int) Value { return l.elems[i] }
+
+func (l *List) checkMutable() error {
+	if l.iterating > 0 {
+		return fmt.Errorf("list changed during iteration")
+	}
+	return nil
+}
+
+// Tuple is an immutable sequence.
+type Tuple []Value
+
+// Dict is a mapping that keeps its keys in insertion order.
+type Dict struct {
+	keys      []Value
+	vals      []Value
+	index     map[string]int
+	iterating int
+}
+
+// NewDict returns an empty dict.
+func NewDict() *Dict { return &Dict{index: map[string]int{}} }
+
+// Len returns the number of entries of the dict.
+func (d *Dict) Len() int { return len(d.keys) }
+
+// Keys returns the keys of the dict in insertion order.
+func (d *Dict) Keys() []Value { return append([]Value(nil), d.keys...) }
+
+// Get returns the value for the key, and whether it is present.
+func (d *Dict) Get(k Value) (Value, bool, error) {
+	h, err := hashKey(k)
+	if err != nil {
+		return nil, false, err
+	}
+	i, ok := d.index[h]
+	if !ok {
+		return nil, false, nil
+	}
+	return d.vals[i], true, nil
+}
+
+// Set sets the value for the key.
+func (d *Dict) Set(k, v Value) error {
+	h, err := hashKey(k)
+	if err != nil {
+		return err
+	}
+	if i, ok := d.index[h]; ok {
+		d.vals[i] = v
+		return nil
+	}
+	if d.iterating > 0 {
+		return fmt.Errorf("dict changed during iteration")
+	}
+	d.index[h] = len(d.keys)
+	d.keys = append(d.keys, k)
+	d.vals = append(d.vals, v)
+	return nil
+}
+
+// delete removes the key, returning its value.
+func (d *Dict) delete(k Value) (Value, bool, error) {
+	h, err := hashKey(k)
+	if err != nil {
+		return nil, false, err
+	}
+	i, ok := d.index[h]
+	if !ok {
+		return nil, false, nil
+	}
+	if d.iterating > 0 {
+		return nil, false, fmt.Errorf("dict changed during iteration")
+	}
+	v := d.vals[i]
+	d.keys = append(d.keys[:i], d.keys[i+1:]...)
+	d.vals = append(d.vals[:i], d.vals[i+1:]...)
+	delete(d.index, h)
+	for j := i; j < len(d.keys); j++ {
+		h, _ := hashKey(d.keys[j])
+		d.index[h] = j
+	}
+	return v, true, nil
+}
+
+// Function is a function defined by def or lambda.
+type Function struct {
+	def      *funcDef
+	defaults []Value
+	// env is the environment the function was defined in.
+	env *env
+}
+
+// Name returns the name of the function.
+func (f *Function) Name() string { return f.def.name }
+
+// Builtin is a function implemented in Go. Its receiver is set when it is
+// a method bound to a value.
+type Builtin struct {
+	name string
+	recv Value
+	fn   func(th *Thread, b *Builtin, args Tuple, kwargs []kwarg) (Value, error)
+}
+
+type kwarg struct {
+	name string
+	val  Value
+}
+
+// Error is an error in a program, at a position in it.
+type Error struct {
+	File string
+	Pos  Pos
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Pos.Line, e.Pos.Col, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Pos.Line, e.Pos.Col, e.Msg)
+}
+
+// Type returns the name of the type of v.
+func Type(v Value) string {
+	switch v.(type) {
+	case nil:
+		return "NoneType"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case *List:
+		return "list"
+	case Tuple:
+		return "tuple"
+	case *Dict:
+		return "dict"
+	case *Function:
+		return "function"
+	case *Builtin:
+		return "builtin_function_or_method"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// Truth reports whether v is true in a condition.
+func Truth(v Value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case *List:
+		return len(v.elems) > 0
+	case Tuple:
+		return len(v) > 0
+	case *Dict:
+		return len(v.keys) > 0
+	}
+	return true
+}
+
+// Str returns v as str does: strings as they are, other values as Repr.
+func Str(v Value) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return Repr(v)
+}
+
+// Repr returns v as it would be written in a program.
+func Repr(v Value) string {
+	var b strings.Builder
+	writeRepr(&b, v, nil)
+	return b.String()
+}
+
+func writeRepr(b *strings.Builder, v Value, path []Value) {
+	for _, p := range path {
+		if p == v {
+			b.WriteString("...")
+			return
+		}
+	}
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("None")
+	case bool:
+		if v {
+			b.WriteString("True")
+		} else {
+			b.WriteString("False")
+		}
+	case int64:
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		b.WriteString(formatFloat(v))
+	case string:
+		b.WriteString(strconv.Quote(v))
+	case *List:
+		b.WriteByte('[')
+		for i, e := range v.elems {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeRepr(b, e, append(path, v))
+		}
+		b.WriteByte(']')
+	case Tuple:
+		b.WriteByte('(')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeRepr(b, e, path)
+		}
+		if len(v) == 1 {
+			b.WriteByte(',')
+		}
+		b.WriteByte(')')
+	case *Dict:
+		b.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeRepr(b, k, path)
+			b.WriteString(": ")
+			writeRepr(b, v.vals[i], append(path, v))
+		}
+		b.WriteByte('}')
+	case *Function:
+		fmt.Fprintf(b, "<function %s>", v.def.name)
+	case *Builtin:
+		if v.recv != nil {
+			fmt.Fprintf(b, "<built-in method %s of %s value>", v.name, Type(v.recv))
+		} else {
+			fmt.Fprintf(b, "<built-in function %s>", v.name)
+		}
+	default:
+		fmt.Fprintf(b, "%v", v)
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	s := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+// hashKey returns the key of a hashable value in a dict. Equal values, like
+// 1 and 1.0, have equal keys.
+func hashKey(v Value) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "N", nil
+	case bool:
+		if v {
+			return "B1", nil
+		}
+		return "B0", nil
+	case int64:
+		return "I" + strconv.FormatInt(v, 10), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return "I" + strconv.FormatInt(int64(v), 10), nil
+		}
+		return "F" + strconv.FormatFloat(v, 'g', -1, 64), nil
+	case string:
+		return "S" + v, nil
+	case Tuple:
+		var b strings.Builder
+		b.WriteString("T(")
+		for _, e := range v {
+			h, err := hashKey(e)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(strconv.Quote(h))
+			b.WriteByte(',')
+		}
+		b.WriteByte(')')
+		return b.String(), nil
+	case *Function:
+		return fmt.Sprintf("P%p", v), nil
+	case *Builtin:
+		return fmt.Sprintf("B%s:%p", v.name, v.fn), nil
+	}
+	return "", fmt.Errorf("unhashable type: %s", Type(v))
+}
+
+// Equal reports whether x and y are equal.
+func Equal(x, y Value) (bool, error) {
+	return equal(x, y, 0)
+}
+
+func equal(x, y Value, depth int) (bool, error) {
+	if depth > 100 {
+		return false, fmt.Errorf("comparison exceeds maximum recursion depth")
+	}
+	if xf, yf, ok := numbers(x, y); ok {
+		return xf == yf, nil
+	}
+	switch x := x.(type) {
+	case *List:
+		y, ok := y.(*List)
+		if !ok {
+			return false, nil
+		}
+		return equalSeq(x.elems, y.elems, depth)
+	case Tuple:
+		y, ok := y.(Tuple)
+		if !ok {
+			return false, nil
+		}
+		return equalSeq(x, y, depth)
+	case *Dict:
+		y, ok := y.(*Dict)
+		if !ok || len(x.keys) != len(y.keys) {
+			return false, nil
+		}
+		for i, k := range x.keys {
+			yv, found, err := y.Get(k)
+			if err != nil || !found {
+				return false, err
+			}
+			if eq, err := equal(x.vals[i], yv, depth+1); err != nil || !eq {
+				return false, err
+			}
+		}
+		return true, nil
+	case *Function, *Builtin:
+		hx, _ := hashKey(x)
+		hy, err := hashKey(y)
+		return err == nil && hx == hy, nil
+	}
+	return x == y, nil
+}
+
+func equalSeq(x, y []Value, depth int) (bool, error) {
+	if len(x) != len(y) {
+		return false, nil
+	}
+	for i := range x {
+		if eq, err := equal(x[i], y[i], depth+1); err != nil || !eq {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// numbers returns x and y as floats when both are numbers. Bools are not
+// numbers here, so that True != 1 as in Starlark.
+func numbers(x, y Value) (float64, float64, bool) {
+	xf, ok := number(x)
+	if !ok {
+		return 0, 0, false
+	}
+	yf, ok := number(y)
+	return xf, yf, ok
+}
+
+func number(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Compare returns -1, 0 or 1 as x is less than, equal to or greater than
+// y. Numbers, strings, and lists and tuples of them are ordered.
+func Compare(x, y Value) (int, error) {
+	if xi, ok := x.(int64); ok {
+		if yi, ok := y.(int64); ok {
+			return cmp(xi < yi, xi > yi), nil
+		}
+	}
+	if xf, yf, ok := numbers(x, y); ok {
+		return cmp(xf < yf, xf > yf), nil
+	}
+	switch x := x.(type) {
+	case string:
+		if y, ok := y.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := y.(bool); ok {
+			return cmp(!x && y, x && !y), nil
+		}
+	case *List:
+		if y, ok := y.(*List); ok {
+			return compareSeq(x.elems, y.elems)
+		}
+	case Tuple:
+		if y, ok := y.(Tuple); ok {
+			return compareSeq(x, y)
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s and %s", Type(x), Type(y))
+}
+
+func cmp(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+func compareSeq(x, y []Value) (int, error) {
+	for i := 0; i < len(x) && i < len(y); i++ {
+		c, err := Compare(x[i], y[i])
+		if err != nil || c != 0 {
+			return c, err
+		}
+	}
+	return cmp(len(x) < len(y), len(x) > len(y)), nil
+}
+
+// iterate returns the elements of an iterable value: a list, tuple or dict,
+// whose keys are iterated. done must be called when the loop ends.
+func iterate(v Value) (elems []Value, done func(), err error) {
+	switch v := v.(type) {
+	case *List:
+		v.iterating++
+		return v.elems, func() { v.iterating-- }, nil
+	case Tuple:
+		return v, func() {}, nil
+	case *Dict:
+		v.iterating++
+		return v.keys, func() { v.iterating-- }, nil
+	}
+	return nil, nil, fmt.Errorf("%s is not iterable", Type(v))
+}
+
+// elements returns a copy of the elements of an iterable value.
+func elements(v Value) ([]Value, error) {
+	elems, done, err := iterate(v)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return append([]Value(nil), elems...), nil
+}
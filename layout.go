@@ -0,0 +1,160 @@
+package randr
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// defaultRateTolerance is how far apart two refresh rates can be and still
+// count as the same, as 59.94 and 60.00 do.
+const defaultRateTolerance = 0.5
+
+// tolerance is how far apart in hertz two refresh rates can be and still
+// count as the same.
+type tolerance float64
+
+// sameRate reports whether two refresh rates are within the tolerance.
+// Drivers round rates differently, so a kernel update can turn a 59.94Hz
+// mode into 59.93Hz.
+func (t tolerance) sameRate(a, b float64) bool {
+	return math.Abs(a-b) <= float64(t)
+}
+
+// outputState is the active configuration of a single connected output.
+type outputState struct {
+	Off     bool       `json:"off,omitempty"`
+	Mode    resolution `json:"mode"`
+	X       int        `json:"x"`
+	Y       int        `json:"y"`
+	Primary bool       `json:"primary,omitempty"`
+	// ScaleFrom is the logical size the mode is scaled from, if the output
+	// is scaled, e.g. to mirror a screen of a different resolution.
+	ScaleFrom resolution `json:"scale_from,omitzero"`
+	// Rotation is "normal", "left", "right" or "inverted"; empty leaves
+	// the output's rotation as it is, and is normal in a current state.
+	Rotation string `json:"rotation,omitempty"`
+	// Rate is the refresh rate; 0 leaves it to xrandr, which picks the
+	// mode's first listed rate.
+	Rate float64 `json:"rate,omitempty"`
+}
+
+// size returns the area the output covers in the framebuffer.
+func (s outputState) size() resolution {
+	if s.ScaleFrom != (resolution{}) {
+		return s.ScaleFrom
+	}
+	if s.Rotation == "left" || s.Rotation == "right" {
+		return resolution{s.Mode.H, s.Mode.W}
+	}
+	return s.Mode
+}
+
+// currentLayout returns the state of every connected output, keyed by name.
+func currentLayout(outputs []output) map[string]outputState {
+	l := make(map[string]outputState)
+	for _, o := range outputs {
+		if !o.Connected {
+			continue
+		}
+		if !o.active() {
+			l[o.Name] = outputState{Off: true}
+			continue
+		}
+		st := outputState{
+			Mode:     o.Resolutions[o.Current],
+			X:        o.X,
+			Y:        o.Y,
+			Primary:  o.Primary,
+			Rotation: o.Rotation,
+			Rate:     o.Rate,
+		}
+		if o.Geometry != o.size() {
+			st.ScaleFrom = o.Geometry
+		}
+		l[o.Name] = st
+	}
+	return l
+}
+
+// snapshotLayout returns the state of every connected output, lit or off,
+// as a layout that puts it back.
+func snapshotLayout(outputs []output) layout {
+	cur := currentLayout(outputs)
+	var l layout
+	for _, name := range slices.Sorted(maps.Keys(cur)) {
+		c := outputConfig{Name: name, outputState: cur[name]}
+		if !c.Off {
+			c.Rotation = cmp.Or(c.Rotation, "normal")
+		}
+		l = append(l, c)
+	}
+	return l
+}
+
+// outputConfig is the desired state of one output.
+type outputConfig struct {
+	Name string `json:"name"`
+	outputState
+	// Props are RandR properties to set on the output.
+	Props map[string]string `json:"props,omitempty"`
+	// resetScale undoes a scaling the output currently has.
+	resetScale bool
+	// addMode adds Mode to the output first.
+	addMode bool
+}
+
+// layout is the desired state of a set of outputs, in the order they are
+// passed to xrandr. Outputs not listed are left untouched.
+type layout []outputConfig
+
+// satisfiedBy reports whether the output's current state already matches,
+// the refresh rate within the tolerance. A desired state that does not ask
+// for primary accepts either.
+func (c outputConfig) satisfiedBy(cur outputState, t tolerance) bool {
+	if c.Off || cur.Off {
+		return c.Off == cur.Off
+	}
+	normal := func(r string) string { return cmp.Or(r, "normal") }
+	return c.Mode == cur.Mode && c.X == cur.X && c.Y == cur.Y &&
+		c.ScaleFrom == cur.ScaleFrom && (!c.Primary || cur.Primary) &&
+		(c.Rotation == "" || normal(c.Rotation) == normal(cur.Rotation)) &&
+		(c.Rate == 0 || t.sameRate(c.Rate, cur.Rate))
+}
+
+// args returns the xrandr arguments that set up the layout.
+func (l layout) args() []string {
+	var args []string
+	for _, c := range l {
+		args = append(args, "--output", c.Name)
+		if c.Off {
+			args = append(args, "--off")
+			continue
+		}
+		args = append(args,
+			"--mode", c.Mode.String(),
+			"--pos", fmt.Sprintf("%dx%d", c.X, c.Y),
+		)
+		if c.Rate > 0 {
+			args = append(args, "--rate", strconv.FormatFloat(c.Rate, 'f', 2, 64))
+		}
+		if c.ScaleFrom != (resolution{}) {
+			args = append(args, "--scale-from", c.ScaleFrom.String())
+		} else if c.resetScale {
+			args = append(args, "--scale", "1x1")
+		}
+		if c.Rotation != "" {
+			args = append(args, "--rotate", c.Rotation)
+		}
+		if c.Primary {
+			args = append(args, "--primary")
+		}
+		for _, name := range slices.Sorted(maps.Keys(c.Props)) {
+			args = append(args, "--set", name, c.Props[name])
+		}
+	}
+	return args
+}
@@ -0,0 +1,154 @@
+package randr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// learnDelay is how long a manually changed layout must stay untouched before
+// it is considered deliberate.
+const learnDelay = 10 * time.Second
+
+// learner notices when the layout is changed behind randr's back, e.g. with
+// arandr, and reports it once it has been stable for learnDelay.
+type learner struct {
+	expected map[string]outputState
+	pending  map[string]outputState
+	since    time.Time
+}
+
+// reset forgets the known layout; the next observation becomes the baseline.
+// It must be called whenever randr itself changes the layout.
+func (l *learner) reset() {
+	l.expected, l.pending = nil, nil
+}
+
+// drifted reports whether the layout differs from the baseline.
+func (l *learner) drifted(outputs []output) bool {
+	return l.expected != nil && !maps.Equal(currentLayout(outputs), l.expected)
+}
+
+// observe returns the current layout when it differs from the baseline and
+// has not changed for learnDelay.
+func (l *learner) observe(outputs []output, now time.Time) (map[string]outputState, bool) {
+	cur := currentLayout(outputs)
+	if l.expected == nil {
+		l.expected = cur
+		return nil, false
+	}
+	if maps.Equal(cur, l.expected) {
+		l.pending = nil
+		return nil, false
+	}
+	if !maps.Equal(cur, l.pending) {
+		l.pending, l.since = cur, now
+		return nil, false
+	}
+	if now.Sub(l.since) < learnDelay {
+		return nil, false
+	}
+	l.expected, l.pending = cur, nil
+	return cur, true
+}
+
+// learnedProfile builds a fixed-layout profile reproducing the given layout
+// for the connected monitor set.
+func learnedProfile(connected []output, layout map[string]outputState) profile {
+	fp := fingerprint(connected)
+	p := profile{
+		Name:        fmt.Sprintf("learned-%x", sha256.Sum256([]byte(fp)))[:16],
+		Layout:      layoutFixed,
+		Arrangement: make(map[string]outputSetting),
+	}
+	for _, o := range connected {
+		st := layout[o.Name]
+		p.Outputs = append(p.Outputs, o.id())
+		if st.Off {
+			p.Arrangement[o.id()] = outputSetting{Off: true}
+			continue
+		}
+		p.Arrangement[o.id()] = outputSetting{
+			Mode:    st.Mode.String(),
+			Pos:     fmt.Sprintf("%dx%d", st.X, st.Y),
+			Primary: st.Primary,
+		}
+	}
+	return p
+}
+
+func loadLearned(path string) ([]profile, error) {
+	var c config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c.Profiles, nil
+}
+
+func saveLearned(path string, profiles []profile) error {
+	data, err := json.MarshalIndent(config{Profiles: profiles}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// rememberLayout saves a manually arranged layout as a learned profile so it
+// is applied the next time the same monitors connect. Without "learn" in the
+// config it only offers to do so.
+func rememberLayout(ctx context.Context, cfg *config, dirs paths, outputs []output, layout map[string]outputState) {
+	connected := connectedOutputs(outputs)
+	// A connector name says nothing about the monitor behind it, so a
+	// profile keyed by one would match whatever is plugged in there next.
+	if i := slices.IndexFunc(connected, func(o output) bool { return o.Unidentified }); i >= 0 {
+		infof(ctx, "manual layout detected, not learning it: %s has no usable EDID", connected[i].Name)
+		return
+	}
+	p := learnedProfile(connected, layout)
+	if !cfg.Learn {
+		infof(ctx, "manual layout detected for %s; set \"learn\": true in the config to remember it", fingerprint(connected))
+		return
+	}
+
+	path := dirs.learned()
+	learned, err := loadLearned(path)
+	if err != nil {
+		logf(ctx, "learn: %v", err)
+		return
+	}
+	learned = replaceProfile(learned, p)
+	if err := saveLearned(path, learned); err != nil {
+		logf(ctx, "learn: %v", err)
+		return
+	}
+	cfg.Profiles = replaceProfile(cfg.Profiles, p)
+	infof(ctx, "learned layout for %s as profile %q", fingerprint(connected), p.Name)
+}
+
+// replaceProfile replaces the profile with the same name, or appends it.
+func replaceProfile(profiles []profile, p profile) []profile {
+	for i := range profiles {
+		if profiles[i].Name == p.Name {
+			profiles[i] = p
+			return profiles
+		}
+	}
+	return append(profiles, p)
+}
@@ -0,0 +1,104 @@
+package randr
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLearnerObserve(t *testing.T) {
+	docked, _ := readQuery(t, "dock.txt")
+	// The Dell lit right of the panel, as arandr would leave it.
+	extended, _ := readQuery(t, "dock.txt")
+	extended[1].Current, extended[1].CRTC, extended[1].X, extended[1].Geometry = 0, true, 1920, resolution{2560, 1440}
+	// And moved left of it.
+	moved, _ := readQuery(t, "dock.txt")
+	moved[1].Current, moved[1].CRTC, moved[1].Geometry = 0, true, resolution{2560, 1440}
+	moved[0].X = 2560
+
+	start := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name  string
+		polls [][]output
+		// at are the seconds after start of each poll.
+		at      []int
+		learned int
+		drifted bool
+	}{
+		{"baseline", [][]output{docked}, []int{0}, -1, false},
+		{"unchanged", [][]output{docked, docked, docked}, []int{0, 30, 60}, -1, false},
+		{"not settled yet", [][]output{docked, extended, extended}, []int{0, 2, 11}, -1, true},
+		{"settled", [][]output{docked, extended, extended, extended}, []int{0, 2, 11, 12}, 3, false},
+		{"changed again", [][]output{docked, extended, moved, moved}, []int{0, 2, 11, 20}, -1, true},
+		{"changed back", [][]output{docked, extended, docked, extended}, []int{0, 2, 11, 20}, -1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var l learner
+			learned := -1
+			for i, outputs := range tc.polls {
+				layout, ok := l.observe(outputs, start.Add(time.Duration(tc.at[i])*time.Second))
+				if ok {
+					learned = i
+					if !reflect.DeepEqual(layout, currentLayout(outputs)) {
+						t.Errorf("learned %v, want the current layout", layout)
+					}
+				}
+			}
+			if learned != tc.learned {
+				t.Errorf("learned at poll %d, want %d", learned, tc.learned)
+			}
+			if got := l.drifted(tc.polls[len(tc.polls)-1]); got != tc.drifted {
+				t.Errorf("drifted %t, want %t", got, tc.drifted)
+			}
+		})
+	}
+
+	var l learner
+	l.observe(docked, start)
+	l.reset()
+	if l.drifted(extended) {
+		t.Error("drifted after a reset")
+	}
+}
+
+func TestLearnedProfile(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	connected := connectedOutputs(outputs)
+	layout := map[string]outputState{
+		"eDP-1":  {Off: true},
+		"HDMI-1": {Mode: resolution{2560, 1440}, Primary: true},
+	}
+	p := learnedProfile(connected, layout)
+	want := profile{
+		Name:    p.Name,
+		Layout:  layoutFixed,
+		Outputs: []string{"AUO-133D-00000000", "DEL-A0B8-718NY83"},
+		Arrangement: map[string]outputSetting{
+			"AUO-133D-00000000": {Off: true},
+			"DEL-A0B8-718NY83":  {Mode: "2560x1440", Pos: "0x0", Primary: true},
+		},
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got  %+v\nwant %+v", p, want)
+	}
+	if other := learnedProfile(connected[:1], layout); other.Name == p.Name || len(p.Name) != 16 {
+		t.Errorf("names %q and %q, want distinct per monitor set", p.Name, other.Name)
+	}
+
+	path := filepath.Join(t.TempDir(), "learned.json")
+	if got, err := loadLearned(path); err != nil || got != nil {
+		t.Fatalf("nothing learned yet: got %v, %v", got, err)
+	}
+	if err := saveLearned(path, replaceProfile(nil, p)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadLearned(path)
+	if err != nil || !reflect.DeepEqual(got, []profile{p}) {
+		t.Errorf("loaded %+v, %v", got, err)
+	}
+	p.Arrangement["DEL-A0B8-718NY83"] = outputSetting{Mode: "1920x1080", Pos: "0x0"}
+	if got := replaceProfile(got, p); len(got) != 1 || got[0].Arrangement["DEL-A0B8-718NY83"].Mode != "1920x1080" {
+		t.Errorf("relearned: %+v", got)
+	}
+}
@@ -0,0 +1,64 @@
+package randr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// procDir is where running processes are listed.
+var procDir = "/proc"
+
+// defaultLockers are the process names of the common X screen lockers.
+var defaultLockers = []string{"xsecurelock", "i3lock", "i3lock-color", "slock", "xlock", "physlock", "xtrlock"}
+
+// lockerConfig sets up coordination with the screen locker. Lockers draw
+// over the screen's geometry when they start, so a layout applied while one
+// is up can leave it covering the wrong area.
+type lockerConfig struct {
+	// Off applies layouts regardless of the locker.
+	Off bool `json:"off,omitempty"`
+	// Processes are the process names of lockers, the common X lockers
+	// by default.
+	Processes []string `json:"processes,omitempty"`
+	// Check is a shell command that exits 0 while the screen is locked,
+	// used instead of looking for locker processes.
+	Check string `json:"check,omitempty"`
+	// Notify is a shell command run after a layout was applied while the
+	// screen is locked, to have the locker redraw. Without it, layout
+	// changes wait until the screen is unlocked.
+	Notify string `json:"notify,omitempty"`
+}
+
+// locked reports whether the screen is locked.
+func (c *lockerConfig) locked(ctx context.Context) bool {
+	if c.Off {
+		return false
+	}
+	if c.Check != "" {
+		_, err := runCommand(ctx, false, "sh", []string{"-c", c.Check}, nil)
+		return err == nil
+	}
+	names := c.Processes
+	if len(names) == 0 {
+		names = defaultLockers
+	}
+	comms, _ := filepath.Glob(filepath.Join(procDir, "[0-9]*", "comm"))
+	for _, f := range comms {
+		data, err := os.ReadFile(f)
+		if err == nil && slices.Contains(names, strings.TrimSpace(string(data))) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify has the locker redraw after a layout change.
+func (c *lockerConfig) notify(ctx context.Context) {
+	infof(ctx, "locker: %s", c.Notify)
+	if _, err := runCommand(ctx, false, "sh", []string{"-c", c.Notify}, nil); err != nil {
+		logf(ctx, "locker: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// logLevel controls how chatty the daemon is. Errors are always logged.
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelInfo
+	levelDebug
+	// levelTrace adds the raw xrandr output and every parsing decision.
+	levelTrace
+)
+
+// minVerbosity is the level requested with -v or -vv; the config can only
+// raise it.
+var minVerbosity = levelError
+
+// captureDir, if set, receives a copy of every distinct xrandr query output.
+var captureDir string
+
+// registerLogFlags adds the verbosity and capture flags to fs.
+func registerLogFlags(fs *flag.FlagSet) {
+	fs.BoolFunc("v", "log debug details", func(string) error {
+		minVerbosity = max(minVerbosity, levelDebug)
+		return nil
+	})
+	fs.BoolFunc("vv", "log raw xrandr output and every parsing decision", func(string) error {
+		minVerbosity = levelTrace
+		return nil
+	})
+	fs.StringVar(&captureDir, "capture-dir", "", "save each distinct xrandr query output in `dir`")
+}
+
+func parseLogLevel(s string) (logLevel, error) {
+	switch strings.ToLower(s) {
+	case "error", "quiet":
+		return levelError, nil
+	case "info", "":
+		return levelInfo, nil
+	case "debug":
+		return levelDebug, nil
+	case "trace":
+		return levelTrace, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// logf logs a failure; it is logged whatever the verbosity.
+func logf(ctx context.Context, format string, args ...any) {
+	settingsFrom(ctx).logger.Printf(format, args...)
+}
+
+// infof logs routine activity: detections, decisions and xrandr calls.
+func infof(ctx context.Context, format string, args ...any) {
+	if s := settingsFrom(ctx); s.verbosity >= levelInfo {
+		s.logger.Printf(format, args...)
+	}
+}
+
+// debugf logs details useful when diagnosing a misbehaving setup.
+func debugf(ctx context.Context, format string, args ...any) {
+	if s := settingsFrom(ctx); s.verbosity >= levelDebug {
+		s.logger.Printf(format, args...)
+	}
+}
+
+// tracef logs the raw input and individual parsing decisions.
+func tracef(ctx context.Context, format string, args ...any) {
+	if s := settingsFrom(ctx); s.verbosity >= levelTrace {
+		s.logger.Printf(format, args...)
+	}
+}
+
+// lastCapture is the most recently captured query output; identical polls
+// are not written again.
+var lastCapture []byte
+
+// captureQuery saves the xrandr query output to captureDir, so parsing bugs
+// on unusual hardware can be reproduced from the exact input.
+func captureQuery(ctx context.Context, data []byte) {
+	if captureDir == "" || bytes.Equal(data, lastCapture) {
+		return
+	}
+	lastCapture = bytes.Clone(data)
+	name := filepath.Join(captureDir, "xrandr-"+time.Now().Format("20060102-150405.000")+".txt")
+	if err := os.MkdirAll(captureDir, 0o755); err != nil {
+		logf(ctx, "capture: %v", err)
+		return
+	}
+	if err := os.WriteFile(name, data, 0o644); err != nil {
+		logf(ctx, "capture: %v", err)
+		return
+	}
+	debugf(ctx, "captured xrandr output to %s", name)
+}
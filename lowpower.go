@@ -0,0 +1,81 @@
+package randr
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// lowPowerRecheck is how long a query's outputs are reused at most, so
+// what the connectors do not show, such as a mode set by hand, is still
+// noticed.
+const lowPowerRecheck = 30 * time.Second
+
+// drmConnectorGlob lists the kernel's DRM connectors, as "card0-HDMI-A-1".
+var drmConnectorGlob = "/sys/class/drm/card*-*"
+
+// connectorHash hashes the status, enabled state and EDID of every DRM
+// connector, which change as monitors come and go and outputs are switched
+// on and off, without probing them. ok is false without connectors, as
+// with drivers that do not expose them, where the hash tells nothing.
+func connectorHash() (uint64, bool) {
+	dirs, _ := filepath.Glob(drmConnectorGlob)
+	if len(dirs) == 0 {
+		return 0, false
+	}
+	h := fnv.New64a()
+	for _, dir := range dirs {
+		h.Write([]byte(dir))
+		for _, name := range []string{"status", "enabled", "edid"} {
+			b, _ := os.ReadFile(filepath.Join(dir, name))
+			h.Write(append(b, 0))
+		}
+	}
+	return h.Sum64(), true
+}
+
+// precheck decides whether a poll can skip running and parsing xrandr,
+// which is measurable on a Raspberry Pi polling every two seconds: as long
+// as the connectors hash the same as when the outputs were last queried,
+// those outputs are handed back instead.
+type precheck struct {
+	// hash is the connectors' hash at the last query, pending the one
+	// taken before the query in progress.
+	hash, pending uint64
+	at            time.Time
+	outputs       []output
+	scr           screen
+}
+
+// reuse returns the last query's outputs if the connectors have not
+// changed since, nor has lowPowerRecheck passed.
+func (pc *precheck) reuse(ctx context.Context, now time.Time) ([]output, screen, bool) {
+	if !settingsFrom(ctx).lowPower {
+		return nil, screen{}, false
+	}
+	h, ok := connectorHash()
+	pc.pending = h
+	if !ok || pc.outputs == nil || h != pc.hash || now.Sub(pc.at) >= lowPowerRecheck {
+		return nil, screen{}, false
+	}
+	return slices.Clone(pc.outputs), pc.scr, true
+}
+
+// store remembers a query's outputs for reuse. Outputs still waiting for
+// their modes are queried again every poll, so they are not stored.
+func (pc *precheck) store(ctx context.Context, outputs []output, scr screen, now time.Time) {
+	if !settingsFrom(ctx).lowPower || slices.ContainsFunc(outputs, func(o output) bool { return o.Connected && len(o.Resolutions) == 0 }) {
+		pc.outputs = nil
+		return
+	}
+	pc.hash, pc.at, pc.outputs, pc.scr = pc.pending, now, slices.Clone(outputs), scr
+}
+
+// reset forgets the stored outputs, so the next poll queries, as it must
+// after a layout change.
+func (pc *precheck) reset() {
+	pc.outputs = nil
+}
@@ -0,0 +1,85 @@
+package randr
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// isPattern reports whether an output reference in a profile or rule is a
+// pattern rather than a connector name or EDID fingerprint: a glob such as
+// "DP-*-1" or "HDMI-?", or a regular expression between slashes.
+func isPattern(want string) bool {
+	return isRegexp(want) || strings.ContainsAny(want, "*?[")
+}
+
+func isRegexp(want string) bool {
+	return len(want) > 2 && strings.HasPrefix(want, "/") && strings.HasSuffix(want, "/")
+}
+
+// checkPattern reports a malformed pattern.
+func checkPattern(want string) error {
+	if isRegexp(want) {
+		_, err := regexp.Compile(want[1 : len(want)-1])
+		return err
+	}
+	if _, err := path.Match(want, ""); err != nil {
+		return fmt.Errorf("bad pattern %q", want)
+	}
+	return nil
+}
+
+// matchesOutput reports whether want names the output: by connector name or
+// EDID fingerprint, exactly or as a pattern.
+func matchesOutput(want string, o output) bool {
+	if want == o.Name || want == o.id() {
+		return true
+	}
+	if !isPattern(want) {
+		return false
+	}
+	match := func(s string) bool {
+		if isRegexp(want) {
+			re, err := regexp.Compile(want[1 : len(want)-1])
+			return err == nil && re.MatchString(s)
+		}
+		ok, _ := path.Match(want, s)
+		return ok
+	}
+	return match(o.Name) || (o.id() != o.Name && match(o.id()))
+}
+
+// assign pairs the wanted outputs with distinct connected outputs. Exact
+// references are paired first, then patterns in the order given, each with
+// the first free output by connector name, so a pattern matching several
+// outputs is resolved the same way every time. The result maps indexes of
+// wants to indexes of connected.
+func assign(wants []string, connected []output) map[int]int {
+	order := make([]int, len(connected))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(connected[a].Name, connected[b].Name)
+	})
+
+	pairs := make(map[int]int)
+	used := make(map[int]bool)
+	for _, patterns := range []bool{false, true} {
+		for wi, want := range wants {
+			if isPattern(want) != patterns {
+				continue
+			}
+			for _, ci := range order {
+				if !used[ci] && matchesOutput(want, connected[ci]) {
+					pairs[wi], used[ci] = ci, true
+					break
+				}
+			}
+		}
+	}
+	return pairs
+}
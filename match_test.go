@@ -0,0 +1,74 @@
+package randr
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestMatchesOutput(t *testing.T) {
+	dell := output{Name: "DP-2-1", Monitor: monitorID{Vendor: "DEL", Product: 0xa0b8, Serial: "718NY83"}}
+	for _, tc := range []struct {
+		want  string
+		match bool
+	}{
+		{"DP-2-1", true},
+		{"DEL-A0B8-718NY83", true},
+		{"DP-1-1", false},
+		{"DP-*-1", true},
+		{"DP-?-1", true},
+		{"DP-[13]-1", false},
+		{"HDMI-?", false},
+		{"DEL-*", true},
+		{"/^DP-[0-9]+-1$/", true},
+		{"/^HDMI/", false},
+		{"/", false},
+	} {
+		if got := matchesOutput(tc.want, dell); got != tc.match {
+			t.Errorf("%q matches %s: %t, want %t", tc.want, dell.Name, got, tc.match)
+		}
+	}
+}
+
+func TestCheckPattern(t *testing.T) {
+	for want, bad := range map[string]bool{
+		"DP-*-1": false, "/^DP-[0-9]+$/": false, "DP-[1": true, "/DP-(/": true,
+	} {
+		if err := checkPattern(want); (err != nil) != bad {
+			t.Errorf("checkPattern(%q) = %v", want, err)
+		}
+	}
+}
+
+// Docks renumber their outputs by port; patterns pair with the outputs in
+// connector order, after exact references took theirs.
+func TestAssign(t *testing.T) {
+	connected := []output{{Name: "eDP-1"}, {Name: "DP-3-2"}, {Name: "DP-3-1"}}
+	for _, tc := range []struct {
+		name  string
+		wants []string
+		want  map[int]int
+	}{
+		{"exact", []string{"eDP-1", "DP-3-1"}, map[int]int{0: 0, 1: 2}},
+		{"first by name", []string{"DP-*"}, map[int]int{0: 2}},
+		{"patterns in order", []string{"DP-*", "DP-*"}, map[int]int{0: 2, 1: 1}},
+		{"exact first", []string{"DP-*", "DP-3-1"}, map[int]int{0: 1, 1: 2}},
+		{"no match", []string{"HDMI-?"}, map[int]int{}},
+		{"distinct outputs", []string{"DP-3-1", "DP-3-1"}, map[int]int{0: 2}},
+	} {
+		if got := assign(tc.wants, connected); !maps.Equal(got, tc.want) {
+			t.Errorf("%s: assign(%q) = %v, want %v", tc.name, tc.wants, got, tc.want)
+		}
+	}
+
+	desk := profile{Outputs: []string{"eDP-1", "DP-*-1", "DP-*-2"}}
+	if !desk.matchesSet(connected) {
+		t.Error("dock profile does not match on DP-3")
+	}
+	moved := []output{{Name: "eDP-1"}, {Name: "DP-1-1"}, {Name: "DP-1-2"}}
+	if !desk.matchesSet(moved) {
+		t.Error("dock profile does not match on DP-1")
+	}
+	if desk.matchesSet(connected[:2]) {
+		t.Error("dock profile matches with a monitor missing")
+	}
+}
@@ -0,0 +1,226 @@
+package randr
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// moduleRetry is how often the module looks for the daemon while it is not
+// running.
+const moduleRetry = 2 * time.Second
+
+// moduleFormats are the status bars the module speaks to.
+var moduleFormats = []string{"i3blocks", "polybar", "waybar"}
+
+// moduleText summarizes the daemon's status in one line: the profile and
+// the lit outputs.
+func moduleText(s daemonStatus) string {
+	var names []string
+	for _, o := range s.Outputs {
+		if o.Connected && o.active() {
+			names = append(names, o.Name)
+		}
+	}
+	text := strings.Join(names, " ")
+	if profile := cmp.Or(s.Profile, s.Matched); profile != "" {
+		text = profile + ": " + text
+	}
+	if s.Paused {
+		text += " (paused)"
+	}
+	return text
+}
+
+// waybarOutput is a line of Waybar's custom module JSON protocol.
+type waybarOutput struct {
+	Text    string `json:"text"`
+	Alt     string `json:"alt,omitempty"`
+	Tooltip string `json:"tooltip"`
+	Class   string `json:"class"`
+}
+
+// waybarLine renders the status as Waybar JSON. The class is "stopped",
+// "paused", "failed" after an error newer than the last action, or
+// "watching", for styling; alt is the profile, for format-icons. The
+// tooltip lists the lit outputs.
+func waybarLine(s *daemonStatus) string {
+	w := waybarOutput{Text: "randr: not running", Tooltip: "randr is not running", Class: "stopped"}
+	if s != nil {
+		w.Text, w.Alt, w.Class = moduleText(*s), cmp.Or(s.Profile, s.Matched), "watching"
+		var lines []string
+		for _, o := range s.Outputs {
+			if !o.Connected || !o.active() {
+				continue
+			}
+			line := fmt.Sprintf("%s %s+%d+%d", o.Name, o.Resolutions[o.Current], o.X, o.Y)
+			if o.Monitor.Name != "" {
+				line += " " + strconv.Quote(o.Monitor.Name)
+			}
+			lines = append(lines, line)
+		}
+		w.Tooltip = strings.Join(lines, "\n")
+		switch {
+		case s.Paused:
+			w.Class = "paused"
+		case s.LastError != "" && s.LastErrorTime.After(s.LastActionTime):
+			w.Class = "failed"
+			w.Tooltip += "\n" + s.LastError
+		}
+	}
+	data, _ := json.Marshal(w)
+	return string(data)
+}
+
+// moduleLine renders the status for the status bar; s is nil while the
+// daemon is not running. Polybar gets a left click action that cycles the
+// profiles.
+func moduleLine(format string, s *daemonStatus) string {
+	text := "randr: not running"
+	if s != nil {
+		text = moduleText(*s)
+	}
+	switch format {
+	case "waybar":
+		return waybarLine(s)
+	case "polybar":
+		self, err := os.Executable()
+		if s == nil || err != nil {
+			return text
+		}
+		// Colons end the command in polybar's action tags.
+		action := strings.ReplaceAll(self, ":", `\:`) + " cycle"
+		return "%{A1:" + action + ":}" + strings.ReplaceAll(text, "%", "%%") + "%{A}"
+	}
+	return text
+}
+
+// runModule prints the daemon's status for a status bar, a line whenever
+// it changes, until ctx is cancelled: i3blocks with interval=persist,
+// polybar's custom/script with tail = true, or a Waybar custom module with
+// the json return type. Clicks i3blocks writes to stdin
+// cycle the profiles on the left button.
+func runModule(ctx context.Context, dirs paths, args []string) error {
+	fs := flag.NewFlagSet("module", flag.ContinueOnError)
+	format := fs.String("format", "i3blocks", "status bar: "+strings.Join(moduleFormats, ", "))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !slices.Contains(moduleFormats, *format) {
+		return fmt.Errorf("unknown format %q, want %s", *format, strings.Join(moduleFormats, ", "))
+	}
+	if *format == "i3blocks" {
+		go moduleClicks(ctx, dirs)
+	}
+
+	last := ""
+	print := func(s *daemonStatus) {
+		if line := moduleLine(*format, s); line != last {
+			fmt.Println(line)
+			last = line
+		}
+	}
+	for {
+		statuses, err := watchDaemon(ctx, dirs.socket())
+		switch {
+		case err == nil:
+			for s := range statuses {
+				// The daemon has no outputs until its first query.
+				if len(s.Outputs) > 0 {
+					print(&s)
+				}
+			}
+		case !errors.Is(err, errNotRunning):
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		print(nil)
+		select {
+		case <-time.After(moduleRetry):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// moduleClicks reads the click events i3blocks writes to a persistent
+// block's stdin, one JSON object a line, and cycles the profiles on a left
+// click.
+func moduleClicks(ctx context.Context, dirs paths) {
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		var click struct {
+			Button int `json:"button"`
+		}
+		if json.Unmarshal(sc.Bytes(), &click) != nil || click.Button != 1 {
+			continue
+		}
+		if _, err := cycleDaemon(ctx, dirs.socket()); err != nil {
+			logf(ctx, "module: %v", err)
+		}
+	}
+}
+
+// runCycle has the running daemon apply the next profile applicable to the
+// connected monitors.
+func runCycle(ctx context.Context, dirs paths) error {
+	profile, err := cycleDaemon(ctx, dirs.socket())
+	if err != nil {
+		return err
+	}
+	fmt.Println(profile)
+	return nil
+}
+
+// runApply has the running daemon apply the named profile, and with --for
+// undo it again once that long has passed.
+func runApply(ctx context.Context, dirs paths, args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	ttl := fs.Duration("for", 0, "undo the layout again after this long, e.g. 30m")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// The flag may come after the profile too.
+	name := fs.Arg(0)
+	if fs.NArg() > 0 {
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if name == "" || fs.NArg() > 0 {
+		return errors.New("usage: randr apply profile [--for d]")
+	}
+	if *ttl < 0 {
+		return fmt.Errorf("--for must not be negative, not %s", *ttl)
+	}
+	profile, err := applyDaemon(ctx, dirs.socket(), name, *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Println(profile)
+	return nil
+}
+
+// runStep has the running daemon undo or redo a layout change, as step
+// says, and prints the profile the layout it put back came from, if any.
+func runStep(ctx context.Context, dirs paths, step string) error {
+	profile, err := stepDaemon(ctx, dirs.socket(), step)
+	if err != nil {
+		return err
+	}
+	if profile != "" {
+		fmt.Println(profile)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// virtualMonitor is a RandR monitor set up with `xrandr --setmonitor`: a
+// part of an output, such as one half of an ultrawide, or several outputs
+// joined into one logical monitor. Window managers place and maximize
+// windows per monitor.
+type virtualMonitor struct {
+	Name string `json:"name"`
+	// Outputs are the outputs the monitor spans, by connector name, EDID
+	// fingerprint or pattern.
+	Outputs []string `json:"outputs"`
+	// Area is the part of the outputs' bounding box the monitor covers:
+	// the "left", "right", "top" or "bottom" half, or "WxH+X+Y" relative
+	// to the box. It is the whole box by default.
+	Area string `json:"area,omitempty"`
+}
+
+// superUltrawideRatio is the aspect ratio from which an output counts as
+// super-ultrawide: 32:9 and wider.
+const superUltrawideRatio = 3.5
+
+// superUltrawide reports whether the output is a super-ultrawide panel, by
+// its preferred mode or else its physical size.
+func (o output) superUltrawide() bool {
+	size := o.Physical
+	if res, ok := o.preferred(); ok {
+		size = res
+	}
+	return size.H > 0 && float64(size.W)/float64(size.H) >= superUltrawideRatio
+}
+
+// monitors returns the profile's virtual monitors for the connected
+// outputs: its own, and with the "split" ultrawide preset a left and right
+// half of each super-ultrawide output not already covered by them.
+func (p *profile) monitors(outputs []output) []virtualMonitor {
+	mons := slices.Clone(p.Monitors)
+	if p.Ultrawide != ultrawideSplit {
+		return mons
+	}
+	for _, o := range connectedOutputs(outputs) {
+		covered := slices.ContainsFunc(p.Monitors, func(m virtualMonitor) bool {
+			return slices.ContainsFunc(m.Outputs, func(want string) bool { return matchesOutput(want, o) })
+		})
+		if o.superUltrawide() && !covered {
+			mons = append(mons,
+				virtualMonitor{Name: o.Name + "-left", Outputs: []string{o.Name}, Area: "left"},
+				virtualMonitor{Name: o.Name + "-right", Outputs: []string{o.Name}, Area: "right"})
+		}
+	}
+	return mons
+}
+
+// Ultrawide presets.
+const (
+	ultrawideWhole = "whole"
+	ultrawideSplit = "split"
+)
+
+var areaRe = regexp.MustCompile(`^(\d+)x(\d+)\+(\d+)\+(\d+)$`)
+
+// rect is an area of the framebuffer with its physical size.
+type rect struct {
+	X, Y, W, H int
+	// MMW and MMH are the physical size in millimetres, or zero.
+	MMW, MMH int
+}
+
+// area returns the part of box the monitor covers.
+func (m virtualMonitor) area(box rect) (rect, error) {
+	r := box
+	switch m.Area {
+	case "":
+	case "left", "right":
+		r.W, r.MMW = box.W/2, box.MMW/2
+		if m.Area == "right" {
+			r.X += box.W - r.W
+		}
+	case "top", "bottom":
+		r.H, r.MMH = box.H/2, box.MMH/2
+		if m.Area == "bottom" {
+			r.Y += box.H - r.H
+		}
+	default:
+		a := areaRe.FindStringSubmatch(m.Area)
+		if a == nil {
+			return rect{}, fmt.Errorf("bad area %q", m.Area)
+		}
+		w, _ := strconv.Atoi(a[1])
+		h, _ := strconv.Atoi(a[2])
+		x, _ := strconv.Atoi(a[3])
+		y, _ := strconv.Atoi(a[4])
+		if x+w > box.W || y+h > box.H {
+			return rect{}, fmt.Errorf("area %s exceeds %dx%d", m.Area, box.W, box.H)
+		}
+		r = rect{X: box.X + x, Y: box.Y + y, W: w, H: h}
+		if box.W > 0 && box.H > 0 {
+			r.MMW, r.MMH = box.MMW*w/box.W, box.MMH*h/box.H
+		}
+	}
+	return r, nil
+}
+
+// validate checks the monitor's definition.
+func (m virtualMonitor) validate() error {
+	switch {
+	case m.Name == "":
+		return fmt.Errorf("monitor without a name")
+	case len(m.Outputs) == 0:
+		return fmt.Errorf("monitor %q without outputs", m.Name)
+	}
+	for _, want := range m.Outputs {
+		if err := checkPattern(want); err != nil {
+			return fmt.Errorf("monitor %q: %v", m.Name, err)
+		}
+	}
+	if _, err := m.area(rect{W: 1 << 16, H: 1 << 16}); err != nil {
+		return fmt.Errorf("monitor %q: %v", m.Name, err)
+	}
+	return nil
+}
+
+// monitorArgs returns the xrandr --setmonitor arguments for the monitors,
+// with the outputs where the layout l puts them. An output can belong to a
+// single monitor only, so when several monitors split one output, the
+// first takes it and the others are set up without one. Monitors whose
+// outputs are not all lit are skipped.
+func monitorArgs(ctx context.Context, mons []virtualMonitor, l layout, outputs []output) [][]string {
+	state := currentLayout(outputs)
+	for _, c := range l {
+		state[c.Name] = c.outputState
+	}
+	taken := make(map[string]bool)
+	var calls [][]string
+	for _, m := range mons {
+		var names []string
+		var box rect
+		lit := true
+		for _, o := range connectedOutputs(outputs) {
+			if !slices.ContainsFunc(m.Outputs, func(want string) bool { return matchesOutput(want, o) }) {
+				continue
+			}
+			st, ok := state[o.Name]
+			if !ok || st.Off {
+				lit = false
+				break
+			}
+			r := rect{X: st.X, Y: st.Y, W: st.size().W, H: st.size().H, MMW: o.Physical.W, MMH: o.Physical.H}
+			if len(names) == 0 {
+				box = r
+			} else {
+				box = box.union(r)
+			}
+			names = append(names, o.Name)
+		}
+		if !lit || len(names) == 0 {
+			infof(ctx, "monitor %q: outputs not lit, skipping", m.Name)
+			continue
+		}
+		r, err := m.area(box)
+		if err != nil {
+			logf(ctx, "monitor %q: %v", m.Name, err)
+			continue
+		}
+		var free []string
+		for _, n := range names {
+			if !taken[n] {
+				free = append(free, n)
+				taken[n] = true
+			}
+		}
+		out := strings.Join(free, ",")
+		if out == "" {
+			out = "none"
+		}
+		geometry := fmt.Sprintf("%d/%dx%d/%d+%d+%d", r.W, r.MMW, r.H, r.MMH, r.X, r.Y)
+		calls = append(calls, []string{"--setmonitor", m.Name, geometry, out})
+	}
+	return calls
+}
+
+// union returns the bounding box of both rects. Physical sizes add up
+// along the axis the rects are side by side on.
+func (a rect) union(b rect) rect {
+	r := rect{X: min(a.X, b.X), Y: min(a.Y, b.Y)}
+	r.W = max(a.X+a.W, b.X+b.W) - r.X
+	r.H = max(a.Y+a.H, b.Y+b.H) - r.Y
+	r.MMW, r.MMH = max(a.MMW, b.MMW), max(a.MMH, b.MMH)
+	if r.W > max(a.W, b.W) {
+		r.MMW = a.MMW + b.MMW
+	}
+	if r.H > max(a.H, b.H) {
+		r.MMH = a.MMH + b.MMH
+	}
+	return r
+}
+
+// setMonitors replaces the virtual monitors randr set up before, named by
+// prev, with mons. It returns the names of the monitors now set up. Where
+// the backend lists the monitors, ones already set up as wanted are left
+// in place, and ones already gone are not deleted again.
+func setMonitors(ctx context.Context, b Backend, prev []string, mons []virtualMonitor, l layout, outputs []output) []string {
+	wanted := monitorArgs(ctx, mons, l, outputs)
+	cur, err := queryMonitors(ctx, b)
+	if err != nil {
+		logf(ctx, "monitors: %v", err)
+	}
+	kept := func(args []string) bool {
+		return slices.ContainsFunc(cur, func(m randrMonitor) bool { return m.same(args) })
+	}
+	for _, name := range prev {
+		exists := slices.ContainsFunc(cur, func(m randrMonitor) bool { return m.Name == name })
+		if cur != nil && !exists || slices.ContainsFunc(wanted, func(args []string) bool { return args[1] == name && kept(args) }) {
+			continue
+		}
+		if err := b.Configure(ctx, []string{"--delmonitor", name}); err != nil {
+			logf(ctx, "monitor %q: %v", name, err)
+		}
+	}
+	var set []string
+	for _, args := range wanted {
+		if kept(args) && slices.Contains(prev, args[1]) {
+			debugf(ctx, "monitor %q: already set up", args[1])
+			set = append(set, args[1])
+			continue
+		}
+		if err := b.Configure(ctx, args); err != nil {
+			logf(ctx, "monitor %q: %v", args[1], err)
+			continue
+		}
+		set = append(set, args[1])
+	}
+	return set
+}
+
+// randrMonitor is a monitor as `xrandr --listmonitors` reports it. Unlike
+// the outputs of --query, these are what window managers see: the server
+// makes one for every lit output, and monitors set up with --setmonitor
+// come on top or instead.
+type randrMonitor struct {
+	Name string `json:"name"`
+	// Automatic is set for the monitors the server made for a lit output.
+	Automatic bool `json:"automatic,omitempty"`
+	Primary   bool `json:"primary,omitempty"`
+	// Geometry is as --setmonitor takes it, "W/MMWxH/MMH+X+Y".
+	Geometry string   `json:"geometry"`
+	Outputs  []string `json:"outputs,omitempty"`
+}
+
+// monitorLister is implemented by backends that can list the monitors.
+type monitorLister interface {
+	// ListMonitors returns the output of `xrandr --listmonitors`.
+	ListMonitors(ctx context.Context) ([]byte, error)
+}
+
+func (xrandrBackend) ListMonitors(ctx context.Context) ([]byte, error) {
+	if useProtocol(ctx) {
+		return protocolBackend{}.ListMonitors(ctx)
+	}
+	return runXrandr(ctx, true, []string{"--listmonitors"}, nil)
+}
+
+// mmRe matches the physical sizes in a monitor's geometry.
+var mmRe = regexp.MustCompile(`/\d+`)
+
+var listMonitorRe = regexp.MustCompile(`^\s*\d+: (\+?)(\*?)(\S+) (\d+/\d+x\d+/\d+[+-]\d+[+-]\d+)\s*(.*)$`)
+
+// parseMonitors parses the output of `xrandr --listmonitors`.
+func parseMonitors(data []byte) []randrMonitor {
+	var mons []randrMonitor
+	for line := range strings.Lines(string(data)) {
+		m := listMonitorRe.FindStringSubmatch(strings.TrimRight(line, "\n"))
+		if m == nil {
+			continue
+		}
+		mons = append(mons, randrMonitor{
+			Name:      m[3],
+			Automatic: m[1] == "+",
+			Primary:   m[2] == "*",
+			Geometry:  m[4],
+			Outputs:   strings.Fields(m[5]),
+		})
+	}
+	return mons
+}
+
+// queryMonitors lists the monitors, or returns nil if the backend cannot.
+func queryMonitors(ctx context.Context, b Backend) ([]randrMonitor, error) {
+	l, ok := b.(monitorLister)
+	if !ok {
+		return nil, nil
+	}
+	data, err := l.ListMonitors(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("xrandr --listmonitors: %w", err)
+	}
+	return parseMonitors(data), nil
+}
+
+// same reports whether the monitor is what the --setmonitor arguments set
+// up.
+func (m randrMonitor) same(args []string) bool {
+	outputs := strings.Join(m.Outputs, ",")
+	if outputs == "" {
+		outputs = "none"
+	}
+	return m.Name == args[1] && m.Geometry == args[2] && outputs == args[3]
+}
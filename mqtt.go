@@ -0,0 +1,276 @@
+package randr
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// mqttConfig publishes events to an MQTT broker and takes commands from it,
+// for home automation such as Home Assistant.
+type mqttConfig struct {
+	// Broker is "host:port", optionally prefixed with "tcp://", or with
+	// "tls://" or "ssl://" for TLS.
+	Broker string `json:"broker"`
+	// Topic receives every event as a JSON message, as with --emit-events.
+	Topic string `json:"topic"`
+	// CommandTopic, if set, is subscribed to: a message holding a profile
+	// name applies that profile, "cycle" cycles through the matching ones.
+	CommandTopic string `json:"command_topic,omitempty"`
+	Username     string `json:"username,omitempty"`
+	Password     string `json:"password,omitempty"`
+	// ClientID defaults to "randr-" and the host name.
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// mqttKeepAlive is the keep-alive interval announced to the broker; a ping
+// is sent at half of it.
+const mqttKeepAlive = 60 * time.Second
+
+// MQTT 3.1.1 packet types.
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+// runMQTT keeps a connection to the broker until ctx is cancelled,
+// reconnecting with backoff when it drops.
+func (d *Daemon) runMQTT(ctx context.Context, mc mqttConfig) {
+	bo := backoff{base: time.Second}
+	for {
+		err := d.mqttSession(ctx, mc)
+		if ctx.Err() != nil {
+			return
+		}
+		bo.fail()
+		logf(ctx, "mqtt: %v (reconnecting in %s)", err, bo.delay())
+		select {
+		case <-time.After(bo.delay()):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// mqttSession runs one connection: events go out to Topic, commands come in
+// from CommandTopic.
+func (d *Daemon) mqttSession(ctx context.Context, mc mqttConfig) error {
+	conn, err := mqttDial(ctx, mc.Broker)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	if err := mqttHandshake(ctx, conn, r, mc); err != nil {
+		return err
+	}
+	infof(ctx, "mqtt: connected to %s", mc.Broker)
+
+	events, stop := subscribe()
+	defer stop()
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- d.mqttRead(ctx, r, mc)
+	}()
+
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		var pkt []byte
+		select {
+		case e := <-events:
+			payload, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			pkt = mqttPacket(mqttPublish<<4, mqttString(mc.Topic), payload)
+		case <-ping.C:
+			pkt = mqttPacket(mqttPingreq << 4)
+		case err := <-readErr:
+			return err
+		case <-ctx.Done():
+			conn.Write(mqttPacket(mqttDisconnect << 4))
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(settingsFrom(ctx).commandTimeout))
+		if _, err := conn.Write(pkt); err != nil {
+			return err
+		}
+	}
+}
+
+func mqttDial(ctx context.Context, broker string) (net.Conn, error) {
+	scheme, addr, ok := strings.Cut(broker, "://")
+	if !ok {
+		scheme, addr = "tcp", broker
+	}
+	dialer := &net.Dialer{Timeout: settingsFrom(ctx).commandTimeout}
+	switch scheme {
+	case "tcp", "mqtt":
+		return dialer.DialContext(ctx, "tcp", addr)
+	case "tls", "ssl", "mqtts":
+		td := &tls.Dialer{NetDialer: dialer}
+		return td.DialContext(ctx, "tcp", addr)
+	}
+	return nil, fmt.Errorf("unsupported broker scheme %q", scheme)
+}
+
+// mqttHandshake connects and subscribes to the command topic.
+func mqttHandshake(ctx context.Context, conn net.Conn, r *bufio.Reader, mc mqttConfig) error {
+	conn.SetDeadline(time.Now().Add(settingsFrom(ctx).commandTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	id := mc.ClientID
+	if id == "" {
+		host, _ := os.Hostname()
+		id = "randr-" + host
+	}
+	flags := byte(0x02) // clean session
+	payload := mqttString(id)
+	if mc.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(mc.Username)...)
+	}
+	if mc.Password != "" {
+		flags |= 0x40
+		payload = append(payload, mqttString(mc.Password)...)
+	}
+	header := append(mqttString("MQTT"), 4, flags)
+	header = binary.BigEndian.AppendUint16(header, uint16(mqttKeepAlive/time.Second))
+	if _, err := conn.Write(mqttPacket(mqttConnect<<4, header, payload)); err != nil {
+		return err
+	}
+	typ, body, err := mqttReadPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != mqttConnack || len(body) < 2 {
+		return fmt.Errorf("unexpected packet %d instead of CONNACK", typ)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("broker refused connection (code %d)", body[1])
+	}
+
+	if mc.CommandTopic == "" {
+		return nil
+	}
+	sub := binary.BigEndian.AppendUint16(nil, 1)
+	sub = append(append(sub, mqttString(mc.CommandTopic)...), 0)
+	if _, err := conn.Write(mqttPacket(mqttSubscribe<<4|0x02, sub)); err != nil {
+		return err
+	}
+	typ, body, err = mqttReadPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != mqttSuback || len(body) < 3 || body[2] == 0x80 {
+		return fmt.Errorf("broker refused subscription to %s", mc.CommandTopic)
+	}
+	return nil
+}
+
+// mqttRead handles incoming packets until the connection fails.
+func (d *Daemon) mqttRead(ctx context.Context, r *bufio.Reader, mc mqttConfig) error {
+	for {
+		typ, body, err := mqttReadPacket(r)
+		if err != nil {
+			return err
+		}
+		if typ != mqttPublish || len(body) < 2 {
+			continue
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+n {
+			return errors.New("malformed PUBLISH")
+		}
+		if topic := string(body[2 : 2+n]); topic != mc.CommandTopic {
+			continue
+		}
+		// Commands are subscribed to at QoS 0, so no packet id follows.
+		msg := strings.TrimSpace(string(body[2+n:]))
+		infof(ctx, "mqtt: command %q", msg)
+		c := command{profile: msg, cycle: msg == "cycle"}
+		if name, err := d.send(ctx, c); err != nil {
+			logf(ctx, "mqtt: %s: %v", msg, err)
+		} else {
+			debugf(ctx, "mqtt: applied %s", name)
+		}
+	}
+}
+
+// mqttPacket assembles a packet from its first header byte and parts.
+func mqttPacket(first byte, parts ...[]byte) []byte {
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	pkt := []byte{first}
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	for _, p := range parts {
+		pkt = append(pkt, p...)
+	}
+	return pkt
+}
+
+// mqttString encodes a length-prefixed UTF-8 string.
+func mqttString(s string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(s))), s...)
+}
+
+// mqttReadPacket reads one packet, returning its type and body.
+func mqttReadPacket(r *bufio.Reader) (byte, []byte, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, shift := 0, 0
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return 0, nil, errors.New("malformed packet length")
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return first >> 4, body, nil
+}
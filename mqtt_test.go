@@ -0,0 +1,117 @@
+package randr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net"
+	"testing"
+)
+
+func TestMQTTPacket(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		n      int
+		header string
+	}{
+		{"empty", 0, "c000"},
+		{"one length byte", 127, "c07f"},
+		{"two length bytes", 128, "c08001"},
+		{"two length bytes, full", 16383, "c0ff7f"},
+		{"three length bytes", 16384, "c0808001"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte{'x'}, tc.n)
+			pkt := mqttPacket(mqttPingreq<<4, body[:tc.n/2], body[tc.n/2:])
+			if got := hex.EncodeToString(pkt[:len(pkt)-tc.n]); got != tc.header {
+				t.Errorf("header %s, want %s", got, tc.header)
+			}
+			typ, got, err := mqttReadPacket(bufio.NewReader(bytes.NewReader(pkt)))
+			if err != nil || typ != mqttPingreq || !bytes.Equal(got, body) {
+				t.Errorf("read back type %d, %d bytes, %v", typ, len(got), err)
+			}
+		})
+	}
+}
+
+func TestMQTTReadPacketErrors(t *testing.T) {
+	for _, tc := range []struct{ name, pkt string }{
+		{"empty", ""},
+		{"no length", "30"},
+		{"length too long", "30ffffffff01"},
+		{"short body", "3005000174"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pkt, _ := hex.DecodeString(tc.pkt)
+			if _, _, err := mqttReadPacket(bufio.NewReader(bytes.NewReader(pkt))); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestMQTTString(t *testing.T) {
+	if got := hex.EncodeToString(mqttString("MQTT")); got != "00044d515454" {
+		t.Errorf("got %s", got)
+	}
+	if got := hex.EncodeToString(mqttString("")); got != "0000" {
+		t.Errorf("empty: got %s", got)
+	}
+}
+
+func TestMQTTHandshake(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		mc      mqttConfig
+		connect string
+		suback  string
+		err     string
+	}{
+		{"anonymous", mqttConfig{ClientID: "desk"},
+			"00044d5154540402003c" + "00046465736b", "", ""},
+		{"credentials", mqttConfig{ClientID: "desk", Username: "u", Password: "pw"},
+			"00044d51545404c2003c" + "00046465736b" + "000175" + "00027077", "", ""},
+		{"subscribed", mqttConfig{ClientID: "desk", CommandTopic: "randr/set"},
+			"00044d5154540402003c" + "00046465736b", "000100", ""},
+		{"subscription refused", mqttConfig{ClientID: "desk", CommandTopic: "randr/set"},
+			"00044d5154540402003c" + "00046465736b", "000180", "refused subscription"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, broker := net.Pipe()
+			defer client.Close()
+			done := make(chan string, 2)
+			go func() {
+				defer broker.Close()
+				r := bufio.NewReader(broker)
+				typ, body, err := mqttReadPacket(r)
+				if err != nil || typ != mqttConnect {
+					done <- "no CONNECT"
+					return
+				}
+				done <- hex.EncodeToString(body)
+				broker.Write(mqttPacket(mqttConnack<<4, []byte{0, 0}))
+				if typ, body, err = mqttReadPacket(r); err == nil && typ == mqttSubscribe {
+					done <- hex.EncodeToString(body)
+					suback, _ := hex.DecodeString(tc.suback)
+					broker.Write(mqttPacket(mqttSuback<<4, suback))
+				}
+			}()
+			err := mqttHandshake(context.Background(), client, bufio.NewReader(client), tc.mc)
+			if got := <-done; got != tc.connect {
+				t.Errorf("CONNECT %s\nwant    %s", got, tc.connect)
+			}
+			if tc.mc.CommandTopic != "" {
+				if got, want := <-done, "0001"+hex.EncodeToString(mqttString(tc.mc.CommandTopic))+"00"; got != want {
+					t.Errorf("SUBSCRIBE %s, want %s", got, want)
+				}
+			}
+			if tc.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.err != "" && (err == nil || !bytes.Contains([]byte(err.Error()), []byte(tc.err))) {
+				t.Errorf("error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
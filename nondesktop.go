@@ -0,0 +1,20 @@
+package randr
+
+import "context"
+
+// hideNonDesktop has the outputs the kernel marks non-desktop, such as VR
+// headsets, count as disconnected, so the desktop is never mirrored or
+// extended onto them. Whatever drives them, typically through a RandR lease,
+// is left alone: they are not seen as lit either.
+func hideNonDesktop(ctx context.Context, outputs []output) {
+	if settingsFrom(ctx).useNonDesktop {
+		return
+	}
+	for i := range outputs {
+		o := &outputs[i]
+		if o.NonDesktop && (o.Connected || o.CRTC) {
+			tracef(ctx, "%s is non-desktop, ignoring it", o.Name)
+			o.Connected, o.CRTC = false, false
+		}
+	}
+}
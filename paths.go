@@ -0,0 +1,72 @@
+package randr
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+// paths locates randr's files following the XDG base directory spec:
+//
+//	$XDG_CONFIG_HOME/randr/config.json     configuration
+//	$XDG_CONFIG_HOME/randr/profiles/*.json one profile per file
+//	$XDG_DATA_HOME/randr/learned.json      learned layouts
+//	$XDG_DATA_HOME/randr/choices.json      layouts picked from notifications
+//	$XDG_STATE_HOME/randr/state.json       daemon state
+//	$XDG_STATE_HOME/randr/windows.json     window placements
+//	$XDG_STATE_HOME/randr/history.jsonl    event history
+//	$XDG_RUNTIME_DIR/randr/randr.sock      control socket
+type paths struct {
+	Config   string
+	Profiles string
+	Data     string
+	State    string
+	Runtime  string
+}
+
+// xdgDir returns the XDG directory from env, or fallback under $HOME.
+func xdgDir(env string, fallback ...string) string {
+	if dir := os.Getenv(env); dir != "" && filepath.IsAbs(dir) {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/"
+	}
+	return filepath.Join(append([]string{home}, fallback...)...)
+}
+
+func defaultPaths() paths {
+	config := filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), "randr")
+	runtime := os.Getenv("XDG_RUNTIME_DIR")
+	if runtime == "" {
+		runtime = os.TempDir()
+	}
+	p := paths{
+		Config:   filepath.Join(config, "config.json"),
+		Profiles: filepath.Join(config, "profiles"),
+		Data:     filepath.Join(xdgDir("XDG_DATA_HOME", ".local", "share"), "randr"),
+		State:    filepath.Join(xdgDir("XDG_STATE_HOME", ".local", "state"), "randr"),
+		Runtime:  filepath.Join(runtime, "randr"),
+	}
+	if c := os.Getenv("RANDR_CONFIG"); c != "" {
+		p.Config = c
+	}
+	return p
+}
+
+// registerFlags adds the path override flags to fs.
+func (p *paths) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&p.Config, "config", p.Config, "config file")
+	fs.StringVar(&p.Profiles, "profiles-dir", p.Profiles, "directory of profile files")
+	fs.StringVar(&p.Data, "data-dir", p.Data, "directory for learned layouts")
+	fs.StringVar(&p.State, "state-dir", p.State, "directory for daemon state")
+	fs.StringVar(&p.Runtime, "runtime-dir", p.Runtime, "directory for runtime files")
+}
+
+func (p paths) learned() string { return filepath.Join(p.Data, "learned.json") }
+func (p paths) choices() string { return filepath.Join(p.Data, "choices.json") }
+func (p paths) state() string   { return filepath.Join(p.State, "state.json") }
+func (p paths) windows() string { return filepath.Join(p.State, "windows.json") }
+func (p paths) history() string { return filepath.Join(p.State, "history.jsonl") }
+func (p paths) socket() string  { return filepath.Join(p.Runtime, "randr.sock") }
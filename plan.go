@@ -0,0 +1,580 @@
+package randr
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+)
+
+// change is the transition of a single output from its current state to the
+// desired one.
+type change struct {
+	To outputConfig
+	// From is the output's current state; Known is false when the output
+	// is not connected.
+	From  outputState
+	Known bool
+	// props are the output's current property values.
+	props map[string]string
+	// audio is set when the output carries sound to its monitor.
+	audio bool
+	// rates is the refresh rate tolerance the change is checked with.
+	rates tolerance
+}
+
+func (c change) noop() bool {
+	if !c.Known || !c.To.satisfiedBy(c.From, c.rates) {
+		return false
+	}
+	// A link that went bad is retrained by setting its mode again.
+	if !c.To.Off && c.props["link-status"] == "Bad" {
+		return false
+	}
+	for name, v := range c.To.Props {
+		if c.props[name] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// plan is the outcome of a planning decision: the desired layout and how it
+// differs from the current state.
+type plan struct {
+	Reason string
+	// Profile is the profile the layout comes from, if any.
+	Profile string
+	Changes []change
+	// Size is the framebuffer size the resulting layout needs.
+	Size resolution
+	// scaled is set when any output ends up scaled.
+	scaled bool
+	// resize sets the framebuffer to the layout's size even if nothing is
+	// scaled, as the server does not shrink it after a monitor is gone.
+	resize bool
+	// presentation is set when the layout mirrors onto a projector.
+	presentation bool
+	// unused switch off the lit outputs the layout does not name.
+	unused []change
+	// invalid are the problems with the layout that keep it from being
+	// applied: modes the outputs lack, outputs overlapping each other.
+	invalid []error
+	// outputs are the outputs the plan was made for; before is the layout
+	// they are in, which undoes the plan, and monitors the fingerprint of
+	// the connected ones.
+	outputs  []output
+	before   layout
+	monitors string
+}
+
+// newPlan compares the desired layout against the outputs' current state.
+// Televisions that are switched on get their TV properties along, the
+// internal panel is kept off when docked if so configured, and the pinned
+// primary output is made primary.
+func newPlan(ctx context.Context, reason string, l layout, outputs []output) *plan {
+	l = pinPrimary(ctx, keepInternalOff(ctx, l, outputs), outputs)
+	cur := currentLayout(outputs)
+	for _, o := range outputs {
+		// A disconnected output without a CRTC is as off as it gets.
+		if !o.Connected && !o.CRTC {
+			cur[o.Name] = outputState{Off: true}
+		}
+	}
+	p := &plan{Reason: reason, outputs: outputs, before: snapshotLayout(outputs),
+		monitors: fingerprint(connectedOutputs(outputs))}
+	final := maps.Clone(cur)
+	rates := settingsFrom(ctx).rates
+	for _, c := range l {
+		st, ok := cur[c.Name]
+		ch := change{To: c, From: st, Known: ok, rates: rates}
+		if i := slices.IndexFunc(outputs, func(o output) bool { return o.Name == c.Name }); i >= 0 {
+			ch.props = outputs[i].Props
+			ch.audio = outputs[i].audioSink()
+			ch.To.addMode = !c.Off && slices.Contains(outputs[i].added, c.Mode)
+			if err := outputs[i].checkMode(c, rates); err != nil {
+				p.invalid = append(p.invalid, err)
+			}
+			switch {
+			case c.Off:
+			case c.Rate == 0:
+				ch.To.Rate = outputs[i].rate(c.Mode, rates)
+			default:
+				// A pinned rate is asked of xrandr as the output
+				// reports it, not as it was saved.
+				if rate, ok := outputs[i].matchRate(c.Mode, c.Rate, rates); ok {
+					ch.To.Rate = rate
+				}
+			}
+			if props := tvProps(ctx, outputs[i]); !c.Off && c.Props == nil && props != nil {
+				ch.To.Props = props
+			}
+		}
+		p.Changes = append(p.Changes, ch)
+		final[c.Name] = c.outputState
+	}
+	for _, name := range slices.Sorted(maps.Keys(cur)) {
+		if st := cur[name]; !st.Off && !slices.ContainsFunc(l, func(c outputConfig) bool { return c.Name == name }) {
+			p.unused = append(p.unused, change{To: outputConfig{Name: name, outputState: outputState{Off: true}}, From: st, Known: true})
+		}
+	}
+	for _, st := range final {
+		if !st.Off {
+			p.Size.W = max(p.Size.W, st.X+st.size().W)
+			p.Size.H = max(p.Size.H, st.Y+st.size().H)
+		}
+		if st.ScaleFrom != (resolution{}) {
+			p.scaled = true
+		}
+	}
+	return p
+}
+
+// checkMode reports a mode or refresh rate the configuration asks of the
+// connected output that it does not have, naming the closest one it has.
+func (o output) checkMode(c outputConfig, t tolerance) error {
+	if !o.Connected || c.Off || len(o.Resolutions) == 0 {
+		return nil
+	}
+	i := slices.Index(o.Resolutions, c.Mode)
+	if i < 0 {
+		closest := slices.MinFunc(o.Resolutions, func(a, b resolution) int {
+			dist := func(r resolution) int { return (r.W-c.Mode.W)*(r.W-c.Mode.W) + (r.H-c.Mode.H)*(r.H-c.Mode.H) }
+			return dist(a) - dist(b)
+		})
+		return fmt.Errorf("%s has no mode %s; the closest is %s", o.Name, c.Mode, closest)
+	}
+	if c.Rate == 0 || i >= len(o.Rates) || len(o.Rates[i]) == 0 {
+		return nil
+	}
+	closest, ok := o.matchRate(c.Mode, c.Rate, t)
+	if ok {
+		return nil
+	}
+	return fmt.Errorf("%s has no %s mode at %gHz; the closest is %s@%g", o.Name, c.Mode, c.Rate, c.Mode, closest)
+}
+
+// matchRate returns the output's refresh rate for the resolution closest to
+// rate, and whether it is within the tolerance.
+func (o output) matchRate(res resolution, rate float64, t tolerance) (float64, bool) {
+	i := slices.Index(o.Resolutions, res)
+	if i < 0 || i >= len(o.Rates) || len(o.Rates[i]) == 0 {
+		return 0, false
+	}
+	closest := slices.MinFunc(o.Rates[i], func(a, b float64) int {
+		return cmp.Compare(math.Abs(a-rate), math.Abs(b-rate))
+	})
+	return closest, t.sameRate(closest, rate)
+}
+
+// args returns the xrandr arguments for the outputs that change. Scaled
+// layouts get an explicit framebuffer size, as xrandr often sizes it from
+// the unscaled modes and crops the picture, as do restored baselines.
+func (p *plan) args() []string {
+	var args []string
+	if p.scaled || p.resize {
+		args = append(args, "--fb", p.Size.String())
+	}
+	return append(args, p.delta().args()...)
+}
+
+// validate checks that the outputs have the modes the layout asks for and
+// that it fits in the screen's maximum framebuffer, which xrandr would
+// otherwise reject with a cryptic error.
+func (p *plan) validate(scr screen) error {
+	if len(p.invalid) > 0 {
+		return errors.Join(p.invalid...)
+	}
+	if scr.Max.W == 0 || (p.Size.W <= scr.Max.W && p.Size.H <= scr.Max.H) {
+		return nil
+	}
+	scale := min(float64(scr.Max.W)/float64(p.Size.W), float64(scr.Max.H)/float64(p.Size.H))
+	return fmt.Errorf("layout needs a %s framebuffer but the maximum is %s; "+
+		"scale the outputs by %.2f or pick lower modes to fit",
+		p.Size, scr.Max, math.Floor(scale*100)/100)
+}
+
+// off extends the plan to switch off the named outputs that are no longer
+// connected but still hold a CRTC, so they go in the same xrandr call.
+func (p *plan) off(outputs []output, names []string) *plan {
+	for _, name := range names {
+		for _, o := range outputs {
+			if o.Name == name && !o.Connected && o.CRTC {
+				p.Changes = append(p.Changes, change{To: outputConfig{Name: name, outputState: outputState{Off: true}}})
+			}
+		}
+	}
+	return p
+}
+
+// zombieOutputs names the outputs that are disconnected but still hold a
+// CRTC.
+func zombieOutputs(outputs []output) []string {
+	var names []string
+	for _, o := range outputs {
+		if !o.Connected && o.CRTC {
+			names = append(names, o.Name)
+		}
+	}
+	return names
+}
+
+// layout returns the full desired layout.
+func (p *plan) layout() layout {
+	var l layout
+	for _, c := range p.Changes {
+		l = append(l, c.To)
+	}
+	return l
+}
+
+// delta returns the layout of the outputs that actually change.
+func (p *plan) delta() layout {
+	var d layout
+	for _, c := range p.Changes {
+		if !c.noop() {
+			to := c.To
+			to.resetScale = c.From.ScaleFrom != (resolution{}) && to.ScaleFrom == (resolution{})
+			d = append(d, to)
+		}
+	}
+	return d
+}
+
+// String renders the plan as a diff of current against desired state.
+func (p *plan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", p.Reason)
+	if len(p.Changes) == 0 {
+		b.WriteString("  no outputs to configure\n")
+	}
+	for _, c := range p.Changes {
+		from := "disconnected"
+		if c.Known {
+			from = c.From.String()
+		}
+		if c.noop() {
+			fmt.Fprintf(&b, "  %-10s %s (unchanged)\n", c.To.Name, from)
+		} else {
+			to := c.To.outputState.String()
+			for _, name := range slices.Sorted(maps.Keys(c.To.Props)) {
+				to += fmt.Sprintf(" %s=%s", name, c.To.Props[name])
+			}
+			fmt.Fprintf(&b, "  %-10s %s -> %s\n", c.To.Name, from, to)
+		}
+	}
+	return b.String()
+}
+
+func (s outputState) String() string {
+	if s.Off {
+		return "off"
+	}
+	str := fmt.Sprintf("%s+%d+%d", s.Mode, s.X, s.Y)
+	if s.Rate > 0 {
+		str += fmt.Sprintf(" at %.2fHz", s.Rate)
+	}
+	if s.ScaleFrom != (resolution{}) {
+		str += " scaled from " + s.ScaleFrom.String()
+	}
+	if s.Rotation != "" && s.Rotation != "normal" {
+		str += " rotated " + s.Rotation
+	}
+	if s.Primary {
+		str += " primary"
+	}
+	return str
+}
+
+// planner decides which layout the connected outputs should have.
+type planner struct {
+	cfg *config
+	// baseline is the internal panels' configuration from before randr
+	// changed anything, restored once the externals are gone.
+	baseline layout
+	// checks holds the outcome of the check commands run by the last
+	// planning pass.
+	checks *checks
+}
+
+// begin starts a planning pass: check commands run again, bounded by ctx.
+func (pl *planner) begin(ctx context.Context) *checks {
+	pl.checks = newChecks(ctx)
+	return pl.checks
+}
+
+// connected plans the layout for the connected outputs: the layout script's
+// choice, if there is a script, else the matching profile. When none
+// matches, a connected projector is mirrored onto, and otherwise the default
+// profile applies.
+func (pl *planner) connected(ctx context.Context, outputs []output) *plan {
+	pl.begin(ctx)
+	return pl.match(ctx, outputs)
+}
+
+// match is connected within a planning pass already begun.
+func (pl *planner) match(ctx context.Context, outputs []output) *plan {
+	if p := pl.scripted(ctx, outputs); p != nil {
+		return p
+	}
+	p := matchProfile(ctx, pl.cfg, connectedOutputs(outputs), pl.checks)
+	if p == nil {
+		if pn := pl.presentation(ctx, outputs); pn != nil {
+			return pn
+		}
+		p = pl.cfg.defaultProfile()
+	}
+	return pl.profile(ctx, p, outputs)
+}
+
+// matchedProfile names the profile for the connected outputs, reporting
+// whether it is the default because none matches.
+func matchedProfile(ctx context.Context, cfg *config, outputs []output, ck *checks) (string, bool) {
+	if p := matchProfile(ctx, cfg, connectedOutputs(outputs), ck); p != nil {
+		return p.Name, false
+	}
+	return cfg.defaultProfile().Name, true
+}
+
+// errNoCycle is returned by nextProfile when there is nothing to cycle
+// through.
+var errNoCycle = errors.New("no profile to cycle to")
+
+// nextProfile returns the profile after current among those applicable to
+// the connected outputs, wrapping around. With fewer than two applicable
+// profiles the built-in mirror and extend layouts join the rotation, unless
+// profiles of the config take their names.
+func nextProfile(ctx context.Context, cfg *config, outputs []output, current string, ck *checks) (*profile, error) {
+	connected := connectedOutputs(outputs)
+	var candidates []*profile
+	for i := range cfg.Profiles {
+		if p := &cfg.Profiles[i]; p.matches(ctx, connected, ck) {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) < 2 {
+		for _, layout := range []string{layoutMirror, layoutExtend} {
+			if cfg.profile(layout) == nil {
+				candidates = append(candidates, cfg.lookup(layout))
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errNoCycle
+	}
+	for i, p := range candidates {
+		if p.Name == current {
+			return candidates[(i+1)%len(candidates)], nil
+		}
+	}
+	return candidates[0], nil
+}
+
+// profile plans applying the given profile to the connected outputs.
+func (pl *planner) profile(ctx context.Context, p *profile, outputs []output) *plan {
+	pn := newPlan(ctx, fmt.Sprintf("profile %q", p.Name), p.layout(ctx, outputs), outputs)
+	pn.Profile = p.Name
+	if p.Layout == layoutFixed {
+		pn.invalid = append(pn.invalid, pn.layout().overlaps()...)
+	}
+	return pn
+}
+
+// The disconnect settings that are not profile names.
+const (
+	disconnectRestore = "restore"
+	disconnectReplan  = "replan"
+	disconnectNone    = "none"
+)
+
+// disconnected plans the layout after monitors were disconnected, as the
+// config's disconnect setting says.
+func (pl *planner) disconnected(ctx context.Context, outputs []output, removed []string) *plan {
+	pl.begin(ctx)
+	switch d := pl.cfg.Disconnect; d {
+	case "", disconnectRestore:
+		return pl.restore(ctx, outputs, removed)
+	case disconnectReplan:
+		return pl.match(ctx, outputs).off(outputs, removed)
+	case disconnectNone:
+		return pl.keep(ctx, outputs, removed)
+	default:
+		p := pl.cfg.lookup(d)
+		if p == nil {
+			return pl.restore(ctx, outputs, removed)
+		}
+		return pl.profile(ctx, p, outputs).off(outputs, removed)
+	}
+}
+
+// keep plans switching the removed outputs off and leaving the remaining
+// active outputs as they are, but shifted so the layout starts at 0x0.
+func (pl *planner) keep(ctx context.Context, outputs []output, removed []string) *plan {
+	cur := currentLayout(outputs)
+	var l layout
+	for _, o := range connectedOutputs(outputs) {
+		if st := cur[o.Name]; !st.Off {
+			l = append(l, outputConfig{Name: o.Name, outputState: st})
+		}
+	}
+	l.toOrigin()
+	return newPlan(ctx, "keep the remaining outputs", l, outputs).off(outputs, removed)
+}
+
+// toOrigin shifts the layout's outputs so the layout starts at 0x0 again.
+func (l layout) toOrigin() {
+	minX, minY := 0, 0
+	first := true
+	for _, c := range l {
+		if c.Off {
+			continue
+		}
+		if first || c.X < minX {
+			minX = c.X
+		}
+		if first || c.Y < minY {
+			minY = c.Y
+		}
+		first = false
+	}
+	for i := range l {
+		if !l[i].Off {
+			l[i].X -= minX
+			l[i].Y -= minY
+		}
+	}
+}
+
+// restore plans the layout after monitors were disconnected, as a single
+// xrandr call: the primary output (or the first connected one if none is
+// primary) goes back to its preferred mode, the remaining active outputs are
+// shifted so the layout starts at 0x0 again, and the removed outputs are
+// switched off. A layout script or a rule that holds, if any, decides
+// instead.
+func (pl *planner) restore(ctx context.Context, outputs []output, removed []string) *plan {
+	if p := pl.scripted(ctx, outputs); p != nil {
+		return p.off(outputs, removed)
+	}
+	if r := matchRule(ctx, pl.cfg, connectedOutputs(outputs), pl.checks); r != nil {
+		return pl.profile(ctx, r, outputs).off(outputs, removed)
+	}
+	if p := pl.restoreBaseline(ctx, outputs); p != nil {
+		return p.off(outputs, removed)
+	}
+	connected := connectedOutputs(outputs)
+	primary := -1
+	for i, o := range connected {
+		if o.Primary {
+			primary = i
+			break
+		}
+	}
+	if primary < 0 && len(connected) > 0 {
+		primary = 0
+	}
+	if primary < 0 {
+		return (&plan{Reason: "restore: no connected outputs"}).off(outputs, removed)
+	}
+
+	var l layout
+	for i, o := range connected {
+		st := outputState{X: o.X, Y: o.Y, Rotation: o.rotate}
+		switch {
+		case i == primary:
+			res, ok := o.preferred()
+			if !ok {
+				continue
+			}
+			st.Mode, st.Primary = res, true
+		case o.active():
+			st.Mode = o.Resolutions[o.Current]
+		default:
+			continue
+		}
+		l = append(l, outputConfig{Name: o.Name, outputState: st})
+	}
+
+	l.toOrigin()
+
+	p := connected[primary]
+	res, _ := p.preferred()
+	return newPlan(ctx, fmt.Sprintf("restore %s to preferred %s", p.Name, res), l, outputs).off(outputs, removed)
+}
+
+// restoreBaseline plans going back to the baseline once no external output
+// is connected: the internal panels get their mode, position, rotation,
+// scaling and primary flag back, and the framebuffer shrinks to fit. It
+// returns nil if there is no baseline for the connected panels or one of
+// them lacks its mode now.
+func (pl *planner) restoreBaseline(ctx context.Context, outputs []output) *plan {
+	connected := connectedOutputs(outputs)
+	if len(pl.baseline) == 0 || slices.ContainsFunc(connected, func(o output) bool { return !o.internal() }) {
+		return nil
+	}
+	var l layout
+	for _, c := range pl.baseline {
+		if slices.ContainsFunc(connected, func(o output) bool { return o.Name == c.Name }) {
+			c.Rotation = cmp.Or(c.Rotation, "normal")
+			l = append(l, c)
+		}
+	}
+	if len(l) == 0 {
+		return nil
+	}
+	l.toOrigin()
+	p := newPlan(ctx, "restore the baseline", l, outputs)
+	if len(p.invalid) > 0 {
+		debugf(ctx, "baseline: %v", errors.Join(p.invalid...))
+		return nil
+	}
+	p.resize = true
+	return p
+}
+
+// baseline returns the configuration of the lit outputs if they are all
+// internal panels, to restore once externals come and go, or nil.
+func baseline(outputs []output) layout {
+	var l layout
+	for name, st := range currentLayout(outputs) {
+		if st.Off {
+			continue
+		}
+		if !(output{Name: name}).internal() {
+			return nil
+		}
+		l = append(l, outputConfig{Name: name, outputState: st})
+	}
+	slices.SortFunc(l, func(a, b outputConfig) int { return cmp.Compare(a.Name, b.Name) })
+	return l
+}
+
+// executor carries out plans.
+type executor interface {
+	apply(ctx context.Context, p *plan) error
+}
+
+// xrandrExecutor applies plans with a single xrandr call that only names the
+// outputs that change, as redundant mode sets make screens flicker and
+// compositors reset.
+type xrandrExecutor struct {
+	backend Backend
+}
+
+func (e xrandrExecutor) apply(ctx context.Context, p *plan) error {
+	if len(p.delta()) == 0 {
+		infof(ctx, "layout already active, nothing to do")
+		return nil
+	}
+	ctx, sp := startSpan(ctx, "configure")
+	sp.set("args", strings.Join(p.args(), " "))
+	err := p.addModes(ctx, e.backend)
+	if err == nil {
+		err = e.backend.Configure(ctx, p.args())
+	}
+	sp.finish(err)
+	return err
+}
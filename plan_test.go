@@ -0,0 +1,269 @@
+package randr
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readQuery parses the xrandr query output saved in testdata.
+func readQuery(t *testing.T, name string) ([]output, screen) {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parseQuery(context.Background(), data)
+}
+
+func TestPlanArgs(t *testing.T) {
+	// The laptop panel is lit at 1920x1080, the Dell connected but dark.
+	docked, _ := readQuery(t, "dock.txt")
+	// The panel scaled from 2560x1440, as a mirror leaves it.
+	scaled, _ := readQuery(t, "dock.txt")
+	scaled[0].Geometry = resolution{2560, 1440}
+
+	at := func(name string, w, h, x, y int) outputConfig {
+		return outputConfig{Name: name, outputState: outputState{Mode: resolution{w, h}, X: x, Y: y}}
+	}
+	primary := func(c outputConfig) outputConfig { c.Primary = true; return c }
+	off := func(name string) outputConfig { return outputConfig{Name: name, outputState: outputState{Off: true}} }
+
+	for _, tc := range []struct {
+		name    string
+		outputs []output
+		l       layout
+		want    string
+		size    resolution
+		unused  string
+	}{
+		{"already active", docked, layout{primary(at("eDP-1", 1920, 1080, 0, 0))},
+			"", resolution{1920, 1080}, ""},
+		{"extend right", docked, layout{at("eDP-1", 1920, 1080, 0, 0), at("HDMI-1", 2560, 1440, 1920, 0)},
+			"--output HDMI-1 --mode 2560x1440 --pos 1920x0", resolution{4480, 1440}, ""},
+		{"external only", docked, layout{off("eDP-1"), primary(at("HDMI-1", 2560, 1440, 0, 0))},
+			"--output eDP-1 --off --output HDMI-1 --mode 2560x1440 --pos 0x0 --primary", resolution{2560, 1440}, ""},
+		{"panel left lit", docked, layout{primary(at("HDMI-1", 2560, 1440, 1920, 0))},
+			"--output HDMI-1 --mode 2560x1440 --pos 1920x0 --primary", resolution{4480, 1440}, "eDP-1"},
+		{"pinned rate", docked, layout{{Name: "HDMI-1", outputState: outputState{Mode: resolution{1920, 1080}, X: 1920, Rate: 59.9}}},
+			"--output HDMI-1 --mode 1920x1080 --pos 1920x0 --rate 59.94", resolution{3840, 1080}, "eDP-1"},
+		{"rotated", docked, layout{{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920, Rotation: "left"}}},
+			"--output HDMI-1 --mode 2560x1440 --pos 1920x0 --rotate left", resolution{3360, 2560}, "eDP-1"},
+		{"mirror scaled", docked, layout{
+			primary(at("eDP-1", 1920, 1080, 0, 0)),
+			{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, ScaleFrom: resolution{1920, 1080}}},
+		}, "--fb 1920x1080 --output HDMI-1 --mode 2560x1440 --pos 0x0 --scale-from 1920x1080", resolution{1920, 1080}, ""},
+		{"scale undone", scaled, layout{primary(at("eDP-1", 1920, 1080, 0, 0))},
+			"--output eDP-1 --mode 1920x1080 --pos 0x0 --scale 1x1 --primary", resolution{1920, 1080}, ""},
+		{"props", docked, layout{{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920},
+			Props: map[string]string{"underscan": "off", "Broadcast RGB": "Full"}}},
+			"--output HDMI-1 --mode 2560x1440 --pos 1920x0 --set Broadcast RGB Full --set underscan off", resolution{4480, 1440}, "eDP-1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newPlan(context.Background(), tc.name, tc.l, tc.outputs)
+			if err := p.validate(screen{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(p.args(), " "); got != tc.want {
+				t.Errorf("args %q\nwant %q", got, tc.want)
+			}
+			if p.Size != tc.size {
+				t.Errorf("framebuffer %s, want %s", p.Size, tc.size)
+			}
+			var unused []string
+			for _, c := range p.unused {
+				unused = append(unused, c.To.Name)
+			}
+			if got := strings.Join(unused, " "); got != tc.unused {
+				t.Errorf("unused %q, want %q", got, tc.unused)
+			}
+		})
+	}
+}
+
+func TestPlanDelta(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	outputs[0].Props["link-status"] = "Bad"
+	l := layout{
+		{Name: "eDP-1", outputState: outputState{Mode: resolution{1920, 1080}, Primary: true}},
+		{Name: "HDMI-1", outputState: outputState{Off: true}},
+		{Name: "DP-1", outputState: outputState{Off: true}},
+	}
+	p := newPlan(context.Background(), "retrain", l, outputs)
+	// The panel's bad link is retrained, the dark Dell and the unplugged
+	// DP-1 are as off as asked.
+	var names []string
+	for _, c := range p.delta() {
+		names = append(names, c.Name)
+	}
+	if got := strings.Join(names, " "); got != "eDP-1" {
+		t.Errorf("delta %q, want only eDP-1", got)
+	}
+	if s := p.String(); !strings.Contains(s, "HDMI-1     off (unchanged)") || !strings.Contains(s, "DP-1       off (unchanged)") {
+		t.Errorf("plan:\n%s", s)
+	}
+}
+
+func TestPlanValidate(t *testing.T) {
+	outputs, scr := readQuery(t, "dock.txt")
+	for _, tc := range []struct {
+		name string
+		c    outputConfig
+		max  resolution
+		err  string
+	}{
+		{"fits", outputConfig{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920}}, scr.Max, ""},
+		{"no such mode", outputConfig{Name: "HDMI-1", outputState: outputState{Mode: resolution{3840, 2160}, X: 1920}}, scr.Max,
+			"HDMI-1 has no mode 3840x2160; the closest is 2560x1440"},
+		{"no such rate", outputConfig{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920, Rate: 144}}, scr.Max,
+			"HDMI-1 has no 2560x1440 mode at 144Hz; the closest is 2560x1440@59.95"},
+		{"limits unknown", outputConfig{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920}}, resolution{}, ""},
+		{"too big", outputConfig{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920}}, resolution{4096, 4096},
+			"layout needs a 4480x1440 framebuffer but the maximum is 4096x4096; scale the outputs by 0.91"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newPlan(context.Background(), tc.name, layout{tc.c}, outputs)
+			err := p.validate(screen{Max: tc.max})
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("error %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestNextProfile(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	desk := profile{Name: "desk", Outputs: []string{"eDP-1", "HDMI-1"}}
+	couch := profile{Name: "couch", Outputs: []string{"eDP-1", "DEL-*"}}
+	away := profile{Name: "away", Outputs: []string{"eDP-1", "DP-1"}}
+	for _, tc := range []struct {
+		name     string
+		profiles []profile
+		current  string
+		want     string
+	}{
+		{"first", []profile{desk, couch, away}, "", "desk"},
+		{"next", []profile{desk, couch, away}, "desk", "couch"},
+		{"wrapped", []profile{desk, couch, away}, "couch", "desk"},
+		{"built-ins joined", []profile{desk, away}, "desk", "mirror"},
+		{"built-ins only", []profile{away}, "mirror", "extend"},
+		{"current elsewhere", []profile{desk, away}, "away", "desk"},
+		// Profiles named like the built-ins keep them out of the
+		// rotation, and when those do not match either, there is
+		// nothing to cycle to.
+		{"nothing to cycle to", []profile{{Name: layoutMirror, Outputs: []string{"DP-1"}}, {Name: layoutExtend, Outputs: []string{"DP-2"}}}, "", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config{Profiles: tc.profiles}
+			p, err := nextProfile(context.Background(), cfg, outputs, tc.current, nil)
+			if tc.want == "" {
+				if !errors.Is(err, errNoCycle) {
+					t.Errorf("got %v, %v; want errNoCycle", p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Name != tc.want {
+				t.Errorf("got %q, want %q", p.Name, tc.want)
+			}
+		})
+	}
+}
+
+func TestNextRate(t *testing.T) {
+	o := output{Name: "DP-1", Connected: true, Current: 0, Rate: 60,
+		Resolutions: []resolution{{2560, 1440}}, Rates: [][]float64{{60, 59.94, 50, 30}}}
+	for _, tc := range []struct {
+		name string
+		cfg  *config
+		rate float64
+		want float64
+	}{
+		{"same within tolerance", &config{}, 60, 50},
+		{"tight tolerance", &config{RateTolerance: 0.01}, 60, 59.94},
+		{"wraps to highest", &config{}, 30, 60},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := withSettings(context.Background(), tc.cfg.settings())
+			o.Rate = tc.rate
+			if got, ok := nextRate(ctx, o); !ok || got != tc.want {
+				t.Errorf("nextRate = %g, %t; want %g", got, ok, tc.want)
+			}
+		})
+	}
+}
+
+// A profile saved at the rates one driver reported still applies when
+// another reports them 0.01Hz off, at the rates the outputs now have.
+func TestPlanRateTolerance(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	l := layout{
+		{Name: "eDP-1", outputState: outputState{Mode: resolution{1920, 1080}, Primary: true, Rate: 60}},
+		{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920, Rate: 59.94}},
+	}
+	p := newPlan(withSettings(context.Background(), (&config{}).settings()), "saved", l, outputs)
+	if err := p.validate(screen{}); err != nil {
+		t.Fatal(err)
+	}
+	want := "--output HDMI-1 --mode 2560x1440 --pos 1920x0 --rate 59.95"
+	if got := strings.Join(p.args(), " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	p = newPlan(withSettings(context.Background(), (&config{RateTolerance: 0.005}).settings()), "saved", l, outputs)
+	if err := p.validate(screen{}); err == nil || !strings.Contains(err.Error(), "HDMI-1 has no 2560x1440 mode at 59.94Hz") {
+		t.Errorf("tight tolerance: got %v", err)
+	}
+	if d := p.delta(); len(d) != 2 {
+		t.Errorf("tight tolerance: %d outputs change, want 2", len(d))
+	}
+}
+
+// A cable swapped between polls shows up as a monitor connected and another
+// disconnected at once; the layout for the new set switches the old one off
+// in the same xrandr call.
+func TestPlanCableSwap(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	// DP-2 was unplugged, but its CRTC still drives it.
+	outputs = append(outputs, output{Name: "DP-2", CRTC: true, Resolutions: []resolution{{1920, 1080}}, X: 1920})
+	pl := &planner{cfg: &config{}}
+	p := pl.connected(context.Background(), outputs).off(outputs, []string{"DP-2"})
+	want := "--output HDMI-1 --mode 1920x1080 --pos 0x0 --output DP-2 --off"
+	if got := strings.Join(p.args(), " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPlanRestore(t *testing.T) {
+	// The Dell was unplugged from the far left, a projector stays lit
+	// right of it, and the panel, pushed to 1280x720, right of that.
+	outputs, _ := readQuery(t, "dock.txt")
+	outputs[0].X, outputs[0].Current, outputs[0].Geometry = 4480, 2, resolution{1280, 720}
+	outputs[1].Connected, outputs[1].CRTC, outputs[1].Current, outputs[1].Geometry = false, true, 0, resolution{2560, 1440}
+	outputs = append(outputs, output{Name: "DP-2", Connected: true, CRTC: true,
+		Resolutions: []resolution{{1920, 1080}}, Geometry: resolution{1920, 1080}, X: 2560})
+
+	for _, tc := range []struct {
+		disconnect string
+		want       string
+	}{
+		{"", "--output eDP-1 --mode 1920x1080 --pos 1920x0 --primary --output DP-2 --mode 1920x1080 --pos 0x0 --output HDMI-1 --off"},
+		{disconnectNone, "--output eDP-1 --mode 1280x720 --pos 1920x0 --rate 60.00 --primary --output DP-2 --mode 1920x1080 --pos 0x0 --output HDMI-1 --off"},
+	} {
+		pl := &planner{cfg: &config{Disconnect: tc.disconnect}}
+		p := pl.disconnected(context.Background(), outputs, []string{"HDMI-1"})
+		if got := strings.Join(p.args(), " "); got != tc.want {
+			t.Errorf("disconnect %q: got %q, want %q", tc.disconnect, got, tc.want)
+		}
+	}
+}
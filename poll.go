@@ -0,0 +1,356 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// poll queries the outputs and acts on what changed since the last poll. It
+// only returns an error when the daemon is to exit, degraded.
+func (l *loop) poll(ctx context.Context) error {
+	l.timer.Reset(settingsFrom(ctx).pollInterval)
+	l.wakeAt = time.Time{}
+
+	// A poll is traced from the query on; only polls that end up applying
+	// a layout are exported.
+	pctx, poll := startSpan(ctx, "poll")
+	if n := drmCards(); n != l.cards {
+		debugf(ctx, "DRM devices: %d -> %d", l.cards, n)
+		l.cards = n
+		l.relink(ctx)
+	}
+	cur, curScr, reused := l.pre.reuse(ctx, time.Now())
+	sum := l.lastSum
+	var err error
+	if reused {
+		tracef(ctx, "connectors unchanged, reusing the last query")
+		poll.set("reused", true)
+	} else {
+		cur, curScr, sum, err = querySum(pctx, l.d.backend)
+		if err == nil {
+			fresh := func(name string) bool { return !l.prevSet[name] && l.modeless[name] == 0 }
+			cur, curScr, err = requeryModeless(pctx, l.d.backend, fresh, cur, curScr)
+		}
+		if err == nil {
+			l.pre.store(ctx, cur, curScr, time.Now())
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		poll.finish(err)
+		l.fail(ctx, err)
+		n := l.bo.fail()
+		switch {
+		case n < l.cfg.maxFailures():
+			logf(ctx, "error: %v (retrying in %s)", err, l.bo.delay())
+		case n == l.cfg.maxFailures():
+			logf(ctx, "degraded: %d consecutive failures, last: %v", n, err)
+			if l.cfg.ExitOnDegraded {
+				return fmt.Errorf("degraded: %w", err)
+			}
+		}
+		l.timer.Reset(l.bo.delay())
+		return nil
+	}
+	if n := l.bo.reset(); n > 0 {
+		infof(ctx, "recovered after %d failure(s)", n)
+	}
+	l.scr = curScr
+	curSet := connectedSet(cur)
+
+	tr := l.triggers(ctx, cur)
+	// The same query output as the last poll's, with nothing else to act
+	// on or wait for, leaves nothing to compare or plan.
+	if sum == l.lastSum && !tr.any() && l.deferred == nil &&
+		!l.manual && l.learn.expected != nil && l.learn.pending == nil && len(l.modeless) == 0 {
+		poll.finish(nil)
+		return nil
+	}
+	l.lastSum = sum
+
+	l.holdModeless(ctx, cur, curSet)
+	hp := l.hotplug(ctx, cur, curSet)
+	l.act(pctx, cur, tr, hp)
+
+	poll.set("connected", len(curSet))
+	poll.set("new", strings.Join(hp.added, ","))
+	poll.set("removed", strings.Join(hp.removed, ","))
+	poll.finish(nil)
+	l.prevSet = curSet
+	l.prev = cur
+	return nil
+}
+
+// triggers are what, besides monitors coming, going or changing, has a poll
+// plan the layout again.
+type triggers struct {
+	// folded is set when a convertible was folded into or out of tablet
+	// mode, rotated when the accelerometer turned the panel, powered when
+	// the power source or a power supply changed, scheduled when a time
+	// window opened or closed, and expired when a temporary layout ran
+	// out.
+	folded, rotated, powered, scheduled, expired bool
+}
+
+func (t triggers) any() bool {
+	return t.folded || t.rotated || t.powered || t.scheduled || t.expired
+}
+
+// triggers finds what happened since the last poll that calls for planning
+// the layout again, rotating the internal panels of cur as the sensors say.
+func (l *loop) triggers(ctx context.Context, cur []output) triggers {
+	var t triggers
+
+	// Folding a convertible over re-evaluates the layout, as profiles and
+	// rules can depend on it.
+	if on, ok := tabletMode(ctx); ok && on != l.tablet {
+		infof(ctx, "tablet mode: %t", on)
+		l.tablet, t.folded = on, true
+		l.rotatePanel(ctx)
+		rotateOutputs(ctx, cur)
+	}
+
+	// So does the accelerometer turning the panel.
+	t.rotated, l.reoriented = l.reoriented, false
+	if t.rotated {
+		rotateOutputs(ctx, cur)
+	}
+
+	// So do switching between mains and battery power, for the power
+	// policies and the rules' ac condition, and power supply events.
+	t.powered, l.supplied = l.supplied, false
+	if online, ok := acState(); ok && online != l.onAC {
+		source := "battery"
+		if online {
+			source = "mains"
+		}
+		infof(ctx, "power: on %s", source)
+		l.onAC, t.powered = online, true
+	}
+
+	// A time window opening or closing, or a new day, switches the layout
+	// on schedule, unless it was arranged by hand.
+	if now := time.Now(); !l.switchAt.IsZero() && !now.Before(l.switchAt) {
+		debugf(ctx, "schedule: %s passed", l.switchAt.Format("15:04"))
+		l.switchAt, t.scheduled = l.cfg.nextSwitch(now), !l.manual
+	}
+
+	// A temporary layout is undone once its time is up, by the wall clock
+	// like the schedules.
+	t.expired = !l.st.Until.IsZero() && !time.Now().Before(l.st.Until)
+	return t
+}
+
+// holdModeless keeps outputs of USB adapters that show up connected before
+// their modes do out of cur and curSet for a few polls, as a mode-less
+// output throws off the common mirror mode.
+func (l *loop) holdModeless(ctx context.Context, cur []output, curSet map[string]bool) {
+	for i := range cur {
+		o := &cur[i]
+		if !o.Connected {
+			continue
+		}
+		switch {
+		case l.prevSet[o.Name] || len(o.Resolutions) > 0:
+			delete(l.modeless, o.Name)
+		case l.modeless[o.Name] < modelessRetries:
+			l.modeless[o.Name]++
+			infof(ctx, "%s connected without modes, waiting", o.Name)
+			o.Connected = false
+			delete(curSet, o.Name)
+		default:
+			logf(ctx, "%s still has no modes", o.Name)
+		}
+	}
+}
+
+// hotplug is how the connected outputs changed since the last poll.
+type hotplug struct {
+	added, removed, altered []string
+	// replan is set when the altered outputs call for planning the
+	// layout again.
+	replan bool
+}
+
+// hotplug compares the outputs with the last poll's and reports each
+// output connected, disconnected or changed to the events, callbacks and
+// feedback.
+func (l *loop) hotplug(ctx context.Context, cur []output, curSet map[string]bool) hotplug {
+	var hp hotplug
+	for name := range curSet {
+		if !l.prevSet[name] {
+			hp.added = append(hp.added, name)
+		}
+	}
+	l.stats.Hotplugs += len(hp.added)
+	if len(hp.added) > 0 {
+		infof(ctx, "new monitor(s) detected: %s", strings.Join(hp.added, ", "))
+	}
+	for _, name := range hp.added {
+		o, _ := findOutput(cur, name)
+		emit(ctx, outputEvent(eventConnected, o))
+		l.cb.run(ctx, eventConnected, o)
+	}
+	if len(hp.added) > 0 {
+		l.cfg.Feedback.run(ctx, "connected", "RANDR_CHANGED_OUTPUTS="+strings.Join(hp.added, " "))
+	}
+
+	for name := range l.prevSet {
+		if !curSet[name] {
+			hp.removed = append(hp.removed, name)
+		}
+	}
+	l.stats.Hotplugs += len(hp.removed)
+	if len(hp.removed) > 0 {
+		infof(ctx, "monitor(s) disconnected: %s", strings.Join(hp.removed, ", "))
+	}
+	for _, name := range hp.removed {
+		o, _ := findOutput(l.prev, name)
+		emit(ctx, outputEvent(eventDisconnected, o))
+		l.cb.run(ctx, eventDisconnected, o)
+	}
+	if len(hp.removed) > 0 {
+		l.cfg.Feedback.run(ctx, "disconnected", "RANDR_CHANGED_OUTPUTS="+strings.Join(hp.removed, " "))
+	}
+
+	// Outputs can change while staying connected: a KVM switch swapping
+	// the monitor, or a link going bad.
+	hp.altered = changedOutputs(l.prev, cur)
+	if len(hp.altered) > 0 {
+		infof(ctx, "monitor(s) changed: %s", strings.Join(hp.altered, ", "))
+	}
+	for _, name := range hp.altered {
+		o, _ := findOutput(cur, name)
+		emit(ctx, outputEvent(eventChanged, o))
+		l.cb.run(ctx, eventChanged, o)
+	}
+	if len(hp.altered) > 0 {
+		l.cfg.Feedback.run(ctx, "changed", "RANDR_CHANGED_OUTPUTS="+strings.Join(hp.altered, " "))
+	}
+	hp.replan = len(hp.altered) > 0 && l.cfg.OutputChanges != outputChangesIgnore
+	return hp
+}
+
+// act applies the layout the hotplug or the first of the triggers calls
+// for, then takes the new baseline: the connected set after a hotplug, or
+// the layout to tell manual changes from.
+func (l *loop) act(ctx context.Context, cur []output, tr triggers, hp hotplug) {
+	st := l.st
+	// A cable swap between polls shows up as both; plan a single target
+	// layout for the new state rather than mirroring and then restoring.
+	// applied is left set when a case applies a layout, which the outputs
+	// queried before it do not show.
+	applied := true
+	switch {
+	case len(hp.added) > 0:
+		l.chosen = ""
+		_, sp := startSpan(ctx, "plan")
+		p := l.pl.connected(ctx, cur).off(cur, hp.removed)
+		// Monitors no profile covers, left to the default profile or the
+		// projector setup rather than a script, get the layout picked for
+		// them before, or a notification asking for one.
+		var unknown []output
+		if _, isDefault := matchedProfile(ctx, l.cfg, cur, l.pl.checks); l.cfg.Ask && isDefault && (p.Profile != "" || p.presentation) {
+			for _, name := range hp.added {
+				if o, _ := findOutput(cur, name); !o.internal() {
+					unknown = append(unknown, o)
+				}
+			}
+		}
+		if c, ok := rememberedChoice(ctx, l.dirs.choices(), unknown); ok {
+			if prof := l.cfg.lookup(c); prof != nil {
+				infof(ctx, "using the %s layout picked before for %s", c, unknown[0].id())
+				p, l.chosen = l.pl.profile(ctx, prof, cur).off(cur, hp.removed), prof.Name
+			}
+			unknown = nil
+		}
+		sp.finish(nil)
+		l.apply(ctx, p, hookEnv{Event: "connected", Changed: append(hp.added, hp.removed...)})
+		if len(unknown) > 0 {
+			go l.d.ask(ctx, l.dirs, unknown)
+		}
+	case len(hp.removed) > 0:
+		l.chosen = ""
+		_, sp := startSpan(ctx, "plan")
+		p := l.pl.disconnected(ctx, cur, hp.removed)
+		sp.finish(nil)
+		l.apply(ctx, p, hookEnv{Event: "disconnected", Changed: hp.removed})
+	case hp.replan:
+		l.chosen = ""
+		l.apply(ctx, l.target(ctx, cur), hookEnv{Event: "changed", Changed: hp.altered})
+	case tr.rotated:
+		l.apply(ctx, l.target(ctx, cur), hookEnv{Event: "rotate"})
+		l.learn.reset()
+		l.manual, st.Paused = false, false
+		l.saveState(ctx)
+	case tr.folded:
+		l.apply(ctx, l.target(ctx, cur), hookEnv{Event: "tablet-mode"})
+		l.learn.reset()
+	case tr.powered:
+		l.apply(ctx, l.target(ctx, cur), hookEnv{Event: "power"})
+		l.learn.reset()
+	case tr.expired:
+		s := st.RevertTo
+		st.Until, st.RevertTo = time.Time{}, nil
+		if s == nil || s.Fingerprint != fingerprint(connectedOutputs(cur)) {
+			infof(ctx, "temporary layout ran out, but the monitors changed since; leaving the layout")
+			l.saveState(ctx)
+			applied = false
+			break
+		}
+		p := newPlan(ctx, "the layout before the temporary one", s.Layout, cur)
+		p.Profile, p.resize = s.Profile, true
+		l.apply(ctx, p, hookEnv{Event: "expire"})
+		l.learn.reset()
+		l.manual, st.Paused = false, false
+		l.saveState(ctx)
+	case tr.scheduled:
+		l.apply(ctx, l.target(ctx, cur), hookEnv{Event: "schedule"})
+		l.learn.reset()
+	case l.deferred != nil && !l.cfg.Locker.locked(ctx):
+		infof(ctx, "screen unlocked")
+		l.apply(ctx, l.target(ctx, cur).off(cur, zombieOutputs(cur)), *l.deferred)
+		l.learn.reset()
+	default:
+		applied = false
+	}
+	l.rebaseline(ctx, cur, hp, applied)
+}
+
+// rebaseline takes the baseline after act: the new connected set after a
+// hotplug, and otherwise, unless a layout was just applied, watches for
+// manual changes and learns the layouts that stay.
+func (l *loop) rebaseline(ctx context.Context, cur []output, hp hotplug, applied bool) {
+	st := l.st
+	switch {
+	case len(hp.added) > 0 || len(hp.removed) > 0 || hp.replan:
+		l.learn.reset()
+		l.manual = false
+		st.Fingerprint, st.Paused = fingerprint(connectedOutputs(cur)), false
+		l.saveState(ctx)
+	case applied:
+		// The learner takes its baseline from the next poll, which sees
+		// the layout just applied.
+	default:
+		if !l.manual && l.learn.drifted(cur) {
+			infof(ctx, "layout changed outside randr")
+			l.manual = true
+			st.Paused = true
+			st.action("paused after a manual layout change")
+			l.saveState(ctx)
+		}
+		if l.manual && !l.cfg.respectManual() {
+			l.apply(ctx, l.target(ctx, cur), hookEnv{Event: "manual"})
+			l.learn.reset()
+			l.manual = false
+			st.Paused = false
+			l.saveState(ctx)
+		} else if layout, ok := l.learn.observe(cur, time.Now()); ok {
+			rememberLayout(ctx, l.cfg, l.dirs, cur, layout)
+		}
+	}
+}
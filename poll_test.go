@@ -0,0 +1,121 @@
+package randr
+
+import (
+	"context"
+	"maps"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A poll that applies a layout for a trigger only has the outputs queried
+// before it; the layout it applied must not look like a manual change to the
+// next poll.
+func TestRebaselineAfterApplying(t *testing.T) {
+	before, _ := readQuery(t, "dock.txt")
+	after, _ := readQuery(t, "dock.txt")
+	after[1].Current, after[1].CRTC, after[1].X, after[1].Geometry = 0, true, 1920, resolution{2560, 1440}
+
+	ctx := context.Background()
+	l := &loop{dirs: paths{State: t.TempDir()}, cfg: &config{}, st: &daemonState{}}
+	l.learn.observe(before, time.Now())
+
+	// The trigger resets the learner as it applies the layout.
+	l.learn.reset()
+	l.rebaseline(ctx, before, hotplug{}, true)
+	if l.learn.expected != nil {
+		t.Fatal("baseline taken from the outputs queried before applying")
+	}
+	// The next polls see the layout applied.
+	l.rebaseline(ctx, after, hotplug{}, false)
+	l.rebaseline(ctx, after, hotplug{}, false)
+	if l.manual || l.st.Paused {
+		t.Fatal("randr's own layout taken for a manual change")
+	}
+	if !maps.Equal(l.learn.expected, currentLayout(after)) {
+		t.Errorf("baseline %v, want the applied layout", l.learn.expected)
+	}
+
+	// A layout changed behind randr's back still is one.
+	l.rebaseline(ctx, before, hotplug{}, false)
+	if !l.manual || !l.st.Paused {
+		t.Error("manual change not noticed")
+	}
+}
+
+// dellBackend plays the Dell lit right of the panel until it is configured,
+// and the panel alone after, recording the arguments it is configured with.
+type dellBackend struct {
+	extended, alone []byte
+	args            [][]string
+}
+
+func (b *dellBackend) Query(ctx context.Context) ([]byte, error) {
+	if len(b.args) > 0 {
+		return b.alone, nil
+	}
+	return b.extended, nil
+}
+
+func (b *dellBackend) Configure(ctx context.Context, args []string) error {
+	b.args = append(b.args, args)
+	return nil
+}
+
+func TestTemporaryLayoutExpires(t *testing.T) {
+	alone, err := os.ReadFile("testdata/dock.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended := strings.Replace(string(alone), "HDMI-1 connected (", "HDMI-1 connected 2560x1440+1920+0 (", 1)
+	extended = strings.Replace(extended, "2560x1440     59.95 +", "2560x1440     59.95*+", 1)
+	ctx := recoverContext()
+	before, _ := parseQuery(ctx, alone)
+	cur, _ := parseQuery(ctx, []byte(extended))
+
+	for _, tc := range []struct {
+		name        string
+		fingerprint string
+		want        string
+	}{
+		{"undone", fingerprint(connectedOutputs(before)), "--fb 1920x1080 --output HDMI-1 --off"},
+		{"monitors changed", "LEN-40A9-0", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &dellBackend{extended: []byte(extended), alone: alone}
+			st := &daemonState{Profile: "desk", Until: time.Now().Add(time.Minute), RevertTo: &snapshot{
+				Layout: snapshotLayout(before), Profile: "laptop", Fingerprint: tc.fingerprint,
+			}}
+			l := &loop{
+				d:    &Daemon{options: newOptions([]Option{WithBackend(b)})},
+				dirs: paths{State: t.TempDir()},
+				cfg:  &config{Providers: "off", Locker: lockerConfig{Off: true}},
+				st:   st,
+			}
+			if l.triggers(ctx, cur).expired {
+				t.Fatal("temporary layout undone before its time")
+			}
+			st.Until = time.Now().Add(-time.Second)
+			tr := l.triggers(ctx, cur)
+			if !tr.expired {
+				t.Fatal("temporary layout not undone once its time was up")
+			}
+			l.act(ctx, cur, tr, hotplug{})
+
+			var got []string
+			for _, args := range b.args {
+				got = append(got, strings.Join(args, " "))
+			}
+			if strings.Join(got, "; ") != tc.want {
+				t.Errorf("configured %q, want %q", got, tc.want)
+			}
+			if !st.Until.IsZero() || st.RevertTo != nil {
+				t.Errorf("temporary layout still pending: until %s, revert to %v", st.Until, st.RevertTo)
+			}
+			if want := map[bool]string{true: "laptop", false: "desk"}[tc.want != ""]; st.Profile != want {
+				t.Errorf("profile %q, want %q", st.Profile, want)
+			}
+		})
+	}
+}
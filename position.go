@@ -0,0 +1,152 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// placements are the relative positions an arrangement can give an output,
+// as "right-of eDP-1".
+var placements = []string{"right-of", "left-of", "above", "below"}
+
+// parsePosition parses an absolute position, "XxY" or "+X+Y" as in an
+// xrandr geometry.
+func parsePosition(s string) (x, y int, err error) {
+	if rest, ok := strings.CutPrefix(s, "+"); ok {
+		xs, ys, ok := strings.Cut(rest, "+")
+		x, err1 := strconv.Atoi(xs)
+		y, err2 := strconv.Atoi(ys)
+		if ok && err1 == nil && err2 == nil {
+			return x, y, nil
+		}
+		return 0, 0, fmt.Errorf("bad position %q", s)
+	}
+	pos, err := parseResolution(s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("bad position %q", s)
+	}
+	return pos.W, pos.H, nil
+}
+
+// parsePlacement parses a relative position, such as "right-of eDP-1", into
+// its direction and the output it is relative to.
+func parsePlacement(s string) (dir, target string, err error) {
+	dir, target, _ = strings.Cut(s, " ")
+	target = strings.TrimSpace(target)
+	if !slices.Contains(placements, dir) || target == "" {
+		return "", "", fmt.Errorf("bad position %q, want XxY, +X+Y or a direction (%s) and an output", s, strings.Join(placements, ", "))
+	}
+	return dir, target, checkPattern(target)
+}
+
+// checkPos checks an arrangement's position, absolute or relative.
+func checkPos(s string) error {
+	if _, _, err := parsePosition(s); err == nil {
+		return nil
+	}
+	_, _, err := parsePlacement(s)
+	return err
+}
+
+// place resolves the relative positions of the layout's outputs, given by
+// index. An output goes against the given edge of its target, top aligned
+// beside it or left aligned above or below it. The target is placed first
+// if it is in the layout too; otherwise it stays where it is. Outputs that
+// cannot be placed keep the position they have. If placing leaves any
+// output at a negative coordinate, the layout is shifted back to 0x0.
+func (l layout) place(ctx context.Context, rel map[int]string, connected []output) {
+	cur := currentLayout(connected)
+	target := func(want string) (outputState, bool, error) {
+		i := slices.IndexFunc(connected, func(o output) bool { return matchesOutput(want, o) })
+		if i < 0 {
+			return outputState{}, false, fmt.Errorf("%s is not connected", want)
+		}
+		name := connected[i].Name
+		if j := slices.IndexFunc(l, func(c outputConfig) bool { return c.Name == name }); j >= 0 {
+			if _, pending := rel[j]; pending {
+				return outputState{}, false, nil
+			}
+			if l[j].Off {
+				return outputState{}, false, fmt.Errorf("%s is off", name)
+			}
+			return l[j].outputState, true, nil
+		}
+		if st, ok := cur[name]; ok && !st.Off {
+			return st, true, nil
+		}
+		return outputState{}, false, fmt.Errorf("%s is off", name)
+	}
+
+	for len(rel) > 0 {
+		progress := false
+		for _, i := range slices.Sorted(maps.Keys(rel)) {
+			dir, want, _ := parsePlacement(rel[i])
+			t, ok, err := target(want)
+			if err != nil {
+				logf(ctx, "%s: cannot place it %s: %v", l[i].Name, rel[i], err)
+				delete(rel, i)
+				progress = true
+				continue
+			}
+			if !ok {
+				continue
+			}
+			c := &l[i]
+			switch dir {
+			case "right-of":
+				c.X, c.Y = t.X+t.size().W, t.Y
+			case "left-of":
+				c.X, c.Y = t.X-c.size().W, t.Y
+			case "above":
+				c.X, c.Y = t.X, t.Y-c.size().H
+			case "below":
+				c.X, c.Y = t.X, t.Y+t.size().H
+			}
+			delete(rel, i)
+			progress = true
+		}
+		if !progress {
+			for _, i := range slices.Sorted(maps.Keys(rel)) {
+				logf(ctx, "%s: cannot place it %s: the positions depend on each other", l[i].Name, rel[i])
+			}
+			break
+		}
+	}
+
+	minX, minY := 0, 0
+	for _, c := range l {
+		if !c.Off {
+			minX, minY = min(minX, c.X), min(minY, c.Y)
+		}
+	}
+	for i := range l {
+		if !l[i].Off {
+			l[i].X -= minX
+			l[i].Y -= minY
+		}
+	}
+}
+
+// overlaps reports the outputs of the layout that partially cover each
+// other. Outputs covering exactly the same area mirror each other, which is
+// fine.
+func (l layout) overlaps() []error {
+	var errs []error
+	for i, a := range l {
+		for _, b := range l[i+1:] {
+			if a.Off || b.Off {
+				continue
+			}
+			as, bs := a.size(), b.size()
+			same := a.X == b.X && a.Y == b.Y && as == bs
+			if !same && a.X < b.X+bs.W && b.X < a.X+as.W && a.Y < b.Y+bs.H && b.Y < a.Y+as.H {
+				errs = append(errs, fmt.Errorf("%s at %s+%d+%d overlaps %s at %s+%d+%d", b.Name, bs, b.X, b.Y, a.Name, as, a.X, a.Y))
+			}
+		}
+	}
+	return errs
+}
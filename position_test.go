@@ -0,0 +1,124 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestParsePosition(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		x, y int
+		ok   bool
+	}{
+		{"1920x0", 1920, 0, true},
+		{"+1920+0", 1920, 0, true},
+		{"+-1080+0", -1080, 0, true},
+		{"1920", 0, 0, false},
+		{"+1920", 0, 0, false},
+		{"+a+0", 0, 0, false},
+		{"right-of eDP-1", 0, 0, false},
+	} {
+		x, y, err := parsePosition(tc.s)
+		if (err == nil) != tc.ok || x != tc.x || y != tc.y {
+			t.Errorf("%q: got %d,%d, %v; want %d,%d ok=%t", tc.s, x, y, err, tc.x, tc.y, tc.ok)
+		}
+	}
+}
+
+func TestCheckPos(t *testing.T) {
+	for _, tc := range []struct {
+		s  string
+		ok bool
+	}{
+		{"0x0", true},
+		{"+0+1080", true},
+		{"right-of eDP-1", true},
+		{"below DEL-*", true},
+		{"left-of /^DP-[0-9]$/", true},
+		{"beside eDP-1", false},
+		{"right-of", false},
+		{"above HDMI-[", false},
+	} {
+		if err := checkPos(tc.s); (err == nil) != tc.ok {
+			t.Errorf("%q: error %v, want ok=%t", tc.s, err, tc.ok)
+		}
+	}
+}
+
+func TestPlace(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	// A third monitor, not lit yet, to place against.
+	outputs[2].Connected = true
+	connected := connectedOutputs(outputs)
+	lit := func(name string, w, h int) outputConfig {
+		return outputConfig{Name: name, outputState: outputState{Mode: resolution{w, h}}}
+	}
+	for _, tc := range []struct {
+		name string
+		l    layout
+		rel  map[int]string
+		want string
+	}{
+		{"right of the lit panel", layout{lit("HDMI-1", 2560, 1440)}, map[int]string{0: "right-of eDP-1"},
+			"[HDMI-1+1920+0]"},
+		{"left of the lit panel", layout{lit("HDMI-1", 2560, 1440)}, map[int]string{0: "left-of eDP-1"},
+			"[HDMI-1+0+0]"},
+		{"above, by fingerprint", layout{lit("eDP-1", 1920, 1080), lit("HDMI-1", 2560, 1440)}, map[int]string{1: "above AUO-*"},
+			"[eDP-1+0+1440 HDMI-1+0+0]"},
+		{"target placed first", layout{lit("HDMI-1", 2560, 1440), lit("eDP-1", 1920, 1080), lit("DP-1", 1920, 1080)},
+			map[int]string{0: "right-of eDP-1", 1: "below DP-1"}, "[HDMI-1+1920+1080 eDP-1+0+1080 DP-1+0+0]"},
+		{"rotated target", layout{{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, Rotation: "left"}}, lit("eDP-1", 1920, 1080)},
+			map[int]string{1: "right-of HDMI-1"}, "[HDMI-1+0+0 eDP-1+1440+0]"},
+		{"off target", layout{{Name: "eDP-1", outputState: outputState{Off: true}}, lit("HDMI-1", 2560, 1440)},
+			map[int]string{1: "right-of eDP-1"}, "[eDP-1 off HDMI-1+0+0]"},
+		{"not connected", layout{lit("HDMI-1", 2560, 1440)}, map[int]string{0: "right-of DP-2"},
+			"[HDMI-1+0+0]"},
+		{"depending on each other", layout{lit("eDP-1", 1920, 1080), lit("HDMI-1", 2560, 1440)},
+			map[int]string{0: "left-of HDMI-1", 1: "right-of eDP-1"}, "[eDP-1+0+0 HDMI-1+0+0]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.l.place(context.Background(), tc.rel, connected)
+			var got []string
+			for _, c := range tc.l {
+				if c.Off {
+					got = append(got, c.Name+" off")
+				} else {
+					got = append(got, fmt.Sprintf("%s+%d+%d", c.Name, c.X, c.Y))
+				}
+			}
+			if fmt.Sprint(got) != tc.want {
+				t.Errorf("placed %v, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	at := func(name string, w, h, x, y int) outputConfig {
+		return outputConfig{Name: name, outputState: outputState{Mode: resolution{w, h}, X: x, Y: y}}
+	}
+	for _, tc := range []struct {
+		name string
+		l    layout
+		want string
+	}{
+		{"side by side", layout{at("eDP-1", 1920, 1080, 0, 0), at("HDMI-1", 2560, 1440, 1920, 0)}, "[]"},
+		{"stacked", layout{at("eDP-1", 1920, 1080, 0, 1440), at("HDMI-1", 2560, 1440, 0, 0)}, "[]"},
+		{"mirrored", layout{at("eDP-1", 1920, 1080, 0, 0), at("HDMI-1", 1920, 1080, 0, 0)}, "[]"},
+		{"overlapping", layout{at("eDP-1", 1920, 1080, 0, 0), at("HDMI-1", 2560, 1440, 1280, 0)},
+			"[HDMI-1 at 2560x1440+1280+0 overlaps eDP-1 at 1920x1080+0+0]"},
+		{"same origin, other size", layout{at("eDP-1", 1920, 1080, 0, 0), at("HDMI-1", 2560, 1440, 0, 0)},
+			"[HDMI-1 at 2560x1440+0+0 overlaps eDP-1 at 1920x1080+0+0]"},
+		{"rotated into the other", layout{{Name: "DP-2", outputState: outputState{Mode: resolution{1920, 1080}, Rotation: "right"}},
+			at("eDP-1", 1920, 1080, 1000, 0)}, "[eDP-1 at 1920x1080+1000+0 overlaps DP-2 at 1080x1920+0+0]"},
+		{"off", layout{at("eDP-1", 1920, 1080, 0, 0), {Name: "HDMI-1", outputState: outputState{Off: true}}}, "[]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := fmt.Sprint(tc.l.overlaps()); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
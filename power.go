@@ -0,0 +1,84 @@
+package randr
+
+import (
+	"context"
+	"math"
+	"slices"
+)
+
+// powerConfig sets display policies by power source. They only apply on
+// machines that report a mains supply.
+type powerConfig struct {
+	// Battery saves power while running on battery.
+	Battery batteryPolicy `json:"battery,omitzero"`
+	// MaxRefresh runs the outputs at their mode's highest refresh rate on
+	// mains power.
+	MaxRefresh bool `json:"max_refresh,omitempty"`
+}
+
+// batteryPolicy tones the outputs down while on battery.
+type batteryPolicy struct {
+	// MaxRate caps the refresh rate of all outputs, e.g. 60.
+	MaxRate float64 `json:"max_rate,omitempty"`
+	// MaxMode caps the resolution external outputs prefer, e.g.
+	// "2560x1440".
+	MaxMode string `json:"max_mode,omitempty"`
+}
+
+// powerOutputs applies the power policy of the current power source to the
+// connected outputs: the refresh rate they are planned with and, on
+// battery, the mode externals prefer.
+func powerOutputs(ctx context.Context, outputs []output) {
+	online, ok := acState()
+	if !ok {
+		return
+	}
+	c := settingsFrom(ctx).power
+	limit, _ := parseResolution(c.Battery.MaxMode)
+	for i := range outputs {
+		o := &outputs[i]
+		if !o.Connected {
+			continue
+		}
+		switch {
+		case online && c.MaxRefresh:
+			o.refresh = math.Inf(1)
+		case !online && c.Battery.MaxRate > 0:
+			o.refresh = c.Battery.MaxRate
+		}
+		if online || c.Battery.MaxMode == "" || o.internal() {
+			continue
+		}
+		pref, ok := o.preferred()
+		if !ok || (pref.W <= limit.W && pref.H <= limit.H) {
+			continue
+		}
+		best := -1
+		for j, r := range o.Resolutions {
+			if r.W <= limit.W && r.H <= limit.H && (best < 0 || r.pixels() > o.Resolutions[best].pixels()) {
+				best = j
+			}
+		}
+		if best >= 0 {
+			debugf(ctx, "power: %s: on battery, preferring %s over %s", o.Name, o.Resolutions[best], pref)
+			o.Preferred = best
+		}
+	}
+}
+
+// rate returns the refresh rate to run the resolution at: its highest one
+// up to the output's cap, or 0 to leave it to xrandr.
+func (o output) rate(res resolution, t tolerance) float64 {
+	i := slices.Index(o.Resolutions, res)
+	if o.refresh == 0 || i < 0 || i >= len(o.Rates) {
+		return 0
+	}
+	rate := 0.0
+	for _, r := range o.Rates[i] {
+		// Allow for rates such as 60.01 under a cap of 60.
+		if (r < o.refresh || t.sameRate(r, o.refresh)) && r > rate {
+			rate = r
+		}
+	}
+	return rate
+}
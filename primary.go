@@ -0,0 +1,66 @@
+package randr
+
+import (
+	"context"
+	"slices"
+)
+
+// primaryPolicy builds the tests picking the primary output, from the
+// config's Primary and PromoteExternal: the pinned outputs in order, then,
+// when externals are promoted, any external and then the internal panel.
+func primaryPolicy(pinned []string, promote bool) []func(output) bool {
+	var policy []func(output) bool
+	for _, want := range pinned {
+		policy = append(policy, func(o output) bool { return matchesOutput(want, o) })
+	}
+	if promote {
+		policy = append(policy, func(o output) bool { return !o.internal() }, output.internal)
+	}
+	return policy
+}
+
+// pinPrimary returns the layout with the primary output picked by the
+// primary policy. Among several outputs passing the same test, the one the
+// layout makes primary is kept, or else the first in connector order. An
+// output the layout leaves alone keeps its current state. When no lit output
+// passes any test the layout is returned as it is.
+func pinPrimary(ctx context.Context, l layout, outputs []output) layout {
+	cur := currentLayout(outputs)
+	picks := slices.ContainsFunc(l, func(c outputConfig) bool { return c.Primary && !c.Off })
+	lit := func(name string) (outputState, bool) {
+		if i := slices.IndexFunc(l, func(c outputConfig) bool { return c.Name == name }); i >= 0 {
+			return l[i].outputState, !l[i].Off
+		}
+		st, ok := cur[name]
+		st.Primary = st.Primary && !picks
+		return st, ok && !st.Off
+	}
+	var candidates []output
+	for _, o := range connectedOutputs(outputs) {
+		if st, ok := lit(o.Name); ok {
+			if st.Primary {
+				candidates = slices.Insert(candidates, 0, o)
+			} else {
+				candidates = append(candidates, o)
+			}
+		}
+	}
+	for _, test := range settingsFrom(ctx).primary {
+		i := slices.IndexFunc(candidates, test)
+		if i < 0 {
+			continue
+		}
+		name := candidates[i].Name
+		pinned := slices.Clone(l)
+		for i := range pinned {
+			pinned[i].Primary = pinned[i].Name == name
+		}
+		if !slices.ContainsFunc(pinned, func(c outputConfig) bool { return c.Name == name }) {
+			st, _ := lit(name)
+			st.Primary = true
+			pinned = append(pinned, outputConfig{Name: name, outputState: st})
+		}
+		return pinned
+	}
+	return l
+}
@@ -0,0 +1,67 @@
+package randr
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// reversePrime reports whether layout changes need sequencing: with the
+// NVIDIA driver sinking output from another GPU (reverse PRIME), a single
+// xrandr call setting several outputs often leaves some of them dark.
+func (c *config) reversePrime(sinks []provider) bool {
+	switch c.ReversePrime {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	return slices.ContainsFunc(sinks, func(p provider) bool {
+		return strings.HasPrefix(p.Name, "NVIDIA")
+	})
+}
+
+// sequencedExecutor applies plans one output per xrandr call, switching
+// outputs off first and setting up the internal panel before the external
+// outputs, then applies the whole layout once more, as reverse PRIME setups
+// need.
+type sequencedExecutor struct {
+	backend Backend
+}
+
+func (e sequencedExecutor) apply(ctx context.Context, p *plan) error {
+	delta := p.delta()
+	if len(delta) == 0 {
+		infof(ctx, "layout already active, nothing to do")
+		return nil
+	}
+	ctx, sp := startSpan(ctx, "configure")
+	sp.set("sequenced", true)
+	var err error
+	defer func() { sp.finish(err) }()
+	if err = p.addModes(ctx, e.backend); err != nil {
+		return err
+	}
+
+	rank := func(c outputConfig) int {
+		switch {
+		case c.Off:
+			return 0
+		case (output{Name: c.Name}).internal():
+			return 1
+		}
+		return 2
+	}
+	slices.SortStableFunc(delta, func(a, b outputConfig) int { return rank(a) - rank(b) })
+	for _, c := range delta {
+		var args []string
+		if p.scaled {
+			args = append(args, "--fb", p.Size.String())
+		}
+		if err = e.backend.Configure(ctx, append(args, layout{c}.args()...)); err != nil {
+			return err
+		}
+	}
+	err = e.backend.Configure(ctx, p.args())
+	return err
+}
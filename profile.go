@@ -0,0 +1,387 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	layoutMirror = "mirror"
+	layoutExtend = "extend"
+	layoutFixed  = "fixed"
+	// external-only and internal-only extend the external or internal
+	// outputs and switch the others off.
+	layoutExternalOnly = "external-only"
+	layoutInternalOnly = "internal-only"
+	// mixed mirrors the primary onto one external, the projector by
+	// default, and extends the others.
+	layoutMixed = "mixed"
+)
+
+// minScale and maxScale bound the scale factors of a profile; beyond them
+// the picture is unreadable or the framebuffer huge.
+const (
+	minScale = 0.25
+	maxScale = 4.0
+)
+
+// profile describes a layout to apply for a particular set of monitors.
+//
+// Outputs lists the monitors (EDID fingerprints or connector names) that must
+// be connected, no more and no less, for the profile to match exactly.
+// Externals optionally constrains the number of connected external outputs,
+// Dock the docking station, Tablet tablet mode, Time and Days the time of
+// day and week, and Check an external command. A profile with none of these
+// conditions besides Externals only matches when reached through a fallback
+// chain.
+type profile struct {
+	Name      string   `json:"name"`
+	Outputs   []string `json:"outputs,omitempty"`
+	Externals *int     `json:"externals,omitempty"`
+	Layout    string   `json:"layout,omitempty"`
+	Fallback  string   `json:"fallback,omitempty"`
+	// Arrangement holds per-output settings for the fixed layout, keyed by
+	// EDID fingerprint or connector name.
+	Arrangement map[string]outputSetting `json:"arrangement,omitempty"`
+	// Hooks run only when this profile is applied.
+	Hooks hooks `json:"hooks,omitzero"`
+	// Dock restricts the profile to when the named dock is attached.
+	Dock string `json:"dock,omitempty"`
+	// Monitors are virtual monitors set up along with the layout.
+	Monitors []virtualMonitor `json:"monitors,omitempty"`
+	// Tablet restricts the profile to when a convertible is, or is not,
+	// in tablet mode.
+	Tablet *bool `json:"tablet,omitempty"`
+	// Time restricts the profile to a daily time window, e.g.
+	// "22:00-07:00".
+	Time string `json:"time,omitempty"`
+	// Days restricts the profile to days of the week: "mon" to "sun",
+	// "weekdays" or "weekend".
+	Days []string `json:"days,omitempty"`
+	// Check is a shell command that must exit 0 for the profile to match,
+	// e.g. to tell whether the VPN is up.
+	Check string `json:"check,omitempty"`
+	// Mirror limits the mirror layout to these outputs mirroring the
+	// primary, and picks the mirrored outputs of the mixed layout; the
+	// others extend to the right.
+	Mirror []string `json:"mirror,omitempty"`
+	// MirrorGroups are groups of outputs that mirror each other in the
+	// mirror layout, laid out left to right in order; the first output of
+	// each group is its source. Outputs in no group extend to the right.
+	MirrorGroups [][]string `json:"mirror_groups,omitempty"`
+	// Ultrawide is "split" to split super-ultrawide outputs into two
+	// virtual monitors, or "whole" (the default) to leave them whole.
+	Ultrawide string `json:"ultrawide,omitempty"`
+	// Rotate rotates outputs in every layout but mirroring, keyed like
+	// Arrangement, e.g. {"DEL-41A2-*": "left"} for a portrait monitor.
+	Rotate map[string]string `json:"rotate,omitempty"`
+	// Scale scales outputs in every layout but mirroring, keyed like
+	// Arrangement: an output at 0.5 takes up half its mode's width and
+	// height, one at 1.5 half as much again.
+	Scale map[string]float64 `json:"scale,omitempty"`
+
+	// file and path locate the profile's definition, for error messages.
+	file, path string
+	// dock is the definition of Dock.
+	dock *dockSpec
+}
+
+// outputSetting pins the configuration of one output in a fixed layout.
+// Mode is "WxH", or "WxH@rate" to pin the refresh rate too. Pos is
+// absolute, "XxY" or "+X+Y", or relative to another output, as
+// "right-of eDP-1".
+type outputSetting struct {
+	Mode    string `json:"mode,omitempty"`
+	Pos     string `json:"pos,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Off     bool   `json:"off,omitempty"`
+}
+
+func (s outputSetting) validate() error {
+	if s.Mode != "" {
+		if _, _, err := parseModeRate(s.Mode); err != nil {
+			return err
+		}
+	}
+	if s.Pos != "" {
+		if err := checkPos(s.Pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseResolution parses a "WxH" string.
+func parseResolution(s string) (resolution, error) {
+	w, h, ok := strings.Cut(s, "x")
+	if ok {
+		wi, err1 := strconv.Atoi(w)
+		hi, err2 := strconv.Atoi(h)
+		if err1 == nil && err2 == nil {
+			return resolution{wi, hi}, nil
+		}
+	}
+	return resolution{}, fmt.Errorf("bad mode %q", s)
+}
+
+// fingerprint identifies a set of connected monitors independent of the
+// order and connectors they were detected on.
+func fingerprint(connected []output) string {
+	ids := make([]string, 0, len(connected))
+	for _, o := range connected {
+		ids = append(ids, o.id())
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// matches reports whether the profile's conditions hold for the connected
+// outputs.
+func (p *profile) matches(ctx context.Context, connected []output, ck *checks) bool {
+	if p.Dock != "" && (p.dock == nil || !p.dock.present(connected)) {
+		return false
+	}
+	if len(p.Outputs) > 0 && !p.matchesSet(connected) {
+		return false
+	}
+	if p.Tablet != nil {
+		if on, ok := tabletMode(ctx); !ok || on != *p.Tablet {
+			return false
+		}
+	}
+	if p.Time != "" && !inWindow(p.Time, time.Now()) {
+		return false
+	}
+	if len(p.Days) > 0 && !onDays(p.Days, time.Now()) {
+		return false
+	}
+	if p.Externals != nil {
+		n := 0
+		for _, o := range connected {
+			if !o.internal() {
+				n++
+			}
+		}
+		if n != *p.Externals {
+			return false
+		}
+	}
+	return p.Check == "" || ck.holds(p.Check)
+}
+
+// conditional reports whether the profile has conditions of its own, so it
+// can match directly rather than only through a fallback chain.
+func (p *profile) conditional() bool {
+	return len(p.Outputs) > 0 || p.Dock != "" || p.Tablet != nil || p.Time != "" ||
+		len(p.Days) > 0 || p.Check != ""
+}
+
+// matchesSet reports whether the connected outputs are exactly the monitors
+// listed in the profile.
+func (p *profile) matchesSet(connected []output) bool {
+	if len(p.Outputs) != len(connected) {
+		return false
+	}
+	return p.overlap(connected) == len(connected)
+}
+
+// overlap counts the connected outputs named by the profile, each paired
+// with a distinct entry of Outputs.
+func (p *profile) overlap(connected []output) int {
+	return len(assign(p.Outputs, connected))
+}
+
+// matchProfile selects the profile to apply for the connected outputs. An
+// exact match on the monitor set wins; otherwise the profile sharing the most
+// monitors with the current set is used as a starting point and its fallback
+// chain is walked until a profile's conditions hold. Rules come first: the
+// first one that holds decides. It returns nil when no profile applies.
+func matchProfile(ctx context.Context, cfg *config, connected []output, ck *checks) *profile {
+	if p := matchRule(ctx, cfg, connected, ck); p != nil {
+		return p
+	}
+	for i := range cfg.Profiles {
+		p := &cfg.Profiles[i]
+		if p.conditional() && p.matches(ctx, connected, ck) {
+			return p
+		}
+	}
+
+	var start *profile
+	best := 0
+	for i := range cfg.Profiles {
+		p := &cfg.Profiles[i]
+		if n := p.overlap(connected); n > best {
+			start, best = p, n
+		}
+	}
+	if start == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for p := cfg.profile(start.Fallback); p != nil; p = cfg.profile(p.Fallback) {
+		if seen[p.Name] {
+			logf(ctx, "profile %q: fallback cycle", p.Name)
+			return nil
+		}
+		seen[p.Name] = true
+		if p.matches(ctx, connected, ck) {
+			infof(ctx, "profile %q does not match, falling back to %q", start.Name, p.Name)
+			return p
+		}
+	}
+	return nil
+}
+
+// ordered returns the connected outputs in the order the profile lists them,
+// followed by any outputs it does not name.
+func (p *profile) ordered(connected []output) []output {
+	var out []output
+	used := make(map[int]bool)
+	pairs := assign(p.Outputs, connected)
+	for wi := range p.Outputs {
+		if ci, ok := pairs[wi]; ok {
+			out = append(out, connected[ci])
+			used[ci] = true
+		}
+	}
+	for i, o := range connected {
+		if !used[i] {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+// layout returns the profile's layout for the connected outputs.
+func (p *profile) layout(ctx context.Context, outputs []output) layout {
+	outputs = p.transformed(outputs)
+	connected := connectedOutputs(outputs)
+	switch p.Layout {
+	case layoutExtend:
+		return extend(p.ordered(connected))
+	case layoutFixed:
+		return p.arrange(ctx, connected)
+	case layoutExternalOnly:
+		return onlyLayout(p.ordered(connected), func(o output) bool { return !o.internal() })
+	case layoutInternalOnly:
+		return onlyLayout(p.ordered(connected), output.internal)
+	case layoutMixed:
+		return mirrorGroups(p.groups(connected))
+	default:
+		if len(p.Mirror) > 0 || len(p.MirrorGroups) > 0 {
+			return mirrorGroups(p.groups(connected))
+		}
+		return mirrorLayout(ctx, outputs)
+	}
+}
+
+// extend places the outputs left to right at their preferred resolutions, the
+// first one being primary. An output to be rotated or scaled takes up its
+// rotated and scaled width.
+func extend(outputs []output) layout {
+	var l layout
+	x := 0
+	for _, o := range outputs {
+		res, ok := o.preferred()
+		if !ok {
+			continue
+		}
+		st := o.state(res)
+		st.X, st.Primary = x, len(l) == 0
+		l = append(l, outputConfig{Name: o.Name, outputState: st})
+		x += st.size().W
+	}
+	return l
+}
+
+// lookupOutput returns the entry for an output, looked up by EDID
+// fingerprint first, connector name second, and then by the first matching
+// pattern in key order.
+func lookupOutput[T any](m map[string]T, o output) (T, bool) {
+	if v, ok := m[o.id()]; ok {
+		return v, true
+	}
+	if v, ok := m[o.Name]; ok {
+		return v, true
+	}
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		if isPattern(key) && matchesOutput(key, o) {
+			return m[key], true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// setting returns the arrangement entry for an output.
+func (p *profile) setting(o output) (outputSetting, bool) {
+	return lookupOutput(p.Arrangement, o)
+}
+
+// transformed returns a copy of the outputs with the profile's rotations,
+// which take precedence over the panel's orientation, and scales. Layouts
+// then give the outputs their rotated and scaled size, so their neighbours
+// move along.
+func (p *profile) transformed(outputs []output) []output {
+	if len(p.Rotate) == 0 && len(p.Scale) == 0 {
+		return outputs
+	}
+	outputs = slices.Clone(outputs)
+	for i, o := range outputs {
+		if !o.Connected {
+			continue
+		}
+		if r, ok := lookupOutput(p.Rotate, o); ok {
+			outputs[i].rotate = r
+		}
+		if f, ok := lookupOutput(p.Scale, o); ok {
+			outputs[i].scale = f
+		}
+	}
+	return outputs
+}
+
+// arrange returns the layout described by the profile's per-output
+// settings. Outputs without an entry are left untouched, as are positions
+// that are not given.
+func (p *profile) arrange(ctx context.Context, connected []output) layout {
+	var l layout
+	rel := make(map[int]string)
+	for _, o := range connected {
+		s, ok := p.setting(o)
+		if !ok {
+			continue
+		}
+		if s.Off {
+			l = append(l, outputConfig{Name: o.Name, outputState: outputState{Off: true}})
+			continue
+		}
+		var st outputState
+		if s.Mode != "" {
+			res, rate, _ := parseModeRate(s.Mode)
+			st = o.state(res)
+			st.Rate = rate
+		} else if res, ok := o.preferred(); ok {
+			st = o.state(res)
+		} else {
+			continue
+		}
+		st.X, st.Y, st.Primary = o.X, o.Y, s.Primary
+		if x, y, err := parsePosition(s.Pos); err == nil {
+			st.X, st.Y = x, y
+		} else if s.Pos != "" {
+			rel[len(l)] = s.Pos
+		}
+		l = append(l, outputConfig{Name: o.Name, outputState: st})
+	}
+	l.place(ctx, rel, connected)
+	return l
+}
@@ -0,0 +1,95 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// projectorVendors are EDID manufacturer IDs of projector makers that make
+// no monitors.
+var projectorVendors = []string{"EPS", "IFS", "OPT", "CAS", "CTX", "PJD"}
+
+// projectorConfig tunes how projectors are set up.
+type projectorConfig struct {
+	// Off treats projectors like any other monitor.
+	Off bool `json:"off,omitempty"`
+	// Mode is the highest mode to mirror at, "1920x1080" by default.
+	Mode string `json:"mode,omitempty"`
+	// KeepBlanking leaves screen blanking and DPMS on during
+	// presentations.
+	KeepBlanking bool `json:"keep_blanking,omitempty"`
+}
+
+// defaultProjectorMode caps the presentation mode unless configured.
+var defaultProjectorMode = resolution{1920, 1080}
+
+// projector reports whether the output is likely a projector: one by a
+// projector maker, calling itself one, or an external output whose native
+// resolution is no more than WXGA.
+func (o output) projector() bool {
+	if o.internal() {
+		return false
+	}
+	name := strings.ToUpper(o.Monitor.Name)
+	if slices.Contains(projectorVendors, o.Monitor.Vendor) ||
+		strings.Contains(name, "PJ") || strings.Contains(name, "PROJECTOR") {
+		return true
+	}
+	res, ok := o.preferred()
+	return ok && res.W <= 1280 && res.H <= 800
+}
+
+// presentation plans mirroring the connected outputs onto a projector, if
+// one is connected: at the highest mode all of them share, up to the
+// projector's native one and the configured cap. It returns nil without a
+// projector or a mode to mirror at.
+func (pl *planner) presentation(ctx context.Context, outputs []output) *plan {
+	c := pl.cfg.Projector
+	connected := connectedOutputs(outputs)
+	i := slices.IndexFunc(connected, output.projector)
+	if c.Off || i < 0 || len(connected) < 2 {
+		return nil
+	}
+	proj := connected[i]
+	limit := defaultProjectorMode
+	if c.Mode != "" {
+		limit, _ = parseResolution(c.Mode)
+	}
+	if native, ok := proj.preferred(); ok && native.pixels() < limit.pixels() {
+		limit = native
+	}
+	var res resolution
+	for _, r := range proj.Resolutions {
+		if r.W > limit.W || r.H > limit.H || r.pixels() <= res.pixels() {
+			continue
+		}
+		if !slices.ContainsFunc(connected, func(o output) bool { return !slices.Contains(o.Resolutions, r) }) {
+			res = r
+		}
+	}
+	if res == (resolution{}) {
+		debugf(ctx, "projector %s: no mode shared by all outputs", proj.Name)
+		return nil
+	}
+	primary := slices.IndexFunc(connected, func(o output) bool { return o.Primary })
+	if primary < 0 {
+		primary = 0
+	}
+	externals := slices.Delete(slices.Clone(connected), primary, primary+1)
+	p := newPlan(ctx, fmt.Sprintf("presentation on %s at %s", proj.Name, res), mirror(connected[primary], externals, res), outputs)
+	p.presentation = true
+	return p
+}
+
+// setBlanking switches screen blanking and DPMS on or off with xset.
+func setBlanking(ctx context.Context, on bool) error {
+	args := []string{"s", "off", "-dpms"}
+	if on {
+		args = []string{"s", "on", "+dpms"}
+	}
+	infof(ctx, "xset %s", strings.Join(args, " "))
+	_, err := runCommand(ctx, false, "xset", args, nil)
+	return err
+}
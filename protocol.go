@@ -0,0 +1,770 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The apply settings.
+const (
+	applyXrandr   = "xrandr"
+	applyProtocol = "protocol"
+)
+
+// newExecutor returns the executor for plans applied through the backend:
+// one speaking the RandR protocol if the settings say so and the backend is
+// xrandr's, which it stands in for, and otherwise one running xrandr.
+func newExecutor(b Backend, s *settings) executor {
+	if _, ok := b.(xrandrBackend); ok && s.applyWith == applyProtocol {
+		return protocolExecutor{b}
+	}
+	return xrandrExecutor{b}
+}
+
+// protocolExecutor applies plans by setting up the CRTCs over the RandR
+// protocol itself, without running xrandr.
+type protocolExecutor struct {
+	backend Backend
+}
+
+func (e protocolExecutor) apply(ctx context.Context, p *plan) error {
+	delta := p.delta()
+	if len(delta) == 0 {
+		infof(ctx, "layout already active, nothing to do")
+		return nil
+	}
+	ctx, sp := startSpan(ctx, "configure")
+	sp.set("protocol", true)
+	err := p.addModes(ctx, e.backend)
+	if err == nil {
+		var fb resolution
+		if p.scaled || p.resize {
+			fb = p.Size
+		}
+		err = withRandR(ctx, func(c *randrClient) error { return c.apply(delta, fb) })
+	}
+	sp.finish(err)
+	return err
+}
+
+// withRandR runs fn on a connection to the display's RandR extension,
+// bounded by command_timeout like an xrandr call.
+func withRandR(ctx context.Context, fn func(*randrClient) error) error {
+	timeout := settingsFrom(ctx).commandTimeout
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	c, err := dialRandR(ctx)
+	if err == nil {
+		err = fn(c)
+		c.Close()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("x11: timed out after %s", timeout)
+	}
+	return err
+}
+
+// RandR requests, by minor opcode.
+const (
+	randrQueryVersion              = 0
+	randrGetScreenSizeRange        = 6
+	randrSetScreenSize             = 7
+	randrGetScreenResources        = 8
+	randrGetOutputInfo             = 9
+	randrListOutputProperties      = 10
+	randrChangeOutputProperty      = 13
+	randrGetOutputProperty         = 15
+	randrCreateMode                = 16
+	randrAddOutputMode             = 18
+	randrGetCrtcInfo               = 20
+	randrSetCrtcConfig             = 21
+	randrGetScreenResourcesCurrent = 25
+	randrSetCrtcTransform          = 26
+	randrGetCrtcTransform          = 27
+	randrSetOutputPrimary          = 30
+	randrGetOutputPrimary          = 31
+	randrGetProviders              = 32
+	randrGetProviderInfo           = 33
+	randrSetProviderOutputSource   = 35
+	randrGetMonitors               = 42
+	randrSetMonitor                = 43
+	randrDeleteMonitor             = 44
+)
+
+// Mode flags that change the refresh rate.
+const (
+	randrInterlace  = 0x10
+	randrDoubleScan = 0x20
+)
+
+// randrRotations are the rotation bits of xrandr's rotations.
+var randrRotations = map[string]uint16{"normal": 1, "left": 2, "inverted": 4, "right": 8}
+
+// randrClient is a connection to the X server's RandR extension.
+type randrClient struct {
+	x      *x11Conn
+	opcode byte
+	// minor is the minor version of RandR 1 the server speaks: 4 and up
+	// have providers, 5 and up monitors.
+	minor int
+}
+
+// dialRandR connects to the display and checks it has RandR 1.3, the
+// first to report resources without probing the outputs, to set the
+// primary output and to scale.
+func dialRandR(ctx context.Context) (*randrClient, error) {
+	x, err := dialX11(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &randrClient{x: x}
+	if c.opcode, err = x.extension("RANDR"); err != nil {
+		x.Close()
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	b, err := c.call(randrQueryVersion, le32(1, 5))
+	if err != nil {
+		x.Close()
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	if major, minor := u32(b[8:]), u32(b[12:]); major < 1 || major == 1 && minor < 3 {
+		x.Close()
+		return nil, fmt.Errorf("x11: RandR %d.%d is too old, 1.3 is needed", major, minor)
+	}
+	c.minor = int(u32(b[12:]))
+	return c, nil
+}
+
+func (c *randrClient) Close() error {
+	return c.x.Close()
+}
+
+func (c *randrClient) call(minor byte, body []byte) ([]byte, error) {
+	return c.x.call(c.opcode, minor, body)
+}
+
+func (c *randrClient) send(minor byte, body []byte) error {
+	_, err := c.x.send(c.opcode, minor, body)
+	return err
+}
+
+func u16(b []byte) int    { return int(binary.LittleEndian.Uint16(b)) }
+func u32(b []byte) uint32 { return binary.LittleEndian.Uint32(b) }
+
+// le32 encodes the values as a request body.
+func le32(vs ...uint32) []byte {
+	var b []byte
+	for _, v := range vs {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	return b
+}
+
+// ids reads n resource IDs from b.
+func ids(b []byte, n int) []uint32 {
+	out := make([]uint32, n)
+	for i := range out {
+		out[i] = u32(b[4*i:])
+	}
+	return out
+}
+
+// randrMode is a mode the screen knows.
+type randrMode struct {
+	Name string
+	W, H int
+	Rate float64
+}
+
+// Output connection states.
+const (
+	randrConnected    = 0
+	randrDisconnected = 1
+)
+
+// randrOutput is an output's state as RandR reports it.
+type randrOutput struct {
+	ID   uint32
+	Name string
+	// Connection is randrConnected, randrDisconnected or 2 when the server
+	// cannot tell.
+	Connection byte
+	// MM is the size of the attached monitor in millimetres.
+	MM resolution
+	// CRTC is the one driving the output, 0 if none; CRTCs are the ones
+	// that can.
+	CRTC  uint32
+	CRTCs []uint32
+	// Modes are the output's modes, the first Preferred of them preferred.
+	Modes     []uint32
+	Preferred int
+	// Props are the output's properties, read for queries only.
+	Props []randrProperty
+}
+
+// crtcConfig is what a CRTC shows: a mode, where and how rotated, on which
+// outputs. A disabled CRTC has no mode. From is the area of the screen
+// scaled to the mode, if it is scaled.
+type crtcConfig struct {
+	X, Y     int
+	Mode     uint32
+	Rotation uint16
+	From     resolution
+	Outputs  []uint32
+}
+
+// randrResources are the screen's modes, outputs and CRTCs.
+type randrResources struct {
+	configTime uint32
+	modes      map[uint32]randrMode
+	outputs    []randrOutput
+	crtcs      map[uint32]crtcConfig
+	// sizes are the CRTCs' current sizes, rotation included.
+	sizes map[uint32]resolution
+	// min and max bound the screen size.
+	min, max resolution
+}
+
+var errShortReply = errors.New("x11: short reply")
+
+// resources reads the screen's resources. With probe the server first
+// looks for monitors plugged in or out, as `xrandr --query` has it do;
+// otherwise it reports what it last found.
+func (c *randrClient) resources(probe bool) (*randrResources, error) {
+	req := byte(randrGetScreenResourcesCurrent)
+	if probe {
+		req = randrGetScreenResources
+	}
+	b, err := c.call(req, le32(c.x.root))
+	if err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	ncrtcs, noutputs, nmodes, nnames := u16(b[16:]), u16(b[18:]), u16(b[20:]), u16(b[22:])
+	if len(b) < 32+4*ncrtcs+4*noutputs+32*nmodes+nnames {
+		return nil, errShortReply
+	}
+	r := &randrResources{
+		configTime: u32(b[12:]),
+		modes:      make(map[uint32]randrMode),
+		crtcs:      make(map[uint32]crtcConfig),
+		sizes:      make(map[uint32]resolution),
+	}
+	crtcs := ids(b[32:], ncrtcs)
+	outputs := ids(b[32+4*ncrtcs:], noutputs)
+	infos := b[32+4*ncrtcs+4*noutputs:]
+	names := infos[32*nmodes:]
+	for i := range nmodes {
+		m := infos[32*i:]
+		clock, htotal, vtotal, flags := float64(u32(m[8:])), float64(u16(m[16:])), float64(u16(m[24:])), u32(m[28:])
+		if flags&randrDoubleScan != 0 {
+			vtotal *= 2
+		}
+		if flags&randrInterlace != 0 {
+			vtotal /= 2
+		}
+		mode := randrMode{W: u16(m[4:]), H: u16(m[6:])}
+		if htotal > 0 && vtotal > 0 {
+			mode.Rate = clock / (htotal * vtotal)
+		}
+		n := u16(m[26:])
+		mode.Name, names = string(names[:n]), names[n:]
+		r.modes[u32(m)] = mode
+	}
+
+	for _, id := range crtcs {
+		b, err := c.call(randrGetCrtcInfo, le32(id, r.configTime))
+		if err != nil {
+			return nil, fmt.Errorf("x11: %w", err)
+		}
+		if b[1] != 0 {
+			return nil, errors.New("x11: the screen changed while being read")
+		}
+		n := u16(b[28:])
+		if len(b) < 32+4*n {
+			return nil, errShortReply
+		}
+		cfg := crtcConfig{
+			X:        int(int16(u16(b[12:]))),
+			Y:        int(int16(u16(b[14:]))),
+			Mode:     u32(b[20:]),
+			Rotation: uint16(u16(b[24:])),
+			Outputs:  ids(b[32:], n),
+		}
+		r.sizes[id] = resolution{u16(b[16:]), u16(b[18:])}
+		if cfg.Mode != 0 && r.size(cfg) != r.sizes[id] {
+			cfg.From = r.sizes[id]
+		}
+		r.crtcs[id] = cfg
+	}
+
+	for _, id := range outputs {
+		b, err := c.call(randrGetOutputInfo, le32(id, r.configTime))
+		if err != nil {
+			return nil, fmt.Errorf("x11: %w", err)
+		}
+		if b[1] != 0 {
+			return nil, errors.New("x11: the screen changed while being read")
+		}
+		ncrtcs, nmodes, nclones, nname := u16(b[26:]), u16(b[28:]), u16(b[32:]), u16(b[34:])
+		if len(b) < 36+4*(ncrtcs+nmodes+nclones)+nname {
+			return nil, errShortReply
+		}
+		o := randrOutput{
+			ID:         id,
+			CRTC:       u32(b[12:]),
+			MM:         resolution{int(u32(b[16:])), int(u32(b[20:]))},
+			Connection: b[24],
+			CRTCs:      ids(b[36:], ncrtcs),
+			Modes:      ids(b[36+4*ncrtcs:], nmodes),
+			Preferred:  u16(b[30:]),
+		}
+		o.Name = string(b[36+4*(ncrtcs+nmodes+nclones):][:nname])
+		r.outputs = append(r.outputs, o)
+	}
+
+	b, err = c.call(randrGetScreenSizeRange, le32(c.x.root))
+	if err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	r.min = resolution{u16(b[8:]), u16(b[10:])}
+	r.max = resolution{u16(b[12:]), u16(b[14:])}
+	return r, nil
+}
+
+// mode returns the output's mode named as the resolution, the one closest
+// to rate if it is set, and the first, the preferred if it is one,
+// otherwise, as xrandr picks them.
+func (r *randrResources) mode(o randrOutput, res resolution, rate float64) (uint32, bool) {
+	var best uint32
+	for _, id := range o.Modes {
+		m, ok := r.modes[id]
+		if !ok || m.Name != res.String() {
+			continue
+		}
+		if best == 0 || rate > 0 && math.Abs(m.Rate-rate) < math.Abs(r.modes[best].Rate-rate) {
+			best = id
+		}
+		if rate == 0 {
+			break
+		}
+	}
+	return best, best != 0
+}
+
+// size returns the area of the screen the CRTC config covers.
+func (r *randrResources) size(c crtcConfig) resolution {
+	if c.From != (resolution{}) {
+		return c.From
+	}
+	m := r.modes[c.Mode]
+	if c.Rotation&(randrRotations["left"]|randrRotations["right"]) != 0 {
+		return resolution{m.H, m.W}
+	}
+	return resolution{m.W, m.H}
+}
+
+// configs works out the CRTC configs the layout's outputs need, keyed by
+// CRTC; the CRTCs to disable get the zero config. Outputs that come on
+// keep their CRTC if they have one to themselves and otherwise take a free
+// one they can use. The primary output comes along, if the layout names
+// one.
+func (r *randrResources) configs(l layout) (map[uint32]crtcConfig, uint32, error) {
+	changing := make(map[uint32]bool)
+	byName := make(map[string]randrOutput)
+	for _, o := range r.outputs {
+		byName[o.Name] = o
+	}
+	for _, c := range l {
+		o, ok := byName[c.Name]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown output %s", c.Name)
+		}
+		changing[o.ID] = true
+	}
+
+	want := make(map[uint32]crtcConfig)
+	taken := make(map[uint32]bool)
+	for id, cur := range r.crtcs {
+		if cur.Mode == 0 {
+			continue
+		}
+		// A CRTC keeps driving the outputs that stay as they are.
+		rest := slices.DeleteFunc(slices.Clone(cur.Outputs), func(o uint32) bool { return changing[o] })
+		switch {
+		case len(rest) == len(cur.Outputs):
+			taken[id] = true
+		case len(rest) > 0:
+			cur.Outputs = rest
+			want[id], taken[id] = cur, true
+		default:
+			want[id] = crtcConfig{}
+		}
+	}
+
+	var primary uint32
+	for _, c := range l {
+		if c.Off {
+			continue
+		}
+		o := byName[c.Name]
+		mode, ok := r.mode(o, c.Mode, c.Rate)
+		if !ok {
+			return nil, 0, fmt.Errorf("%s has no mode %s", o.Name, c.Mode)
+		}
+		rotation := randrRotations["normal"]
+		if c.Rotation != "" {
+			rotation = randrRotations[c.Rotation]
+		} else if cur, ok := r.crtcs[o.CRTC]; ok && cur.Mode != 0 {
+			rotation = cur.Rotation
+		}
+		crtc := o.CRTC
+		if crtc == 0 || taken[crtc] {
+			i := slices.IndexFunc(o.CRTCs, func(id uint32) bool { return !taken[id] })
+			if i < 0 {
+				return nil, 0, fmt.Errorf("%s: no free CRTC", o.Name)
+			}
+			crtc = o.CRTCs[i]
+		}
+		want[crtc], taken[crtc] = crtcConfig{X: c.X, Y: c.Y, Mode: mode, Rotation: rotation, From: c.ScaleFrom, Outputs: []uint32{o.ID}}, true
+		if c.Primary {
+			primary = o.ID
+		}
+	}
+	return want, primary, nil
+}
+
+// apply sets up the CRTCs for the layout with the server grabbed, from
+// reading the resources to the last change, so no other client, the
+// compositor included, sees the screen half set up or changes it in
+// between. A failure or timeout closes the connection, which ends the grab.
+// The screen is made fb in size, or as large as the layout needs if fb is
+// zero.
+func (c *randrClient) apply(l layout, fb resolution) error {
+	if err := c.x.grab(); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	if err := c.set(l, fb); err != nil {
+		return err
+	}
+	if err := c.x.ungrab(); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
+
+// set sets up the CRTCs for the layout the way xrandr does: the output
+// properties are set first, then the CRTCs going off, or not fitting the
+// new screen, are disabled, then the screen is resized to hold them all,
+// then they are set, and finally the primary output is chosen.
+func (c *randrClient) set(l layout, fb resolution) error {
+	r, err := c.resources(false)
+	if err != nil {
+		return err
+	}
+	want, primary, err := r.configs(l)
+	if err != nil {
+		return err
+	}
+	for _, oc := range l {
+		for _, name := range slices.Sorted(maps.Keys(oc.Props)) {
+			if err := c.setProperty(r, oc.Name, name, oc.Props[name]); err != nil {
+				return err
+			}
+		}
+	}
+
+	size := fb
+	for id, cur := range r.crtcs {
+		cfg, ok := want[id]
+		if !ok {
+			cfg = cur
+		}
+		if cfg.Mode != 0 && fb == (resolution{}) {
+			s := r.size(cfg)
+			size.W, size.H = max(size.W, cfg.X+s.W), max(size.H, cfg.Y+s.H)
+		}
+	}
+	if size.W > r.max.W || size.H > r.max.H {
+		return fmt.Errorf("layout needs a %s screen but the maximum is %s", size, r.max)
+	}
+	size.W, size.H = max(size.W, r.min.W), max(size.H, r.min.H)
+
+	for _, id := range slices.Sorted(maps.Keys(want)) {
+		cur, cfg := r.crtcs[id], want[id]
+		s := r.sizes[id]
+		if cur.Mode != 0 && (cfg.Mode == 0 || cur.X+s.W > size.W || cur.Y+s.H > size.H) {
+			if err := c.setCrtc(r, id, crtcConfig{Rotation: randrRotations["normal"]}); err != nil {
+				return err
+			}
+			cur.Mode = 0
+			r.crtcs[id] = cur
+		}
+	}
+	if size != (resolution{c.x.width, c.x.height}) {
+		if err := c.setScreenSize(size); err != nil {
+			return err
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(want)) {
+		if cfg := want[id]; cfg.Mode != 0 && !cfg.same(r.crtcs[id]) {
+			if err := c.setCrtc(r, id, cfg); err != nil {
+				return err
+			}
+		}
+	}
+	if primary != 0 {
+		if err := c.send(randrSetOutputPrimary, le32(c.x.root, primary)); err != nil {
+			return fmt.Errorf("x11: %w", err)
+		}
+	}
+	if err := c.x.sync(); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
+
+// same reports whether two CRTC configs show the same thing.
+func (a crtcConfig) same(b crtcConfig) bool {
+	return a.X == b.X && a.Y == b.Y && a.Mode == b.Mode && a.Rotation == b.Rotation && a.From == b.From &&
+		slices.Equal(a.Outputs, b.Outputs)
+}
+
+// setCrtc configures a CRTC; the zero mode disables it. A CRTC set to a
+// mode gets the scaling of the config, or none, as its transform.
+func (c *randrClient) setCrtc(r *randrResources, id uint32, cfg crtcConfig) error {
+	if cfg.Mode != 0 {
+		if err := c.setTransform(r, id, cfg); err != nil {
+			return err
+		}
+	}
+	body := le32(id, 0, r.configTime)
+	body = binary.LittleEndian.AppendUint16(body, uint16(int16(cfg.X)))
+	body = binary.LittleEndian.AppendUint16(body, uint16(int16(cfg.Y)))
+	body = binary.LittleEndian.AppendUint32(body, cfg.Mode)
+	body = binary.LittleEndian.AppendUint16(body, cfg.Rotation)
+	body = append(body, 0, 0)
+	b, err := c.call(randrSetCrtcConfig, append(body, le32(cfg.Outputs...)...))
+	if err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	switch b[1] {
+	case 0:
+		return nil
+	case 1, 2:
+		return errors.New("x11: the screen changed while being set up")
+	}
+	return fmt.Errorf("x11: setting up CRTC %#x failed", id)
+}
+
+// setScreenSize resizes the screen, keeping its DPI, or making it 96 if
+// the screen has no physical size.
+func (c *randrClient) setScreenSize(size resolution) error {
+	mmW, mmH := size.W*254/960, size.H*254/960
+	if c.x.width > 0 && c.x.height > 0 && c.x.widthMM > 0 && c.x.heightMM > 0 {
+		mmW, mmH = size.W*c.x.widthMM/c.x.width, size.H*c.x.heightMM/c.x.height
+	}
+	body := binary.LittleEndian.AppendUint32(nil, c.x.root)
+	body = binary.LittleEndian.AppendUint16(body, uint16(size.W))
+	body = binary.LittleEndian.AppendUint16(body, uint16(size.H))
+	if err := c.send(randrSetScreenSize, append(body, le32(uint32(mmW), uint32(mmH))...)); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	// The request has no reply; make sure it took before the CRTCs are
+	// set against it.
+	if err := c.x.sync(); err != nil {
+		return fmt.Errorf("x11: resizing the screen to %s: %w", size, err)
+	}
+	c.x.width, c.x.height = size.W, size.H
+	return nil
+}
+
+// setTransform has the CRTC's next config scaled as cfg asks. A transform
+// the CRTC already has pending, the identity on drivers that cannot scale
+// among them, is left alone.
+func (c *randrClient) setTransform(r *randrResources, id uint32, cfg crtcConfig) error {
+	req := r.transform(id, cfg)
+	b, err := c.call(randrGetCrtcTransform, le32(id))
+	if err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	if len(b) < 96 {
+		return errShortReply
+	}
+	// The reply starts with the pending transform, then whether the CRTC
+	// can be transformed at all.
+	if bytes.Equal(b[8:44], req[4:40]) {
+		return nil
+	}
+	if b[44] == 0 {
+		return fmt.Errorf("CRTC %#x cannot scale", id)
+	}
+	if err := c.send(randrSetCrtcTransform, req); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
+
+// transform returns the SetCrtcTransform request scaling the CRTC's mode to
+// cover cfg.From, or undoing any scaling if it is zero. Scaled pictures are
+// filtered bilinearly, as xrandr --scale has them.
+func (r *randrResources) transform(id uint32, cfg crtcConfig) []byte {
+	sx, sy, filter := 1.0, 1.0, "nearest"
+	if cfg.From != (resolution{}) {
+		m := r.size(crtcConfig{Mode: cfg.Mode, Rotation: cfg.Rotation})
+		sx, sy = float64(cfg.From.W)/float64(m.W), float64(cfg.From.H)/float64(m.H)
+		if sx != 1 || sy != 1 {
+			filter = "bilinear"
+		}
+	}
+	fixed := func(v float64) uint32 { return uint32(int32(math.Round(v * 65536))) }
+	body := le32(id, fixed(sx), 0, 0, 0, fixed(sy), 0, 0, 0, fixed(1))
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(filter)))
+	body = append(body, 0, 0)
+	return pad4(append(body, filter...))
+}
+
+// randrProperty is an output property: its name and type, and its values as
+// xrandr prints them, or its bytes when it holds 8-bit data, as an EDID
+// does.
+type randrProperty struct {
+	Name   string
+	Type   string
+	Values []string
+	Data   []byte
+}
+
+// propertyValues are the most 32-bit units of a property read, enough for
+// any EDID.
+const propertyValues = 1024
+
+// getProperty reads the output's property.
+func (c *randrClient) getProperty(output, atom uint32) (format byte, typ uint32, data []byte, err error) {
+	body := le32(output, atom, 0, 0, propertyValues)
+	b, err := c.call(randrGetOutputProperty, append(body, 0, 0, 0, 0))
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	format, typ, n := b[1], u32(b[8:]), int(u32(b[16:]))*int(b[1])/8
+	if len(b) < 32+n {
+		return 0, 0, nil, errShortReply
+	}
+	return format, typ, b[32 : 32+n], nil
+}
+
+// properties reads all the output's properties.
+func (c *randrClient) properties(output uint32) ([]randrProperty, error) {
+	b, err := c.call(randrListOutputProperties, le32(output))
+	if err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	n := u16(b[8:])
+	if len(b) < 32+4*n {
+		return nil, errShortReply
+	}
+	var props []randrProperty
+	for _, atom := range ids(b[32:], n) {
+		name, err := c.x.atomName(atom)
+		if err != nil {
+			return nil, fmt.Errorf("x11: %w", err)
+		}
+		format, typ, data, err := c.getProperty(output, atom)
+		if err != nil {
+			return nil, fmt.Errorf("x11: %s: %w", name, err)
+		}
+		p := randrProperty{Name: name}
+		if typ != 0 {
+			if p.Type, err = c.x.atomName(typ); err != nil {
+				return nil, fmt.Errorf("x11: %w", err)
+			}
+		}
+		switch format {
+		case 8:
+			p.Data = data
+		case 16:
+			for i := 0; i+2 <= len(data); i += 2 {
+				p.Values = append(p.Values, strconv.Itoa(int(int16(u16(data[i:])))))
+			}
+		case 32:
+			for _, v := range ids(data, len(data)/4) {
+				switch p.Type {
+				case "ATOM":
+					name, err := c.x.atomName(v)
+					if err != nil {
+						return nil, fmt.Errorf("x11: %w", err)
+					}
+					p.Values = append(p.Values, name)
+				case "CARDINAL":
+					p.Values = append(p.Values, strconv.FormatUint(uint64(v), 10))
+				default:
+					p.Values = append(p.Values, strconv.Itoa(int(int32(v))))
+				}
+			}
+		}
+		props = append(props, p)
+	}
+	return props, nil
+}
+
+// setProperty sets the output's property to value, given as xrandr --set
+// takes it: an atom's name, or integers separated by commas.
+func (c *randrClient) setProperty(r *randrResources, output, name, value string) error {
+	i := slices.IndexFunc(r.outputs, func(o randrOutput) bool { return o.Name == output })
+	if i < 0 {
+		return fmt.Errorf("unknown output %s", output)
+	}
+	id := r.outputs[i].ID
+	atom, err := c.x.atom(name, false)
+	if err != nil {
+		return fmt.Errorf("%s has no property %s", output, name)
+	}
+	format, typ, _, err := c.getProperty(id, atom)
+	if err != nil {
+		return fmt.Errorf("x11: %s: %w", name, err)
+	}
+	if typ == 0 {
+		return fmt.Errorf("%s has no property %s", output, name)
+	}
+	typName, err := c.x.atomName(typ)
+	if err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	var data []byte
+	n := 0
+	if typName == "ATOM" {
+		a, err := c.x.atom(value, true)
+		if err != nil {
+			return fmt.Errorf("x11: %w", err)
+		}
+		data, n, format = le32(a), 1, 32
+	} else {
+		for f := range strings.SplitSeq(value, ",") {
+			v, err := strconv.ParseInt(strings.TrimSpace(f), 0, 64)
+			if err != nil {
+				return fmt.Errorf("%s: bad value %q for %s", output, value, name)
+			}
+			switch format {
+			case 8:
+				data = append(data, byte(v))
+			case 16:
+				data = binary.LittleEndian.AppendUint16(data, uint16(v))
+			default:
+				data, format = binary.LittleEndian.AppendUint32(data, uint32(v)), 32
+			}
+			n++
+		}
+	}
+	body := le32(id, atom, typ)
+	body = append(body, format, 0, 0, 0)
+	if err := c.send(randrChangeOutputProperty, append(append(body, le32(uint32(n))...), data...)); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
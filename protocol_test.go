@@ -0,0 +1,228 @@
+package randr
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// laptopResources are a laptop's RandR resources: the panel lit on the
+// first CRTC, a Dell on HDMI-1 dark, and a second CRTC free.
+func laptopResources() *randrResources {
+	return &randrResources{
+		modes: map[uint32]randrMode{
+			0x4a: {Name: "1920x1080", W: 1920, H: 1080, Rate: 60.01},
+			0x4b: {Name: "1920x1080", W: 1920, H: 1080, Rate: 48},
+			0x50: {Name: "2560x1440", W: 2560, H: 1440, Rate: 59.95},
+			0x51: {Name: "1920x1080", W: 1920, H: 1080, Rate: 60},
+		},
+		outputs: []randrOutput{
+			{ID: 0x42, Name: "eDP-1", CRTC: 0x3f, CRTCs: []uint32{0x3f, 0x40}, Modes: []uint32{0x4a, 0x4b}},
+			{ID: 0x43, Name: "HDMI-1", CRTCs: []uint32{0x3f, 0x40}, Modes: []uint32{0x50, 0x51}},
+			{ID: 0x44, Name: "DP-1", CRTCs: []uint32{0x40}, Modes: []uint32{0x51}},
+		},
+		crtcs: map[uint32]crtcConfig{
+			0x3f: {Mode: 0x4a, Rotation: 1, Outputs: []uint32{0x42}},
+			0x40: {},
+		},
+	}
+}
+
+func TestRandRConfigs(t *testing.T) {
+	lit := func(name string, w, h, x int) outputConfig {
+		return outputConfig{Name: name, outputState: outputState{Mode: resolution{w, h}, X: x}}
+	}
+	for _, tc := range []struct {
+		name    string
+		l       layout
+		want    string
+		primary uint32
+		err     string
+	}{
+		{"extend", layout{{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920, Primary: true}}},
+			"0x40: 2560x1440@59.95+1920+0 r1 [0x43]", 0x43, ""},
+		{"rate", layout{{Name: "eDP-1", outputState: outputState{Mode: resolution{1920, 1080}, Rate: 48}}},
+			"0x3f: 1920x1080@48+0+0 r1 [0x42]", 0, ""},
+		{"preferred rate", layout{lit("HDMI-1", 1920, 1080, 1920)},
+			"0x40: 1920x1080@60+1920+0 r1 [0x43]", 0, ""},
+		{"rotated", layout{{Name: "eDP-1", outputState: outputState{Mode: resolution{1920, 1080}, Rotation: "inverted"}}},
+			"0x3f: 1920x1080@60.01+0+0 r4 [0x42]", 0, ""},
+		{"CRTC handed over", layout{{Name: "eDP-1", outputState: outputState{Off: true}}, lit("HDMI-1", 2560, 1440, 0)},
+			"0x3f: 2560x1440@59.95+0+0 r1 [0x43]", 0, ""},
+		{"off", layout{{Name: "eDP-1", outputState: outputState{Off: true}}}, "0x3f: off", 0, ""},
+		{"no CRTC left", layout{lit("HDMI-1", 2560, 1440, 1920), lit("DP-1", 1920, 1080, 4480)}, "", 0, "DP-1: no free CRTC"},
+		{"no such mode", layout{lit("HDMI-1", 3840, 2160, 1920)}, "", 0, "HDMI-1 has no mode 3840x2160"},
+		{"no such output", layout{lit("DP-9", 1920, 1080, 0)}, "", 0, "unknown output DP-9"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := laptopResources()
+			want, primary, err := r.configs(tc.l)
+			if tc.err != "" {
+				if err == nil || err.Error() != tc.err {
+					t.Errorf("error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, id := range slices.Sorted(maps.Keys(want)) {
+				c := want[id]
+				if c.Mode == 0 {
+					got = append(got, fmt.Sprintf("%#x: off", id))
+					continue
+				}
+				m := r.modes[c.Mode]
+				got = append(got, fmt.Sprintf("%#x: %s@%g+%d+%d r%d %#x", id, m.Name, m.Rate, c.X, c.Y, c.Rotation, c.Outputs))
+			}
+			if s := strings.Join(got, ", "); s != tc.want {
+				t.Errorf("got  %s\nwant %s", s, tc.want)
+			}
+			if primary != tc.primary {
+				t.Errorf("primary %#x, want %#x", primary, tc.primary)
+			}
+		})
+	}
+}
+
+func TestRandRSize(t *testing.T) {
+	r := laptopResources()
+	if got := r.size(crtcConfig{Mode: 0x50, Rotation: randrRotations["normal"]}); got != (resolution{2560, 1440}) {
+		t.Errorf("normal: %s", got)
+	}
+	if got := r.size(crtcConfig{Mode: 0x50, Rotation: randrRotations["right"]}); got != (resolution{1440, 2560}) {
+		t.Errorf("right: %s", got)
+	}
+}
+
+func TestLE32(t *testing.T) {
+	b := le32(0x4ee, 1)
+	if fmt.Sprintf("%x", b) != "ee04000001000000" {
+		t.Errorf("got %x", b)
+	}
+	if got := ids(b, 2); !slices.Equal(got, []uint32{0x4ee, 1}) {
+		t.Errorf("ids %#x", got)
+	}
+}
+
+func TestRandRQuery(t *testing.T) {
+	r := laptopResources()
+	r.min, r.max = resolution{320, 200}, resolution{16384, 16384}
+	r.sizes = map[uint32]resolution{0x3f: {1080, 1920}}
+	r.crtcs[0x3f] = crtcConfig{Mode: 0x4a, Rotation: randrRotations["left"], Outputs: []uint32{0x42}}
+	edid, _ := hex.DecodeString(dellEDID)
+	r.outputs[0].MM, r.outputs[0].Preferred = resolution{344, 194}, 1
+	r.outputs[0].Props = []randrProperty{{Name: "non-desktop", Type: "INTEGER", Values: []string{"0"}}}
+	r.outputs[1].Preferred = 1
+	r.outputs[1].Props = []randrProperty{
+		{Name: "EDID", Type: "INTEGER", Data: edid},
+		{Name: "Broadcast RGB", Type: "ATOM", Values: []string{"Automatic"}},
+	}
+	r.outputs[2].Connection = randrDisconnected
+
+	data := r.query(0, resolution{1080, 1920}, 0x42)
+	outputs, scr := parseQuery(context.Background(), data)
+	if scr != (screen{Min: resolution{320, 200}, Current: resolution{1080, 1920}, Max: resolution{16384, 16384}}) {
+		t.Errorf("screen %+v", scr)
+	}
+	if len(outputs) != 3 {
+		t.Fatalf("parsed %d outputs from\n%s", len(outputs), data)
+	}
+	edp, hdmi, dp := outputs[0], outputs[1], outputs[2]
+	if !edp.Connected || !edp.Primary || edp.Geometry != (resolution{1080, 1920}) || edp.Rotation != "left" ||
+		edp.Physical != (resolution{344, 194}) || edp.Rate != 60.01 || edp.Props["non-desktop"] != "0" {
+		t.Errorf("eDP-1 %+v", edp)
+	}
+	// The panel's two 1920x1080 modes share a line.
+	if len(edp.Resolutions) != 1 || !slices.Equal(edp.Rates[0], []float64{60.01, 48}) || edp.Preferred != 0 || edp.Current != 0 {
+		t.Errorf("eDP-1 modes %v %v", edp.Resolutions, edp.Rates)
+	}
+	if !hdmi.Connected || hdmi.active() || hdmi.Monitor.String() != "DEL-A0B8-718NY83" || hdmi.Props["Broadcast RGB"] != "Automatic" ||
+		hdmi.Preferred != 0 || len(hdmi.Resolutions) != 2 {
+		t.Errorf("HDMI-1 %+v", hdmi)
+	}
+	if dp.Connected || dp.CRTC {
+		t.Errorf("DP-1 %+v", dp)
+	}
+}
+
+func TestLayoutArgs(t *testing.T) {
+	l := layout{
+		{Name: "eDP-1", outputState: outputState{Off: true}},
+		{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920, Y: -20, Rate: 59.95,
+			ScaleFrom: resolution{3840, 2160}, Rotation: "normal", Primary: true},
+			Props: map[string]string{"Broadcast RGB": "Full", "underscan": "on"}},
+		{Name: "DP-1", outputState: outputState{Mode: resolution{1920, 1080}}, resetScale: true},
+	}
+	args := append([]string{"--fb", "5760x2160"}, l.args()...)
+	got, fb, err := layoutArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l[2].resetScale = false
+	if fb != (resolution{5760, 2160}) || !reflect.DeepEqual(got, l) {
+		t.Errorf("got %+v, %s", got, fb)
+	}
+
+	for _, bad := range [][]string{
+		{"--mode", "1920x1080"},
+		{"--output", "DP-1", "--scale", "2x2"},
+		{"--output", "DP-1", "--rotate", "sideways"},
+		{"--output", "DP-1", "--pos", "1920"},
+		{"--output", "DP-1", "--brightness", "0.5"},
+		{"--output", "DP-1", "--set", "audio"},
+	} {
+		if _, _, err := layoutArgs(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestRandRTransform(t *testing.T) {
+	r := laptopResources()
+	// 2560x1440 scaled up to cover 3840x2160 is 1.5 times each way.
+	got := hex.EncodeToString(r.transform(0x40, crtcConfig{Mode: 0x50, Rotation: 1, From: resolution{3840, 2160}}))
+	want := "40000000" + "00800100000000000000000000000000" + "0080010000000000000000000000000000000100" + "0800000062696c696e656172"
+	if got != want {
+		t.Errorf("scaled:\ngot  %s\nwant %s", got, want)
+	}
+	if got := r.transform(0x40, crtcConfig{Mode: 0x50, Rotation: 1}); !strings.HasSuffix(string(got), "nearest\x00") {
+		t.Errorf("unscaled: %q", got)
+	}
+}
+
+func TestListProviders(t *testing.T) {
+	data := listProviders([]randrProvider{
+		{ID: 0x47, Name: "modesetting", Caps: 0xf, CRTCs: 4, Outputs: 5, Associated: 1},
+		{ID: 0x1b8, Name: "DisplayLink", Caps: 0x2, CRTCs: 1, Outputs: 1},
+	})
+	got := parseProviders(data)
+	want := []provider{
+		{ID: "0x47", Name: "modesetting", Caps: []string{"Source Output", "Sink Output", "Source Offload", "Sink Offload"}, Associated: 1},
+		{ID: "0x1b8", Name: "DisplayLink", Caps: []string{"Sink Output"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v from\n%s", got, data)
+	}
+}
+
+func TestRandRListMonitors(t *testing.T) {
+	r := laptopResources()
+	data := r.listMonitors([]randrMonitorInfo{
+		{Name: "eDP-1", Automatic: true, Primary: true, W: 1920, H: 1080, MMW: 344, MMH: 194, Outputs: []uint32{0x42}},
+		{Name: "desk", X: 1920, W: 5120, H: 1440, MMW: 1194, MMH: 336, Outputs: []uint32{0x43, 0x44}},
+	})
+	want := []randrMonitor{
+		{Name: "eDP-1", Automatic: true, Primary: true, Geometry: "1920/344x1080/194+0+0", Outputs: []string{"eDP-1"}},
+		{Name: "desk", Geometry: "5120/1194x1440/336+1920+0", Outputs: []string{"HDMI-1", "DP-1"}},
+	}
+	if got := parseMonitors(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v from\n%s", got, data)
+	}
+}
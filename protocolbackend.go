@@ -0,0 +1,579 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// protocolBackend talks to the X server's RandR extension itself in place
+// of the xrandr binary. It gives what xrandr prints, so the parsing stays
+// the same, and takes xrandr's arguments.
+type protocolBackend struct{}
+
+// useProtocol reports whether the settings have randr speak the RandR
+// protocol rather than run xrandr.
+func useProtocol(ctx context.Context) bool {
+	return settingsFrom(ctx).applyWith == applyProtocol
+}
+
+// Query returns the outputs as `xrandr --query --prop` prints them. Like
+// xrandr, it has the server probe for monitors plugged in or out first.
+func (protocolBackend) Query(ctx context.Context) ([]byte, error) {
+	var out []byte
+	err := withRandR(ctx, func(c *randrClient) error {
+		r, err := c.resources(true)
+		if err != nil {
+			return err
+		}
+		for i := range r.outputs {
+			if r.outputs[i].Props, err = c.properties(r.outputs[i].ID); err != nil {
+				return err
+			}
+		}
+		b, err := c.call(randrGetOutputPrimary, le32(c.x.root))
+		if err != nil {
+			return fmt.Errorf("x11: %w", err)
+		}
+		out = r.query(c.x.screen, resolution{c.x.width, c.x.height}, u32(b[8:]))
+		return nil
+	})
+	return out, err
+}
+
+func (protocolBackend) Configure(ctx context.Context, args []string) error {
+	return withRandR(ctx, func(c *randrClient) error { return c.run(args) })
+}
+
+// ListMonitors returns the monitors as `xrandr --listmonitors` prints them.
+func (protocolBackend) ListMonitors(ctx context.Context) ([]byte, error) {
+	var out []byte
+	err := withRandR(ctx, func(c *randrClient) error {
+		r, err := c.resources(false)
+		if err != nil {
+			return err
+		}
+		mons, err := c.monitors()
+		if err != nil {
+			return err
+		}
+		out = r.listMonitors(mons)
+		return nil
+	})
+	return out, err
+}
+
+// Providers returns the providers as `xrandr --listproviders` prints them.
+func (protocolBackend) Providers(ctx context.Context) ([]byte, error) {
+	var out []byte
+	err := withRandR(ctx, func(c *randrClient) error {
+		ps, err := c.providers()
+		if err != nil {
+			return err
+		}
+		out = listProviders(ps)
+		return nil
+	})
+	return out, err
+}
+
+// randrRotationNames are the names of the rotation bits.
+var randrRotationNames = map[uint16]string{1: "normal", 2: "left", 4: "inverted", 8: "right"}
+
+// query renders the resources, with the outputs' properties, as
+// `xrandr --query --prop` prints them.
+func (r *randrResources) query(screen int, current resolution, primary uint32) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Screen %d: minimum %d x %d, current %d x %d, maximum %d x %d\n",
+		screen, r.min.W, r.min.H, current.W, current.H, r.max.W, r.max.H)
+	for _, o := range r.outputs {
+		b.WriteString(o.Name)
+		switch o.Connection {
+		case randrConnected:
+			b.WriteString(" connected")
+		case randrDisconnected:
+			b.WriteString(" disconnected")
+		default:
+			b.WriteString(" unknown connection")
+		}
+		if o.ID == primary {
+			b.WriteString(" primary")
+		}
+		crtc, ok := r.crtcs[o.CRTC]
+		lit := ok && crtc.Mode != 0
+		if lit {
+			s := r.sizes[o.CRTC]
+			fmt.Fprintf(&b, " %dx%d+%d+%d", s.W, s.H, crtc.X, crtc.Y)
+			if name := randrRotationNames[crtc.Rotation&0xf]; name != "normal" {
+				b.WriteString(" " + name)
+			}
+		}
+		b.WriteString(" (normal left inverted right x axis y axis)")
+		if lit {
+			fmt.Fprintf(&b, " %dmm x %dmm", o.MM.W, o.MM.H)
+		}
+		b.WriteByte('\n')
+
+		for _, p := range o.Props {
+			fmt.Fprintf(&b, "\t%s: ", p.Name)
+			if p.Data == nil {
+				fmt.Fprintf(&b, "%s \n", strings.Join(p.Values, ", "))
+				continue
+			}
+			for line := range slices.Chunk(p.Data, 16) {
+				fmt.Fprintf(&b, "\n\t\t%x", line)
+			}
+			b.WriteByte('\n')
+		}
+
+		// Modes of the same name share a line, one rate after the other,
+		// marked * when current and + when preferred.
+		done := make([]bool, len(o.Modes))
+		for i, id := range o.Modes {
+			if done[i] {
+				continue
+			}
+			name := r.modes[id].Name
+			fmt.Fprintf(&b, "   %-12s", name)
+			for j := i; j < len(o.Modes); j++ {
+				m := r.modes[o.Modes[j]]
+				if done[j] || m.Name != name {
+					continue
+				}
+				done[j] = true
+				cur, pref := ' ', ' '
+				if lit && o.Modes[j] == crtc.Mode {
+					cur = '*'
+				}
+				if j < o.Preferred {
+					pref = '+'
+				}
+				fmt.Fprintf(&b, " %6.2f%c%c", m.Rate, cur, pref)
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+// randrMonitorInfo is a monitor as GetMonitors reports it.
+type randrMonitorInfo struct {
+	Name               string
+	Primary, Automatic bool
+	X, Y, W, H         int
+	MMW, MMH           int
+	Outputs            []uint32
+}
+
+// monitors lists the monitors, which RandR has from 1.5.
+func (c *randrClient) monitors() ([]randrMonitorInfo, error) {
+	if c.minor < 5 {
+		return nil, fmt.Errorf("x11: RandR 1.%d has no monitors, 1.5 is needed", c.minor)
+	}
+	b, err := c.call(randrGetMonitors, le32(c.x.root, 0))
+	if err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	n := int(u32(b[12:]))
+	var mons []randrMonitorInfo
+	for m := b[32:]; len(mons) < n; {
+		if len(m) < 24 {
+			return nil, errShortReply
+		}
+		noutputs := u16(m[6:])
+		if len(m) < 24+4*noutputs {
+			return nil, errShortReply
+		}
+		name, err := c.x.atomName(u32(m))
+		if err != nil {
+			return nil, fmt.Errorf("x11: %w", err)
+		}
+		mons = append(mons, randrMonitorInfo{
+			Name:      name,
+			Primary:   m[4] != 0,
+			Automatic: m[5] != 0,
+			X:         int(int16(u16(m[8:]))),
+			Y:         int(int16(u16(m[10:]))),
+			W:         u16(m[12:]),
+			H:         u16(m[14:]),
+			MMW:       int(u32(m[16:])),
+			MMH:       int(u32(m[20:])),
+			Outputs:   ids(m[24:], noutputs),
+		})
+		m = m[24+4*noutputs:]
+	}
+	return mons, nil
+}
+
+// listMonitors renders the monitors as `xrandr --listmonitors` prints them.
+func (r *randrResources) listMonitors(mons []randrMonitorInfo) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Monitors: %d\n", len(mons))
+	for i, m := range mons {
+		var flags string
+		if m.Automatic {
+			flags += "+"
+		}
+		if m.Primary {
+			flags += "*"
+		}
+		fmt.Fprintf(&b, " %d: %s%s %d/%dx%d/%d%+d%+d ", i, flags, m.Name, m.W, m.MMW, m.H, m.MMH, m.X, m.Y)
+		for _, id := range m.Outputs {
+			if i := slices.IndexFunc(r.outputs, func(o randrOutput) bool { return o.ID == id }); i >= 0 {
+				b.WriteString(" " + r.outputs[i].Name)
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// randrProvider is a provider as GetProviderInfo reports it.
+type randrProvider struct {
+	ID             uint32
+	Name           string
+	Caps           uint32
+	CRTCs, Outputs int
+	Associated     int
+}
+
+// providerCaps are the names of the provider capability bits, as xrandr
+// prints them.
+var providerCaps = []string{"Source Output", "Sink Output", "Source Offload", "Sink Offload"}
+
+// providerList reads the providers and the time their configuration last
+// changed, which RandR has from 1.4.
+func (c *randrClient) providerList() ([]uint32, uint32, error) {
+	if c.minor < 4 {
+		return nil, 0, fmt.Errorf("x11: RandR 1.%d has no providers, 1.4 is needed", c.minor)
+	}
+	b, err := c.call(randrGetProviders, le32(c.x.root))
+	if err != nil {
+		return nil, 0, fmt.Errorf("x11: %w", err)
+	}
+	n := u16(b[12:])
+	if len(b) < 32+4*n {
+		return nil, 0, errShortReply
+	}
+	return ids(b[32:], n), u32(b[8:]), nil
+}
+
+// providers lists the providers.
+func (c *randrClient) providers() ([]randrProvider, error) {
+	list, timestamp, err := c.providerList()
+	if err != nil {
+		return nil, err
+	}
+	var ps []randrProvider
+	for _, id := range list {
+		b, err := c.call(randrGetProviderInfo, le32(id, timestamp))
+		if err != nil {
+			return nil, fmt.Errorf("x11: %w", err)
+		}
+		ncrtcs, noutputs, nassoc, nname := u16(b[16:]), u16(b[18:]), u16(b[20:]), u16(b[22:])
+		off := 32 + 4*(ncrtcs+noutputs+2*nassoc)
+		if len(b) < off+nname {
+			return nil, errShortReply
+		}
+		ps = append(ps, randrProvider{
+			ID:         id,
+			Name:       string(b[off : off+nname]),
+			Caps:       u32(b[12:]),
+			CRTCs:      ncrtcs,
+			Outputs:    noutputs,
+			Associated: nassoc,
+		})
+	}
+	return ps, nil
+}
+
+// listProviders renders the providers as `xrandr --listproviders` prints
+// them.
+func listProviders(ps []randrProvider) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Providers: number : %d\n", len(ps))
+	for i, p := range ps {
+		fmt.Fprintf(&b, "Provider %d: id: %#x cap: %#x", i, p.ID, p.Caps)
+		for bit, name := range providerCaps {
+			if p.Caps&(1<<bit) != 0 {
+				b.WriteString(", " + name)
+			}
+		}
+		fmt.Fprintf(&b, " crtcs: %d outputs: %d associated providers: %d name:%s\n", p.CRTCs, p.Outputs, p.Associated, p.Name)
+	}
+	return b.Bytes()
+}
+
+// run carries out an xrandr command line, one of those randr makes.
+func (c *randrClient) run(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	want := map[string]int{"--newmode": 11, "--addmode": 3, "--setmonitor": 4, "--delmonitor": 2, "--setprovideroutputsource": 3}
+	if n, ok := want[args[0]]; ok && len(args) < n {
+		return fmt.Errorf("%s: missing arguments", args[0])
+	}
+	switch args[0] {
+	case "--newmode":
+		return c.newMode(args[1], args[2:])
+	case "--addmode":
+		return c.addMode(args[1], args[2])
+	case "--setmonitor":
+		return c.setMonitor(args[1], args[2], args[3])
+	case "--delmonitor":
+		return c.deleteMonitor(args[1])
+	case "--setprovideroutputsource":
+		return c.setProviderSource(args[1], args[2])
+	}
+	l, fb, err := layoutArgs(args)
+	if err != nil {
+		return err
+	}
+	return c.apply(l, fb)
+}
+
+// layoutArgs reads the layout, and the screen size given with --fb if any,
+// back from the xrandr arguments plan.args makes.
+func layoutArgs(args []string) (layout, resolution, error) {
+	var l layout
+	var fb resolution
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--fb" || arg == "--output" {
+			if i+1 >= len(args) {
+				return nil, fb, fmt.Errorf("%s: missing argument", arg)
+			}
+			i++
+			var err error
+			if arg == "--fb" {
+				fb, err = parseResolution(args[i])
+			} else {
+				l = append(l, outputConfig{Name: args[i]})
+			}
+			if err != nil {
+				return nil, fb, err
+			}
+			continue
+		}
+		if len(l) == 0 {
+			return nil, fb, fmt.Errorf("%s before --output", arg)
+		}
+		c := &l[len(l)-1]
+		switch arg {
+		case "--off":
+			c.Off = true
+			continue
+		case "--primary":
+			c.Primary = true
+			continue
+		}
+		n := 1
+		if arg == "--set" {
+			n = 2
+		}
+		if i+n >= len(args) {
+			return nil, fb, fmt.Errorf("%s: missing argument", arg)
+		}
+		v := args[i+1]
+		i += n
+		var err error
+		switch arg {
+		case "--mode":
+			c.Mode, err = parseResolution(v)
+		case "--pos":
+			x, y, _ := strings.Cut(v, "x")
+			var err2 error
+			c.X, err = strconv.Atoi(x)
+			c.Y, err2 = strconv.Atoi(y)
+			if err == nil && err2 != nil {
+				err = err2
+			}
+		case "--rate":
+			c.Rate, err = strconv.ParseFloat(v, 64)
+		case "--scale-from":
+			c.ScaleFrom, err = parseResolution(v)
+		case "--scale":
+			if v != "1x1" {
+				err = fmt.Errorf("--scale %s: only 1x1 is supported", v)
+			}
+		case "--rotate":
+			if _, ok := randrRotations[v]; !ok {
+				err = fmt.Errorf("bad rotation %q", v)
+			}
+			c.Rotation = v
+		case "--set":
+			if c.Props == nil {
+				c.Props = make(map[string]string)
+			}
+			c.Props[v] = args[i]
+		default:
+			return nil, fb, fmt.Errorf("unsupported xrandr argument %s", arg)
+		}
+		if err != nil {
+			return nil, fb, fmt.Errorf("%s: %w", c.Name, err)
+		}
+	}
+	return l, fb, nil
+}
+
+// modeFlags are the mode flags xrandr --newmode takes.
+var modeFlags = map[string]uint32{
+	"+hsync": 0x1, "-hsync": 0x2, "+vsync": 0x4, "-vsync": 0x8,
+	"interlace": 0x10, "doublescan": 0x20, "+csync": 0x40, "-csync": 0x80,
+}
+
+// newMode creates a mode from an xrandr modeline: the clock in MHz, the
+// horizontal and vertical timings, and flags.
+func (c *randrClient) newMode(name string, line []string) error {
+	clock, err := strconv.ParseFloat(line[0], 64)
+	if err != nil {
+		return fmt.Errorf("mode %s: bad clock %q", name, line[0])
+	}
+	var timing [8]uint16
+	for i := range timing {
+		v, err := strconv.Atoi(line[1+i])
+		if err != nil || v < 0 || v > math.MaxUint16 {
+			return fmt.Errorf("mode %s: bad timing %q", name, line[1+i])
+		}
+		timing[i] = uint16(v)
+	}
+	var flags uint32
+	for _, f := range line[9:] {
+		bit, ok := modeFlags[strings.ToLower(f)]
+		if !ok {
+			return fmt.Errorf("mode %s: bad flag %q", name, f)
+		}
+		flags |= bit
+	}
+	// The mode info: id, width, height, clock, the horizontal sync
+	// start, end and total, skew, the vertical sync start, end and total,
+	// the name's length and the flags.
+	info := le32(c.x.root, 0)
+	info = binary.LittleEndian.AppendUint16(info, timing[0])
+	info = binary.LittleEndian.AppendUint16(info, timing[4])
+	info = binary.LittleEndian.AppendUint32(info, uint32(math.Round(clock*1e6)))
+	for _, v := range []uint16{timing[1], timing[2], timing[3], 0, timing[5], timing[6], timing[7], uint16(len(name))} {
+		info = binary.LittleEndian.AppendUint16(info, v)
+	}
+	info = binary.LittleEndian.AppendUint32(info, flags)
+	if _, err := c.call(randrCreateMode, append(info, name...)); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
+
+// addMode adds the mode of the name to the output's modes.
+func (c *randrClient) addMode(output, name string) error {
+	r, err := c.resources(false)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(r.outputs, func(o randrOutput) bool { return o.Name == output })
+	if i < 0 {
+		return fmt.Errorf("unknown output %s", output)
+	}
+	var mode uint32
+	for id, m := range r.modes {
+		if m.Name == name {
+			mode = id
+			break
+		}
+	}
+	if mode == 0 {
+		return fmt.Errorf("unknown mode %s", name)
+	}
+	if err := c.send(randrAddOutputMode, le32(r.outputs[i].ID, mode)); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	if err := c.x.sync(); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
+
+// setMonitor sets up a monitor as xrandr --setmonitor does, from a geometry
+// "W/MMWxH/MMH+X+Y" and the outputs, comma separated or "none".
+func (c *randrClient) setMonitor(name, geometry, outputs string) error {
+	m := randrMonitorInfo{Name: name}
+	if _, err := fmt.Sscanf(geometry, "%d/%dx%d/%d+%d+%d", &m.W, &m.MMW, &m.H, &m.MMH, &m.X, &m.Y); err != nil {
+		return fmt.Errorf("monitor %s: bad geometry %q", name, geometry)
+	}
+	if c.minor < 5 {
+		return fmt.Errorf("x11: RandR 1.%d has no monitors, 1.5 is needed", c.minor)
+	}
+	r, err := c.resources(false)
+	if err != nil {
+		return err
+	}
+	if outputs != "none" {
+		for out := range strings.SplitSeq(outputs, ",") {
+			i := slices.IndexFunc(r.outputs, func(o randrOutput) bool { return o.Name == out })
+			if i < 0 {
+				return fmt.Errorf("monitor %s: unknown output %s", name, out)
+			}
+			m.Outputs = append(m.Outputs, r.outputs[i].ID)
+		}
+	}
+	atom, err := c.x.atom(name, true)
+	if err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	body := le32(c.x.root, atom)
+	body = append(body, 0, 0)
+	for _, v := range []int{len(m.Outputs), m.X, m.Y, m.W, m.H} {
+		body = binary.LittleEndian.AppendUint16(body, uint16(v))
+	}
+	body = append(body, le32(uint32(m.MMW), uint32(m.MMH))...)
+	if err := c.send(randrSetMonitor, append(body, le32(m.Outputs...)...)); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	if err := c.x.sync(); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
+
+// deleteMonitor removes the monitor set up with the name.
+func (c *randrClient) deleteMonitor(name string) error {
+	if c.minor < 5 {
+		return fmt.Errorf("x11: RandR 1.%d has no monitors, 1.5 is needed", c.minor)
+	}
+	atom, err := c.x.atom(name, false)
+	if err != nil {
+		return fmt.Errorf("no monitor %s", name)
+	}
+	if err := c.send(randrDeleteMonitor, le32(c.x.root, atom)); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	if err := c.x.sync(); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
+
+// setProviderSource has the source provider feed the provider's outputs,
+// both given by ID; source 0 unlinks them.
+func (c *randrClient) setProviderSource(provider, source string) error {
+	p, err1 := strconv.ParseUint(provider, 0, 32)
+	s, err2 := strconv.ParseUint(source, 0, 32)
+	if err := errors.Join(err1, err2); err != nil {
+		return fmt.Errorf("providers: bad ID: %w", err)
+	}
+	_, timestamp, err := c.providerList()
+	if err != nil {
+		return err
+	}
+	if err := c.send(randrSetProviderOutputSource, le32(uint32(p), uint32(s), timestamp)); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	if err := c.x.sync(); err != nil {
+		return fmt.Errorf("x11: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// providerSettleDelay replaces verifyDelay once an output source has been
+// set up for a provider: USB adapters such as DisplayLink take noticeably
+// longer than a GPU's own outputs to light up.
+const providerSettleDelay = 2 * time.Second
+
+// modelessRetries is how many polls an output connected without modes is
+// given to report them before it is planned for anyway.
+const modelessRetries = 5
+
+// modelessQueries and modelessQueryDelay bound the immediate re-queries
+// while a newly connected output has no modes yet, as its EDID is usually
+// read within a second of the hotplug.
+const (
+	modelessQueries    = 3
+	modelessQueryDelay = 300 * time.Millisecond
+)
+
+// drmCardGlob lists the DRM devices; a USB display adapter adds one when it
+// is plugged in.
+var drmCardGlob = "/dev/dri/card*"
+
+// providerLister is implemented by backends that can list the RandR
+// providers, as `xrandr --listproviders` does.
+type providerLister interface {
+	Providers(ctx context.Context) ([]byte, error)
+}
+
+func (xrandrBackend) Providers(ctx context.Context) ([]byte, error) {
+	if useProtocol(ctx) {
+		return protocolBackend{}.Providers(ctx)
+	}
+	return runXrandr(ctx, true, []string{"--listproviders"}, nil)
+}
+
+// provider is a RandR provider: a GPU or a USB display adapter.
+type provider struct {
+	ID   string
+	Name string
+	Caps []string
+	// Associated counts the providers this one is linked with.
+	Associated int
+}
+
+func (p provider) can(c string) bool {
+	return slices.Contains(p.Caps, c)
+}
+
+var providerRe = regexp.MustCompile(`^Provider \d+: id: (0x[0-9a-f]+) cap: 0x[0-9a-f]+,?(.*?) crtcs: \d+ outputs: \d+ associated providers: (\d+) name:\s*(.*)$`)
+
+// parseProviders parses the output of `xrandr --listproviders`.
+func parseProviders(data []byte) []provider {
+	var providers []provider
+	for _, line := range strings.Split(string(data), "\n") {
+		m := providerRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		p := provider{ID: m[1], Name: m[4]}
+		for _, c := range strings.Split(m[2], ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				p.Caps = append(p.Caps, c)
+			}
+		}
+		p.Associated, _ = strconv.Atoi(m[3])
+		providers = append(providers, p)
+	}
+	return providers
+}
+
+// linkProviders has the source provider feed every other provider that can
+// sink output and is not linked yet: a DisplayLink adapter, or the second
+// GPU of a hybrid laptop whose external ports are wired to it. Without that
+// link their outputs never show up. The source is the provider named by
+// source (by name or ID), or with "auto" the first one that can source
+// output, normally the GPU X runs on. It returns the providers fed by the
+// source; any at all call for a longer settle time.
+func linkProviders(ctx context.Context, b Backend, source string) ([]provider, error) {
+	lister, ok := b.(providerLister)
+	if !ok {
+		return nil, nil
+	}
+	data, err := lister.Providers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	providers := parseProviders(data)
+	for _, p := range providers {
+		tracef(ctx, "provider %s %q: %s, %d associated", p.ID, p.Name, strings.Join(p.Caps, ", "), p.Associated)
+	}
+	if len(providers) < 2 {
+		return nil, nil
+	}
+	i := slices.IndexFunc(providers, func(p provider) bool {
+		if source == "" || source == "auto" {
+			return p.can("Source Output")
+		}
+		return p.Name == source || p.ID == source
+	})
+	if i < 0 {
+		if source == "" || source == "auto" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("no provider %q", source)
+	}
+	src := providers[i]
+	var sinks []provider
+	for _, p := range providers {
+		if p.ID == src.ID || !p.can("Sink Output") {
+			continue
+		}
+		if p.Associated == 0 {
+			infof(ctx, "providers: feeding %s (%s) from %s (%s)", p.ID, p.Name, src.ID, src.Name)
+			if err := b.Configure(ctx, []string{"--setprovideroutputsource", p.ID, src.ID}); err != nil {
+				return sinks, err
+			}
+		}
+		sinks = append(sinks, p)
+	}
+	return sinks, nil
+}
+
+// drmCards counts the DRM devices.
+func drmCards() int {
+	cards, _ := filepath.Glob(drmCardGlob)
+	return len(cards)
+}
+
+// requeryModeless queries the outputs again, a few times and shortly after
+// each other, while a fresh output, one that just got connected, reports no
+// modes.
+func requeryModeless(ctx context.Context, b Backend, fresh func(string) bool, outputs []output, scr screen) ([]output, screen, error) {
+	for range modelessQueries {
+		i := slices.IndexFunc(outputs, func(o output) bool {
+			return o.Connected && fresh(o.Name) && len(o.Resolutions) == 0
+		})
+		if i < 0 {
+			break
+		}
+		debugf(ctx, "%s connected without modes, querying again", outputs[i].Name)
+		select {
+		case <-time.After(modelessQueryDelay):
+		case <-ctx.Done():
+			return nil, screen{}, ctx.Err()
+		}
+		var err error
+		if outputs, scr, err = queryOutputs(ctx, b); err != nil {
+			return nil, screen{}, err
+		}
+	}
+	return outputs, scr, nil
+}
@@ -0,0 +1,158 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// quirk works around a monitor model that misreports itself.
+type quirk struct {
+	// IgnoreModes are modes the monitor lists but cannot show, as "WxH"
+	// or "WxH@rate", e.g. "3840x2160@30".
+	IgnoreModes []string `json:"ignore_modes,omitempty"`
+	// Preferred is the mode to prefer over the one the monitor marks.
+	Preferred string `json:"preferred,omitempty"`
+	// Settle is how long the monitor takes to light up after a layout
+	// change, e.g. "2s".
+	Settle duration `json:"settle,omitempty"`
+}
+
+// builtinQuirks are the quirks randr knows about, keyed like the config's:
+// by "VENDOR-PRODUCT" as in the first two parts of an EDID fingerprint, or
+// a pattern. The config's quirks take precedence. Add models here as they
+// are reported and confirmed.
+var builtinQuirks = map[string]quirk{}
+
+// model returns the "VENDOR-PRODUCT" key of the output's monitor, or "" if
+// it has no EDID.
+func (o output) model() string {
+	if o.Monitor.Vendor == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s-%04X", o.Monitor.Vendor, o.Monitor.Product)
+}
+
+// quirk returns the quirk for the output's monitor model: the one keyed by
+// its model, else the first matching pattern in key order.
+func (s *settings) quirk(o output) (quirk, bool) {
+	m := o.model()
+	if m == "" {
+		return quirk{}, false
+	}
+	if q, ok := s.quirks[m]; ok {
+		return q, true
+	}
+	for _, key := range slices.Sorted(maps.Keys(s.quirks)) {
+		if isPattern(key) && matchesOutput(key, o) {
+			return s.quirks[key], true
+		}
+	}
+	return quirk{}, false
+}
+
+// parseModeRate parses a "WxH" or "WxH@rate" mode; rate is 0 without one.
+func parseModeRate(s string) (resolution, float64, error) {
+	mode, rate, ok := strings.Cut(s, "@")
+	res, err := parseResolution(mode)
+	if err != nil || !ok {
+		return res, 0, err
+	}
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil || r <= 0 {
+		return res, 0, fmt.Errorf("bad rate in %q", s)
+	}
+	return res, r, nil
+}
+
+// validate checks the quirk's modes.
+func (q quirk) validate() error {
+	for _, m := range q.IgnoreModes {
+		if _, _, err := parseModeRate(m); err != nil {
+			return err
+		}
+	}
+	if q.Preferred != "" {
+		if _, err := parseResolution(q.Preferred); err != nil {
+			return err
+		}
+	}
+	if q.Settle < 0 {
+		return fmt.Errorf("negative settle")
+	}
+	return nil
+}
+
+// applyQuirks corrects the connected outputs' modes by their quirks.
+func applyQuirks(ctx context.Context, outputs []output) {
+	s := settingsFrom(ctx)
+	for i := range outputs {
+		o := &outputs[i]
+		q, ok := s.quirk(*o)
+		if !o.Connected || !ok {
+			continue
+		}
+		for _, m := range q.IgnoreModes {
+			res, rate, _ := parseModeRate(m)
+			o.dropMode(ctx, res, rate)
+		}
+		if q.Preferred != "" {
+			res, _ := parseResolution(q.Preferred)
+			if j := slices.Index(o.Resolutions, res); j >= 0 && j != o.Preferred {
+				debugf(ctx, "quirk: %s (%s) prefers %s", o.Name, o.model(), res)
+				o.Preferred = j
+			}
+		}
+	}
+}
+
+// dropMode removes a resolution from the output, or only the given refresh
+// rate of it. The mode currently shown is kept.
+func (o *output) dropMode(ctx context.Context, res resolution, rate float64) {
+	i := slices.Index(o.Resolutions, res)
+	if i < 0 || i == o.Current {
+		return
+	}
+	if rate > 0 && i < len(o.Rates) {
+		o.Rates[i] = slices.DeleteFunc(o.Rates[i], func(r float64) bool { return settingsFrom(ctx).rates.sameRate(r, rate) })
+		if len(o.Rates[i]) > 0 {
+			debugf(ctx, "quirk: %s (%s) ignoring %s@%g", o.Name, o.model(), res, rate)
+			return
+		}
+	}
+	debugf(ctx, "quirk: %s (%s) ignoring %s", o.Name, o.model(), res)
+	o.Resolutions = slices.Delete(o.Resolutions, i, i+1)
+	if i < len(o.Rates) {
+		o.Rates = slices.Delete(o.Rates, i, i+1)
+	}
+	for _, idx := range []*int{&o.Preferred, &o.Current} {
+		switch {
+		case *idx == i:
+			*idx = -1
+		case *idx > i:
+			*idx--
+		}
+	}
+}
+
+// settleTime returns how long to wait for the plan to take effect: the
+// settle delay, or longer if a quirk of one of the outputs it sets up says
+// so.
+func settleTime(ctx context.Context, p *plan) time.Duration {
+	s := settingsFrom(ctx)
+	d := s.settleDelay
+	for _, c := range p.layout() {
+		i := slices.IndexFunc(p.outputs, func(o output) bool { return o.Name == c.Name })
+		if i < 0 {
+			continue
+		}
+		if q, ok := s.quirk(p.outputs[i]); ok {
+			d = max(d, time.Duration(q.Settle))
+		}
+	}
+	return d
+}
@@ -0,0 +1,69 @@
+package randr
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestQuirkLookup(t *testing.T) {
+	s := (&config{Quirks: map[string]quirk{
+		"DEL-A0B8": {Preferred: "1920x1080"},
+		"DEL-*":    {Preferred: "1280x720"},
+		"AUS-*":    {Preferred: "800x600"},
+	}}).settings()
+	for _, tc := range []struct {
+		o    output
+		want string
+	}{
+		{output{Name: "HDMI-1", Monitor: monitorID{Vendor: "DEL", Product: 0xa0b8}}, "1920x1080"},
+		{output{Name: "HDMI-1", Monitor: monitorID{Vendor: "DEL", Product: 0x4123}}, "1280x720"},
+		{output{Name: "HDMI-1", Monitor: monitorID{Vendor: "GSM", Product: 0x5b7f}}, ""},
+		{output{Name: "DEL-A0B8"}, ""},
+	} {
+		q, ok := s.quirk(tc.o)
+		if ok != (tc.want != "") || q.Preferred != tc.want {
+			t.Errorf("quirk for %q = %+v, %t, want %q", tc.o.model(), q, ok, tc.want)
+		}
+	}
+}
+
+func TestApplyQuirks(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	cfg := &config{Quirks: map[string]quirk{
+		"DEL-A0B8": {IgnoreModes: []string{"2560x1440@59.95", "1280x720@50"}, Preferred: "1920x1080"},
+		// The panel's own current mode is never dropped.
+		"/.*/": {IgnoreModes: []string{"1920x1080", "1680x1050"}},
+	}}
+	ctx := withSettings(context.Background(), cfg.settings())
+	applyQuirks(ctx, outputs)
+
+	panel, hdmi := outputs[0], outputs[1]
+	if want := []resolution{{1920, 1080}, {1280, 720}}; !slices.Equal(panel.Resolutions, want) || panel.Current != 0 {
+		t.Errorf("panel modes %v, current %d, want %v and 0", panel.Resolutions, panel.Current, want)
+	}
+	if want := []resolution{{1920, 1080}, {1280, 720}}; !slices.Equal(hdmi.Resolutions, want) {
+		t.Errorf("HDMI-1 modes %v, want %v", hdmi.Resolutions, want)
+	}
+	if hdmi.Preferred != 0 {
+		t.Errorf("HDMI-1 prefers %d, want 1920x1080", hdmi.Preferred)
+	}
+	if want := []float64{60, 59.94}; !slices.Equal(hdmi.Rates[1], want) {
+		t.Errorf("HDMI-1 1280x720 rates %v, want %v", hdmi.Rates[1], want)
+	}
+}
+
+func TestSettleTime(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	cfg := &config{Quirks: map[string]quirk{"DEL-A0B8": {Settle: duration(3 * time.Second)}}}
+	ctx := withSettings(context.Background(), cfg.settings())
+	p := newPlan(ctx, "test", layout{{Name: "eDP-1", outputState: outputState{Mode: resolution{1280, 720}}}}, outputs)
+	if d := settleTime(ctx, p); d != verifyDelay {
+		t.Errorf("settle without the monitor = %s, want %s", d, verifyDelay)
+	}
+	p = newPlan(ctx, "test", layout{{Name: "HDMI-1", outputState: outputState{Mode: resolution{1920, 1080}}}}, outputs)
+	if d := settleTime(ctx, p); d != 3*time.Second {
+		t.Errorf("settle with the monitor = %s, want 3s", d)
+	}
+}
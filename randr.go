@@ -0,0 +1,478 @@
+// Package randr watches for monitors being connected and disconnected with
+// xrandr and arranges the displays according to profiles. The randr command
+// in cmd/randr runs it as a daemon; NewWatcher embeds the detection alone.
+package randr
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type resolution struct {
+	W, H int
+}
+
+func (r resolution) pixels() int { return r.W * r.H }
+func (r resolution) String() string {
+	return fmt.Sprintf("%dx%d", r.W, r.H)
+}
+
+func (r resolution) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *resolution) UnmarshalText(b []byte) error {
+	res, err := parseResolution(string(b))
+	*r = res
+	return err
+}
+
+type output struct {
+	Name        string
+	Connected   bool
+	Primary     bool
+	Resolutions []resolution
+	// Rates are the refresh rates of each of the Resolutions.
+	Rates [][]float64
+	// Preferred and Current are indexes into Resolutions of the modes
+	// xrandr marks with "+" and "*", or -1 if none is marked.
+	Preferred int
+	Current   int
+	// Rate is the refresh rate of the current mode, or 0 if unknown.
+	Rate float64
+	// Geometry, X and Y are the size and position of an active output in
+	// the framebuffer.
+	Geometry resolution
+	X, Y     int
+	// Rotation is "left", "right" or "inverted" for a rotated output, and
+	// empty otherwise.
+	Rotation string
+	// CRTC is set when the output is driving a CRTC, which a just
+	// disconnected output can still be doing.
+	CRTC    bool
+	Monitor monitorID
+	// Physical is the monitor's size in millimetres, if reported.
+	Physical resolution
+	// CEA holds what the EDID's CEA extension announces.
+	CEA ceaInfo
+	// Props are the output's RandR properties with their current values,
+	// such as "Broadcast RGB".
+	Props map[string]string
+	// Unidentified is set for a connected output without a usable EDID,
+	// as behind some KVMs: its monitor is unknown and it is matched by
+	// connector name only. EDIDError says what was wrong with the EDID,
+	// if there was one.
+	Unidentified bool
+	EDIDError    string
+	// NonDesktop is set for outputs that are not part of the desktop,
+	// such as VR headsets, which the kernel marks with the non-desktop
+	// property.
+	NonDesktop bool
+	// UnknownConnection is set when xrandr cannot tell whether a monitor
+	// is connected; Connected is then decided by the unknown_connection
+	// policy.
+	UnknownConnection bool
+
+	// rotate is the rotation the output is to be planned with, or "" to
+	// leave its rotation alone.
+	rotate string
+	// scale is the factor the output's picture is to be scaled by, or 0
+	// to leave it unscaled.
+	scale float64
+	// refresh caps the refresh rate the output is planned with, by the
+	// power policy; 0 leaves the rate to xrandr.
+	refresh float64
+	// added are modes randr adds to the output with --newmode and
+	// --addmode before using them.
+	added []resolution
+}
+
+// state returns the state of the output lit at the resolution, rotated and
+// scaled as it is to be planned.
+func (o output) state(res resolution) outputState {
+	st := outputState{Mode: res, Rotation: o.rotate}
+	if o.scale > 0 && o.scale != 1 {
+		sz := st.size()
+		st.ScaleFrom = resolution{int(math.Round(float64(sz.W) * o.scale)), int(math.Round(float64(sz.H) * o.scale))}
+	}
+	return st
+}
+
+// size returns the area an active output's mode covers before any scaling,
+// which is the mode with width and height swapped when rotated sideways.
+func (o output) size() resolution {
+	m := o.Resolutions[o.Current]
+	if o.Rotation == "left" || o.Rotation == "right" {
+		return resolution{m.H, m.W}
+	}
+	return m
+}
+
+// active reports whether the output is currently driving a mode.
+func (o output) active() bool {
+	return o.Current >= 0 && o.Current < len(o.Resolutions)
+}
+
+// preferred returns the output's preferred resolution, falling back to the
+// first listed one.
+func (o output) preferred() (resolution, bool) {
+	if o.Preferred >= 0 && o.Preferred < len(o.Resolutions) {
+		return o.Resolutions[o.Preferred], true
+	}
+	if len(o.Resolutions) > 0 {
+		return o.Resolutions[0], true
+	}
+	return resolution{}, false
+}
+
+// internalPrefixes are connector name prefixes used for built-in panels.
+var internalPrefixes = []string{"eDP", "LVDS", "DSI"}
+
+func (o output) internal() bool {
+	for _, p := range internalPrefixes {
+		if strings.HasPrefix(o.Name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// id returns the EDID fingerprint of the attached monitor, or the connector
+// name when no EDID could be read.
+func (o output) id() string {
+	if s := o.Monitor.String(); s != "" {
+		return s
+	}
+	return o.Name
+}
+
+var (
+	screenRe = regexp.MustCompile(`^Screen (\d+): minimum (\d+) x (\d+), current (\d+) x (\d+), maximum (\d+) x (\d+)`)
+	outputRe = regexp.MustCompile(`^(\S+)\s+(connected|disconnected|unknown connection)\s*(primary)?\s*(?:(\d+)x(\d+)\+(-?\d+)\+(-?\d+))?\s*(left|right|inverted)?`)
+	modeRe   = regexp.MustCompile(`^ +(\d+)x(\d+)\S*\s+(.*)$`)
+	propRe   = regexp.MustCompile(`^\t(\S[^:]*):\s*(.*)$`)
+	hexRe    = regexp.MustCompile(`^\t\t([0-9a-f]+)$`)
+	physRe   = regexp.MustCompile(`(\d+)mm x (\d+)mm\s*$`)
+)
+
+// screen holds the framebuffer size limits from xrandr's "Screen" line.
+type screen struct {
+	Min, Current, Max resolution
+}
+
+// parseXrandr queries the outputs with the xrandr binary.
+func parseXrandr(ctx context.Context) ([]output, screen, error) {
+	return queryOutputs(ctx, xrandrBackend{})
+}
+
+// queryOutputs queries the outputs and screen limits from the backend.
+func queryOutputs(ctx context.Context, b Backend) ([]output, screen, error) {
+	outputs, scr, _, err := querySum(ctx, b)
+	return outputs, scr, err
+}
+
+// querySum is queryOutputs that also returns a checksum of the query's
+// output, which tells polls that nothing changed at all without comparing
+// the outputs.
+func querySum(ctx context.Context, b Backend) ([]output, screen, uint64, error) {
+	qctx, sp := startSpan(ctx, "query")
+	data, err := b.Query(qctx)
+	sp.finish(err)
+	if err != nil {
+		return nil, screen{}, 0, fmt.Errorf("xrandr --query: %w", err)
+	}
+	h := fnv.New64a()
+	h.Write(data)
+	captureQuery(ctx, data)
+	tracef(ctx, "xrandr --query output:\n%s", data)
+	_, sp = startSpan(ctx, "parse")
+	outputs, scr := parseQuery(ctx, data)
+	sp.set("outputs", len(outputs))
+	sp.finish(nil)
+	hideNonDesktop(ctx, outputs)
+	tuneOutputs(ctx, outputs)
+	rotateOutputs(ctx, outputs)
+	return outputs, scr, h.Sum64(), nil
+}
+
+// parseQuery parses the output of `xrandr --query --prop`.
+func parseQuery(ctx context.Context, data []byte) ([]output, screen) {
+	var scr screen
+	var outputs []output
+	var cur *output
+	var prop string
+	var edid strings.Builder
+
+	// flushEDID decodes the EDID collected for the current output, if any.
+	flushEDID := func() {
+		if cur != nil && edid.Len() > 0 {
+			if id, err := parseEDID(edid.String()); err == nil {
+				cur.Monitor = id
+				cur.CEA = parseCEA(edid.String())
+				tracef(ctx, "parse: %s EDID: %s %q", cur.Name, id, id.Name)
+			} else {
+				logf(ctx, "%s: %v", cur.Name, err)
+				cur.EDIDError = err.Error()
+			}
+		}
+		edid.Reset()
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := screenRe.FindStringSubmatch(line); m != nil {
+			n := make([]int, 6)
+			for i := range n {
+				n[i], _ = strconv.Atoi(m[i+2])
+			}
+			scr = screen{
+				Min:     resolution{n[0], n[1]},
+				Current: resolution{n[2], n[3]},
+				Max:     resolution{n[4], n[5]},
+			}
+			tracef(ctx, "parse: %q: screen min %s current %s max %s", line, scr.Min, scr.Current, scr.Max)
+			continue
+		}
+
+		if m := outputRe.FindStringSubmatch(line); m != nil {
+			flushEDID()
+			prop = ""
+			outputs = append(outputs, output{
+				Name:              m[1],
+				Connected:         m[2] == "connected",
+				UnknownConnection: m[2] == "unknown connection",
+				Primary:           m[3] == "primary",
+				Preferred:         -1,
+				Current:           -1,
+			})
+			cur = &outputs[len(outputs)-1]
+			if m[4] != "" {
+				cur.CRTC = true
+				cur.Geometry.W, _ = strconv.Atoi(m[4])
+				cur.Geometry.H, _ = strconv.Atoi(m[5])
+				cur.X, _ = strconv.Atoi(m[6])
+				cur.Y, _ = strconv.Atoi(m[7])
+			}
+			cur.Rotation = m[8]
+			if m := physRe.FindStringSubmatch(line); m != nil {
+				cur.Physical.W, _ = strconv.Atoi(m[1])
+				cur.Physical.H, _ = strconv.Atoi(m[2])
+			}
+			tracef(ctx, "parse: %q: output %s connected=%t primary=%t crtc=%t geometry %s+%d+%d rotation=%q",
+				line, cur.Name, cur.Connected, cur.Primary, cur.CRTC, cur.Geometry, cur.X, cur.Y, cur.Rotation)
+			continue
+		}
+
+		if m := propRe.FindStringSubmatch(line); m != nil {
+			prop = m[1]
+			if cur != nil {
+				if cur.Props == nil {
+					cur.Props = make(map[string]string)
+				}
+				cur.Props[prop] = strings.TrimSpace(m[2])
+			}
+			tracef(ctx, "parse: %q: property %s", line, prop)
+			continue
+		}
+		if m := hexRe.FindStringSubmatch(line); m != nil {
+			if prop == "EDID" {
+				edid.WriteString(m[1])
+			} else {
+				tracef(ctx, "parse: %q: %s data, ignored", line, prop)
+			}
+			continue
+		}
+
+		if cur != nil {
+			if m := modeRe.FindStringSubmatch(line); m != nil {
+				w, _ := strconv.Atoi(m[1])
+				h, _ := strconv.Atoi(m[2])
+				if strings.Contains(m[3], "+") && cur.Preferred < 0 {
+					cur.Preferred = len(cur.Resolutions)
+				}
+				if strings.Contains(m[3], "*") && cur.Current < 0 {
+					cur.Current = len(cur.Resolutions)
+				}
+				cur.Resolutions = append(cur.Resolutions, resolution{w, h})
+				var rates []float64
+				for _, f := range strings.Fields(m[3]) {
+					if r, err := strconv.ParseFloat(strings.Trim(f, "*+"), 64); err == nil {
+						rates = append(rates, r)
+						if strings.Contains(f, "*") {
+							cur.Rate = r
+						}
+					}
+				}
+				cur.Rates = append(cur.Rates, rates)
+				tracef(ctx, "parse: %q: %s mode %dx%d current=%t preferred=%t", line, cur.Name, w, h,
+					cur.Current == len(cur.Resolutions)-1, cur.Preferred == len(cur.Resolutions)-1)
+				continue
+			}
+		}
+		tracef(ctx, "parse: %q: no match, ignored", line)
+	}
+	flushEDID()
+	resolveUnknown(ctx, outputs)
+	for i := range outputs {
+		o := &outputs[i]
+		o.Unidentified = o.Connected && o.Monitor.Vendor == ""
+		o.NonDesktop = o.Props["non-desktop"] == "1"
+		if o.Unidentified {
+			debugf(ctx, "parse: %s has no usable EDID, matching it by connector name", o.Name)
+		}
+	}
+	return outputs, scr
+}
+
+// primaryNativeRes returns the first (native) resolution of the primary output.
+func primaryNativeRes(outputs []output) resolution {
+	for _, o := range outputs {
+		if o.Primary && len(o.Resolutions) > 0 {
+			return o.Resolutions[0]
+		}
+	}
+	// No primary found — use the first connected output with resolutions.
+	for _, o := range outputs {
+		if o.Connected && len(o.Resolutions) > 0 {
+			return o.Resolutions[0]
+		}
+	}
+	return resolution{}
+}
+
+// bestCommonResolution finds the highest-pixel-count resolution shared by all
+// the given outputs. Falls back to the primary monitor's native resolution.
+func bestCommonResolution(primary output, outputs []output) resolution {
+	if len(outputs) == 0 {
+		return primaryNativeRes(outputs)
+	}
+
+	// Build set from first output's resolutions.
+	common := make(map[resolution]bool)
+	for _, r := range outputs[0].Resolutions {
+		common[r] = true
+	}
+
+	// Intersect with each subsequent output.
+	for _, o := range outputs[1:] {
+		have := make(map[resolution]bool)
+		for _, r := range o.Resolutions {
+			have[r] = true
+		}
+		for r := range common {
+			if !have[r] {
+				delete(common, r)
+			}
+		}
+	}
+
+	var shared []resolution
+	for r := range common {
+		shared = append(shared, r)
+	}
+
+	if len(shared) == 0 {
+		// No common resolution — fall back to primary's native resolution.
+		if len(primary.Resolutions) > 0 {
+			return primary.Resolutions[0]
+		}
+		return primaryNativeRes(outputs)
+	}
+
+	sort.Slice(shared, func(i, j int) bool {
+		return shared[i].pixels() > shared[j].pixels()
+	})
+	return shared[0]
+}
+
+// mirror lays the externals over the primary output at the given resolution.
+// An external that lacks the mode runs at its preferred one, scaled from the
+// primary's, so the mirror is neither cropped nor letterboxed off-screen.
+func mirror(primary output, externals []output, res resolution) layout {
+	l := layout{{Name: primary.Name, outputState: outputState{Mode: res, Primary: true}}}
+	for _, ext := range externals {
+		st := outputState{Mode: res}
+		if !slices.Contains(ext.Resolutions, res) {
+			if pref, ok := ext.preferred(); ok {
+				st.Mode, st.ScaleFrom = pref, res
+			}
+		}
+		l = append(l, outputConfig{Name: ext.Name, outputState: st})
+	}
+	return l
+}
+
+// xrandr runs xrandr with the given arguments, logging the invocation. Its
+// output goes to stderr, keeping stdout for the event stream.
+func xrandr(ctx context.Context, args ...string) error {
+	infof(ctx, "xrandr %s", strings.Join(args, " "))
+	_, err := runXrandr(ctx, false, args, func(cmd *exec.Cmd) {
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+	})
+	return err
+}
+
+func connectedOutputs(outputs []output) []output {
+	var c []output
+	for _, o := range outputs {
+		if o.Connected {
+			c = append(c, o)
+		}
+	}
+	return c
+}
+
+func connectedSet(outputs []output) map[string]bool {
+	s := make(map[string]bool)
+	for _, o := range outputs {
+		if o.Connected {
+			s[o.Name] = true
+		}
+	}
+	return s
+}
+
+// mirrorLayout finds the primary and external outputs among connected
+// monitors and mirrors them at the best common resolution. It returns nil
+// when there is nothing to mirror.
+func mirrorLayout(ctx context.Context, outputs []output) layout {
+	var primary output
+	var externals []output
+	var all []output
+	for _, o := range outputs {
+		if !o.Connected {
+			continue
+		}
+		if o.Primary {
+			primary = o
+		} else {
+			externals = append(externals, o)
+		}
+		all = append(all, o)
+	}
+
+	if primary.Name == "" && len(all) > 0 {
+		primary = all[0]
+		externals = all[1:]
+	}
+
+	if len(externals) == 0 {
+		return nil
+	}
+	res := bestCommonResolution(primary, all)
+	infof(ctx, "mirroring at %s", res)
+	return mirror(primary, externals, res)
+}
@@ -0,0 +1,98 @@
+package randr
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	data, err := os.ReadFile("testdata/dock.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputs, scr := parseQuery(context.Background(), data)
+	if want := (screen{Min: resolution{320, 200}, Current: resolution{1920, 1080}, Max: resolution{16384, 16384}}); scr != want {
+		t.Errorf("screen %+v, want %+v", scr, want)
+	}
+	if len(outputs) != 3 {
+		t.Fatalf("%d outputs, want 3", len(outputs))
+	}
+	edp, hdmi, dp := outputs[0], outputs[1], outputs[2]
+
+	if !edp.Connected || !edp.Primary || !edp.CRTC || !edp.internal() {
+		t.Errorf("eDP-1: %+v", edp)
+	}
+	if want := []resolution{{1920, 1080}, {1680, 1050}, {1280, 720}}; !reflect.DeepEqual(edp.Resolutions, want) {
+		t.Errorf("eDP-1 modes %v, want %v", edp.Resolutions, want)
+	}
+	if edp.Preferred != 0 || edp.Current != 0 || edp.Rate != 60.01 || len(edp.Rates[0]) != 5 {
+		t.Errorf("eDP-1 preferred %d, current %d at %v, rates %v", edp.Preferred, edp.Current, edp.Rate, edp.Rates)
+	}
+	if edp.Geometry != (resolution{1920, 1080}) || edp.Physical != (resolution{344, 194}) {
+		t.Errorf("eDP-1 geometry %s, size %smm", edp.Geometry, edp.Physical)
+	}
+	if edp.id() != "AUO-133D-00000000" {
+		t.Errorf("eDP-1 monitor %q", edp.id())
+	}
+
+	if !hdmi.Connected || hdmi.Primary || hdmi.CRTC || hdmi.active() {
+		t.Errorf("HDMI-1: %+v", hdmi)
+	}
+	if hdmi.Preferred != 0 || hdmi.Current != -1 || hdmi.Rate != 0 {
+		t.Errorf("HDMI-1 preferred %d, current %d at %v", hdmi.Preferred, hdmi.Current, hdmi.Rate)
+	}
+	if want := (monitorID{Vendor: "DEL", Product: 0xa0b8, Serial: "718NY83", Name: "DELL U2720Q"}); hdmi.Monitor != want {
+		t.Errorf("HDMI-1 monitor %+v, want %+v", hdmi.Monitor, want)
+	}
+	if want := [][]float64{{59.95}, {60, 50, 59.94}, {60, 50, 59.94}}; !reflect.DeepEqual(hdmi.Rates, want) {
+		t.Errorf("HDMI-1 rates %v, want %v", hdmi.Rates, want)
+	}
+
+	if dp.Connected || dp.Unidentified || len(dp.Resolutions) != 0 {
+		t.Errorf("DP-1: %+v", dp)
+	}
+}
+
+func TestParseQueryOutputs(t *testing.T) {
+	for _, tc := range []struct {
+		name, query string
+		want        output
+	}{
+		{"rotated left of the panel",
+			"DP-2 connected 1080x1920+-1080+0 left (normal left inverted right x axis y axis) 527mm x 296mm\n   1920x1080     60.00*+\n",
+			output{Name: "DP-2", Connected: true, CRTC: true, Geometry: resolution{1080, 1920}, X: -1080, Rotation: "left",
+				Physical: resolution{527, 296}, Unidentified: true}},
+		{"unknown connection with modes",
+			"VGA-1 unknown connection (normal left inverted right x axis y axis)\n   1024x768      60.00 +\n",
+			output{Name: "VGA-1", Connected: true, UnknownConnection: true, Unidentified: true, Current: -1}},
+		{"unknown connection without modes",
+			"VGA-1 unknown connection (normal left inverted right x axis y axis)\n",
+			output{Name: "VGA-1", UnknownConnection: true, Preferred: -1, Current: -1}},
+		{"broken EDID behind a KVM",
+			"HDMI-2 connected (normal left inverted right x axis y axis)\n\tEDID: \n\t\t00ffffffffffff00\n   1920x1080     60.00 +\n",
+			output{Name: "HDMI-2", Connected: true, Unidentified: true, Current: -1,
+				EDIDError: "edid: short block (8 bytes)", Props: map[string]string{"EDID": ""}}},
+		{"headset",
+			"DP-3 connected (normal left inverted right x axis y axis)\n\tnon-desktop: 1 \n\t\trange: (0, 1)\n   2160x1200     90.00 +\n",
+			output{Name: "DP-3", Connected: true, Unidentified: true, NonDesktop: true, Current: -1,
+				Props: map[string]string{"non-desktop": "1"}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outputs, _ := parseQuery(context.Background(), []byte(tc.query))
+			if len(outputs) != 1 {
+				t.Fatalf("%d outputs, want 1", len(outputs))
+			}
+			got := outputs[0]
+			// The modes are covered above; compare the rest.
+			if len(got.Resolutions) > 0 {
+				tc.want.Resolutions, tc.want.Rates, tc.want.Rate = got.Resolutions, got.Rates, got.Rate
+				tc.want.Preferred, tc.want.Current = got.Preferred, got.Current
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tc.want)
+			}
+		})
+	}
+}
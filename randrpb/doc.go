@@ -0,0 +1,13 @@
+// Package randrpb is the randr daemon's gRPC interface: the messages and
+// the client and server of the Randr service in randr.proto, generated by
+// internal/protogen, on a protocol buffer and gRPC runtime of their own.
+//
+// Calls go over HTTP/2 without TLS:
+//
+//	cc := randrpb.Dial("signage-3:7601")
+//	defer cc.Close()
+//	c := randrpb.NewRandrClient(cc)
+//	resp, err := c.Cycle(ctx, &randrpb.CycleRequest{})
+package randrpb
+
+//go:generate go run ../internal/protogen -o randr.pb.go randr.proto
@@ -0,0 +1,407 @@
+package randrpb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Code is a gRPC status code.
+type Code uint32
+
+// The status codes randr uses, out of those gRPC defines.
+const (
+	OK                 Code = 0
+	Canceled           Code = 1
+	Unknown            Code = 2
+	InvalidArgument    Code = 3
+	DeadlineExceeded   Code = 4
+	NotFound           Code = 5
+	ResourceExhausted  Code = 8
+	FailedPrecondition Code = 9
+	Unimplemented      Code = 12
+	Internal           Code = 13
+	Unavailable        Code = 14
+)
+
+var codeNames = map[Code]string{
+	OK: "OK", Canceled: "Canceled", Unknown: "Unknown", InvalidArgument: "InvalidArgument",
+	DeadlineExceeded: "DeadlineExceeded", NotFound: "NotFound", ResourceExhausted: "ResourceExhausted",
+	FailedPrecondition: "FailedPrecondition", Unimplemented: "Unimplemented", Internal: "Internal",
+	Unavailable: "Unavailable",
+}
+
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return "Code(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Status is a call's failure as gRPC reports it.
+type Status struct {
+	Code    Code
+	Message string
+}
+
+func (s *Status) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", s.Code, s.Message)
+}
+
+// Errorf returns a Status error.
+func Errorf(c Code, format string, args ...any) error {
+	return &Status{Code: c, Message: fmt.Sprintf(format, args...)}
+}
+
+// CodeOf returns the status code err carries: OK for nil, Unknown for
+// errors other than Status and the context's.
+func CodeOf(err error) Code {
+	var s *Status
+	switch {
+	case err == nil:
+		return OK
+	case errors.As(err, &s):
+		return s.Code
+	case errors.Is(err, context.Canceled):
+		return Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return DeadlineExceeded
+	}
+	return Unknown
+}
+
+// maxMessageSize bounds the messages read, as gRPC does by default.
+const maxMessageSize = 4 << 20
+
+// writeFrame writes a message with the gRPC length prefix: a byte saying it
+// is not compressed and the length, big-endian.
+func writeFrame(w io.Writer, m Message) error {
+	b := m.Marshal()
+	frame := make([]byte, 5, 5+len(b))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+	_, err := w.Write(append(frame, b...))
+	return err
+}
+
+// readFrame reads a message written by writeFrame. It returns io.EOF at the
+// end of the stream.
+func readFrame(r io.Reader, m Message) error {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Errorf(Internal, "truncated message")
+		}
+		return err
+	}
+	if hdr[0] != 0 {
+		return Errorf(Unimplemented, "compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(hdr[1:])
+	if n > maxMessageSize {
+		return Errorf(ResourceExhausted, "message of %d bytes exceeds %d", n, maxMessageSize)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return Errorf(Internal, "truncated message")
+	}
+	if err := m.Unmarshal(b); err != nil {
+		return Errorf(Internal, "%v", err)
+	}
+	return nil
+}
+
+// isGRPC reports whether the content type is gRPC's with protocol buffers.
+func isGRPC(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return mt == "application/grpc" || mt == "application/grpc+proto"
+}
+
+// encodeMessage percent-encodes a status message for the grpc-message
+// trailer.
+func encodeMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeMessage undoes encodeMessage.
+func decodeMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseTimeout parses a grpc-timeout header, such as "500m" or "2S".
+func parseTimeout(s string) (time.Duration, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	unit, ok := map[byte]time.Duration{'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond}[s[len(s)-1]]
+	return time.Duration(n) * unit, ok
+}
+
+// ServerStream is the server's side of a call, through which it sends its
+// responses.
+type ServerStream struct {
+	ctx context.Context
+	w   http.ResponseWriter
+	rc  *http.ResponseController
+}
+
+// Context returns the call's context, cancelled when the client goes away.
+func (s *ServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// SendMsg sends a response and flushes it to the client.
+func (s *ServerStream) SendMsg(m Message) error {
+	if err := writeFrame(s.w, m); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// method serves one method, reading its request from r.
+type method func(s *ServerStream, r io.Reader) error
+
+// unaryMethod serves a method with a single response.
+func unaryMethod[In any, P interface {
+	*In
+	Message
+}, Out Message](call func(context.Context, P) (Out, error)) method {
+	return func(s *ServerStream, r io.Reader) error {
+		in := P(new(In))
+		if err := readFrame(r, in); err != nil {
+			return err
+		}
+		out, err := call(s.ctx, in)
+		if err != nil {
+			return err
+		}
+		return s.SendMsg(out)
+	}
+}
+
+// streamMethod serves a method streaming its responses.
+func streamMethod[In any, P interface {
+	*In
+	Message
+}](call func(P, *ServerStream) error) method {
+	return func(s *ServerStream, r io.Reader) error {
+		in := P(new(In))
+		if err := readFrame(r, in); err != nil {
+			return err
+		}
+		return call(in, s)
+	}
+}
+
+// service serves a service's methods by path, as "/randr.v1.Randr/Apply".
+type service map[string]method
+
+// ServeHTTP serves a call over HTTP/2, ending it with its status in the
+// trailers.
+func (svc service) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !isGRPC(r.Header.Get("Content-Type")) {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	ctx := r.Context()
+	if d, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+	// Send the headers now, so a client waiting on a stream knows the
+	// call was taken.
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	var err error
+	if m, ok := svc[r.URL.Path]; ok {
+		err = m(&ServerStream{ctx: ctx, w: w, rc: rc}, r.Body)
+	} else {
+		err = Errorf(Unimplemented, "unknown method %s", r.URL.Path)
+	}
+	code := CodeOf(err)
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(code)))
+	if err != nil {
+		msg := err.Error()
+		if s := (*Status)(nil); errors.As(err, &s) {
+			msg = s.Message
+		}
+		w.Header().Set("Grpc-Message", encodeMessage(msg))
+	}
+}
+
+// ClientConn calls a gRPC server over HTTP/2 without TLS, as the daemon
+// serves it.
+type ClientConn struct {
+	base   string
+	client *http.Client
+}
+
+// Dial returns a connection to the server at addr, e.g. "signage-3:7601".
+// It connects on the first call.
+func Dial(addr string) *ClientConn {
+	t := &http.Transport{Protocols: new(http.Protocols)}
+	t.Protocols.SetUnencryptedHTTP2(true)
+	return &ClientConn{base: "http://" + addr, client: &http.Client{Transport: t}}
+}
+
+// Close closes the connection.
+func (cc *ClientConn) Close() error {
+	cc.client.CloseIdleConnections()
+	return nil
+}
+
+// Invoke calls a method with a single response.
+func (cc *ClientConn) Invoke(ctx context.Context, method string, in, out Message) error {
+	s, err := cc.NewStream(ctx, method, in)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.RecvMsg(out); err != nil {
+		if err == io.EOF {
+			return Errorf(Internal, "no response")
+		}
+		return err
+	}
+	// Read on to the trailers, for the call's status.
+	switch err := s.RecvMsg(discard{}); err {
+	case io.EOF:
+		return nil
+	case nil:
+		return Errorf(Internal, "more than one response")
+	default:
+		return err
+	}
+}
+
+// discard is a message that is read and thrown away.
+type discard struct{}
+
+func (discard) Marshal() []byte          { return nil }
+func (discard) Unmarshal(b []byte) error { return nil }
+
+// NewStream calls a method streaming its responses.
+func (cc *ClientConn) NewStream(ctx context.Context, method string, in Message) (*ClientStream, error) {
+	var body bytes.Buffer
+	writeFrame(&body, in)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cc.base+method, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", strconv.FormatInt(max(time.Until(deadline).Milliseconds(), 1), 10)+"m")
+	}
+	resp, err := cc.client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, Errorf(Unavailable, "%v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		code := Unknown
+		switch resp.StatusCode {
+		case http.StatusBadRequest:
+			code = Internal
+		case http.StatusNotFound:
+			code = Unimplemented
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			code = Unavailable
+		}
+		return nil, Errorf(code, "HTTP status %s", resp.Status)
+	}
+	s := &ClientStream{resp: resp}
+	// A call failing before any response can have its status in the
+	// headers alone.
+	if resp.Header.Get("Grpc-Status") != "" {
+		resp.Body.Close()
+		if err := status(resp.Header); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ClientStream is the client's side of a call, from which it reads the
+// responses.
+type ClientStream struct {
+	resp *http.Response
+}
+
+// RecvMsg reads the next response into m. It returns io.EOF once the call
+// ended with status OK, or the call's Status error.
+func (s *ClientStream) RecvMsg(m Message) error {
+	err := readFrame(s.resp.Body, m)
+	if err == io.EOF {
+		s.resp.Body.Close()
+		trailer := s.resp.Trailer
+		if s.resp.Header.Get("Grpc-Status") != "" {
+			trailer = s.resp.Header
+		}
+		if err := status(trailer); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	return err
+}
+
+// Close ends the call.
+func (s *ClientStream) Close() error {
+	return s.resp.Body.Close()
+}
+
+// status returns the status in a call's trailers as an error, nil for OK.
+func status(h http.Header) error {
+	v := h.Get("Grpc-Status")
+	if v == "" {
+		return Errorf(Internal, "missing grpc-status")
+	}
+	code, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return Errorf(Internal, "bad grpc-status %q", v)
+	}
+	if code == 0 {
+		return nil
+	}
+	return &Status{Code: Code(code), Message: decodeMessage(h.Get("Grpc-Message"))}
+}
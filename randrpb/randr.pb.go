@@ -0,0 +1,722 @@
+// Code generated by protogen from randr.proto. DO NOT EDIT.
+
+package randrpb
+
+import (
+	"context"
+	"net/http"
+)
+
+// Output is a connected output.
+type Output struct {
+	// Name is the connector, e.g. "HDMI-1".
+	Name string
+	// Monitor is the EDID fingerprint of the attached monitor, e.g.
+	// "DEL-A0B8-718NY83", and monitor_name its advertised name.
+	Monitor     string
+	MonitorName string
+	// Unidentified is set when the monitor has no usable EDID; edid_error
+	// then says what was wrong with it.
+	Unidentified bool
+	EdidError    string
+	Primary      bool
+	// Audio is set when the output carries sound to the monitor.
+	Audio bool
+	// Active is set when the output shows a picture; width and height are
+	// then its mode and x and y its position.
+	Active bool
+	Width  int32
+	Height int32
+	X      int32
+	Y      int32
+	// Rotation is "left", "right" or "inverted" for a rotated output.
+	Rotation string
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *Output) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Monitor)
+	b = appendString(b, 3, m.MonitorName)
+	b = appendBool(b, 4, m.Unidentified)
+	b = appendString(b, 5, m.EdidError)
+	b = appendBool(b, 6, m.Primary)
+	b = appendBool(b, 7, m.Audio)
+	b = appendBool(b, 8, m.Active)
+	b = appendInt32(b, 9, m.Width)
+	b = appendInt32(b, 10, m.Height)
+	b = appendInt32(b, 11, m.X)
+	b = appendInt32(b, 12, m.Y)
+	b = appendString(b, 13, m.Rotation)
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *Output) Unmarshal(b []byte) error {
+	*m = Output{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.Name, err = f.string()
+		case 2:
+			m.Monitor, err = f.string()
+		case 3:
+			m.MonitorName, err = f.string()
+		case 4:
+			m.Unidentified, err = f.bool()
+		case 5:
+			m.EdidError, err = f.string()
+		case 6:
+			m.Primary, err = f.bool()
+		case 7:
+			m.Audio, err = f.bool()
+		case 8:
+			m.Active, err = f.bool()
+		case 9:
+			m.Width, err = f.int32()
+		case 10:
+			m.Height, err = f.int32()
+		case 11:
+			m.X, err = f.int32()
+		case 12:
+			m.Y, err = f.int32()
+		case 13:
+			m.Rotation, err = f.string()
+		}
+		return err
+	})
+}
+
+type ListOutputsRequest struct {
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *ListOutputsRequest) Marshal() []byte {
+	return nil
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *ListOutputsRequest) Unmarshal(b []byte) error {
+	*m = ListOutputsRequest{}
+	return decode(b, func(field) error { return nil })
+}
+
+type ListOutputsResponse struct {
+	Outputs []*Output
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *ListOutputsResponse) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Outputs {
+		b = appendMessage(b, 1, v)
+	}
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *ListOutputsResponse) Unmarshal(b []byte) error {
+	*m = ListOutputsResponse{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			v := new(Output)
+			err = f.message(v)
+			m.Outputs = append(m.Outputs, v)
+		}
+		return err
+	})
+}
+
+// Profile is a configured profile.
+type Profile struct {
+	Name    string
+	Layout  string
+	Outputs []string
+	// Matches is set when the profile applies to the connected outputs,
+	// active when it is the one last applied.
+	Matches bool
+	Active  bool
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *Profile) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Layout)
+	for _, v := range m.Outputs {
+		b = appendBytes(b, 3, []byte(v))
+	}
+	b = appendBool(b, 4, m.Matches)
+	b = appendBool(b, 5, m.Active)
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *Profile) Unmarshal(b []byte) error {
+	*m = Profile{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.Name, err = f.string()
+		case 2:
+			m.Layout, err = f.string()
+		case 3:
+			var v string
+			v, err = f.string()
+			m.Outputs = append(m.Outputs, v)
+		case 4:
+			m.Matches, err = f.bool()
+		case 5:
+			m.Active, err = f.bool()
+		}
+		return err
+	})
+}
+
+type ListProfilesRequest struct {
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *ListProfilesRequest) Marshal() []byte {
+	return nil
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *ListProfilesRequest) Unmarshal(b []byte) error {
+	*m = ListProfilesRequest{}
+	return decode(b, func(field) error { return nil })
+}
+
+type ListProfilesResponse struct {
+	Profiles []*Profile
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *ListProfilesResponse) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Profiles {
+		b = appendMessage(b, 1, v)
+	}
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *ListProfilesResponse) Unmarshal(b []byte) error {
+	*m = ListProfilesResponse{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			v := new(Profile)
+			err = f.message(v)
+			m.Profiles = append(m.Profiles, v)
+		}
+		return err
+	})
+}
+
+type ApplyRequest struct {
+	Profile string
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *ApplyRequest) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	b = appendString(b, 1, m.Profile)
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *ApplyRequest) Unmarshal(b []byte) error {
+	*m = ApplyRequest{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.Profile, err = f.string()
+		}
+		return err
+	})
+}
+
+type ApplyResponse struct {
+	// Profile is the profile applied.
+	Profile string
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *ApplyResponse) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	b = appendString(b, 1, m.Profile)
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *ApplyResponse) Unmarshal(b []byte) error {
+	*m = ApplyResponse{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.Profile, err = f.string()
+		}
+		return err
+	})
+}
+
+type CycleRequest struct {
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *CycleRequest) Marshal() []byte {
+	return nil
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *CycleRequest) Unmarshal(b []byte) error {
+	*m = CycleRequest{}
+	return decode(b, func(field) error { return nil })
+}
+
+type CycleResponse struct {
+	// Profile is the profile applied.
+	Profile string
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *CycleResponse) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	b = appendString(b, 1, m.Profile)
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *CycleResponse) Unmarshal(b []byte) error {
+	*m = CycleResponse{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.Profile, err = f.string()
+		}
+		return err
+	})
+}
+
+// Position places an active output.
+type Position struct {
+	Name string
+	X    int32
+	Y    int32
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *Position) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	b = appendString(b, 1, m.Name)
+	b = appendInt32(b, 2, m.X)
+	b = appendInt32(b, 3, m.Y)
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *Position) Unmarshal(b []byte) error {
+	*m = Position{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.Name, err = f.string()
+		case 2:
+			m.X, err = f.int32()
+		case 3:
+			m.Y, err = f.int32()
+		}
+		return err
+	})
+}
+
+type ArrangeRequest struct {
+	Outputs []*Position
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *ArrangeRequest) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	for _, v := range m.Outputs {
+		b = appendMessage(b, 1, v)
+	}
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *ArrangeRequest) Unmarshal(b []byte) error {
+	*m = ArrangeRequest{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			v := new(Position)
+			err = f.message(v)
+			m.Outputs = append(m.Outputs, v)
+		}
+		return err
+	})
+}
+
+type ArrangeResponse struct {
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *ArrangeResponse) Marshal() []byte {
+	return nil
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *ArrangeResponse) Unmarshal(b []byte) error {
+	*m = ArrangeResponse{}
+	return decode(b, func(field) error { return nil })
+}
+
+type EventsRequest struct {
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *EventsRequest) Marshal() []byte {
+	return nil
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *EventsRequest) Unmarshal(b []byte) error {
+	*m = EventsRequest{}
+	return decode(b, func(field) error { return nil })
+}
+
+// Event is something the daemon noticed or did, as with --emit-events.
+type Event struct {
+	// Time is when it happened, in nanoseconds since the Unix epoch.
+	TimeUnixNano int64
+	// Type is "connected" or "disconnected" when a monitor is plugged in or
+	// out, "changed" when a connected output changes, "layout-applied" when
+	// the daemon changed the layout, or "error".
+	Type string
+	// Output, monitor and name identify the output of connected,
+	// disconnected and changed events: the connector, its EDID fingerprint
+	// and the monitor's name.
+	Output       string
+	Monitor      string
+	Name         string
+	Unidentified bool
+	EdidError    string
+	Audio        bool
+	// Profile, reason and layout describe an applied layout.
+	Profile string
+	Reason  string
+	Layout  []*LayoutOutput
+	Error   string
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *Event) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	b = appendInt64(b, 1, m.TimeUnixNano)
+	b = appendString(b, 2, m.Type)
+	b = appendString(b, 3, m.Output)
+	b = appendString(b, 4, m.Monitor)
+	b = appendString(b, 5, m.Name)
+	b = appendBool(b, 6, m.Unidentified)
+	b = appendString(b, 7, m.EdidError)
+	b = appendBool(b, 8, m.Audio)
+	b = appendString(b, 9, m.Profile)
+	b = appendString(b, 10, m.Reason)
+	for _, v := range m.Layout {
+		b = appendMessage(b, 11, v)
+	}
+	b = appendString(b, 12, m.Error)
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *Event) Unmarshal(b []byte) error {
+	*m = Event{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.TimeUnixNano, err = f.int64()
+		case 2:
+			m.Type, err = f.string()
+		case 3:
+			m.Output, err = f.string()
+		case 4:
+			m.Monitor, err = f.string()
+		case 5:
+			m.Name, err = f.string()
+		case 6:
+			m.Unidentified, err = f.bool()
+		case 7:
+			m.EdidError, err = f.string()
+		case 8:
+			m.Audio, err = f.bool()
+		case 9:
+			m.Profile, err = f.string()
+		case 10:
+			m.Reason, err = f.string()
+		case 11:
+			v := new(LayoutOutput)
+			err = f.message(v)
+			m.Layout = append(m.Layout, v)
+		case 12:
+			m.Error, err = f.string()
+		}
+		return err
+	})
+}
+
+// LayoutOutput is one output of an applied layout.
+type LayoutOutput struct {
+	Name string
+	Off  bool
+	// Mode is the resolution, e.g. "1920x1080", and scale_from the one
+	// scaled from, if any.
+	Mode      string
+	X         int32
+	Y         int32
+	Primary   bool
+	ScaleFrom string
+	// Rotation is "normal", "left", "right" or "inverted", or empty when
+	// the layout left the rotation as it was; rate is the refresh rate, 0
+	// when left to the server.
+	Rotation string
+	Rate     float64
+}
+
+// Marshal encodes the message in the protocol buffer wire format.
+func (m *LayoutOutput) Marshal() []byte {
+	if m == nil {
+		return nil
+	}
+	var b []byte
+	b = appendString(b, 1, m.Name)
+	b = appendBool(b, 2, m.Off)
+	b = appendString(b, 3, m.Mode)
+	b = appendInt32(b, 4, m.X)
+	b = appendInt32(b, 5, m.Y)
+	b = appendBool(b, 6, m.Primary)
+	b = appendString(b, 7, m.ScaleFrom)
+	b = appendString(b, 8, m.Rotation)
+	b = appendDouble(b, 9, m.Rate)
+	return b
+}
+
+// Unmarshal decodes the message from the protocol buffer wire format.
+// Unknown fields are skipped.
+func (m *LayoutOutput) Unmarshal(b []byte) error {
+	*m = LayoutOutput{}
+	return decode(b, func(f field) (err error) {
+		switch f.num {
+		case 1:
+			m.Name, err = f.string()
+		case 2:
+			m.Off, err = f.bool()
+		case 3:
+			m.Mode, err = f.string()
+		case 4:
+			m.X, err = f.int32()
+		case 5:
+			m.Y, err = f.int32()
+		case 6:
+			m.Primary, err = f.bool()
+		case 7:
+			m.ScaleFrom, err = f.string()
+		case 8:
+			m.Rotation, err = f.string()
+		case 9:
+			m.Rate, err = f.double()
+		}
+		return err
+	})
+}
+
+// RandrClient is a client of the Randr service.
+//
+// Randr controls and observes one randr daemon.
+type RandrClient struct {
+	cc *ClientConn
+}
+
+// NewRandrClient returns a client calling the Randr service over cc.
+func NewRandrClient(cc *ClientConn) *RandrClient {
+	return &RandrClient{cc}
+}
+
+// ListOutputs returns the connected outputs.
+func (c *RandrClient) ListOutputs(ctx context.Context, in *ListOutputsRequest) (*ListOutputsResponse, error) {
+	out := new(ListOutputsResponse)
+	if err := c.cc.Invoke(ctx, "/randr.v1.Randr/ListOutputs", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ListProfiles returns the configured profiles, and which apply to the
+// connected outputs.
+func (c *RandrClient) ListProfiles(ctx context.Context, in *ListProfilesRequest) (*ListProfilesResponse, error) {
+	out := new(ListProfilesResponse)
+	if err := c.cc.Invoke(ctx, "/randr.v1.Randr/ListProfiles", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Apply applies a profile, or the built-in mirror, extend,
+// external-only or internal-only layout. An unknown profile fails with
+// NOT_FOUND.
+func (c *RandrClient) Apply(ctx context.Context, in *ApplyRequest) (*ApplyResponse, error) {
+	out := new(ApplyResponse)
+	if err := c.cc.Invoke(ctx, "/randr.v1.Randr/Apply", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Cycle applies the next profile matching the connected monitors. It
+// fails with FAILED_PRECONDITION when there is none to cycle to.
+func (c *RandrClient) Cycle(ctx context.Context, in *CycleRequest) (*CycleResponse, error) {
+	out := new(CycleResponse)
+	if err := c.cc.Invoke(ctx, "/randr.v1.Randr/Cycle", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Arrange moves the active outputs to new positions, keeping their
+// modes, as a manual change. Outputs that are not active fail it with
+// INVALID_ARGUMENT.
+func (c *RandrClient) Arrange(ctx context.Context, in *ArrangeRequest) (*ArrangeResponse, error) {
+	out := new(ArrangeResponse)
+	if err := c.cc.Invoke(ctx, "/randr.v1.Randr/Arrange", in, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Events streams the daemon's events as they happen, until the client
+// goes away.
+func (c *RandrClient) Events(ctx context.Context, in *EventsRequest) (*RandrEventsClient, error) {
+	st, err := c.cc.NewStream(ctx, "/randr.v1.Randr/Events", in)
+	if err != nil {
+		return nil, err
+	}
+	return &RandrEventsClient{st}, nil
+}
+
+// RandrEventsClient receives the responses of Randr.Events.
+type RandrEventsClient struct {
+	stream *ClientStream
+}
+
+// Recv returns the next response, or io.EOF once the call ended.
+func (x *RandrEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.stream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close ends the call.
+func (x *RandrEventsClient) Close() error {
+	return x.stream.Close()
+}
+
+// RandrServer implements the Randr service.
+type RandrServer interface {
+	// ListOutputs returns the connected outputs.
+	ListOutputs(context.Context, *ListOutputsRequest) (*ListOutputsResponse, error)
+	// ListProfiles returns the configured profiles, and which apply to the
+	// connected outputs.
+	ListProfiles(context.Context, *ListProfilesRequest) (*ListProfilesResponse, error)
+	// Apply applies a profile, or the built-in mirror, extend,
+	// external-only or internal-only layout. An unknown profile fails with
+	// NOT_FOUND.
+	Apply(context.Context, *ApplyRequest) (*ApplyResponse, error)
+	// Cycle applies the next profile matching the connected monitors. It
+	// fails with FAILED_PRECONDITION when there is none to cycle to.
+	Cycle(context.Context, *CycleRequest) (*CycleResponse, error)
+	// Arrange moves the active outputs to new positions, keeping their
+	// modes, as a manual change. Outputs that are not active fail it with
+	// INVALID_ARGUMENT.
+	Arrange(context.Context, *ArrangeRequest) (*ArrangeResponse, error)
+	// Events streams the daemon's events as they happen, until the client
+	// goes away.
+	Events(*EventsRequest, *RandrEventsServer) error
+}
+
+// RandrEventsServer sends the responses of Randr.Events.
+type RandrEventsServer struct {
+	stream *ServerStream
+}
+
+// Send sends a response.
+func (x *RandrEventsServer) Send(m *Event) error {
+	return x.stream.SendMsg(m)
+}
+
+// Context returns the call's context, cancelled when the client goes away.
+func (x *RandrEventsServer) Context() context.Context {
+	return x.stream.Context()
+}
+
+// NewRandrHandler returns a handler serving srv to gRPC clients. It must be
+// served over HTTP/2.
+func NewRandrHandler(srv RandrServer) http.Handler {
+	return service{
+		"/randr.v1.Randr/ListOutputs":  unaryMethod(srv.ListOutputs),
+		"/randr.v1.Randr/ListProfiles": unaryMethod(srv.ListProfiles),
+		"/randr.v1.Randr/Apply":        unaryMethod(srv.Apply),
+		"/randr.v1.Randr/Cycle":        unaryMethod(srv.Cycle),
+		"/randr.v1.Randr/Arrange":      unaryMethod(srv.Arrange),
+		"/randr.v1.Randr/Events":       streamMethod(func(in *EventsRequest, st *ServerStream) error { return srv.Events(in, &RandrEventsServer{st}) }),
+	}
+}
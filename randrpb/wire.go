@@ -0,0 +1,163 @@
+package randrpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Message is a protocol buffer message in its binary wire format.
+type Message interface {
+	Marshal() []byte
+	Unmarshal(b []byte) error
+}
+
+// Wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("protobuf: truncated message")
+
+func appendTag(b []byte, num, typ int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+// The append functions add a field to b. Scalars at their zero value are
+// left out, as proto3 does.
+
+func appendString(b []byte, num int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return appendBytes(b, num, []byte(s))
+}
+
+func appendBytes(b []byte, num int, v []byte) []byte {
+	b = appendTag(b, num, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendBool(b []byte, num int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return binary.AppendUvarint(appendTag(b, num, wireVarint), 1)
+}
+
+func appendInt64(b []byte, num int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendTag(b, num, wireVarint), uint64(v))
+}
+
+func appendInt32(b []byte, num int, v int32) []byte {
+	return appendInt64(b, num, int64(v))
+}
+
+func appendDouble(b []byte, num int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.LittleEndian.AppendUint64(appendTag(b, num, wireFixed64), math.Float64bits(v))
+}
+
+// appendMessage adds an embedded message. Elements of repeated fields are
+// added even when empty.
+func appendMessage(b []byte, num int, m Message) []byte {
+	return appendBytes(b, num, m.Marshal())
+}
+
+// field is a field read from the wire: a varint or fixed value in n, or a
+// length-delimited one in data.
+type field struct {
+	num  int
+	typ  int
+	n    uint64
+	data []byte
+}
+
+// decode calls fn for every field of the message in b, in wire order.
+func decode(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		tag, k := binary.Uvarint(b)
+		if k <= 0 {
+			return errTruncated
+		}
+		b = b[k:]
+		f := field{num: int(tag >> 3), typ: int(tag & 7)}
+		if f.num <= 0 {
+			return fmt.Errorf("protobuf: bad field number %d", f.num)
+		}
+		switch f.typ {
+		case wireVarint:
+			if f.n, k = binary.Uvarint(b); k <= 0 {
+				return errTruncated
+			}
+			b = b[k:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errTruncated
+			}
+			f.n, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errTruncated
+			}
+			f.n, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			n, k := binary.Uvarint(b)
+			if k <= 0 || uint64(len(b)-k) < n {
+				return errTruncated
+			}
+			f.data, b = b[k:k+int(n)], b[k+int(n):]
+		default:
+			return fmt.Errorf("protobuf: field %d has unsupported wire type %d", f.num, f.typ)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// want checks that the field has the wire type its declaration implies.
+func (f field) want(typ int) error {
+	if f.typ != typ {
+		return fmt.Errorf("protobuf: field %d has wire type %d, want %d", f.num, f.typ, typ)
+	}
+	return nil
+}
+
+func (f field) string() (string, error) {
+	return string(f.data), f.want(wireBytes)
+}
+
+func (f field) bool() (bool, error) {
+	return f.n != 0, f.want(wireVarint)
+}
+
+func (f field) int64() (int64, error) {
+	return int64(f.n), f.want(wireVarint)
+}
+
+func (f field) int32() (int32, error) {
+	return int32(f.n), f.want(wireVarint)
+}
+
+func (f field) double() (float64, error) {
+	return math.Float64frombits(f.n), f.want(wireFixed64)
+}
+
+func (f field) message(m Message) error {
+	if err := f.want(wireBytes); err != nil {
+		return err
+	}
+	return m.Unmarshal(f.data)
+}
@@ -0,0 +1,83 @@
+package randrpb
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		m    Message
+		want string
+	}{
+		{"empty", &CycleRequest{}, ""},
+		{"string", &ApplyRequest{Profile: "tv"}, "0a027476"},
+		// Negative int32s are sign-extended to ten bytes.
+		{"ints", &Position{Name: "DP-1", X: 1920, Y: -1080}, "0a0444502d3110800f18c8f7ffffffffffffff01"},
+		{"repeated", &Profile{Name: "desk", Outputs: []string{"eDP-1", ""}, Active: true},
+			"0a046465736b1a056544502d311a002801"},
+		{"double", &LayoutOutput{Name: "HDMI-1", Rate: 59.94}, "0a0648444d492d3149b81e85eb51f84d40"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := hex.EncodeToString(tc.m.Marshal()); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	want := &Event{
+		TimeUnixNano: 1767225600000000000,
+		Type:         "layout-applied",
+		Profile:      "desk",
+		Layout: []*LayoutOutput{
+			{Name: "eDP-1", Off: true},
+			{Name: "HDMI-1", Mode: "2560x1440", X: 1920, Primary: true, Rate: 59.95},
+		},
+	}
+	b := want.Marshal()
+	// Fields from a newer version of the message are skipped: a varint,
+	// a fixed32, a fixed64 and a string.
+	b = append(b, 0xf8, 0x01, 0x05, 0x85, 0x02, 1, 2, 3, 4, 0x89, 0x02, 1, 2, 3, 4, 5, 6, 7, 8, 0x92, 0x02, 1, 'x')
+	got := new(Event)
+	if err := got.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"0a05747631", "08", "0d0102", "0f", "08ff"} {
+		b, _ := hex.DecodeString(bad)
+		if err := new(Event).Unmarshal(b); err == nil {
+			t.Errorf("%s: no error", bad)
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	writeFrame(&buf, &ApplyRequest{Profile: "tv"})
+	if got := hex.EncodeToString(buf.Bytes()); got != "00000000040a027476" {
+		t.Errorf("frame %s", got)
+	}
+	got := new(ApplyRequest)
+	if err := readFrame(&buf, got); err != nil || got.Profile != "tv" {
+		t.Errorf("got %+v, %v", got, err)
+	}
+}
+
+func TestStatusMessage(t *testing.T) {
+	msg := "unknown profile café 100%"
+	enc := encodeMessage(msg)
+	if enc != "unknown profile caf%C3%A9 100%25" {
+		t.Errorf("encoded %q", enc)
+	}
+	if got := decodeMessage(enc); got != msg {
+		t.Errorf("decoded %q", got)
+	}
+}
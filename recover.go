@@ -0,0 +1,107 @@
+package randr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// verifyDelay gives the X server a moment to settle before the result of an
+// apply is checked.
+const verifyDelay = 500 * time.Millisecond
+
+// verify re-reads the outputs and checks that the plan's layout took effect
+// and that at least one connected output is lit.
+func verify(ctx context.Context, b Backend, p *plan) (outputs []output, err error) {
+	ctx, sp := startSpan(ctx, "verify")
+	defer func() { sp.finish(err) }()
+	l := p.layout()
+	select {
+	case <-time.After(settleTime(ctx, p)):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	outputs, _, err = queryOutputs(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	lit := false
+	for _, o := range outputs {
+		if o.Connected && o.active() {
+			lit = true
+		}
+	}
+	if !lit {
+		return outputs, errors.New("all screens are off")
+	}
+	if d := newPlan(ctx, "", l, outputs).delta(); len(d) > 0 {
+		return outputs, fmt.Errorf("%d output(s) not in the requested state", len(d))
+	}
+	return outputs, nil
+}
+
+// safeLayout lights the internal panel, or else the first connected output,
+// at its preferred mode. It is the recovery of last resort when no
+// known-good layout exists for the connected monitors.
+func safeLayout(outputs []output) layout {
+	connected := connectedOutputs(outputs)
+	for _, o := range connected {
+		if o.internal() {
+			connected = []output{o}
+			break
+		}
+	}
+	for _, o := range connected {
+		if res, ok := o.preferred(); ok {
+			return layout{{Name: o.Name, outputState: outputState{Mode: res, Primary: true, Rotation: o.rotate}}}
+		}
+	}
+	return nil
+}
+
+// applyVerified applies the plan and verifies the result, retrying once. A
+// verified layout becomes the last known good one; if applying fails,
+// verification fails twice or the screens end up dark, the last known good
+// layout for the monitor set is put back so the user is never left without
+// a display.
+func applyVerified(ctx context.Context, ex executor, b Backend, p *plan, st *daemonState) error {
+	var err error
+	var outputs []output
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err = ex.apply(ctx, p); err != nil {
+			// A failed apply can leave the outputs half set up; see
+			// what they are in before recovering.
+			if cur, _, qerr := queryOutputs(ctx, b); qerr == nil {
+				outputs = cur
+			}
+			break
+		}
+		if outputs, err = verify(ctx, b, p); err == nil {
+			st.LastGood, st.LastGoodFingerprint = p.layout(), fingerprint(connectedOutputs(outputs))
+			return nil
+		}
+		if outputs == nil {
+			break
+		}
+		logf(ctx, "verification failed (attempt %d): %v", attempt, err)
+		p = newPlan(ctx, p.Reason, p.layout(), outputs)
+	}
+	if outputs == nil {
+		return err
+	}
+
+	good := st.LastGood
+	if st.LastGoodFingerprint != fingerprint(connectedOutputs(outputs)) {
+		good = safeLayout(outputs)
+	}
+	if len(good) == 0 {
+		return err
+	}
+	logf(ctx, "recovering last known good layout")
+	rp := newPlan(ctx, "recover last known good layout", good, outputs)
+	if rerr := ex.apply(ctx, rp); rerr != nil {
+		return fmt.Errorf("%w; recovery failed: %v", err, rerr)
+	}
+	return err
+}
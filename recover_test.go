@@ -0,0 +1,109 @@
+package randr
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+// fileBackend answers every query with an xrandr query output saved in
+// testdata, or with err.
+type fileBackend struct {
+	name string
+	err  error
+}
+
+func (b fileBackend) Query(ctx context.Context) ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return os.ReadFile("testdata/" + b.name)
+}
+
+func (b fileBackend) Configure(ctx context.Context, args []string) error { return nil }
+
+// scriptedExecutor records the plans it is asked to apply and fails those
+// errs says to, in order.
+type scriptedExecutor struct {
+	errs    []error
+	applied []*plan
+}
+
+func (ex *scriptedExecutor) apply(ctx context.Context, p *plan) error {
+	ex.applied = append(ex.applied, p)
+	if len(ex.errs) == 0 {
+		return nil
+	}
+	err := ex.errs[0]
+	ex.errs = ex.errs[1:]
+	return err
+}
+
+// recoverContext is a context whose settings do not wait for layouts to
+// settle.
+func recoverContext() context.Context {
+	s := defaultSettings()
+	s.settleDelay = 0
+	return withSettings(context.Background(), s)
+}
+
+func TestApplyVerifiedRecoversFailedApply(t *testing.T) {
+	ctx := recoverContext()
+	outputs, _ := readQuery(t, "dock.txt")
+	desk := layout{
+		{Name: "eDP-1", outputState: outputState{Mode: resolution{1920, 1080}, Primary: true}},
+		{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920}},
+	}
+	broken := errors.New("xrandr: Configure crtc 1 failed")
+
+	t.Run("safe layout", func(t *testing.T) {
+		ex := &scriptedExecutor{errs: []error{broken}}
+		err := applyVerified(ctx, ex, fileBackend{name: "dock.txt"}, newPlan(ctx, "desk", desk, outputs), &daemonState{})
+		if !errors.Is(err, broken) {
+			t.Errorf("got %v, want the apply error", err)
+		}
+		if len(ex.applied) != 2 {
+			t.Fatalf("applied %d plans, want the failed one and the recovery", len(ex.applied))
+		}
+		want := layout{{Name: "eDP-1", outputState: outputState{Mode: resolution{1920, 1080}, Primary: true}}}
+		if got := ex.applied[1].layout(); !slices.Equal(got.args(), want.args()) {
+			t.Errorf("recovered %v, want the panel at its preferred mode %v", got, want)
+		}
+	})
+
+	t.Run("query fails too", func(t *testing.T) {
+		ex := &scriptedExecutor{errs: []error{broken}}
+		b := fileBackend{err: errors.New("cannot open display")}
+		err := applyVerified(ctx, ex, b, newPlan(ctx, "desk", desk, outputs), &daemonState{})
+		if !errors.Is(err, broken) || len(ex.applied) != 1 {
+			t.Errorf("got %v after %d applies, want the apply error and no recovery", err, len(ex.applied))
+		}
+	})
+}
+
+func TestApplyVerifiedRecoversLastGood(t *testing.T) {
+	ctx := recoverContext()
+	outputs, _ := readQuery(t, "dock.txt")
+	// The Dell stays dark whatever is applied, so verification fails.
+	desk := layout{
+		{Name: "eDP-1", outputState: outputState{Mode: resolution{1920, 1080}, Primary: true}},
+		{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920}},
+	}
+	good := layout{
+		{Name: "eDP-1", outputState: outputState{Mode: resolution{1280, 720}, Primary: true}},
+	}
+	st := &daemonState{LastGood: good, LastGoodFingerprint: fingerprint(connectedOutputs(outputs))}
+	ex := &scriptedExecutor{}
+	err := applyVerified(ctx, ex, fileBackend{name: "dock.txt"}, newPlan(ctx, "desk", desk, outputs), st)
+	if err == nil {
+		t.Fatal("verification passed with the Dell dark")
+	}
+	if len(ex.applied) != 3 {
+		t.Fatalf("applied %d plans, want two attempts and the recovery", len(ex.applied))
+	}
+	if got := ex.applied[2].layout(); !slices.Equal(got.args(), good.args()) {
+		t.Errorf("recovered %v, want the last known good layout %v", got, good)
+	}
+}
@@ -0,0 +1,166 @@
+package randr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// reloadDelay gives editors time to finish saving before the config is read.
+const reloadDelay = 200 * time.Millisecond
+
+// watchDirs watches the given directories with inotify and signals on the
+// returned channel whenever a file in them is written, replaced or removed.
+// Directories are watched rather than files so editors that save by renaming
+// a temporary file are noticed. Missing directories are skipped. Watching
+// stops when ctx is cancelled.
+func watchDirs(ctx context.Context, dirs ...string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_MOVED_FROM |
+		syscall.IN_CREATE | syscall.IN_DELETE
+	for _, dir := range slices.Compact(slices.Sorted(slices.Values(dirs))) {
+		if _, err := syscall.InotifyAddWatch(fd, dir, mask); err != nil {
+			debugf(ctx, "not watching %s: %v", dir, err)
+		}
+	}
+
+	// Reading through the runtime poller lets Close interrupt a blocked
+	// read, which a plain syscall.Read would not notice.
+	f := os.NewFile(uintptr(fd), "inotify")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil || n < syscall.SizeofInotifyEvent {
+				return
+			}
+			// The events themselves don't matter; any change triggers
+			// a full reload.
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// configDiff describes what changed between two configs, one line per
+// added, removed or modified profile or setting.
+func configDiff(old, cur *config) []string {
+	var diff []string
+	for _, p := range old.Profiles {
+		if cur.profile(p.Name) == nil {
+			diff = append(diff, fmt.Sprintf("- profile %q", p.Name))
+		}
+	}
+	for _, p := range cur.Profiles {
+		o := old.profile(p.Name)
+		switch {
+		case o == nil:
+			diff = append(diff, fmt.Sprintf("+ profile %q", p.Name))
+		case !reflect.DeepEqual(*o, p):
+			diff = append(diff, fmt.Sprintf("~ profile %q", p.Name))
+		}
+	}
+
+	// Compare the remaining settings field by field through their JSON
+	// form, which is what the user edited.
+	a, b := settingsMap(old), settingsMap(cur)
+	for _, k := range slices.Sorted(mapKeys(a, b)) {
+		if !reflect.DeepEqual(a[k], b[k]) {
+			diff = append(diff, fmt.Sprintf("~ %s: %s -> %s", k, jsonString(redact(k, a[k])), jsonString(redact(k, b[k]))))
+		}
+	}
+	return diff
+}
+
+func settingsMap(c *config) map[string]any {
+	data, _ := json.Marshal(c)
+	var m map[string]any
+	json.Unmarshal(data, &m)
+	delete(m, "profiles")
+	return m
+}
+
+// secretSettings are the keys, by setting, whose values configDiff does not
+// log. A changed secret still shows as a change of its setting.
+var secretSettings = map[string][]string{"mqtt": {"password"}}
+
+// redact returns the value of the setting with its secrets masked.
+func redact(setting string, v any) any {
+	block, ok := v.(map[string]any)
+	if !ok {
+		return v
+	}
+	block = maps.Clone(block)
+	for _, k := range secretSettings[setting] {
+		if block[k] != nil {
+			block[k] = "<redacted>"
+		}
+	}
+	return block
+}
+
+func mapKeys(ms ...map[string]any) func(func(string) bool) {
+	return func(yield func(string) bool) {
+		seen := make(map[string]bool)
+		for _, m := range ms {
+			for k := range m {
+				if !seen[k] {
+					seen[k] = true
+					if !yield(k) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+func jsonString(v any) string {
+	if v == nil {
+		return "unset"
+	}
+	// Settings such as check commands are shell, which HTML escaping
+	// would garble.
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// reloadConfig loads the config again and logs what changed. On error the
+// old config stays in effect.
+func reloadConfig(ctx context.Context, dirs paths, old *config) (*config, bool) {
+	cur, err := loadConfig(dirs)
+	if err != nil {
+		logf(ctx, "reload: %v (keeping previous config)", err)
+		return old, false
+	}
+	diff := configDiff(old, cur)
+	if len(diff) == 0 {
+		return old, false
+	}
+	for _, d := range diff {
+		infof(ctx, "config: %s", d)
+	}
+	return cur, true
+}
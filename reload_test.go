@@ -0,0 +1,47 @@
+package randr
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConfigDiff(t *testing.T) {
+	desk := profile{Name: "desk", Outputs: []string{"eDP-1", "HDMI-1"}}
+	moved := desk
+	moved.Outputs = []string{"eDP-1", "DP-1"}
+	for _, tc := range []struct {
+		name     string
+		old, cur config
+		want     []string
+	}{
+		{"unchanged", config{Profiles: []profile{desk}, LogLevel: "debug"}, config{Profiles: []profile{desk}, LogLevel: "debug"}, nil},
+		{"profile added", config{}, config{Profiles: []profile{desk}}, []string{`+ profile "desk"`}},
+		{"profile removed", config{Profiles: []profile{desk}}, config{}, []string{`- profile "desk"`}},
+		{"profile changed", config{Profiles: []profile{desk}}, config{Profiles: []profile{moved}}, []string{`~ profile "desk"`}},
+		{"settings", config{LogLevel: "info", PollInterval: duration(2 * time.Second)},
+			config{LogLevel: "debug", PollInterval: duration(time.Second), Learn: true},
+			[]string{`~ learn: unset -> true`, `~ log_level: "info" -> "debug"`, `~ poll_interval: "2s" -> "1s"`}},
+		{"unescaped", config{}, config{Rules: []rule{{When: ruleCondition{Check: "pidof obs >/dev/null && true"}, Do: ruleAction{Profile: "desk"}}}},
+			[]string{`~ rules: unset -> [{"do":{"profile":"desk"},"when":{"check":"pidof obs >/dev/null && true"}}]`}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := configDiff(&tc.old, &tc.cur); !slices.Equal(got, tc.want) {
+				t.Errorf("got %q\nwant %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigDiffRedactsSecrets(t *testing.T) {
+	old := config{MQTT: &mqttConfig{Broker: "localhost:1883", Topic: "randr", Password: "hunter2"}}
+	cur := config{MQTT: &mqttConfig{Broker: "localhost:1883", Topic: "randr", Password: "correct horse"}}
+	diff := configDiff(&old, &cur)
+	want := `~ mqtt: {"broker":"localhost:1883","password":"<redacted>","topic":"randr"} -> {"broker":"localhost:1883","password":"<redacted>","topic":"randr"}`
+	if !slices.Equal(diff, []string{want}) {
+		t.Errorf("got %q\nwant %q", diff, want)
+	}
+	if old.MQTT.Password != "hunter2" {
+		t.Error("the config's password was masked")
+	}
+}
@@ -0,0 +1,116 @@
+package randr
+
+import (
+	"bufio"
+	"context"
+	"os/exec"
+	"regexp"
+	"sync"
+)
+
+// rotationConfig sets up rotating the internal panel as the device is
+// turned, on convertibles.
+type rotationConfig struct {
+	// Sensor follows the accelerometer through iio-sensor-proxy.
+	Sensor bool `json:"sensor,omitempty"`
+	// TabletOnly follows the sensor only in tablet mode and keeps the
+	// panel upright otherwise.
+	TabletOnly bool `json:"tablet_only,omitempty"`
+	// Touch are the xinput names of the touchscreens and pens to map to
+	// the internal panel after every layout change.
+	Touch []string `json:"touch,omitempty"`
+}
+
+// rotations are the rotations xrandr knows.
+var rotations = []string{"normal", "left", "right", "inverted"}
+
+// sensorCommand is iio-sensor-proxy's tool printing sensor changes.
+var sensorCommand = "monitor-sensor"
+
+// panelRotation is the rotation the internal panel should have, or "" to
+// leave it alone.
+type panelRotation struct {
+	mu sync.Mutex
+	r  string
+}
+
+// set sets the rotation the internal panel is planned with.
+func (p *panelRotation) set(r string) {
+	p.mu.Lock()
+	p.r = r
+	p.mu.Unlock()
+}
+
+// rotateOutputs sets the rotation the internal panels are to be planned
+// with.
+func rotateOutputs(ctx context.Context, outputs []output) {
+	p := settingsFrom(ctx).panel
+	p.mu.Lock()
+	r := p.r
+	p.mu.Unlock()
+	for i := range outputs {
+		if outputs[i].internal() {
+			outputs[i].rotate = r
+		}
+	}
+}
+
+// orientations maps iio-sensor-proxy's orientations to xrandr rotations.
+var orientations = map[string]string{
+	"normal":    "normal",
+	"bottom-up": "inverted",
+	"left-up":   "left",
+	"right-up":  "right",
+}
+
+var orientationRe = regexp.MustCompile(`orientation(?: changed)?: ([a-z-]+)`)
+
+// watchOrientation follows the accelerometer's orientation with
+// monitor-sensor and sends the rotation for each change, starting with the
+// current one. The channel is closed when monitor-sensor exits.
+func watchOrientation(ctx context.Context) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		cmd := exec.CommandContext(ctx, sensorCommand)
+		out, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			logf(ctx, "rotation: %v", err)
+			return
+		}
+		defer cmd.Wait()
+		sc := bufio.NewScanner(out)
+		for sc.Scan() {
+			tracef(ctx, "rotation: %s", sc.Text())
+			m := orientationRe.FindStringSubmatch(sc.Text())
+			if m == nil {
+				continue
+			}
+			r, ok := orientations[m[1]]
+			if !ok {
+				continue
+			}
+			select {
+			case ch <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+		logf(ctx, "rotation: %s exited", sensorCommand)
+	}()
+	return ch
+}
+
+// mapTouch maps the touch devices onto the output, so touches land where
+// they are made whatever its position and rotation.
+func mapTouch(ctx context.Context, devices []string, output string) {
+	for _, dev := range devices {
+		debugf(ctx, "xinput map-to-output %q %s", dev, output)
+		if _, err := runCommand(ctx, false, "xinput", []string{"map-to-output", dev, output}, nil); err != nil {
+			logf(ctx, "touch %q: %v", dev, err)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// rule picks a profile or layout when its conditions hold. Rules are
+// evaluated in order before the profiles are matched; the first that holds
+// wins.
+type rule struct {
+	// Name identifies the rule in logs and status; it defaults to
+	// "rule N".
+	Name string        `json:"name,omitempty"`
+	When ruleCondition `json:"when"`
+	Do   ruleAction    `json:"do"`
+}
+
+// ruleCondition holds when all of its set fields do.
+type ruleCondition struct {
+	// Connected outputs must all be connected, Disconnected ones must
+	// not be; both by connector name or EDID fingerprint.
+	Connected    []string `json:"connected,omitempty"`
+	Disconnected []string `json:"disconnected,omitempty"`
+	// Externals is the number of connected external outputs.
+	Externals *int `json:"externals,omitempty"`
+	// Lid is "open" or "closed"; it never holds on machines without a lid.
+	Lid string `json:"lid,omitempty"`
+	// AC is whether the machine runs on mains power.
+	AC *bool `json:"ac,omitempty"`
+	// Tablet is whether a convertible is folded into tablet mode; it never
+	// holds on machines without a tablet-mode switch.
+	Tablet *bool `json:"tablet,omitempty"`
+	// Dock names the docking station that must be attached.
+	Dock string `json:"dock,omitempty"`
+	// Time is the daily time window, e.g. "09:00-17:30".
+	Time string `json:"time,omitempty"`
+	// Days are the days of the week: "mon" to "sun", "weekdays" or
+	// "weekend".
+	Days []string `json:"days,omitempty"`
+	// Check is a shell command that must exit 0.
+	Check string `json:"check,omitempty"`
+}
+
+// ruleAction is what a rule applies: a profile, or one of the built-in
+// layouts.
+type ruleAction struct {
+	Profile string `json:"profile,omitempty"`
+	Layout  string `json:"layout,omitempty"`
+}
+
+func (r *rule) name(i int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rule %d", i+1)
+}
+
+// holds reports whether the condition is met by the connected outputs and
+// the machine's state.
+func (c *ruleCondition) holds(ctx context.Context, cfg *config, connected []output, ck *checks) bool {
+	has := func(want string) bool {
+		return slices.ContainsFunc(connected, func(o output) bool {
+			return matchesOutput(want, o)
+		})
+	}
+	for _, want := range c.Connected {
+		if !has(want) {
+			return false
+		}
+	}
+	for _, want := range c.Disconnected {
+		if has(want) {
+			return false
+		}
+	}
+	if c.Externals != nil {
+		n := 0
+		for _, o := range connected {
+			if !o.internal() {
+				n++
+			}
+		}
+		if n != *c.Externals {
+			return false
+		}
+	}
+	if c.Lid != "" && lidState() != c.Lid {
+		return false
+	}
+	if c.AC != nil {
+		if online, ok := acState(); !ok || online != *c.AC {
+			return false
+		}
+	}
+	if c.Tablet != nil {
+		if on, ok := tabletMode(ctx); !ok || on != *c.Tablet {
+			return false
+		}
+	}
+	if c.Time != "" && !inWindow(c.Time, time.Now()) {
+		return false
+	}
+	if len(c.Days) > 0 && !onDays(c.Days, time.Now()) {
+		return false
+	}
+	if c.Dock != "" {
+		if d, ok := cfg.Docks[c.Dock]; !ok || !d.present(connected) {
+			return false
+		}
+	}
+	return c.Check == "" || ck.holds(c.Check)
+}
+
+// checks runs the check commands of rules and profiles and remembers what
+// they reported, so a planning pass runs each command once and the status
+// and HTTP API reuse its outcome rather than running the command again.
+type checks struct {
+	// ctx bounds the commands; without one, only the remembered outcomes
+	// are reported.
+	ctx     context.Context
+	results map[string]bool
+}
+
+func newChecks(ctx context.Context) *checks {
+	return &checks{ctx: ctx, results: make(map[string]bool)}
+}
+
+// holds reports whether the command exits 0. A command that was not run
+// does not hold when the checks cannot run it, nor do any with nil checks.
+func (ck *checks) holds(command string) bool {
+	if ck == nil {
+		return false
+	}
+	ok, seen := ck.results[command]
+	if seen || ck.ctx == nil {
+		return ok
+	}
+	ok = commandHolds(ck.ctx, command)
+	ck.results[command] = ok
+	return ok
+}
+
+// cached returns checks reporting the outcomes seen so far without running
+// anything, safe to hand to other goroutines.
+func (ck *checks) cached() *checks {
+	if ck == nil {
+		return nil
+	}
+	return &checks{results: maps.Clone(ck.results)}
+}
+
+// commandHolds runs a condition's shell command and reports whether it
+// exited 0. It is bounded by command_timeout, as planning waits for it.
+func commandHolds(ctx context.Context, command string) bool {
+	_, err := runCommand(ctx, false, "sh", []string{"-c", command}, nil)
+	if err != nil {
+		debugf(ctx, "check %q: %v", command, err)
+	}
+	return err == nil
+}
+
+// matchRule returns the profile chosen by the first rule that holds, or nil.
+func matchRule(ctx context.Context, cfg *config, connected []output, ck *checks) *profile {
+	for i := range cfg.Rules {
+		r := &cfg.Rules[i]
+		if !r.When.holds(ctx, cfg, connected, ck) {
+			continue
+		}
+		debugf(ctx, "%s holds", r.name(i))
+		if r.Do.Profile != "" {
+			return cfg.lookup(r.Do.Profile)
+		}
+		return &profile{Name: r.name(i), Layout: r.Do.Layout}
+	}
+	return nil
+}
+
+// checkRules reports problems in the rules.
+func (c *config) checkRules() []*configError {
+	var errs []*configError
+	for i, r := range c.Rules {
+		bad := func(field, format string, args ...any) {
+			errs = append(errs, &configError{File: c.file, Path: fmt.Sprintf("rules[%d].%s", i, field),
+				Msg: r.name(i) + ": " + fmt.Sprintf(format, args...)})
+		}
+		for j, want := range r.When.Connected {
+			if err := checkPattern(want); err != nil {
+				bad(fmt.Sprintf("when.connected[%d]", j), "%v", err)
+			}
+		}
+		for j, want := range r.When.Disconnected {
+			if err := checkPattern(want); err != nil {
+				bad(fmt.Sprintf("when.disconnected[%d]", j), "%v", err)
+			}
+		}
+		if _, ok := c.Docks[r.When.Dock]; r.When.Dock != "" && !ok {
+			bad("when.dock", "unknown dock %q", r.When.Dock)
+		}
+		switch r.When.Lid {
+		case "", "open", "closed":
+		default:
+			bad("when.lid", "lid must be \"open\" or \"closed\", not %q", r.When.Lid)
+		}
+		if r.When.Time != "" {
+			if _, err := parseTimeWindow(r.When.Time); err != nil {
+				bad("when.time", "%v", err)
+			}
+		}
+		if err := checkDays(r.When.Days); err != nil {
+			bad("when.days", "%v", err)
+		}
+		switch {
+		case r.Do.Profile != "" && r.Do.Layout != "":
+			bad("do", "both profile and layout")
+		case r.Do.Profile != "":
+			if c.lookup(r.Do.Profile) == nil {
+				bad("do.profile", "unknown profile %q", r.Do.Profile)
+			}
+		case r.Do.Layout != "":
+			switch r.Do.Layout {
+			case layoutMirror, layoutExtend, layoutExternalOnly, layoutInternalOnly, layoutMixed:
+			default:
+				bad("do.layout", "unknown layout %q", r.Do.Layout)
+			}
+		default:
+			bad("do", "neither profile nor layout")
+		}
+	}
+	return errs
+}
+
+// onlyLayout extends the outputs for which keep holds, left to right, and
+// switches the other connected ones off. When keep holds for none of them,
+// all are extended, so the screens never go dark.
+func onlyLayout(connected []output, keep func(output) bool) layout {
+	var kept, off []output
+	for _, o := range connected {
+		if keep(o) {
+			kept = append(kept, o)
+		} else {
+			off = append(off, o)
+		}
+	}
+	if len(kept) == 0 {
+		return extend(connected)
+	}
+	l := extend(kept)
+	for _, o := range off {
+		l = append(l, outputConfig{Name: o.Name, outputState: outputState{Off: true}})
+	}
+	return l
+}
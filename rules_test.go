@@ -0,0 +1,116 @@
+package randr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchRule(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	connected := connectedOutputs(outputs)
+	one, two := 1, 2
+	today := strings.ToLower(time.Now().Weekday().String()[:3])
+	var otherDays []string
+	for _, d := range []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"} {
+		if d != today {
+			otherDays = append(otherDays, d)
+		}
+	}
+	cfg := &config{Profiles: []profile{{Name: "desk"}}}
+
+	for _, tc := range []struct {
+		name  string
+		rules []rule
+		want  string
+	}{
+		{"no rules", nil, ""},
+		{"connector", []rule{{When: ruleCondition{Connected: []string{"HDMI-1"}}, Do: ruleAction{Profile: "desk"}}}, "desk"},
+		{"fingerprint", []rule{{When: ruleCondition{Connected: []string{"DEL-A0B8-718NY83"}}, Do: ruleAction{Profile: "desk"}}}, "desk"},
+		{"pattern", []rule{{When: ruleCondition{Connected: []string{"DEL-*"}}, Do: ruleAction{Profile: "desk"}}}, "desk"},
+		{"not all connected", []rule{{When: ruleCondition{Connected: []string{"HDMI-1", "DP-1"}}, Do: ruleAction{Profile: "desk"}}}, ""},
+		{"disconnected", []rule{{When: ruleCondition{Disconnected: []string{"DP-1"}}, Do: ruleAction{Layout: layoutMirror}}}, "rule 1"},
+		{"not disconnected", []rule{{When: ruleCondition{Disconnected: []string{"HDMI-1"}}, Do: ruleAction{Profile: "desk"}}}, ""},
+		{"externals", []rule{
+			{Name: "two", When: ruleCondition{Externals: &two}, Do: ruleAction{Layout: layoutExtend}},
+			{Name: "one", When: ruleCondition{Externals: &one}, Do: ruleAction{Layout: layoutExternalOnly}},
+		}, "one"},
+		{"first that holds", []rule{
+			{When: ruleCondition{Connected: []string{"eDP-1"}}, Do: ruleAction{Layout: layoutInternalOnly}},
+			{When: ruleCondition{Connected: []string{"HDMI-1"}}, Do: ruleAction{Profile: "desk"}},
+		}, "rule 1"},
+		{"today", []rule{{When: ruleCondition{Days: []string{"weekdays", "weekend"}}, Do: ruleAction{Profile: "desk"}}}, "desk"},
+		{"not today", []rule{{When: ruleCondition{Days: otherDays}, Do: ruleAction{Profile: "desk"}}}, ""},
+		{"unknown dock", []rule{{When: ruleCondition{Dock: "tb4"}, Do: ruleAction{Profile: "desk"}}}, ""},
+		{"check holds", []rule{{When: ruleCondition{Check: "true"}, Do: ruleAction{Profile: "desk"}}}, "desk"},
+		{"check fails", []rule{{When: ruleCondition{Check: "false"}, Do: ruleAction{Profile: "desk"}}}, ""},
+		{"all must hold", []rule{{When: ruleCondition{Connected: []string{"HDMI-1"}, Check: "false"}, Do: ruleAction{Profile: "desk"}}}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg.Rules = tc.rules
+			got := ""
+			if p := matchRule(context.Background(), cfg, connected, newChecks(context.Background())); p != nil {
+				got = p.Name
+			}
+			if got != tc.want {
+				t.Errorf("matched %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestChecksRunOnce(t *testing.T) {
+	ran := filepath.Join(t.TempDir(), "ran")
+	command := "echo >> " + ran
+	ck := newChecks(context.Background())
+	for range 3 {
+		if !ck.holds(command) {
+			t.Fatal("check did not hold")
+		}
+	}
+	if !ck.cached().holds(command) || ck.cached().holds("true") {
+		t.Error("cached checks do not report what was seen")
+	}
+	if (*checks)(nil).holds("true") {
+		t.Error("nil checks hold")
+	}
+	data, _ := os.ReadFile(ran)
+	if n := strings.Count(string(data), "\n"); n != 1 {
+		t.Errorf("check ran %d times, want once", n)
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		r    rule
+		want []string
+	}{
+		{"valid", rule{When: ruleCondition{Connected: []string{"DEL-*"}, Lid: "closed", Time: "09:00-17:30", Days: []string{"weekdays"}},
+			Do: ruleAction{Profile: "desk"}}, nil},
+		{"built-in profile", rule{Do: ruleAction{Profile: layoutMirror}}, nil},
+		{"bad patterns", rule{When: ruleCondition{Connected: []string{"HDMI-["}, Disconnected: []string{"/(/"}}, Do: ruleAction{Layout: layoutExtend}},
+			[]string{"rules[0].when.connected[0]", "rules[0].when.disconnected[0]"}},
+		{"bad conditions", rule{When: ruleCondition{Dock: "tb4", Lid: "ajar", Time: "9-5", Days: []string{"caturday"}}, Do: ruleAction{Layout: layoutExtend}},
+			[]string{"rules[0].when.dock", "rules[0].when.lid", "rules[0].when.time", "rules[0].when.days"}},
+		{"both", rule{Do: ruleAction{Profile: "desk", Layout: layoutExtend}}, []string{"rules[0].do"}},
+		{"neither", rule{}, []string{"rules[0].do"}},
+		{"unknown profile", rule{Do: ruleAction{Profile: "couch"}}, []string{"rules[0].do.profile"}},
+		{"unknown layout", rule{Do: ruleAction{Layout: "diagonal"}}, []string{"rules[0].do.layout"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config{Profiles: []profile{{Name: "desk"}}, Rules: []rule{tc.r}}
+			var got []string
+			for _, err := range cfg.checkRules() {
+				got = append(got, err.Path)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("errors at %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
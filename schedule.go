@@ -0,0 +1,119 @@
+package randr
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// timeWindow is a daily span of local time, written "HH:MM-HH:MM". One that
+// ends before it starts runs past midnight, as "22:00-07:00" does.
+type timeWindow struct {
+	// from and to are minutes since midnight; to is exclusive.
+	from, to int
+}
+
+// parseTimeWindow parses an "HH:MM-HH:MM" window.
+func parseTimeWindow(s string) (timeWindow, error) {
+	var w timeWindow
+	var h1, m1, h2, m2 int
+	n, err := fmt.Sscanf(s, "%d:%d-%d:%d", &h1, &m1, &h2, &m2)
+	if err != nil || n != 4 || fmt.Sprintf("%02d:%02d-%02d:%02d", h1, m1, h2, m2) != s ||
+		h1 > 23 || h2 > 24 || m1 > 59 || m2 > 59 || (h2 == 24 && m2 != 0) {
+		return w, fmt.Errorf("bad time window %q, want HH:MM-HH:MM", s)
+	}
+	w.from, w.to = h1*60+m1, h2*60+m2
+	if w.from == w.to {
+		return timeWindow{}, fmt.Errorf("empty time window %q", s)
+	}
+	return w, nil
+}
+
+// contains reports whether t falls in the window.
+func (w timeWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.from < w.to {
+		return m >= w.from && m < w.to
+	}
+	return m >= w.from || m < w.to
+}
+
+// inWindow reports whether t falls in the window s. Windows are checked
+// when the config is loaded, so a bad one is never in effect.
+func inWindow(s string, t time.Time) bool {
+	w, err := parseTimeWindow(s)
+	return err == nil && w.contains(t)
+}
+
+// weekdays maps the day names of the config to the days they stand for.
+var weekdays = map[string][]time.Weekday{
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"sun":      {time.Sunday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend":  {time.Saturday, time.Sunday},
+}
+
+// checkDays reports a day name that is not known.
+func checkDays(days []string) error {
+	for _, d := range days {
+		if _, ok := weekdays[d]; !ok {
+			return fmt.Errorf("unknown day %q, want mon to sun, weekdays or weekend", d)
+		}
+	}
+	return nil
+}
+
+// onDays reports whether t falls on one of the days.
+func onDays(days []string, t time.Time) bool {
+	for _, d := range days {
+		if slices.Contains(weekdays[d], t.Weekday()) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextSwitch returns when the time window of a profile or rule next opens
+// or closes after t, or the day changes if one is limited to some days. It
+// returns the zero time if none is scheduled.
+func (c *config) nextSwitch(t time.Time) time.Time {
+	var windows []string
+	days := false
+	for _, p := range c.Profiles {
+		if p.Time != "" {
+			windows = append(windows, p.Time)
+		}
+		days = days || len(p.Days) > 0
+	}
+	for _, r := range c.Rules {
+		if r.When.Time != "" {
+			windows = append(windows, r.When.Time)
+		}
+		days = days || len(r.When.Days) > 0
+	}
+	var boundaries []int
+	if days {
+		boundaries = append(boundaries, 0)
+	}
+	for _, s := range windows {
+		if w, err := parseTimeWindow(s); err == nil {
+			boundaries = append(boundaries, w.from, w.to)
+		}
+	}
+	var next time.Time
+	for _, m := range boundaries {
+		at := time.Date(t.Year(), t.Month(), t.Day(), m/60, m%60, 0, 0, t.Location())
+		if !at.After(t) {
+			at = time.Date(t.Year(), t.Month(), t.Day()+1, m/60, m%60, 0, 0, t.Location())
+		}
+		if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return next
+}
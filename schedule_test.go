@@ -0,0 +1,110 @@
+package randr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		from, to int
+		ok       bool
+	}{
+		{"09:00-17:30", 9 * 60, 17*60 + 30, true},
+		{"22:00-07:00", 22 * 60, 7 * 60, true},
+		{"00:00-24:00", 0, 24 * 60, true},
+		{"9:00-17:30", 0, 0, false},
+		{"09:00-17:30 ", 0, 0, false},
+		{"09:00", 0, 0, false},
+		{"24:00-07:00", 0, 0, false},
+		{"09:60-17:00", 0, 0, false},
+		{"09:00-24:30", 0, 0, false},
+		{"08:00-08:00", 0, 0, false},
+	} {
+		w, err := parseTimeWindow(tc.s)
+		if (err == nil) != tc.ok {
+			t.Errorf("%q: error %v, want ok=%t", tc.s, err, tc.ok)
+			continue
+		}
+		if tc.ok && (w.from != tc.from || w.to != tc.to) {
+			t.Errorf("%q: got %d-%d, want %d-%d", tc.s, w.from, w.to, tc.from, tc.to)
+		}
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2026, 10, 14, h, m, 0, 0, time.Local) }
+	for _, tc := range []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"09:00-17:30", at(9, 0), true},
+		{"09:00-17:30", at(17, 29), true},
+		{"09:00-17:30", at(17, 30), false},
+		{"09:00-17:30", at(8, 59), false},
+		{"22:00-07:00", at(23, 0), true},
+		{"22:00-07:00", at(6, 59), true},
+		{"22:00-07:00", at(7, 0), false},
+		{"22:00-07:00", at(12, 0), false},
+		{"00:00-24:00", at(23, 59), true},
+		{"bad", at(12, 0), false},
+	} {
+		if got := inWindow(tc.window, tc.t); got != tc.want {
+			t.Errorf("%s at %s: got %t, want %t", tc.window, tc.t.Format("15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestOnDays(t *testing.T) {
+	wed := time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local)
+	sat := wed.AddDate(0, 0, 3)
+	for _, tc := range []struct {
+		days []string
+		t    time.Time
+		want bool
+	}{
+		{[]string{"wed"}, wed, true},
+		{[]string{"mon", "tue"}, wed, false},
+		{[]string{"weekdays"}, wed, true},
+		{[]string{"weekdays"}, sat, false},
+		{[]string{"weekend"}, sat, true},
+		{[]string{"fri", "weekend"}, sat, true},
+	} {
+		if got := onDays(tc.days, tc.t); got != tc.want {
+			t.Errorf("%v on %s: got %t, want %t", tc.days, tc.t.Weekday(), got, tc.want)
+		}
+	}
+	if err := checkDays([]string{"weekdays", "sun"}); err != nil {
+		t.Error(err)
+	}
+	if err := checkDays([]string{"Monday"}); err == nil {
+		t.Error("Monday accepted")
+	}
+}
+
+func TestNextSwitch(t *testing.T) {
+	at := func(d, h, m int) time.Time { return time.Date(2026, 10, d, h, m, 0, 0, time.Local) }
+	for _, tc := range []struct {
+		name string
+		cfg  config
+		t    time.Time
+		want time.Time
+	}{
+		{"nothing scheduled", config{Profiles: []profile{{Name: "desk"}}}, at(14, 12, 0), time.Time{}},
+		{"window opens", config{Profiles: []profile{{Name: "desk", Time: "09:00-17:30"}}}, at(14, 8, 0), at(14, 9, 0)},
+		{"window closes", config{Profiles: []profile{{Name: "desk", Time: "09:00-17:30"}}}, at(14, 9, 0), at(14, 17, 30)},
+		{"tomorrow", config{Profiles: []profile{{Name: "desk", Time: "09:00-17:30"}}}, at(14, 18, 0), at(15, 9, 0)},
+		{"rule", config{Rules: []rule{{When: ruleCondition{Time: "22:00-07:00"}}}}, at(14, 12, 0), at(14, 22, 0)},
+		{"earliest", config{Profiles: []profile{{Name: "desk", Time: "09:00-17:30"}},
+			Rules: []rule{{When: ruleCondition{Time: "12:30-13:15"}}}}, at(14, 12, 0), at(14, 12, 30)},
+		{"new day", config{Profiles: []profile{{Name: "desk", Days: []string{"weekdays"}}}}, at(14, 12, 0), at(15, 0, 0)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.cfg.nextSwitch(tc.t); !got.Equal(tc.want) {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
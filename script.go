@@ -0,0 +1,260 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"randr/internal/starlark"
+)
+
+// scriptInput is what the layout script reads on stdin, or a Starlark
+// script's layout function receives as a dict.
+type scriptInput struct {
+	Outputs []scriptOutput `json:"outputs"`
+	// Lid is "open", "closed" or absent without a lid; AC is absent
+	// without a mains power supply, and Tablet without a tablet-mode
+	// switch.
+	Lid    string `json:"lid,omitempty"`
+	AC     *bool  `json:"ac,omitempty"`
+	Tablet *bool  `json:"tablet,omitempty"`
+	// Dock is the configured docking station that is attached, if any.
+	Dock string `json:"dock,omitempty"`
+	// Profile is the profile the built-in planner would apply.
+	Profile string `json:"profile"`
+}
+
+type scriptOutput struct {
+	Name        string       `json:"name"`
+	Connected   bool         `json:"connected"`
+	Internal    bool         `json:"internal,omitempty"`
+	Primary     bool         `json:"primary,omitempty"`
+	Monitor     string       `json:"monitor,omitempty"`
+	MonitorName string       `json:"monitor_name,omitempty"`
+	Modes       []resolution `json:"modes,omitempty"`
+	Preferred   resolution   `json:"preferred,omitzero"`
+	// Current, X, Y and Rotation describe an active output.
+	Current  resolution `json:"current,omitzero"`
+	X        int        `json:"x"`
+	Y        int        `json:"y"`
+	Rotation string     `json:"rotation,omitempty"`
+}
+
+// scriptResult is what the script writes on stdout: a profile to apply, or
+// a layout in the format of the layout-applied event. An empty object
+// leaves the decision to the built-in planner. A Starlark script returns
+// the same as a dict, or a profile name, a layout list or None.
+type scriptResult struct {
+	Profile string `json:"profile,omitempty"`
+	Layout  layout `json:"layout,omitempty"`
+}
+
+// scriptPath resolves the script against the config file's directory.
+func (c *config) scriptPath() string {
+	if c.Script == "" || filepath.IsAbs(c.Script) {
+		return c.Script
+	}
+	return filepath.Join(filepath.Dir(c.file), c.Script)
+}
+
+// scripted returns the layout script's plan, or nil when there is no script,
+// it fails or it leaves the decision to the built-in planner.
+func (pl *planner) scripted(ctx context.Context, outputs []output) *plan {
+	if pl.cfg.Script == "" {
+		return nil
+	}
+	p, err := pl.script(ctx, outputs)
+	if err != nil {
+		logf(ctx, "script: %v", err)
+	}
+	return p
+}
+
+// script plans the layout with the user's script. It returns nil when the
+// script leaves the decision to the built-in planner.
+func (pl *planner) script(ctx context.Context, outputs []output) (*plan, error) {
+	in := scriptInput{Lid: lidState()}
+	if online, ok := acState(); ok {
+		in.AC = &online
+	}
+	if on, ok := tabletMode(ctx); ok {
+		in.Tablet = &on
+	}
+	in.Profile, _ = matchedProfile(ctx, pl.cfg, outputs, pl.checks)
+	in.Dock = pl.cfg.currentDock(connectedOutputs(outputs))
+	for _, o := range outputs {
+		so := scriptOutput{
+			Name:        o.Name,
+			Connected:   o.Connected,
+			Internal:    o.internal(),
+			Primary:     o.Primary,
+			Monitor:     o.Monitor.String(),
+			MonitorName: o.Monitor.Name,
+			Modes:       o.Resolutions,
+		}
+		if res, ok := o.preferred(); ok {
+			so.Preferred = res
+		}
+		if o.active() {
+			so.Current, so.X, so.Y, so.Rotation = o.Resolutions[o.Current], o.X, o.Y, o.Rotation
+		}
+		in.Outputs = append(in.Outputs, so)
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	path := pl.cfg.scriptPath()
+	var out []byte
+	if isStarlark(path) {
+		// Starlark errors carry the script's name and position.
+		if out, err = runStarlark(ctx, path, data); err != nil {
+			return nil, err
+		}
+	} else {
+		out, err = runCommand(ctx, true, path, nil, func(cmd *exec.Cmd) {
+			cmd.Stdin = bytes.NewReader(data)
+			cmd.Stderr = os.Stderr
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	var res scriptResult
+	if len(bytes.TrimSpace(out)) > 0 {
+		if err := json.Unmarshal(out, &res); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	debugf(ctx, "script: %s", bytes.TrimSpace(out))
+
+	switch {
+	case res.Profile != "":
+		p := pl.cfg.lookup(res.Profile)
+		if p == nil {
+			return nil, fmt.Errorf("%s: unknown profile %q", path, res.Profile)
+		}
+		return pl.profile(ctx, p, outputs), nil
+	case len(res.Layout) > 0:
+		for _, c := range res.Layout {
+			o, ok := findOutput(outputs, c.Name)
+			if !ok {
+				return nil, fmt.Errorf("%s: unknown output %q", path, c.Name)
+			}
+			if !c.Off && !slices.Contains(o.Resolutions, c.Mode) {
+				return nil, fmt.Errorf("%s: %s does not support mode %s", path, o.Name, c.Mode)
+			}
+		}
+		return newPlan(ctx, "script layout", res.Layout, outputs), nil
+	}
+	return nil, nil
+}
+
+// isStarlark reports whether the script is run by the embedded Starlark
+// interpreter rather than as a program.
+func isStarlark(path string) bool {
+	return strings.HasSuffix(path, ".star")
+}
+
+// loadStarlark runs a Starlark script's top level and returns its layout
+// function.
+func loadStarlark(th *starlark.Thread, path string) (starlark.Value, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	globals, err := starlark.ExecFile(th, path, src)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := globals["layout"].(*starlark.Function)
+	if !ok {
+		return nil, fmt.Errorf("%s: no layout function", path)
+	}
+	return fn, nil
+}
+
+// runStarlark calls a Starlark script's layout function with the input and
+// returns its result as the JSON a script program would write. What the
+// script prints goes to the log.
+func runStarlark(ctx context.Context, path string, data []byte) ([]byte, error) {
+	timeout := settingsFrom(ctx).commandTimeout
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	th := &starlark.Thread{
+		Context: ctx,
+		Print:   func(msg string) { logf(ctx, "%s: %s", filepath.Base(path), msg) },
+	}
+	fn, err := loadStarlark(th, path)
+	if err != nil {
+		return nil, err
+	}
+	var in any
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&in); err != nil {
+		return nil, err
+	}
+	state, err := starlark.FromGo(in)
+	if err != nil {
+		return nil, err
+	}
+	v, err := starlark.Call(th, fn, state)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("%s: timed out after %s", path, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var wrap string
+	switch v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		wrap = "profile"
+	case *starlark.List, starlark.Tuple:
+		wrap = "layout"
+	case *starlark.Dict:
+	default:
+		return nil, fmt.Errorf("%s: layout returned %s, want a profile name, a layout or None", path, starlark.Type(v))
+	}
+	res, err := starlark.ToGo(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: layout returned %w", path, err)
+	}
+	if wrap != "" {
+		res = map[string]any{wrap: res}
+	}
+	return json.Marshal(res)
+}
+
+// checkScript reports a configured script that can't be run: a program
+// that isn't executable, or a Starlark script that doesn't load.
+func checkScript(cfg *config) []*configError {
+	if cfg.Script == "" {
+		return nil
+	}
+	path := cfg.scriptPath()
+	fi, err := os.Stat(path)
+	if err == nil && isStarlark(path) {
+		if _, err := loadStarlark(&starlark.Thread{}, path); err != nil {
+			return []*configError{{File: cfg.file, Path: "script", Msg: fmt.Sprintf("script: %v", err)}}
+		}
+		return nil
+	}
+	switch {
+	case err != nil:
+		return []*configError{{File: cfg.file, Path: "script", Msg: fmt.Sprintf("script: %v", err)}}
+	case fi.Mode()&0o111 == 0:
+		return []*configError{{File: cfg.file, Path: "script", Msg: fmt.Sprintf("script: %s is not executable", path)}}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package randr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStarlarkScript(t *testing.T) {
+	outputs, _ := readQuery(t, "dock.txt")
+	for _, tc := range []struct {
+		name   string
+		script string
+		want   string
+		err    string
+	}{
+		{"layout", `
+def layout(state):
+    ext = [o for o in state["outputs"] if o["connected"] and not o.get("internal")]
+    panel = [o for o in state["outputs"] if o.get("internal")][0]
+    if not ext:
+        return None
+    best = max(ext[0]["modes"], key=lambda m: int(m.split("x")[0]))
+    return [
+        {"name": panel["name"], "off": True},
+        {"name": ext[0]["name"], "mode": best, "x": 0, "y": 0, "primary": True},
+    ]
+`, "--output eDP-1 --off --output HDMI-1 --mode 2560x1440 --pos 0x0 --primary", ""},
+		{"profile", `
+def layout(state):
+    return "desk" if state.get("lid") != "closed" else None
+`, "--output HDMI-1 --mode 2560x1440 --pos 1920x0", ""},
+		{"planner decides", "def layout(state):\n    return {}\n", "", ""},
+		{"unknown output", `
+def layout(state):
+    return [{"name": "DP-9", "mode": "1920x1080", "x": 0, "y": 0}]
+`, "", `unknown output "DP-9"`},
+		{"bad result", "def layout(state):\n    return 1\n", "", "layout returned int"},
+		{"error", "def layout(state):\n    fail(\"no\")\n", "", "layout.star:2:9: fail: no"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "layout.star"), []byte(tc.script), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config{
+				Script:   "layout.star",
+				Profiles: []profile{{Name: "desk", Layout: layoutExtend}},
+				file:     filepath.Join(dir, "config.json"),
+			}
+			ctx := withSettings(context.Background(), cfg.settings())
+			pl := &planner{cfg: cfg, checks: newChecks(ctx)}
+			p, err := pl.script(ctx, outputs)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("got error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if p != nil {
+				got = strings.Join(p.args(), " ")
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckStarlarkScript(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config{Script: "layout.star", file: filepath.Join(dir, "config.json")}
+	for _, tc := range []struct {
+		script string
+		want   string
+	}{
+		{"def layout(state):\n    return None\n", ""},
+		{"layout = 1\n", "no layout function"},
+		{"def layout(state)\n", "layout.star:1:18: expected :"},
+	} {
+		if err := os.WriteFile(filepath.Join(dir, "layout.star"), []byte(tc.script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		errs := checkScript(cfg)
+		switch {
+		case tc.want == "" && len(errs) > 0:
+			t.Errorf("%q: %v", tc.script, errs[0])
+		case tc.want != "" && (len(errs) == 0 || !strings.Contains(errs[0].Error(), tc.want)):
+			t.Errorf("%q: got %v, want %q", tc.script, errs, tc.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package randr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Where the lid, power supply and input device state are read from.
+var (
+	lidStateGlob     = "/proc/acpi/button/lid/*/state"
+	powerSupplyDir   = "/sys/class/power_supply"
+	inputDevicesFile = "/proc/bus/input/devices"
+	inputDir         = "/dev/input"
+)
+
+// swTabletMode is the evdev switch a convertible's tablet-mode switch
+// reports.
+const swTabletMode = 1
+
+// lidState returns "open" or "closed" as reported by ACPI, or "" on
+// machines without a lid.
+func lidState() string {
+	files, _ := filepath.Glob(lidStateGlob)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		// The file reads "state:      open".
+		if fields := strings.Fields(string(data)); len(fields) == 2 {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// acState reports whether the machine runs on mains power; ok is false when
+// no mains supply is reported at all, as on most desktops. It reads sysfs,
+// as UPower does.
+func acState() (online, ok bool) {
+	dirs, _ := filepath.Glob(filepath.Join(powerSupplyDir, "*"))
+	for _, d := range dirs {
+		typ, err := os.ReadFile(filepath.Join(d, "type"))
+		if err != nil || strings.TrimSpace(string(typ)) != "Mains" {
+			continue
+		}
+		v, err := os.ReadFile(filepath.Join(d, "online"))
+		if err != nil {
+			continue
+		}
+		ok = true
+		if strings.TrimSpace(string(v)) == "1" {
+			return true, true
+		}
+	}
+	return false, ok
+}
+
+// tabletMode reports whether a convertible is folded into tablet mode; ok
+// is false without a tablet-mode switch or permission to read it, which
+// takes membership of the input group.
+func tabletMode(ctx context.Context) (on, ok bool) {
+	dev := tabletSwitch()
+	if dev == "" {
+		return false, false
+	}
+	f, err := os.Open(filepath.Join(inputDir, dev))
+	if err != nil {
+		debugf(ctx, "tablet mode: %v", err)
+		return false, false
+	}
+	defer f.Close()
+	// EVIOCGSW reads the state of all the device's switches.
+	var bits [8]byte
+	req := uintptr(2<<30 | len(bits)<<16 | 'E'<<8 | 0x1b)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(&bits[0]))); errno != 0 {
+		debugf(ctx, "tablet mode: %v", errno)
+		return false, false
+	}
+	return bits[0]&(1<<swTabletMode) != 0, true
+}
+
+// tabletSwitch returns the event device, e.g. "event5", of the input
+// device with a tablet-mode switch, or "".
+func tabletSwitch() string {
+	data, err := os.ReadFile(inputDevicesFile)
+	if err != nil {
+		return ""
+	}
+	for _, dev := range strings.Split(string(data), "\n\n") {
+		var event string
+		var sw uint64
+		for _, line := range strings.Split(dev, "\n") {
+			switch {
+			case strings.HasPrefix(line, "H: Handlers="):
+				for _, h := range strings.Fields(strings.TrimPrefix(line, "H: Handlers=")) {
+					if strings.HasPrefix(h, "event") {
+						event = h
+					}
+				}
+			case strings.HasPrefix(line, "B: SW="):
+				// The bitmap is in words, the lowest last.
+				words := strings.Fields(strings.TrimPrefix(line, "B: SW="))
+				if len(words) > 0 {
+					sw, _ = strconv.ParseUint(words[len(words)-1], 16, 64)
+				}
+			}
+		}
+		if event != "" && sw&(1<<swTabletMode) != 0 {
+			return event
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package randr
+
+import (
+	"context"
+	"log"
+	"maps"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPollInterval is how often xrandr is queried for changes unless the
+// config or WithPollInterval say otherwise.
+const defaultPollInterval = 2 * time.Second
+
+// settings are what a Daemon, Watcher or command runs with: where it logs
+// and how much, how often it polls, how long external commands may take,
+// how xrandr is run and layouts applied, and the config's policies for
+// tuning outputs and layouts. Each carries its own down through the
+// context, so several can share a process.
+type settings struct {
+	logger    *log.Logger
+	verbosity logLevel
+	// pollInterval is how often xrandr is queried for changes.
+	pollInterval time.Duration
+	// commandTimeout bounds every external command randr runs; xrandr has
+	// been seen hanging on dying DisplayLink adapters.
+	commandTimeout time.Duration
+	// xrandrPath and xrandrArgs select the xrandr binary and global
+	// arguments passed before every invocation, e.g. "--screen 1" or a
+	// wrapper script.
+	xrandrPath string
+	xrandrArgs []string
+	// applyWith is how layouts are applied, as the config's apply setting
+	// says.
+	applyWith string
+
+	// useNonDesktop lays out non-desktop outputs like any other.
+	useNonDesktop bool
+	// lowPower reuses the last query's outputs while the DRM connectors
+	// look unchanged.
+	lowPower bool
+	// unknownConnection is the policy for outputs in unknown connection
+	// state.
+	unknownConnection string
+	// rates is how far apart two refresh rates can be and still count as
+	// the same.
+	rates tolerance
+	// tv are the TV defaults and power the power policies.
+	tv    tvConfig
+	power powerConfig
+	// primary picks the primary output: the first of its tests that a lit
+	// output passes decides, whatever the layout says.
+	primary []func(output) bool
+	// dockedInternalOff keeps the internal panel off while any external
+	// output is connected.
+	dockedInternalOff bool
+	// forcedModes are the modes to run unidentified outputs at, by
+	// connector name.
+	forcedModes map[string]resolution
+	// quirks are the monitor quirks in effect, the built-in ones and the
+	// config's.
+	quirks map[string]quirk
+
+	// settleDelay is how long verify waits at least: verifyDelay, or
+	// providerSettleDelay when USB display adapters are in use.
+	settleDelay time.Duration
+	// panel is the rotation the internal panel is planned with. It is
+	// state rather than a setting, and stays the same across reloads.
+	panel *panelRotation
+}
+
+// defaultSettings are the settings without a config, with the verbosity
+// asked for on the command line.
+func defaultSettings() *settings {
+	return &settings{
+		logger:         log.Default(),
+		verbosity:      max(levelInfo, minVerbosity),
+		pollInterval:   defaultPollInterval,
+		commandTimeout: defaultCommandTimeout,
+		xrandrPath:     "xrandr",
+		applyWith:      applyXrandr,
+
+		unknownConnection: unknownProbe,
+		rates:             defaultRateTolerance,
+		quirks:            builtinQuirks,
+		settleDelay:       verifyDelay,
+		panel:             new(panelRotation),
+	}
+}
+
+// settings returns the settings the config asks for. The environment
+// overrides the xrandr binary and arguments.
+func (c *config) settings() *settings {
+	s := defaultSettings()
+	if c.CommandTimeout > 0 {
+		s.commandTimeout = time.Duration(c.CommandTimeout)
+	}
+	if c.PollInterval > 0 {
+		s.pollInterval = time.Duration(c.PollInterval)
+	}
+	s.verbosity, _ = parseLogLevel(c.LogLevel)
+	s.verbosity = max(s.verbosity, minVerbosity)
+	if c.Apply != "" {
+		s.applyWith = c.Apply
+	}
+	s.xrandrArgs = c.XrandrArgs
+	if c.XrandrPath != "" {
+		s.xrandrPath = c.XrandrPath
+	}
+	if v := os.Getenv("RANDR_XRANDR"); v != "" {
+		s.xrandrPath = v
+	}
+	if v, ok := os.LookupEnv("RANDR_XRANDR_ARGS"); ok {
+		s.xrandrArgs = strings.Fields(v)
+	}
+
+	s.useNonDesktop = c.UseNonDesktop
+	s.lowPower = c.LowPower
+	if c.UnknownConnection != "" {
+		s.unknownConnection = c.UnknownConnection
+	}
+	s.rates = c.tolerance()
+	s.tv, s.power = c.TV, c.Power
+	s.primary = primaryPolicy(c.Primary, c.PromoteExternal)
+	s.dockedInternalOff = c.DockedInternalOff
+	s.quirks = maps.Clone(builtinQuirks)
+	maps.Copy(s.quirks, c.Quirks)
+	s.forcedModes = make(map[string]resolution)
+	for name, mode := range c.ForcedModes {
+		s.forcedModes[name], _ = parseResolution(mode)
+	}
+	return s
+}
+
+// carry keeps the state s holds across a reload to next.
+func (s *settings) carry(next *settings) *settings {
+	next.settleDelay, next.panel = s.settleDelay, s.panel
+	return next
+}
+
+// over sets the logger and poll interval given to a Daemon or Watcher on s,
+// in place of the config's.
+func (o options) over(s *settings) *settings {
+	if o.logger != nil {
+		s.logger = o.logger
+	}
+	if o.interval > 0 {
+		s.pollInterval = o.interval
+	}
+	return s
+}
+
+type settingsKey struct{}
+
+// withSettings returns a context carrying s, for everything run under it.
+func withSettings(ctx context.Context, s *settings) context.Context {
+	var cur atomic.Pointer[settings]
+	cur.Store(s)
+	return context.WithValue(ctx, settingsKey{}, &cur)
+}
+
+// setSettings replaces the settings ctx carries, as a config reload does,
+// for everything run under it from now on, including goroutines started
+// before.
+func setSettings(ctx context.Context, s *settings) {
+	if cur, ok := ctx.Value(settingsKey{}).(*atomic.Pointer[settings]); ok {
+		cur.Store(s)
+	}
+}
+
+// settingsFrom returns the settings ctx carries, or the defaults.
+func settingsFrom(ctx context.Context) *settings {
+	if cur, ok := ctx.Value(settingsKey{}).(*atomic.Pointer[settings]); ok {
+		return cur.Load()
+	}
+	return defaultSettings()
+}
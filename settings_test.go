@@ -0,0 +1,112 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const laptopQuery = `Screen 0: minimum 320 x 200, current 1920 x 1080, maximum 16384 x 16384
+eDP-1 connected primary 1920x1080+0+0 (normal left inverted right x axis y axis) 344mm x 194mm
+   1920x1080     60.01*+
+`
+
+// flakyBackend answers the first query and fails every one after it.
+type flakyBackend struct {
+	name    string
+	queries atomic.Int32
+}
+
+func (b *flakyBackend) Query(ctx context.Context) ([]byte, error) {
+	if b.queries.Add(1) == 1 {
+		return []byte(laptopQuery), nil
+	}
+	return nil, errors.New(b.name + " is gone")
+}
+
+func (b *flakyBackend) Configure(ctx context.Context, args []string) error { return nil }
+
+func TestWatchersKeepTheirSettings(t *testing.T) {
+	var logs [2]bytes.Buffer
+	var watchers [2]*Watcher
+	for i, name := range []string{"first", "second"} {
+		w, err := NewWatcher(context.Background(), WithBackend(&flakyBackend{name: name}),
+			WithPollInterval(time.Millisecond), WithLogger(log.New(&logs[i], "", 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		watchers[i] = w
+	}
+	time.Sleep(50 * time.Millisecond)
+	for _, w := range watchers {
+		w.Close()
+	}
+	for i, want := range []string{"first is gone", "second is gone"} {
+		other := []string{"second is gone", "first is gone"}[i]
+		if got := logs[i].String(); !strings.Contains(got, want) || strings.Contains(got, other) {
+			t.Errorf("watcher %d logged %q, want only its own failures", i, got)
+		}
+	}
+	if got := settingsFrom(context.Background()).logger; got != log.Default() {
+		t.Errorf("the default logger was replaced")
+	}
+}
+
+func TestConfigsKeepTheirPolicies(t *testing.T) {
+	strict := &config{RateTolerance: 0.01, UnknownConnection: unknownDisconnected, DockedInternalOff: true}
+	loose := &config{UnknownConnection: unknownConnected}
+	strictCtx := withSettings(context.Background(), strict.settings())
+	looseCtx := withSettings(context.Background(), loose.settings())
+
+	unknown := []output{{Name: "VGA-1", UnknownConnection: true}}
+	for _, tc := range []struct {
+		ctx  context.Context
+		want bool
+	}{{strictCtx, false}, {looseCtx, true}, {strictCtx, false}} {
+		outputs := slices.Clone(unknown)
+		resolveUnknown(tc.ctx, outputs)
+		if outputs[0].Connected != tc.want {
+			t.Errorf("unknown connection taken as connected=%t, want %t", outputs[0].Connected, tc.want)
+		}
+	}
+
+	if settingsFrom(strictCtx).rates.sameRate(59.94, 60) {
+		t.Errorf("59.94 and 60 the same at a tolerance of 0.01")
+	}
+	if !settingsFrom(looseCtx).rates.sameRate(59.94, 60) {
+		t.Errorf("59.94 and 60 not the same at the default tolerance")
+	}
+
+	docked := []output{
+		{Name: "eDP-1", Connected: true, CRTC: true, Current: 0, Resolutions: []resolution{{1920, 1080}}},
+		{Name: "HDMI-1", Connected: true},
+	}
+	l := layout{
+		{Name: "eDP-1", outputState: outputState{Mode: resolution{1920, 1080}, Primary: true}},
+		{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920}},
+	}
+	if got := keepInternalOff(strictCtx, l, docked); !got[0].Off {
+		t.Errorf("docked_internal_off left the panel lit: %v", got)
+	}
+	if got := keepInternalOff(looseCtx, l, docked); got[0].Off {
+		t.Errorf("panel switched off without docked_internal_off: %v", got)
+	}
+}
+
+func TestReloadKeepsPanelRotation(t *testing.T) {
+	ctx := withSettings(context.Background(), defaultSettings())
+	settingsFrom(ctx).panel.set("left")
+	d := NewDaemon()
+	d.setup(ctx, &config{})
+	outputs := []output{{Name: "eDP-1", Connected: true}}
+	rotateOutputs(ctx, outputs)
+	if outputs[0].rotate != "left" {
+		t.Errorf("panel rotation after reload = %q, want left", outputs[0].rotate)
+	}
+}
@@ -0,0 +1,32 @@
+package randr
+
+import "context"
+
+// restoreStartup applies the layout the daemon started with, as it exits,
+// if the monitors connected are still the ones it started with; otherwise
+// that layout may not fit them, and the current one stays. The
+// framebuffer is sized to fit, as the server does not shrink it by itself.
+func (d *Daemon) restoreStartup(ctx context.Context, ex executor, l layout, fp string) {
+	// The daemon's context is done by now; each command is bounded by its
+	// own timeout.
+	ctx = context.WithoutCancel(ctx)
+	outputs, scr, err := queryOutputs(ctx, d.backend)
+	if err != nil {
+		logf(ctx, "restore on exit: %v", err)
+		return
+	}
+	if fingerprint(connectedOutputs(outputs)) != fp {
+		infof(ctx, "restore on exit: the monitors changed since startup, leaving the layout")
+		return
+	}
+	p := newPlan(ctx, "restore the layout from startup", l, outputs)
+	p.resize = true
+	if err := p.validate(scr); err != nil {
+		logf(ctx, "restore on exit: %v", err)
+		return
+	}
+	infof(ctx, "applying %s", p.Reason)
+	if err := applyVerified(ctx, ex, d.backend, p, &daemonState{}); err != nil {
+		logf(ctx, "restore on exit: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package randr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// daemonState is what the daemon remembers across restarts, stored in
+// $XDG_STATE_HOME/randr/state.json.
+type daemonState struct {
+	// Fingerprint identifies the monitor set last seen connected.
+	Fingerprint string `json:"fingerprint"`
+	// Profile and Layout describe the last layout randr applied.
+	Profile string `json:"profile,omitempty"`
+	Layout  layout `json:"layout,omitempty"`
+	// LastGood is the last layout that was verified after applying, for
+	// the monitor set identified by LastGoodFingerprint.
+	LastGood            layout `json:"last_good,omitempty"`
+	LastGoodFingerprint string `json:"last_good_fingerprint,omitempty"`
+	// Undo are the layouts the outputs were in before randr's last changes,
+	// the latest last, which `randr undo` puts back; Redo are the ones it
+	// undid, which `randr redo` puts back again.
+	Undo []snapshot `json:"undo,omitempty"`
+	Redo []snapshot `json:"redo,omitempty"`
+	// Until is when the temporary layout applied with `randr apply --for`
+	// runs out, and RevertTo the layout put back then.
+	Until    time.Time `json:"until,omitzero"`
+	RevertTo *snapshot `json:"revert_to,omitempty"`
+	// Baseline is the configuration of the internal panels before randr
+	// first changed it, restored when all externals are gone.
+	Baseline layout `json:"baseline,omitempty"`
+	// Paused is set while randr holds off because the layout was changed
+	// by hand.
+	Paused bool `json:"paused,omitempty"`
+	// Monitors are the virtual monitors randr set up.
+	Monitors []string `json:"monitors,omitempty"`
+	// LastAction describes the last thing the daemon did, at LastActionTime.
+	LastAction     string    `json:"last_action,omitempty"`
+	LastActionTime time.Time `json:"last_action_time,omitzero"`
+	Updated        time.Time `json:"updated"`
+}
+
+// action records what the daemon just did for `randr status`.
+func (st *daemonState) action(format string, args ...any) {
+	st.LastAction, st.LastActionTime = fmt.Sprintf(format, args...), time.Now()
+}
+
+// loadState reads the saved state. A missing file yields the zero state.
+func loadState(path string) (*daemonState, error) {
+	st := &daemonState{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// save writes the state atomically so a crash never leaves a torn file.
+func (st *daemonState) save(path string) error {
+	st.Updated = time.Now()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
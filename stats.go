@@ -0,0 +1,59 @@
+package randr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// daemonStats counts what the daemon has done since it started, for the
+// stats command.
+type daemonStats struct {
+	Started time.Time `json:"started"`
+	// Hotplugs counts monitors connected and disconnected.
+	Hotplugs int `json:"hotplugs"`
+	// Applied counts the layouts that changed the displays, and ApplyTime
+	// is the time they took, verification included.
+	Applied   int           `json:"applied"`
+	ApplyTime time.Duration `json:"apply_time"`
+	// Failures counts failed queries and refused or failed layouts.
+	Failures int `json:"failures"`
+	// Backoff is the number of consecutive query failures and Delay the
+	// poll interval they stretched it to.
+	Backoff int           `json:"backoff,omitempty"`
+	Delay   time.Duration `json:"delay,omitempty"`
+}
+
+// statsDaemon asks the daemon listening on path for its statistics.
+func statsDaemon(ctx context.Context, path string) (*daemonStats, error) {
+	resp, err := callDaemon(ctx, path, controlRequest{Command: "stats"})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Stats == nil {
+		return nil, errors.New("control: empty response")
+	}
+	return resp.Stats, nil
+}
+
+// runStats prints the running daemon's statistics.
+func runStats(ctx context.Context, dirs paths) error {
+	s, err := statsDaemon(ctx, dirs.socket())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("uptime:     %s\n", time.Since(s.Started).Round(time.Second))
+	fmt.Printf("hotplugs:   %d\n", s.Hotplugs)
+	fmt.Printf("applied:    %d\n", s.Applied)
+	if s.Applied > 0 {
+		fmt.Printf("apply time: %s on average\n", (s.ApplyTime / time.Duration(s.Applied)).Round(time.Millisecond))
+	}
+	fmt.Printf("failures:   %d\n", s.Failures)
+	if s.Backoff > 0 {
+		fmt.Printf("backoff:    %d consecutive query failure(s), polling every %s\n", s.Backoff, s.Delay)
+	} else {
+		fmt.Println("backoff:    none")
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracer exports spans of the detect, plan and apply pipeline with
+// OTLP/HTTP, when the standard OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT environment variable is set. It is nil,
+// and tracing a no-op, otherwise.
+var tracer *otlpExporter
+
+// setupTracing configures tracer from the environment.
+func setupTracing(ctx context.Context) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			tracer = nil
+			return
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "randr"
+	}
+	headers := make(map[string]string)
+	for _, kv := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	tracer = &otlpExporter{endpoint: endpoint, service: service, headers: headers, settings: settingsFrom(ctx)}
+	infof(ctx, "tracing: exporting to %s", endpoint)
+}
+
+// trace collects the spans sharing a root until the root ends.
+type trace struct {
+	mu    sync.Mutex
+	id    [16]byte
+	spans []*span
+	// keep is set once something worth exporting happened; polls that
+	// found nothing to do are dropped.
+	keep bool
+}
+
+// span is one timed step. All methods are no-ops on a nil span, which is
+// what startSpan returns when tracing is off.
+type span struct {
+	trace      *trace
+	id, parent [8]byte
+	name       string
+	start, end time.Time
+	attrs      map[string]string
+	err        error
+}
+
+type spanKey struct{}
+
+// startSpan starts a span as a child of the span in ctx, or as the root of
+// a new trace.
+func startSpan(ctx context.Context, name string) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, start: time.Now(), attrs: make(map[string]string)}
+	rand.Read(s.id[:])
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.trace, s.parent = parent.trace, parent.id
+	} else {
+		s.trace = &trace{}
+		rand.Read(s.trace.id[:])
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// set adds an attribute.
+func (s *span) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	s.attrs[key] = fmt.Sprint(value)
+}
+
+// keep marks the span's trace for export.
+func (s *span) keep() {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	s.trace.keep = true
+}
+
+// finish ends the span, recording err as its status. Ending a root span
+// exports its trace if it was kept.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	t := s.trace
+	t.mu.Lock()
+	s.end, s.err = time.Now(), err
+	t.spans = append(t.spans, s)
+	export := s.parent == [8]byte{} && t.keep
+	spans := t.spans
+	t.mu.Unlock()
+	if export {
+		go tracer.export(t.id, spans)
+	}
+}
+
+// otlpExporter posts traces as OTLP/HTTP JSON.
+type otlpExporter struct {
+	endpoint string
+	service  string
+	headers  map[string]string
+	// settings are the daemon's, for exports that run detached from it.
+	settings *settings
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func (e *otlpExporter) export(traceID [16]byte, spans []*span) {
+	ctx := withSettings(context.Background(), e.settings)
+	var out []otlpSpan
+	for _, s := range spans {
+		os := otlpSpan{
+			TraceID: hex.EncodeToString(traceID[:]),
+			SpanID:  hex.EncodeToString(s.id[:]),
+			Name:    s.name,
+			Kind:    1, // internal
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			os.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for k, v := range s.attrs {
+			os.Attributes = append(os.Attributes, otlpAttr{"randr." + k, otlpValue{v}})
+		}
+		if s.err != nil {
+			os.Status.Code, os.Status.Message = 2, s.err.Error()
+		}
+		out = append(out, os)
+	}
+
+	body := map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttr{{"service.name", otlpValue{e.service}}},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "randr"},
+				"spans": out,
+			}},
+		}},
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		logf(ctx, "tracing: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, e.settings.commandTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		logf(ctx, "tracing: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		debugf(ctx, "tracing: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		debugf(ctx, "tracing: %s: %s", e.endpoint, resp.Status)
+	}
+}
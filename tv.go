@@ -0,0 +1,110 @@
+package randr
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// tvVendors are EDID manufacturer IDs of television makers. Some of them
+// make monitors too, so a vendor alone does not make a TV.
+var tvVendors = []string{"SAM", "SNY", "VIZ", "TCL", "HSE", "HEC", "PHL", "SHP", "PAN", "MEI", "TOS", "HIQ", "GRU", "GSM"}
+
+// tvConfig tunes the defaults televisions get.
+type tvConfig struct {
+	// Off treats televisions like any other monitor.
+	Off bool `json:"off,omitempty"`
+	// LimitedRange leaves the RGB range to the driver instead of forcing
+	// full range, for TVs that expect limited range.
+	LimitedRange bool `json:"limited_range,omitempty"`
+	// Underscan shrinks the picture by this many pixels on each side, for
+	// TVs that overscan and cut its edges off.
+	Underscan int `json:"underscan,omitempty"`
+}
+
+// tv reports whether the output is a television rather than a monitor: an
+// external HDMI sink with speakers, made by a TV maker or calling itself
+// one.
+func (o output) tv() bool {
+	if o.internal() || !o.CEA.HDMI || !o.CEA.Audio {
+		return false
+	}
+	return slices.Contains(tvVendors, o.Monitor.Vendor) ||
+		strings.Contains(strings.ToUpper(o.Monitor.Name), "TV")
+}
+
+// maxRate returns the highest refresh rate of the i-th resolution.
+func (o output) maxRate(i int) float64 {
+	if i < 0 || i >= len(o.Rates) || len(o.Rates[i]) == 0 {
+		return 0
+	}
+	return slices.Max(o.Rates[i])
+}
+
+// tuneOutputs adjusts the outputs' modes before anything is planned for
+// them: by the monitors' quirks, the modes forced for unidentified outputs,
+// the TV defaults and the power policy.
+func tuneOutputs(ctx context.Context, outputs []output) {
+	applyQuirks(ctx, outputs)
+	forceModes(ctx, outputs)
+	preferFullHD(ctx, outputs)
+	powerOutputs(ctx, outputs)
+}
+
+// preferFullHD has TVs whose preferred mode is above 1080p but the link
+// only carries it at a low refresh rate, such as 4K at 30Hz over HDMI 1.4,
+// prefer 1080p instead.
+func preferFullHD(ctx context.Context, outputs []output) {
+	if settingsFrom(ctx).tv.Off {
+		return
+	}
+	fullHD := resolution{1920, 1080}
+	for i := range outputs {
+		o := &outputs[i]
+		if !o.Connected || !o.tv() {
+			continue
+		}
+		pref, ok := o.preferred()
+		if !ok || pref.pixels() <= fullHD.pixels() {
+			continue
+		}
+		p := slices.Index(o.Resolutions, pref)
+		hd := slices.Index(o.Resolutions, fullHD)
+		if hd >= 0 && o.maxRate(p) < 50 && o.maxRate(hd) >= 50 {
+			debugf(ctx, "tv: %s: %s only at %.0fHz, preferring %s", o.Name, pref, o.maxRate(p), fullHD)
+			o.Preferred = hd
+		}
+	}
+}
+
+// tvProps returns the RandR properties a TV is set up with: full range RGB
+// and, if configured, underscan. Only properties the driver offers are
+// set, as xrandr fails on others.
+func tvProps(ctx context.Context, o output) map[string]string {
+	tv := settingsFrom(ctx).tv
+	if tv.Off || !o.tv() {
+		return nil
+	}
+	props := make(map[string]string)
+	set := func(name, value string) {
+		if _, ok := o.Props[name]; ok {
+			props[name] = value
+		}
+	}
+	if !tv.LimitedRange {
+		set("Broadcast RGB", "Full")
+	}
+	if n := tv.Underscan; n > 0 {
+		if _, ok := o.Props["underscan"]; !ok {
+			debugf(ctx, "tv: %s: driver does not support underscan", o.Name)
+		}
+		set("underscan", "on")
+		set("underscan hborder", strconv.Itoa(n))
+		set("underscan vborder", strconv.Itoa(n))
+	}
+	if len(props) == 0 {
+		return nil
+	}
+	return props
+}
@@ -0,0 +1,65 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"syscall"
+	"time"
+)
+
+// powerSupplyDelay gives docks time to renegotiate their links after the
+// power source changes before the outputs are queried again.
+const powerSupplyDelay = 2 * time.Second
+
+// watchPowerSupply listens for the kernel's power_supply uevents, the ones
+// udev acts on, and signals on the returned channel whenever a mains or USB
+// supply changes. Batteries report their charge the same way, so their
+// events are left out. Watching stops when ctx is cancelled.
+func watchPowerSupply(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK,
+		syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, fmt.Errorf("uevent socket: %w", err)
+	}
+	// Group 1 carries the kernel's own events.
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("uevent socket: %w", err)
+	}
+
+	// As with inotify, the runtime poller lets Close interrupt a read.
+	f := os.NewFile(uintptr(fd), "uevent")
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	ch := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 8192)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			// An event is "ACTION@DEVPATH" followed by KEY=VALUE
+			// fields, all NUL terminated.
+			fields := bytes.Split(buf[:n], []byte{0})
+			has := func(kv string) bool {
+				return slices.ContainsFunc(fields, func(f []byte) bool { return string(f) == kv })
+			}
+			if !has("SUBSYSTEM=power_supply") || has("POWER_SUPPLY_TYPE=Battery") {
+				continue
+			}
+			tracef(ctx, "uevent: %s", fields[0])
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,32 @@
+package randr
+
+// undoDepth is how many layout changes `randr undo` can step back through;
+// older ones are forgotten.
+const undoDepth = 10
+
+// snapshot is a layout the outputs were in, with the profile applied then,
+// for the monitor set identified by Fingerprint.
+type snapshot struct {
+	Layout      layout `json:"layout"`
+	Profile     string `json:"profile,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// record pushes the layout a change left onto the undo stack, dropping the
+// oldest beyond undoDepth. A new change forgets what was undone before it.
+func (st *daemonState) record(s snapshot) {
+	st.Undo = append(st.Undo, s)
+	if n := len(st.Undo) - undoDepth; n > 0 {
+		st.Undo = st.Undo[n:]
+	}
+	st.Redo = nil
+}
+
+// stacks returns the stack the step takes a layout from and the one the
+// layout it replaces goes on: undo moves from Undo to Redo, redo back.
+func (st *daemonState) stacks(step string) (from, to *[]snapshot) {
+	if step == "redo" {
+		return &st.Redo, &st.Undo
+	}
+	return &st.Undo, &st.Redo
+}
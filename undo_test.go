@@ -0,0 +1,67 @@
+package randr
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestUndoRecord(t *testing.T) {
+	snap := func(i int) snapshot { return snapshot{Profile: fmt.Sprint(i), Fingerprint: "eDP-1"} }
+	for _, tc := range []struct {
+		name       string
+		changes    int
+		first, top string
+	}{
+		{"one", 1, "1", "1"},
+		{"full", undoDepth, "1", fmt.Sprint(undoDepth)},
+		{"oldest forgotten", undoDepth + 3, "4", fmt.Sprint(undoDepth + 3)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			st := &daemonState{Redo: []snapshot{snap(0)}}
+			for i := 1; i <= tc.changes; i++ {
+				st.record(snap(i))
+			}
+			if n := min(tc.changes, undoDepth); len(st.Undo) != n {
+				t.Fatalf("%d layouts to undo, want %d", len(st.Undo), n)
+			}
+			if first, top := st.Undo[0].Profile, st.Undo[len(st.Undo)-1].Profile; first != tc.first || top != tc.top {
+				t.Errorf("undo stack from %s to %s, want %s to %s", first, top, tc.first, tc.top)
+			}
+			if st.Redo != nil {
+				t.Errorf("redo stack kept after a change: %v", st.Redo)
+			}
+		})
+	}
+}
+
+func TestUndoStacks(t *testing.T) {
+	st := &daemonState{}
+	if from, to := st.stacks("undo"); from != &st.Undo || to != &st.Redo {
+		t.Error("undo does not move from Undo to Redo")
+	}
+	if from, to := st.stacks("redo"); from != &st.Redo || to != &st.Undo {
+		t.Error("redo does not move from Redo to Undo")
+	}
+}
+
+func TestUndoSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st := &daemonState{}
+	for i := range undoDepth + 1 {
+		st.record(snapshot{Layout: layout{{Name: "HDMI-1", outputState: outputState{Mode: resolution{2560, 1440}, X: 1920 * i}}},
+			Profile: "desk", Fingerprint: "eDP-1,DEL-A0B8-718NY83"})
+	}
+	st.Redo = st.Undo[len(st.Undo)-1:]
+	st.Undo = st.Undo[:len(st.Undo)-1]
+	if err := st.save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Undo) != undoDepth-1 || len(got.Redo) != 1 || got.Redo[0].Layout[0].X != 1920*undoDepth {
+		t.Errorf("loaded %d to undo and %+v to redo", len(got.Undo), got.Redo)
+	}
+}
@@ -0,0 +1,269 @@
+package randr
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the release the binary was built from, set with
+// -ldflags "-X randr.version=v1.2.3" by the Makefile.
+var version = "dev"
+
+const (
+	// releasesURL is where self-update looks for the latest release.
+	releasesURL = "https://api.github.com/repos/l3pp4rd/randr/releases/latest"
+	// checksumsAsset lists the SHA-256 of every binary of a release, in
+	// sha256sum's format.
+	checksumsAsset = "SHA256SUMS"
+	// updateTimeout bounds the whole update, downloads included.
+	updateTimeout = 5 * time.Minute
+	// maxBinarySize guards against downloading something that cannot be
+	// a randr binary.
+	maxBinarySize = 64 << 20
+)
+
+// release is the part of GitHub's release JSON self-update uses.
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// asset returns the download URL of the named asset.
+func (r *release) asset(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no %s", r.Tag, name)
+}
+
+// download fetches url, reading at most limit bytes.
+func download(ctx context.Context, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s: larger than %d bytes", url, limit)
+	}
+	return data, nil
+}
+
+// checksum returns the SHA-256 the sha256sum listing gives the named file.
+func checksum(sums []byte, name string) ([]byte, error) {
+	sc := bufio.NewScanner(bytes.NewReader(sums))
+	for sc.Scan() {
+		sum, file, ok := strings.Cut(sc.Text(), " ")
+		// sha256sum marks files read in binary mode with a "*".
+		if !ok || strings.TrimPrefix(strings.TrimSpace(file), "*") != name {
+			continue
+		}
+		return hex.DecodeString(sum)
+	}
+	return nil, fmt.Errorf("%s lists no checksum for %s", checksumsAsset, name)
+}
+
+// semver splits a "v1.2.3" or "v1.2.3-rc.1" tag into its version numbers
+// and pre-release part.
+func semver(tag string) (v [3]int, pre string, ok bool) {
+	rest, found := strings.CutPrefix(tag, "v")
+	if !found {
+		return v, "", false
+	}
+	rest, _, _ = strings.Cut(rest, "+")
+	rest, pre, _ = strings.Cut(rest, "-")
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return v, "", false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, "", false
+		}
+		v[i] = n
+	}
+	return v, pre, true
+}
+
+// compareVersions orders two release tags by semantic versioning: -1 when a
+// is older than b, 0 when they are the same release, 1 when a is newer. A
+// pre-release is older than its release.
+func compareVersions(a, b string) (int, error) {
+	va, prea, ok := semver(a)
+	if !ok {
+		return 0, fmt.Errorf("%q is not a release version", a)
+	}
+	vb, preb, ok := semver(b)
+	if !ok {
+		return 0, fmt.Errorf("%q is not a release version", b)
+	}
+	if c := slices.Compare(va[:], vb[:]); c != 0 {
+		return c, nil
+	}
+	switch {
+	case prea == preb:
+		return 0, nil
+	case prea == "":
+		return 1, nil
+	case preb == "":
+		return -1, nil
+	}
+	ia, ib := strings.Split(prea, "."), strings.Split(preb, ".")
+	for i := 0; i < len(ia) && i < len(ib); i++ {
+		na, erra := strconv.Atoi(ia[i])
+		nb, errb := strconv.Atoi(ib[i])
+		var c int
+		switch {
+		case erra == nil && errb == nil:
+			c = cmp.Compare(na, nb)
+		case erra == nil:
+			c = -1
+		case errb == nil:
+			c = 1
+		default:
+			c = strings.Compare(ia[i], ib[i])
+		}
+		if c != 0 {
+			return c, nil
+		}
+	}
+	return cmp.Compare(len(ia), len(ib)), nil
+}
+
+// selfUpdateHelp is printed by `randr self-update -h`.
+const selfUpdateHelp = `usage: randr self-update [--check]
+
+Replaces the binary with the latest release's, if that is newer than this
+one; it never downgrades. The download is checked against the SHA256SUMS of
+the same release. The releases are not signed, so this catches a corrupted
+download but not a compromised release: whoever can change the binary can
+change its checksum too.
+
+`
+
+// runSelfUpdate replaces the running binary with the latest release's for
+// this platform, once its checksum matches the one the release lists. It
+// refuses releases no newer than the running one. With check set it only
+// reports whether there is a newer release.
+func runSelfUpdate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("self-update", flag.ContinueOnError)
+	check := fs.Bool("check", false, "only report whether a newer release is out")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), selfUpdateHelp)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, updateTimeout)
+	defer cancel()
+
+	data, err := download(ctx, releasesURL, 1<<20)
+	if err != nil {
+		return err
+	}
+	var rel release
+	if err := json.Unmarshal(data, &rel); err != nil {
+		return fmt.Errorf("release: %w", err)
+	}
+	newer, err := compareVersions(rel.Tag, version)
+	if err != nil {
+		return fmt.Errorf("cannot tell whether %s is newer than %s: %w", rel.Tag, version, err)
+	}
+	if newer <= 0 {
+		fmt.Printf("randr %s is the latest release\n", version)
+		return nil
+	}
+	if *check {
+		fmt.Printf("randr %s is out, this is %s\n", rel.Tag, version)
+		return nil
+	}
+
+	name := fmt.Sprintf("randr_%s_%s", runtime.GOOS, runtime.GOARCH)
+	binURL, err := rel.asset(name)
+	if err != nil {
+		return err
+	}
+	sumsURL, err := rel.asset(checksumsAsset)
+	if err != nil {
+		return err
+	}
+	sums, err := download(ctx, sumsURL, 1<<20)
+	if err != nil {
+		return err
+	}
+	want, err := checksum(sums, name)
+	if err != nil {
+		return err
+	}
+	bin, err := download(ctx, binURL, maxBinarySize)
+	if err != nil {
+		return err
+	}
+	if got := sha256.Sum256(bin); !bytes.Equal(got[:], want) {
+		return fmt.Errorf("%s: checksum mismatch, got %x, want %x", name, got, want)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if self, err = filepath.EvalSymlinks(self); err != nil {
+		return err
+	}
+	// Written next to the binary and renamed over it, the replacement is
+	// atomic, and the running daemon keeps the old file open.
+	tmp, err := os.CreateTemp(filepath.Dir(self), ".randr-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), self); err != nil {
+		return err
+	}
+	fmt.Printf("updated %s from %s to %s; restart the daemon to run it\n", self, version, rel.Tag)
+	return nil
+}
@@ -0,0 +1,31 @@
+package randr
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "v1.2.3", 0},
+		{"v1.2.4", "v1.2.3", 1},
+		{"v1.2.3", "v1.10.0", -1},
+		{"v2.0.0", "v1.99.99", 1},
+		{"v1.2.3-rc.1", "v1.2.3", -1},
+		{"v1.2.3", "v1.2.3-rc.1", 1},
+		{"v1.2.3-rc.2", "v1.2.3-rc.10", -1},
+		{"v1.2.3-beta", "v1.2.3-rc.1", -1},
+		{"v1.2.3-rc", "v1.2.3-rc.1", -1},
+		{"v1.2.3+build.5", "v1.2.3", 0},
+	} {
+		got, err := compareVersions(tc.a, tc.b)
+		if err != nil || got != tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, %v, want %d", tc.a, tc.b, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"dev", "1.2.3", "v1.2", "v1.2.x", "v1.-2.3"} {
+		if _, err := compareVersions("v1.0.0", bad); err == nil {
+			t.Errorf("compareVersions(v1.0.0, %q): no error", bad)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+// fingerprintRe matches EDID fingerprints as produced by monitorID.String.
+var fingerprintRe = regexp.MustCompile(`^[A-Z]{3}-[0-9A-F]{4}-`)
+
+// jsonLines maps the JSON path of every value in data, in the form used by
+// configError, to the line it starts on.
+func jsonLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var walk func(path string) bool
+	walk = func(path string) bool {
+		tok, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		line, _ := lineCol(data, int(dec.InputOffset()))
+		lines[path] = line
+		switch tok {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return false
+				}
+				child := key.(string)
+				if path != "" {
+					child = path + "." + child
+				}
+				if !walk(child) {
+					return false
+				}
+			}
+			_, err = dec.Token()
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if !walk(path + "[" + strconv.Itoa(i) + "]") {
+					return false
+				}
+			}
+			_, err = dec.Token()
+		}
+		return err == nil
+	}
+	walk("")
+	return lines
+}
+
+// crossCheck compares the config against the system: the profiles against
+// the outputs xrandr knows about, for connectors that don't exist, modes an
+// output can't do, and fixed arrangements whose outputs partially overlap,
+// and the hooks, feedback and callbacks against the programs installed.
+// Without outputs, as when xrandr can't be run, only the programs are
+// checked.
+func crossCheck(cfg *config, outputs []output) []*configError {
+	errs := checkHooks(cfg)
+	if outputs == nil {
+		return errs
+	}
+	for _, p := range cfg.Profiles {
+		bad := func(field, format string, args ...any) {
+			errs = append(errs, &configError{File: p.file, Path: joinPath(p.path, field),
+				Msg: fmt.Sprintf("profile %q: ", p.Name) + fmt.Sprintf(format, args...)})
+		}
+		for i, want := range p.Outputs {
+			if _, ok := findOutput(outputs, want); !ok && !fingerprintRe.MatchString(want) && !isPattern(want) {
+				bad(fmt.Sprintf("outputs[%d]", i), "unknown output %q", want)
+			}
+		}
+
+		type rect struct {
+			name       string
+			x, y, w, h int
+		}
+		var rects []rect
+		for _, name := range slices.Sorted(maps.Keys(p.Arrangement)) {
+			s := p.Arrangement[name]
+			o, ok := findOutput(outputs, name)
+			if !ok {
+				if !fingerprintRe.MatchString(name) && !isPattern(name) {
+					bad("arrangement."+name, "unknown output %q", name)
+				}
+				continue
+			}
+			if s.Off || s.Mode == "" {
+				continue
+			}
+			mode, rate, _ := parseModeRate(s.Mode)
+			if err := o.checkMode(outputConfig{Name: o.Name, outputState: outputState{Mode: mode, Rate: rate}}, cfg.tolerance()); err != nil {
+				bad("arrangement."+name+".mode", "%v", err)
+			}
+			// Relative positions depend on the outputs connected; the
+			// planner checks those for overlaps when it places them.
+			if x, y, err := parsePosition(s.Pos); err == nil || s.Pos == "" {
+				rects = append(rects, rect{name, x, y, mode.W, mode.H})
+			}
+		}
+		for i, a := range rects {
+			for _, b := range rects[i+1:] {
+				same := a.x == b.x && a.y == b.y && a.w == b.w && a.h == b.h
+				if !same && a.x < b.x+b.w && b.x < a.x+a.w && a.y < b.y+b.h && b.y < a.y+a.h {
+					bad("arrangement."+b.name, "%s overlaps %s", b.name, a.name)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// findOutput looks an output up by EDID fingerprint or connector name.
+func findOutput(outputs []output, want string) (output, bool) {
+	for _, o := range outputs {
+		if o.Name == want || (o.Connected && o.id() == want) {
+			return o, true
+		}
+	}
+	return output{}, false
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// runConfigValidate checks the config and every profile, reporting each
+// problem with its file and line so broken configs are caught before the
+// next hotplug event.
+func runConfigValidate(ctx context.Context, dirs paths) error {
+	cfg, err := readConfig(dirs)
+	if err != nil {
+		return err
+	}
+
+	errs := cfg.check()
+	outputs, _, err := parseXrandr(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "skipping output checks: %v\n", err)
+	}
+	errs = append(errs, crossCheck(cfg, outputs)...)
+	errs = append(errs, checkScript(cfg)...)
+
+	lines := make(map[string]map[string]int)
+	for _, e := range errs {
+		if lines[e.File] == nil {
+			data, _ := os.ReadFile(e.File)
+			lines[e.File] = jsonLines(data)
+		}
+		// Fall back to the nearest enclosing value with a known line.
+		line := 0
+		for path := e.Path; ; path = parentPath(path) {
+			if l, ok := lines[e.File][path]; ok {
+				line = l
+				break
+			}
+			if path == "" {
+				break
+			}
+		}
+		fmt.Printf("%s:%d: %s\n", e.File, line, e.Msg)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(errs))
+	}
+	fmt.Printf("%s: ok (%d profiles)\n", cfg.file, len(cfg.Profiles))
+	return nil
+}
+
+// parentPath strips the last element from a JSON path.
+func parentPath(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '.' || path[i] == '[' {
+			return path[:i]
+		}
+	}
+	return ""
+}
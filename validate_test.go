@@ -0,0 +1,39 @@
+package randr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCrossCheckHooks(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "notify.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{file: "config.json", Hooks: hooks{
+		Pre:  []string{"sh -c true", "cd /tmp && sh", "FOO=1 sh", "randr-no-such-hook --now"},
+		Post: []string{script + " done"},
+	}}
+	var got []string
+	for _, e := range crossCheck(cfg, nil) {
+		got = append(got, e.Path+": "+e.Msg)
+	}
+	want := []string{
+		"hooks.pre[3]: pre hook: randr-no-such-hook not found",
+		"hooks.post[0]: post hook: " + script + " is not executable",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if err := os.Chmod(script, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Hooks.Pre = nil
+	if errs := crossCheck(cfg, nil); len(errs) != 0 {
+		t.Errorf("executable hook reported: %v", errs[0].Msg)
+	}
+}
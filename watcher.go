@@ -0,0 +1,208 @@
+package randr
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// Event is a change observed by a Watcher: OutputConnected,
+// OutputDisconnected, OutputChanged or LayoutApplied.
+type Event interface {
+	isEvent()
+}
+
+// OutputConnected reports a monitor plugged into an output.
+type OutputConnected struct {
+	Output Output
+}
+
+// OutputDisconnected reports a monitor unplugged from an output. Output
+// describes it as it was last seen.
+type OutputDisconnected struct {
+	Output Output
+}
+
+// OutputChanged reports that a connected output changed without being
+// unplugged, such as a monitor swapped behind a KVM switch or a link that
+// went bad. Output describes it as it is now.
+type OutputChanged struct {
+	Output Output
+}
+
+// LayoutApplied reports that the arrangement of the connected outputs
+// changed, whether by randr, another tool or the user. Outputs is the new
+// state of every connected output.
+type LayoutApplied struct {
+	Outputs []Output
+}
+
+func (OutputConnected) isEvent()    {}
+func (OutputDisconnected) isEvent() {}
+func (OutputChanged) isEvent()      {}
+func (LayoutApplied) isEvent()      {}
+
+// Output is a connected output.
+type Output struct {
+	// Name is the connector, e.g. "HDMI-1".
+	Name string `json:"name"`
+	// Monitor is the EDID fingerprint of the attached monitor, e.g.
+	// "DEL-A0B8-718NY83", and MonitorName its advertised name. Both are
+	// empty for monitors without a readable EDID.
+	Monitor     string `json:"monitor,omitempty"`
+	MonitorName string `json:"monitor_name,omitempty"`
+	// Unidentified is set when the monitor has no usable EDID; EDIDError
+	// then says what was wrong with it, if it had one.
+	Unidentified bool   `json:"unidentified,omitempty"`
+	EDIDError    string `json:"edid_error,omitempty"`
+	Primary      bool   `json:"primary,omitempty"`
+	// Audio is set when the output carries sound to the monitor.
+	Audio bool `json:"audio,omitempty"`
+	// Active is set when the output shows a picture; Width and Height are
+	// then its mode and X and Y its position.
+	Active bool `json:"active"`
+	Width  int  `json:"width,omitempty"`
+	Height int  `json:"height,omitempty"`
+	X      int  `json:"x"`
+	Y      int  `json:"y"`
+	// Rotation is "left", "right" or "inverted" for a rotated output.
+	Rotation string `json:"rotation,omitempty"`
+}
+
+func newOutput(o output) Output {
+	out := Output{
+		Name:         o.Name,
+		Monitor:      o.Monitor.String(),
+		MonitorName:  o.Monitor.Name,
+		Unidentified: o.Unidentified,
+		EDIDError:    o.EDIDError,
+		Primary:      o.Primary,
+		Audio:        o.audioSink(),
+		Active:       o.active(),
+		X:            o.X,
+		Y:            o.Y,
+		Rotation:     o.Rotation,
+	}
+	if out.Active {
+		mode := o.Resolutions[o.Current]
+		out.Width, out.Height = mode.W, mode.H
+	}
+	return out
+}
+
+// Watcher detects monitors being connected and disconnected and layout
+// changes, without changing anything itself. It is the daemon's detection
+// for programs such as status bars that want to follow the displays without
+// running randr.
+type Watcher struct {
+	options
+	events chan Event
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewWatcher queries the current outputs and starts watching until ctx is
+// cancelled or Close is called. The events channel first receives an
+// OutputConnected for every output connected at start and a LayoutApplied
+// with their arrangement.
+func NewWatcher(ctx context.Context, opts ...Option) (*Watcher, error) {
+	w := &Watcher{
+		options: newOptions(opts),
+		events:  make(chan Event, 16),
+		done:    make(chan struct{}),
+	}
+	ctx = withSettings(ctx, w.over(defaultSettings()))
+	outputs, _, err := queryOutputs(ctx, w.backend)
+	if err != nil {
+		return nil, err
+	}
+	ctx, w.cancel = context.WithCancel(ctx)
+	go w.watch(ctx, outputs)
+	return w, nil
+}
+
+// Events returns the channel events are delivered on. It is closed once the
+// watcher is closed.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Close stops watching and waits for the watcher to finish.
+func (w *Watcher) Close() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *Watcher) watch(ctx context.Context, outputs []output) {
+	defer close(w.done)
+	defer close(w.events)
+
+	var prev []output
+	var prevLayout map[string]outputState
+	interval := settingsFrom(ctx).pollInterval
+	bo := backoff{base: interval}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		if !w.diff(ctx, prev, outputs, prevLayout) {
+			return
+		}
+		prev, prevLayout = outputs, currentLayout(outputs)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			cur, _, err := queryOutputs(ctx, w.backend)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				bo.reset()
+				outputs = cur
+				timer.Reset(interval)
+				break
+			}
+			bo.fail()
+			logf(ctx, "watcher: %v (retrying in %s)", err, bo.delay())
+			timer.Reset(bo.delay())
+		}
+	}
+}
+
+// diff sends the events between two observations. It returns false when the
+// watcher was closed meanwhile.
+func (w *Watcher) diff(ctx context.Context, prev, cur []output, prevLayout map[string]outputState) bool {
+	var events []Event
+	for _, o := range cur {
+		if p, ok := findOutput(prev, o.Name); o.Connected && (!ok || !p.Connected) {
+			events = append(events, OutputConnected{newOutput(o)})
+		}
+	}
+	for _, o := range prev {
+		if c, ok := findOutput(cur, o.Name); o.Connected && (!ok || !c.Connected) {
+			events = append(events, OutputDisconnected{newOutput(o)})
+		}
+	}
+	for _, name := range changedOutputs(prev, cur) {
+		o, _ := findOutput(cur, name)
+		events = append(events, OutputChanged{newOutput(o)})
+	}
+	if l := currentLayout(cur); prevLayout == nil || !maps.Equal(l, prevLayout) {
+		var outputs []Output
+		for _, o := range connectedOutputs(cur) {
+			outputs = append(outputs, newOutput(o))
+		}
+		events = append(events, LayoutApplied{outputs})
+	}
+	for _, e := range events {
+		select {
+		case w.events <- e:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package randr
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestWatcherDiff(t *testing.T) {
+	mode := []resolution{{1920, 1080}}
+	laptop := output{Name: "eDP-1", Connected: true, Resolutions: mode, Current: 0}
+	dell := output{Name: "HDMI-1", Connected: true, Resolutions: mode, Current: -1,
+		Monitor: monitorID{Vendor: "DEL", Product: 0xa0b8, Serial: "718NY83"}}
+	lg := dell
+	lg.Monitor = monitorID{Vendor: "GSM", Product: 0x5b09, Serial: "1"}
+	unplugged := dell
+	unplugged.Connected = false
+	moved := laptop
+	moved.X = 1920
+
+	for _, tc := range []struct {
+		name      string
+		prev, cur []output
+		want      []string
+	}{
+		{"start", nil, []output{laptop, dell},
+			[]string{"connected eDP-1", "connected HDMI-1", "layout"}},
+		{"unchanged", []output{laptop, dell}, []output{laptop, dell}, nil},
+		{"plugged", []output{laptop, unplugged}, []output{laptop, dell},
+			[]string{"connected HDMI-1", "layout"}},
+		{"unplugged", []output{laptop, dell}, []output{laptop, unplugged},
+			[]string{"disconnected HDMI-1", "layout"}},
+		{"swapped behind a KVM", []output{laptop, dell}, []output{laptop, lg},
+			[]string{"changed HDMI-1 GSM-5B09-1"}},
+		{"moved", []output{laptop, dell}, []output{moved, dell}, []string{"layout"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &Watcher{events: make(chan Event, 16)}
+			var prevLayout map[string]outputState
+			if tc.prev != nil {
+				prevLayout = currentLayout(tc.prev)
+			}
+			if !w.diff(context.Background(), tc.prev, tc.cur, prevLayout) {
+				t.Fatal("diff gave up")
+			}
+			close(w.events)
+			var got []string
+			for e := range w.events {
+				switch e := e.(type) {
+				case OutputConnected:
+					got = append(got, "connected "+e.Output.Name)
+				case OutputDisconnected:
+					got = append(got, "disconnected "+e.Output.Name)
+				case OutputChanged:
+					got = append(got, fmt.Sprintf("changed %s %s", e.Output.Name, e.Output.Monitor))
+				case LayoutApplied:
+					got = append(got, "layout")
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("events %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package randr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// windowsConfig looks after application windows around layout changes. The
+// windows are listed and moved with wmctrl, which asks the window manager
+// through EWMH.
+type windowsConfig struct {
+	// Rescue moves the windows a layout change leaves outside every lit
+	// output onto the nearest one.
+	Rescue bool `json:"rescue,omitempty"`
+	// Remember saves where the windows are before switching away from a
+	// profile and puts them back when switching back to it.
+	Remember bool `json:"remember,omitempty"`
+}
+
+// wmctrlCommand lists and moves windows.
+var wmctrlCommand = "wmctrl"
+
+// window is a top-level window as the window manager reports it.
+type window struct {
+	ID string `json:"id"`
+	// Class is the WM_CLASS instance and class, "code.Code".
+	Class string `json:"class"`
+	// Desktop is the virtual desktop the window is on, or -1 for windows
+	// on all of them, such as panels.
+	Desktop int    `json:"desktop"`
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	W       int    `json:"w"`
+	H       int    `json:"h"`
+	Title   string `json:"title,omitempty"`
+}
+
+// listWindows lists the top-level windows with their geometry, from
+// "wmctrl -l -G -x" lines such as
+//
+//	0x03a00007  0 120  80   1200 900  code.Code  host Title
+func listWindows(ctx context.Context) ([]window, error) {
+	out, err := runCommand(ctx, true, wmctrlCommand, []string{"-l", "-G", "-x"}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s -l -G -x: %w", wmctrlCommand, err)
+	}
+	var windows []window
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 8 {
+			continue
+		}
+		var nums [5]int
+		ok := true
+		for i := range nums {
+			n, err := strconv.Atoi(f[i+1])
+			nums[i], ok = n, ok && err == nil
+		}
+		if !ok {
+			continue
+		}
+		windows = append(windows, window{
+			ID: f[0], Class: f[6], Desktop: nums[0], X: nums[1], Y: nums[2], W: nums[3], H: nums[4],
+			Title: strings.Join(f[8:], " "),
+		})
+	}
+	return windows, sc.Err()
+}
+
+// moveWindow moves the window's top left corner to x, y, keeping its size.
+func moveWindow(ctx context.Context, w window, x, y int) error {
+	_, err := runCommand(ctx, false, wmctrlCommand, []string{"-i", "-r", w.ID, "-e", fmt.Sprintf("0,%d,%d,-1,-1", x, y)}, nil)
+	return err
+}
+
+// placeWindow gives the window the position and size of to.
+func placeWindow(ctx context.Context, w, to window) error {
+	_, err := runCommand(ctx, false, wmctrlCommand, []string{"-i", "-r", w.ID, "-e", fmt.Sprintf("0,%d,%d,%d,%d", to.X, to.Y, to.W, to.H)}, nil)
+	return err
+}
+
+// outputRects returns the areas the lit outputs cover.
+func outputRects(outputs []output) []rect {
+	var rects []rect
+	for _, o := range connectedOutputs(outputs) {
+		if o.active() {
+			rects = append(rects, rect{X: o.X, Y: o.Y, W: o.Geometry.W, H: o.Geometry.H})
+		}
+	}
+	return rects
+}
+
+// overlaps reports whether the two areas share any pixels.
+func (r rect) overlaps(o rect) bool {
+	return r.X < o.X+o.W && o.X < r.X+r.W && r.Y < o.Y+o.H && o.Y < r.Y+r.H
+}
+
+// distance returns how far the point is from the area, squared.
+func (r rect) distance(x, y int) int {
+	dx := max(r.X-x, 0, x-(r.X+r.W-1))
+	dy := max(r.Y-y, 0, y-(r.Y+r.H-1))
+	return dx*dx + dy*dy
+}
+
+// within returns where a window of the given size at x, y goes to lie
+// inside the area, moving it as little as possible; a window larger than
+// the area goes to its top left corner.
+func (r rect) within(x, y, w, h int) (int, int) {
+	return max(r.X, min(x, r.X+r.W-w)), max(r.Y, min(y, r.Y+r.H-h))
+}
+
+// rescueWindows moves the windows that are off every lit output onto the
+// nearest one. Windows on all desktops, such as panels and docks, are left
+// to their programs.
+func rescueWindows(ctx context.Context, outputs []output) {
+	rects := outputRects(outputs)
+	if len(rects) == 0 {
+		return
+	}
+	windows, err := listWindows(ctx)
+	if err != nil {
+		logf(ctx, "windows: %v", err)
+		return
+	}
+	for _, w := range windows {
+		area := rect{X: w.X, Y: w.Y, W: w.W, H: w.H}
+		if w.Desktop < 0 || slices.ContainsFunc(rects, area.overlaps) {
+			continue
+		}
+		cx, cy := w.X+w.W/2, w.Y+w.H/2
+		nearest := slices.MinFunc(rects, func(a, b rect) int { return a.distance(cx, cy) - b.distance(cx, cy) })
+		x, y := nearest.within(w.X, w.Y, w.W, w.H)
+		infof(ctx, "windows: moving %q from %d,%d to %d,%d", w.Title, w.X, w.Y, x, y)
+		if err := moveWindow(ctx, w, x, y); err != nil {
+			logf(ctx, "windows: moving %q: %v", w.Title, err)
+		}
+	}
+}
+
+// loadPlacements reads the saved window placements, keyed by profile. A
+// missing file yields none.
+func loadPlacements(path string) (map[string][]window, error) {
+	placements := make(map[string][]window)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return placements, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &placements); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return placements, nil
+}
+
+func savePlacements(path string, placements map[string][]window) error {
+	data, err := json.MarshalIndent(placements, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// rememberWindows saves where the windows are under the profile's name.
+func rememberWindows(ctx context.Context, path, profile string) {
+	windows, err := listWindows(ctx)
+	if err != nil {
+		logf(ctx, "windows: %v", err)
+		return
+	}
+	placements, err := loadPlacements(path)
+	if err != nil {
+		logf(ctx, "windows: %v", err)
+		return
+	}
+	placements[profile] = slices.DeleteFunc(windows, func(w window) bool { return w.Desktop < 0 })
+	if err := savePlacements(path, placements); err != nil {
+		logf(ctx, "windows: %v", err)
+	}
+	debugf(ctx, "windows: remembered %d window(s) for profile %q", len(placements[profile]), profile)
+}
+
+// restoreWindows puts the windows back where they were when the profile was
+// last switched away from. A window is recognized by its ID, as long as its
+// class is the same, or else it takes the place of a window of its class
+// that is gone.
+func restoreWindows(ctx context.Context, path, profile string) {
+	placements, err := loadPlacements(path)
+	if err != nil {
+		logf(ctx, "windows: %v", err)
+		return
+	}
+	saved := placements[profile]
+	if len(saved) == 0 {
+		return
+	}
+	windows, err := listWindows(ctx)
+	if err != nil {
+		logf(ctx, "windows: %v", err)
+		return
+	}
+	// Windows that kept their ID claim their places first, then the
+	// others take the free places of their class in order.
+	used := make([]bool, len(saved))
+	places := make(map[string]window)
+	claim := func(same func(w, s window) bool) {
+		for _, w := range windows {
+			if _, ok := places[w.ID]; ok || w.Desktop < 0 {
+				continue
+			}
+			for i, s := range saved {
+				if !used[i] && same(w, s) {
+					used[i], places[w.ID] = true, s
+					break
+				}
+			}
+		}
+	}
+	claim(func(w, s window) bool { return w.ID == s.ID && w.Class == s.Class })
+	claim(func(w, s window) bool { return w.Class == s.Class })
+	for _, w := range windows {
+		to, ok := places[w.ID]
+		if !ok || (to.X == w.X && to.Y == w.Y && to.W == w.W && to.H == w.H) {
+			continue
+		}
+		debugf(ctx, "windows: putting %q back at %d,%d", w.Title, to.X, to.Y)
+		if err := placeWindow(ctx, w, to); err != nil {
+			logf(ctx, "windows: placing %q: %v", w.Title, err)
+		}
+	}
+}
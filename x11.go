@@ -0,0 +1,391 @@
+package randr
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// x11Conn is a connection to the X server speaking just enough of the core
+// protocol to find the screen's root window and an extension, and to send
+// requests and read their replies in turn. Everything is little endian.
+type x11Conn struct {
+	conn net.Conn
+	stop func() bool
+	seq  uint16
+	// screen is the number of the screen used and root its root window;
+	// width and height are its size in pixels and millimetres when the
+	// connection was made.
+	screen            int
+	root              uint32
+	width, height     int
+	widthMM, heightMM int
+	// atoms caches the names of the atoms looked up.
+	atoms map[uint32]string
+}
+
+// x11Error is an error the server reported for a request.
+type x11Error struct {
+	Code         byte
+	Major, Minor int
+	Value        uint32
+}
+
+func (e *x11Error) Error() string {
+	name := map[byte]string{2: "BadValue", 3: "BadWindow", 8: "BadMatch", 11: "BadAlloc", 16: "BadLength", 17: "BadImplementation"}[e.Code]
+	if name == "" {
+		name = fmt.Sprintf("error %d", e.Code)
+	}
+	return fmt.Sprintf("X server: %s in request %d.%d (value %#x)", name, e.Major, e.Minor, e.Value)
+}
+
+// x11Display returns the display to connect to: the one given to xrandr
+// with --display, or $DISPLAY.
+func x11Display(ctx context.Context) string {
+	args := settingsFrom(ctx).xrandrArgs
+	for i, a := range args {
+		if (a == "-d" || a == "-display" || a == "--display") && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv("DISPLAY")
+}
+
+// parseDisplay splits a display name, "[host]:display[.screen]", into
+// where to connect and the screen to use. Without a host, or with "unix",
+// the server's local socket is used.
+func parseDisplay(name string) (network, addr, number string, screen int, err error) {
+	host, rest, ok := cutLast(name, ":")
+	if !ok {
+		return "", "", "", 0, fmt.Errorf("bad display %q", name)
+	}
+	number, scr, _ := strings.Cut(rest, ".")
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		return "", "", "", 0, fmt.Errorf("bad display %q", name)
+	}
+	if scr != "" {
+		if screen, err = strconv.Atoi(scr); err != nil || screen < 0 {
+			return "", "", "", 0, fmt.Errorf("bad display %q", name)
+		}
+	}
+	if host == "" || host == "unix" {
+		return "unix", fmt.Sprintf("/tmp/.X11-unix/X%d", n), number, screen, nil
+	}
+	return "tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)), number, screen, nil
+}
+
+// cutLast is strings.Cut around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// dialX11 connects to the display, authenticating with the MIT-MAGIC-COOKIE
+// from the X authority file if it has one for it. The connection is closed
+// when ctx is cancelled.
+func dialX11(ctx context.Context) (*x11Conn, error) {
+	display := x11Display(ctx)
+	if display == "" {
+		return nil, errors.New("x11: DISPLAY is not set")
+	}
+	network, addr, number, screen, err := parseDisplay(display)
+	if err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil && network == "unix" {
+		// Servers on Linux listen on an abstract socket of the same name
+		// too, which works when /tmp is private.
+		conn, err = d.DialContext(ctx, network, "@"+addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("x11: %w", err)
+	}
+	c := &x11Conn{conn: conn}
+	c.stop = context.AfterFunc(ctx, func() { conn.Close() })
+	authName, authData := xauth(network, addr, number)
+	if err := c.setup(authName, authData, screen); err != nil {
+		c.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("x11: %s: %w", display, err)
+	}
+	return c, nil
+}
+
+func (c *x11Conn) Close() error {
+	c.stop()
+	return c.conn.Close()
+}
+
+// xauthCookie is the only authorization protocol looked for.
+const xauthCookie = "MIT-MAGIC-COOKIE-1"
+
+// xauth returns the authorization for the display from $XAUTHORITY or
+// ~/.Xauthority, or nothing if there is none, in which case the server may
+// still let the user in by other means.
+func xauth(network, addr, number string) (name string, data []byte) {
+	file := os.Getenv("XAUTHORITY")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		file = filepath.Join(home, ".Xauthority")
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", nil
+	}
+	// Entries are a family and four length prefixed, big endian fields:
+	// address, display number, protocol name and data.
+	const familyLocal, familyWild = 256, 65535
+	hostname, _ := os.Hostname()
+	host, _, _ := net.SplitHostPort(addr)
+	field := func() ([]byte, bool) {
+		if len(b) < 2 {
+			return nil, false
+		}
+		n := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+n {
+			return nil, false
+		}
+		f := b[2 : 2+n]
+		b = b[2+n:]
+		return f, true
+	}
+	for len(b) >= 2 {
+		family := binary.BigEndian.Uint16(b)
+		b = b[2:]
+		address, ok1 := field()
+		num, ok2 := field()
+		proto, ok3 := field()
+		cookie, ok4 := field()
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return "", nil
+		}
+		if string(proto) != xauthCookie || len(num) > 0 && string(num) != number {
+			continue
+		}
+		local := network == "unix" || host == "localhost" || net.ParseIP(host).IsLoopback()
+		switch {
+		case family == familyWild,
+			family == familyLocal && local && string(address) == hostname,
+			family != familyLocal && !local && (string(address) == host || bytes.Equal(address, net.ParseIP(host).To4())):
+			return xauthCookie, cookie
+		}
+	}
+	return "", nil
+}
+
+// pad4 returns b padded with zeros to a multiple of four bytes.
+func pad4(b []byte) []byte {
+	return append(b, make([]byte, -len(b)&3)...)
+}
+
+// setup does the connection handshake and finds the screen's root window.
+func (c *x11Conn) setup(authName string, authData []byte, screen int) error {
+	req := make([]byte, 12)
+	req[0] = 'l'
+	binary.LittleEndian.PutUint16(req[2:], 11)
+	binary.LittleEndian.PutUint16(req[6:], uint16(len(authName)))
+	binary.LittleEndian.PutUint16(req[8:], uint16(len(authData)))
+	req = pad4(append(req, authName...))
+	req = pad4(append(req, authData...))
+	if _, err := c.conn.Write(req); err != nil {
+		return err
+	}
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(c.conn, head); err != nil {
+		return err
+	}
+	b := make([]byte, int(binary.LittleEndian.Uint16(head[6:]))*4)
+	if _, err := io.ReadFull(c.conn, b); err != nil {
+		return err
+	}
+	switch head[0] {
+	case 0:
+		return fmt.Errorf("connection refused: %s", b[:min(int(head[1]), len(b))])
+	case 2:
+		return fmt.Errorf("connection refused: %s", bytes.TrimRight(b, "\x00"))
+	}
+
+	if len(b) < 32 {
+		return errors.New("short setup reply")
+	}
+	vendor := int(binary.LittleEndian.Uint16(b[16:]))
+	screens, formats := int(b[20]), int(b[21])
+	off := 32 + (vendor+3)&^3 + 8*formats
+	if screen >= screens {
+		return fmt.Errorf("no screen %d", screen)
+	}
+	for i := 0; ; i++ {
+		if len(b) < off+40 {
+			return errors.New("short setup reply")
+		}
+		s := b[off:]
+		if i == screen {
+			c.screen = screen
+			c.root = binary.LittleEndian.Uint32(s)
+			c.width, c.height = int(binary.LittleEndian.Uint16(s[20:])), int(binary.LittleEndian.Uint16(s[22:]))
+			c.widthMM, c.heightMM = int(binary.LittleEndian.Uint16(s[24:])), int(binary.LittleEndian.Uint16(s[26:]))
+			return nil
+		}
+		// Each depth is 8 bytes followed by its visuals, 24 bytes each.
+		depths := int(s[39])
+		off += 40
+		for range depths {
+			if len(b) < off+8 {
+				return errors.New("short setup reply")
+			}
+			off += 8 + 24*int(binary.LittleEndian.Uint16(b[off+2:]))
+		}
+	}
+}
+
+// send writes a request with the given opcode, data byte and body, which is
+// padded to a multiple of four bytes, and returns its sequence number.
+func (c *x11Conn) send(opcode, data byte, body []byte) (uint16, error) {
+	req := pad4(append([]byte{opcode, data, 0, 0}, body...))
+	binary.LittleEndian.PutUint16(req[2:], uint16(len(req)/4))
+	c.seq++
+	_, err := c.conn.Write(req)
+	return c.seq, err
+}
+
+// reply reads until the reply to the request with the given sequence
+// number, returning it whole. An error for it, or for any request sent
+// before it, is returned instead; events are skipped.
+func (c *x11Conn) reply(seq uint16) ([]byte, error) {
+	for {
+		b := make([]byte, 32)
+		if _, err := io.ReadFull(c.conn, b); err != nil {
+			return nil, err
+		}
+		switch {
+		case b[0] == 0:
+			return nil, &x11Error{
+				Code:  b[1],
+				Value: binary.LittleEndian.Uint32(b[4:]),
+				Minor: int(binary.LittleEndian.Uint16(b[8:])),
+				Major: int(b[10]),
+			}
+		case b[0] == 1, b[0]&0x7f == 35:
+			// Replies and generic events carry more data than 32 bytes.
+			if n := binary.LittleEndian.Uint32(b[4:]); n > 0 {
+				b = append(b, make([]byte, n*4)...)
+				if _, err := io.ReadFull(c.conn, b[32:]); err != nil {
+					return nil, err
+				}
+			}
+			if b[0] == 1 && binary.LittleEndian.Uint16(b[2:]) == seq {
+				return b, nil
+			}
+		}
+	}
+}
+
+// call sends a request and returns its reply.
+func (c *x11Conn) call(opcode, data byte, body []byte) ([]byte, error) {
+	seq, err := c.send(opcode, data, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.reply(seq)
+}
+
+// Core protocol opcodes.
+const (
+	x11InternAtom     = 16
+	x11GetAtomName    = 17
+	x11GrabServer     = 36
+	x11UngrabServer   = 37
+	x11GetInputFocus  = 43
+	x11QueryExtension = 98
+)
+
+// sync waits until the server has handled every request sent so far,
+// returning the error of any that failed.
+func (c *x11Conn) sync() error {
+	_, err := c.call(x11GetInputFocus, 0, nil)
+	return err
+}
+
+// grab holds off every other client's requests until ungrab, or until the
+// connection closes, which releases the grab too.
+func (c *x11Conn) grab() error {
+	_, err := c.send(x11GrabServer, 0, nil)
+	return err
+}
+
+func (c *x11Conn) ungrab() error {
+	_, err := c.send(x11UngrabServer, 0, nil)
+	return err
+}
+
+// extension returns the major opcode of the named extension.
+func (c *x11Conn) extension(name string) (byte, error) {
+	body := binary.LittleEndian.AppendUint16(nil, uint16(len(name)))
+	body = append(body, 0, 0)
+	b, err := c.call(x11QueryExtension, 0, append(body, name...))
+	if err != nil {
+		return 0, err
+	}
+	if b[8] == 0 {
+		return 0, fmt.Errorf("the X server has no %s extension", name)
+	}
+	return b[9], nil
+}
+
+// atom returns the atom named name. Unless create is set, a name the server
+// has no atom for is an error.
+func (c *x11Conn) atom(name string, create bool) (uint32, error) {
+	var onlyIfExists byte = 1
+	if create {
+		onlyIfExists = 0
+	}
+	body := binary.LittleEndian.AppendUint16(nil, uint16(len(name)))
+	body = append(body, 0, 0)
+	b, err := c.call(x11InternAtom, onlyIfExists, append(body, name...))
+	if err != nil {
+		return 0, err
+	}
+	a := binary.LittleEndian.Uint32(b[8:])
+	if a == 0 {
+		return 0, fmt.Errorf("no atom %q", name)
+	}
+	return a, nil
+}
+
+// atomName returns the name of the atom.
+func (c *x11Conn) atomName(a uint32) (string, error) {
+	if name, ok := c.atoms[a]; ok {
+		return name, nil
+	}
+	b, err := c.call(x11GetAtomName, 0, binary.LittleEndian.AppendUint32(nil, a))
+	if err != nil {
+		return "", err
+	}
+	n := int(binary.LittleEndian.Uint16(b[8:]))
+	if len(b) < 32+n {
+		return "", errors.New("short reply")
+	}
+	if c.atoms == nil {
+		c.atoms = make(map[uint32]string)
+	}
+	c.atoms[a] = string(b[32 : 32+n])
+	return c.atoms[a], nil
+}
@@ -0,0 +1,213 @@
+package randr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDisplay(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		network, addr, number string
+		screen                int
+		ok                    bool
+	}{
+		{":0", "unix", "/tmp/.X11-unix/X0", "0", 0, true},
+		{":1.2", "unix", "/tmp/.X11-unix/X1", "1", 2, true},
+		{"unix:0", "unix", "/tmp/.X11-unix/X0", "0", 0, true},
+		{"localhost:10.0", "tcp", "localhost:6010", "10", 0, true},
+		{"10.0.0.5:1", "tcp", "10.0.0.5:6001", "1", 0, true},
+		{"0", "", "", "", 0, false},
+		{":x", "", "", "", 0, false},
+		{":-1", "", "", "", 0, false},
+		{":0.x", "", "", "", 0, false},
+	} {
+		network, addr, number, screen, err := parseDisplay(tc.name)
+		if (err == nil) != tc.ok || network != tc.network || addr != tc.addr || number != tc.number || screen != tc.screen {
+			t.Errorf("%q: got %s %s %s %d, %v", tc.name, network, addr, number, screen, err)
+		}
+	}
+}
+
+// xauthEntry encodes an X authority file entry.
+func xauthEntry(family uint16, address []byte, number, proto string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, family)
+	for _, f := range [][]byte{address, []byte(number), []byte(proto), data} {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(f)))
+		b = append(b, f...)
+	}
+	return b
+}
+
+func TestXauth(t *testing.T) {
+	host, _ := os.Hostname()
+	local, remote, wild := []byte("local"), []byte("remote"), []byte("wild")
+	for _, tc := range []struct {
+		name                  string
+		file                  [][]byte
+		network, addr, number string
+		want                  []byte
+	}{
+		{"local", [][]byte{xauthEntry(256, []byte(host), "0", xauthCookie, local)},
+			"unix", "/tmp/.X11-unix/X0", "0", local},
+		{"other display", [][]byte{xauthEntry(256, []byte(host), "1", xauthCookie, local)},
+			"unix", "/tmp/.X11-unix/X0", "0", nil},
+		{"other protocol first", [][]byte{xauthEntry(256, []byte(host), "0", "XDM-AUTHORIZATION-1", remote),
+			xauthEntry(256, []byte(host), "0", xauthCookie, local)},
+			"unix", "/tmp/.X11-unix/X0", "0", local},
+		{"remote by address", [][]byte{xauthEntry(256, []byte(host), "1", xauthCookie, local),
+			xauthEntry(0, []byte{10, 0, 0, 5}, "1", xauthCookie, remote)},
+			"tcp", "10.0.0.5:6001", "1", remote},
+		{"remote by name", [][]byte{xauthEntry(0, []byte("desk"), "1", xauthCookie, remote)},
+			"tcp", "desk:6001", "1", remote},
+		{"local entry for a remote display", [][]byte{xauthEntry(256, []byte(host), "1", xauthCookie, local)},
+			"tcp", "desk:6001", "1", nil},
+		{"any display", [][]byte{xauthEntry(65535, nil, "", xauthCookie, wild)},
+			"tcp", "desk:6001", "1", wild},
+		{"truncated", [][]byte{xauthEntry(256, []byte(host), "0", xauthCookie, local)[:12]},
+			"unix", "/tmp/.X11-unix/X0", "0", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "Xauthority")
+			if err := os.WriteFile(file, bytes.Join(tc.file, nil), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("XAUTHORITY", file)
+			name, data := xauth(tc.network, tc.addr, tc.number)
+			if !bytes.Equal(data, tc.want) || (tc.want != nil) != (name == xauthCookie) {
+				t.Errorf("got %q %q, want %q", name, data, tc.want)
+			}
+		})
+	}
+}
+
+// x11Packet returns a 32 byte reply, error or event of the given type with
+// the sequence number, followed by extra words of data.
+func x11Packet(typ byte, seq uint16, extra int) []byte {
+	b := make([]byte, 32+4*extra)
+	b[0] = typ
+	binary.LittleEndian.PutUint16(b[2:], seq)
+	binary.LittleEndian.PutUint32(b[4:], uint32(extra))
+	return b
+}
+
+func TestX11Call(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &x11Conn{conn: client, seq: 6}
+	sent := make(chan string, 1)
+	go func() {
+		defer server.Close()
+		req := make([]byte, 12)
+		if _, err := server.Read(req); err != nil {
+			return
+		}
+		sent <- hex.EncodeToString(req)
+		// An event and a late reply to an earlier request come first.
+		server.Write(x11Packet(12, 6, 0))
+		server.Write(x11Packet(1, 6, 2))
+		reply := x11Packet(1, 7, 1)
+		copy(reply[32:], "done")
+		server.Write(reply)
+
+		server.Read(req[:4])
+		e := x11Packet(0, 8, 0)
+		e[1], e[10] = 8, 140
+		binary.LittleEndian.PutUint32(e[4:], 0x3f)
+		binary.LittleEndian.PutUint16(e[8:], 21)
+		server.Write(e)
+	}()
+
+	seq, err := c.send(98, 0, []byte{5, 0, 0, 0, 'R'})
+	if err != nil || seq != 7 {
+		t.Fatalf("sent as %d, %v", seq, err)
+	}
+	b, err := c.reply(seq)
+	if err != nil || len(b) != 36 || string(b[32:]) != "done" {
+		t.Fatalf("reply %q, %v", b, err)
+	}
+	// The request is padded to whole words, and its length counted in
+	// them.
+	if req := <-sent; req != "620003000500000052000000" {
+		t.Errorf("request %s", req)
+	}
+
+	_, err = c.call(x11GetInputFocus, 0, nil)
+	var xe *x11Error
+	if !errors.As(err, &xe) || err.Error() != "X server: BadMatch in request 140.21 (value 0x3f)" {
+		t.Errorf("error %v", err)
+	}
+}
+
+// setupReply returns the server's answer to the connection setup with one
+// screen of the size.
+func setupReply(w, h, mmW, mmH int) []byte {
+	b := make([]byte, 32)
+	vendor := "X.Org"
+	binary.LittleEndian.PutUint16(b[16:], uint16(len(vendor)))
+	b[20], b[21] = 1, 1
+	b = pad4(append(b, vendor...))
+	b = append(b, make([]byte, 8)...)
+	s := make([]byte, 40)
+	binary.LittleEndian.PutUint32(s, 0x4ee)
+	binary.LittleEndian.PutUint16(s[20:], uint16(w))
+	binary.LittleEndian.PutUint16(s[22:], uint16(h))
+	binary.LittleEndian.PutUint16(s[24:], uint16(mmW))
+	binary.LittleEndian.PutUint16(s[26:], uint16(mmH))
+	b = append(b, s...)
+	head := []byte{1, 0, 11, 0, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(head[6:], uint16(len(b)/4))
+	return append(head, b...)
+}
+
+func TestX11Setup(t *testing.T) {
+	reason := "No protocol"
+	refused := append([]byte{0, byte(len(reason)), 11, 0, 0, 0, 4, 0}, reason...)
+	refused = append(refused, make([]byte, 16-len(reason))...)
+	for _, tc := range []struct {
+		name   string
+		reply  []byte
+		screen int
+		err    string
+	}{
+		{"ok", setupReply(1920, 1080, 508, 286), 0, ""},
+		{"no such screen", setupReply(1920, 1080, 508, 286), 1, "no screen 1"},
+		{"refused", refused, 0, "connection refused: No protocol"},
+		{"short", setupReply(1920, 1080, 508, 286)[:40], 0, "EOF"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			go func() {
+				defer server.Close()
+				req := make([]byte, 64)
+				n, _ := server.Read(req)
+				if n != 12+20+16 {
+					return
+				}
+				server.Write(tc.reply)
+			}()
+			c := &x11Conn{conn: client}
+			err := c.setup(xauthCookie, bytes.Repeat([]byte{1}, 16), tc.screen)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.root != 0x4ee || c.width != 1920 || c.height != 1080 || c.widthMM != 508 || c.heightMM != 286 {
+				t.Errorf("got %+v", c)
+			}
+		})
+	}
+}